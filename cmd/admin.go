@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/admin"
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// const to define cobra command flag names that only apply to the admin register-cluster subcommand
+const (
+	flagKubeconfig              = "kubeconfig"
+	flagKubeContext             = "context"
+	flagKubernetesHost          = "kubernetes-host"
+	flagServiceAccountNamespace = "service-account-namespace"
+	flagServiceAccountName      = "service-account-name"
+	flagAllowedNamespaces       = "allowed-namespaces"
+	flagKubernetesRole          = "kubernetes-role"
+	flagTokenDefaultTTL         = "token-default-ttl"
+	flagTokenMaxTTL             = "token-max-ttl"
+	flagTeardown                = "teardown"
+)
+
+// const to define cobra command flag names that only apply to the admin setup-auth subcommand
+const (
+	flagServiceAccount           = "service-account"
+	flagAudience                 = "audience"
+	flagPolicies                 = "policies"
+	flagVaultKubernetesLoginRole = "vault-kubernetes-login-role"
+	flagTokenTTL                 = "token-ttl"
+)
+
+// newKubernetesClient creates the client of a downstream cluster, tests replace it with a fake clientset
+var newKubernetesClient = func(config *rest.Config) (kubernetes.Interface, error) {
+	return kubernetes.NewForConfig(config)
+}
+
+// Admin() creates an admin cobra subcommand grouping the commands that provision Vault and downstream clusters
+func Admin() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin [command]",
+		Args:  cobra.NoArgs,
+		Short: "Provisions Hashicorp Vault and downstream clusters for kubectl-vaultlogin",
+		Long: `kubectl-vaultlogin admin groups the commands run by Vault and kubernetes administrators to onboard downstream clusters.
+They log in to Vault with one of the authentication methods, typically token or oidc, and need a Vault policy allowing the changes.`,
+	}
+	cmd.AddCommand(RegisterCluster())
+	cmd.AddCommand(SetupAuth())
+	return cmd
+}
+
+// RegisterCluster() creates a register-cluster cobra subcommand that provisions Vault's kubernetes secret engine
+// for a downstream cluster. It has one subcommand per registered vault authentication method, which it logs in with
+func RegisterCluster() *cobra.Command {
+	var (
+		kubeconfig  string
+		kubeContext string
+		settings    admin.ClusterRegistration
+		teardown    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "register-cluster [command]",
+		Args:  cobra.NoArgs,
+		Short: "Provisions Vault's kubernetes secret engine for a downstream cluster of a kubeconfig context",
+		Long: `kubectl-vaultlogin admin register-cluster onboards the downstream cluster of a kubeconfig context:
+  - in the cluster, a service account for Vault, its token secret and a ClusterRole and ClusterRoleBinding
+    allowing Vault to generate service accounts bound to --kubernetes-role
+  - in Vault, the kubernetes secret engine at --vault-secrets-mount, its config with the API server, CA and
+    service account token, and the --vault-secret-role role federate requests tokens of
+The cluster name defaults to the first label of the API server's host, as federate derives it.
+Objects already as desired are left unchanged, so it can be rerun. --dry-run prints the changes without making them
+and --teardown disables the secret engine and deletes the objects of Vault from the cluster.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringVar(&kubeconfig, flagKubeconfig, "", "kubeconfig of the downstream cluster, defaults to KUBECONFIG, then ~/.kube/config")
+	cmd.PersistentFlags().StringVar(&kubeContext, flagKubeContext, "", "kubeconfig context of the downstream cluster, defaults to the current context")
+	cmd.PersistentFlags().StringVar(&settings.KubernetesHost, flagKubernetesHost, "", "URL Vault reaches the API server at, defaults to the server of the kubeconfig context")
+	cmd.PersistentFlags().StringVar(&settings.ServiceAccountNamespace, flagServiceAccountNamespace, admin.DefaultServiceAccountNamespace, "namespace of the service account Vault manages the cluster with")
+	cmd.PersistentFlags().StringVar(&settings.ServiceAccountName, flagServiceAccountName, admin.DefaultServiceAccountName, "name of the service account Vault manages the cluster with, also names its token secret, ClusterRole and ClusterRoleBinding")
+	cmd.PersistentFlags().StringSliceVar(&settings.AllowedNamespaces, flagAllowedNamespaces, nil, "namespaces tokens can be requested for, created when missing. Defaults to the namespace federate requests, kube-priv")
+	cmd.PersistentFlags().StringVar(&settings.KubernetesRole, flagKubernetesRole, admin.DefaultKubernetesRole, "ClusterRole bound to the service accounts Vault generates")
+	cmd.PersistentFlags().DurationVar(&settings.TokenDefaultTTL, flagTokenDefaultTTL, 0, "default ttl of the generated tokens, Vault's default when unset")
+	cmd.PersistentFlags().DurationVar(&settings.TokenMaxTTL, flagTokenMaxTTL, 0, "maximum ttl of the generated tokens, Vault's default when unset")
+	cmd.PersistentFlags().BoolVar(&settings.DryRun, federate.FlagDryRun, false, "print the changes to the cluster and to Vault without making them")
+	cmd.PersistentFlags().BoolVar(&teardown, flagTeardown, false, "disable the secret engine and delete the objects of Vault from the cluster, namespaces are kept")
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		opts.OneShot = true
+		if err := opts.LoadEnv(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		federator, err := federate.NewFederator(opts)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		config, err := kubeconfigContext(kubeconfig, kubeContext)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		// the cluster name is derived from the API server as federate does, unless --cluster-name is set
+		clusterName := opts.ClusterName
+		if clusterName == "" {
+			execCredential := &clientauthentication.ExecCredential{Spec: clientauthentication.ExecCredentialSpec{Cluster: &clientauthentication.Cluster{Server: config.Host}}}
+			if clusterName, err = federator.ClusterName(execCredential); err != nil {
+				return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+			}
+		}
+		secrets, err := federator.KubernetesSecrets(clusterName)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		registration := settings
+		registration.ClusterName = clusterName
+		registration.Mount = secrets.Mount
+		registration.Role = secrets.Role
+		if registration.KubernetesHost == "" {
+			registration.KubernetesHost = config.Host
+		}
+		if len(registration.AllowedNamespaces) == 0 {
+			registration.AllowedNamespaces = []string{secrets.Namespace}
+		}
+		if registration.KubernetesCACert, err = kubeconfigCA(config); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		if registration.Kubernetes, err = newKubernetesClient(config); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		session, err := federator.Login(context.Background(), federator.TemplateData(clusterName))
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		registration.Vault = session.Client()
+		if err := registration.Validate(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		run := registration.Register
+		if teardown {
+			run = registration.Teardown
+		}
+		actions, err := run(context.Background())
+		for _, action := range actions {
+			if registration.DryRun {
+				fmt.Fprint(cmd.OutOrStdout(), "(dry run) ")
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), action)
+			for _, change := range action.Changes {
+				fmt.Fprintln(cmd.OutOrStdout(), "    "+change)
+			}
+		}
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		return nil
+	})...)
+
+	return cmd
+}
+
+// SetupAuth() creates a setup-auth cobra subcommand that configures Vault's kubernetes authentication for the cluster
+// ArgoCD runs in. It has one subcommand per registered vault authentication method, which it logs in with
+func SetupAuth() *cobra.Command {
+	var (
+		kubeconfig     string
+		kubeContext    string
+		serviceAccount string
+		settings       admin.AuthSetup
+	)
+
+	cmd := &cobra.Command{
+		Use:   "setup-auth [command]",
+		Args:  cobra.NoArgs,
+		Short: "Configures Vault's kubernetes authentication for the ArgoCD cluster of a kubeconfig context",
+		Long: `kubectl-vaultlogin admin setup-auth prepares the cluster ArgoCD runs in for the psat authentication method:
+  - in the cluster, a ClusterRoleBinding to system:auth-delegator letting ArgoCD's --service-account review tokens,
+    Vault reviews a login's token with the token itself
+  - in Vault, the kubernetes auth method at --vault-kubernetes-auth-mount, its config with the API server and CA,
+    and the --vault-kubernetes-login-role role bound to the service account, the token --audience and --policies
+Fields already as desired are left unchanged and changed ones are printed as a diff, so it can be rerun to detect drift.
+--dry-run prints the changes without making them.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringVar(&kubeconfig, flagKubeconfig, "", "kubeconfig of the ArgoCD cluster, defaults to KUBECONFIG, then ~/.kube/config")
+	cmd.PersistentFlags().StringVar(&kubeContext, flagKubeContext, "", "kubeconfig context of the ArgoCD cluster, defaults to the current context")
+	cmd.PersistentFlags().StringVar(&settings.KubernetesHost, flagKubernetesHost, "", "URL Vault reaches the API server at, defaults to the server of the kubeconfig context")
+	cmd.PersistentFlags().StringVar(&serviceAccount, flagServiceAccount, "", "service account of ArgoCD as namespace/name, ex. argocd/argocd-repo-server, the only one allowed to log in")
+	cmd.PersistentFlags().StringVar(&settings.Audience, flagAudience, "", "audience the service account token must be issued for, ex. vault")
+	cmd.PersistentFlags().StringSliceVar(&settings.Policies, flagPolicies, nil, "Vault policies attached to the tokens of the login role, allowing the secret roles federate requests")
+	cmd.PersistentFlags().String(federate.FlagVaultKubernetesAuthMount, "", "vault kubernetes authentication mount point, ex. /kubernetes/argocd. Defaults to VAULT_AUTH_MOUNT")
+	cmd.PersistentFlags().StringVar(&settings.Role, flagVaultKubernetesLoginRole, "", "login role ArgoCD presents its token to. Defaults to VAULT_K8S_LOGIN_ROLE, then "+admin.DefaultKubernetesLoginRole)
+	cmd.PersistentFlags().DurationVar(&settings.TokenTTL, flagTokenTTL, 0, "ttl of the vault tokens the login role issues, Vault's default when unset")
+	cmd.PersistentFlags().BoolVar(&settings.DryRun, federate.FlagDryRun, false, "print the changes to the cluster and to Vault without making them")
+	for _, name := range []string{flagServiceAccount, flagAudience, flagPolicies} {
+		cmd.MarkPersistentFlagRequired(name)
+	}
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		opts.OneShot = true
+		if err := opts.LoadEnv(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		federator, err := federate.NewFederator(opts)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		config, err := kubeconfigContext(kubeconfig, kubeContext)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		setup := settings
+		// the psat subcommand defines its own --vault-kubernetes-auth-mount, which shadows this one
+		setup.Mount = cmd.Flags().Lookup(federate.FlagVaultKubernetesAuthMount).Value.String()
+		if setup.Mount == "" {
+			setup.Mount = os.Getenv("VAULT_AUTH_MOUNT")
+		}
+		if setup.Role == "" {
+			setup.Role = os.Getenv("VAULT_K8S_LOGIN_ROLE")
+		}
+		var found bool
+		if setup.ServiceAccountNamespace, setup.ServiceAccountName, found = strings.Cut(serviceAccount, "/"); !found {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("%s must be namespace/name: %s", flagServiceAccount, serviceAccount))
+		}
+		if setup.KubernetesHost == "" {
+			setup.KubernetesHost = config.Host
+		}
+		if setup.KubernetesCACert, err = kubeconfigCA(config); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		if setup.Kubernetes, err = newKubernetesClient(config); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		// the source cluster of --cluster-name, when set, designates the auth mount and login role of a templated login
+		session, err := federator.Login(context.Background(), federator.TemplateData(opts.ClusterName))
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		setup.Vault = session.Client()
+		if err := setup.Validate(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		actions, err := setup.Setup(context.Background())
+		for _, action := range actions {
+			if setup.DryRun {
+				fmt.Fprint(cmd.OutOrStdout(), "(dry run) ")
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), action)
+			for _, change := range action.Changes {
+				fmt.Fprintln(cmd.OutOrStdout(), "    "+change)
+			}
+		}
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		return nil
+	})...)
+
+	return cmd
+}
+
+// kubeconfigContext returns the client configuration of kubeContext in kubeconfig, kubectl's defaults apply to empty values
+func kubeconfigContext(kubeconfig string, kubeContext string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfigContext() cannot load the kubeconfig context: %w", err)
+	}
+	return config, nil
+}
+
+// kubeconfigCA returns the PEM encoded CA of the API server in config, empty when the kubeconfig has none
+func kubeconfigCA(config *rest.Config) (string, error) {
+	if len(config.CAData) > 0 {
+		return string(config.CAData), nil
+	}
+	if config.CAFile == "" {
+		return "", nil
+	}
+	caCert, err := os.ReadFile(config.CAFile)
+	if err != nil {
+		return "", fmt.Errorf("kubeconfigCA() cannot read the certificate-authority of the kubeconfig: %w", err)
+	}
+	return string(caCert), nil
+}
@@ -0,0 +1,159 @@
+// cmd/admin_test.go
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// writeKubeconfig writes a kubeconfig whose context prod points at the API server https://prod.example.com:6443
+func writeKubeconfig(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+    certificate-authority-data: `+base64.StdEncoding.EncodeToString([]byte("prod-ca"))+`
+users:
+- name: admin
+  user:
+    token: admin-token
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+current-context: prod
+`), 0600))
+	return path
+}
+
+// fakeDownstreamCluster points admin commands at a fake clientset populating service account token secrets
+func fakeDownstreamCluster(t *testing.T) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*corev1.Secret)
+		secret.Data = map[string][]byte{corev1.ServiceAccountTokenKey: []byte("vault-sa-jwt")}
+		return false, nil, nil
+	})
+	newKubernetesClient = func(config *rest.Config) (kubernetes.Interface, error) {
+		return clientset, nil
+	}
+	t.Cleanup(func() {
+		newKubernetesClient = func(config *rest.Config) (kubernetes.Interface, error) { return kubernetes.NewForConfig(config) }
+	})
+	return clientset
+}
+
+// tests a cluster is registered from a kubeconfig context with a vault token, then torn down
+func TestAdminRegisterCluster(t *testing.T) {
+	clientset := fakeDownstreamCluster(t)
+	vault := startFakeVault(t)
+	vault.EnableMounts()
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	kubeconfig := writeKubeconfig(t)
+	run := func(args ...string) string {
+		buf := new(bytes.Buffer)
+		cmd := New()
+		cmd.SetOut(buf)
+		cmd.SetArgs(append([]string{"admin", "register-cluster", "token", "--vault-address=" + vault.URL, "--kubeconfig=" + kubeconfig}, args...))
+		require.NoError(t, cmd.Execute())
+		return buf.String()
+	}
+
+	output := run("--dry-run")
+	assert.Contains(t, output, "(dry run) create secret engine /kubernetes/prod\n")
+	assert.Empty(t, vault.Mounts())
+
+	output = run()
+	assert.Contains(t, output, "create ServiceAccount kube-system/kvl-vault\n")
+	assert.Contains(t, output, "create kubernetes/prod/roles/kvl-edit-role\n")
+	assert.Equal(t, map[string]string{"kubernetes/prod": "kubernetes"}, vault.Mounts())
+	config := vault.Stored("kubernetes/prod/config")
+	assert.Equal(t, "https://prod.example.com:6443", config["kubernetes_host"])
+	assert.Equal(t, "prod-ca", config["kubernetes_ca_cert"])
+	assert.Equal(t, []any{"kube-priv"}, vault.Stored("kubernetes/prod/roles/kvl-edit-role")["allowed_kubernetes_namespaces"])
+
+	output = run("--teardown")
+	assert.Contains(t, output, "delete secret engine /kubernetes/prod\n")
+	assert.Empty(t, vault.Mounts())
+	_, err := clientset.CoreV1().ServiceAccounts("kube-system").Get(context.Background(), "kvl-vault", metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+// tests the cluster name, mount and role follow --cluster-name and the templates
+func TestAdminRegisterClusterTemplates(t *testing.T) {
+	fakeDownstreamCluster(t)
+	vault := startFakeVault(t)
+	vault.EnableMounts()
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	cmd := New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs([]string{"admin", "register-cluster", "token", "--vault-address=" + vault.URL, "--kubeconfig=" + writeKubeconfig(t),
+		"--cluster-name=eu-prod", "--vault-secrets-mount=/k8s-creds/{{.Environment}}/{{.ClusterName}}", "--environment=live",
+		"--vault-secret-role=deployer", "--allowed-namespaces=apps,jobs"})
+	require.NoError(t, cmd.Execute())
+	assert.Equal(t, map[string]string{"k8s-creds/live/eu-prod": "kubernetes"}, vault.Mounts())
+	assert.Equal(t, []any{"apps", "jobs"}, vault.Stored("k8s-creds/live/eu-prod/roles/deployer")["allowed_kubernetes_namespaces"])
+}
+
+// tests the auth method of the ArgoCD cluster is set up from a kubeconfig context, then drift is printed as a diff
+func TestAdminSetupAuth(t *testing.T) {
+	clientset := fakeDownstreamCluster(t)
+	vault := startFakeVault(t)
+	vault.EnableMounts()
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	kubeconfig := writeKubeconfig(t)
+	run := func(args ...string) string {
+		buf := new(bytes.Buffer)
+		cmd := New()
+		cmd.SetOut(buf)
+		cmd.SetArgs(append([]string{"admin", "setup-auth", "token", "--vault-address=" + vault.URL, "--kubeconfig=" + kubeconfig,
+			"--vault-kubernetes-auth-mount=/kubernetes/argocd", "--service-account=argocd/argocd-repo-server"}, args...))
+		require.NoError(t, cmd.Execute())
+		return buf.String()
+	}
+
+	output := run("--audience=vault", "--policies=kvl-federate", "--token-ttl=1h")
+	assert.Contains(t, output, "create auth method /kubernetes/argocd\n")
+	assert.Contains(t, output, "create auth/kubernetes/argocd/role/kvl-login\n    audience: (unset) -> \"vault\"\n")
+	assert.Equal(t, map[string]string{"auth/kubernetes/argocd": "kubernetes"}, vault.Mounts())
+	config := vault.Stored("auth/kubernetes/argocd/config")
+	assert.Equal(t, "https://prod.example.com:6443", config["kubernetes_host"])
+	assert.Equal(t, "prod-ca", config["kubernetes_ca_cert"])
+	_, err := clientset.RbacV1().ClusterRoleBindings().Get(context.Background(), "kvl-auth-delegator-argocd-argocd-repo-server", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	output = run("--audience=argocd", "--policies=kvl-federate", "--token-ttl=1h", "--dry-run")
+	assert.Contains(t, output, "(dry run) unchanged auth/kubernetes/argocd/config\n")
+	assert.Contains(t, output, "(dry run) update auth/kubernetes/argocd/role/kvl-login\n    audience: \"vault\" -> \"argocd\"\n")
+	assert.Equal(t, "vault", vault.Stored("auth/kubernetes/argocd/role/kvl-login")["audience"])
+}
+
+// tests the service account must be namespace/name
+func TestAdminSetupAuthServiceAccount(t *testing.T) {
+	fakeDownstreamCluster(t)
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	cmd := New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs([]string{"admin", "setup-auth", "token", "--vault-address=" + vault.URL, "--kubeconfig=" + writeKubeconfig(t),
+		"--vault-kubernetes-auth-mount=/kubernetes/argocd", "--service-account=argocd-repo-server", "--audience=vault", "--policies=kvl-federate"})
+	assert.ErrorContains(t, cmd.Execute(), "service-account must be namespace/name: argocd-repo-server")
+}
@@ -5,8 +5,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
 
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/client-go/pkg/apis/clientauthentication"
 )
@@ -16,7 +18,7 @@ func TestFederateApproleSubcmd(t *testing.T) {
 	buf := new(bytes.Buffer)
 
 	// create root command
-	cmd := New(true)
+	cmd := New()
 
 	// Set the output of the command to the buffer
 	cmd.SetOut(buf)
@@ -33,16 +35,17 @@ func TestFederateApproleSubcmd(t *testing.T) {
 
 func TestFederateApproleEndToEnd(t *testing.T) {
 	var execCredential clientauthentication.ExecCredential
-	// KUBERNETES_EXEC_INFO=$(cat input-ec-nocluster) ./kubectl-vaultlogin federate psat -a /kubernetes/c1 -p /home/kwaberski/go/src/kvl/kvl-token -c c4 -v https://vault.corp.guardanet.net:8200
-	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com","config":null},"interactive":false}}`)
-	// os.Setenv("APPROLE_ROLE_ID", "d162568c-d3c2-daee-235b-467ff1cd74e2")
-	// os.Setenv("APPROLE_SECRET_ID", "731d4403-55e1-e87a-3425-ab4eb9ebb337")
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	t.Setenv("APPROLE_ROLE_ID", "d162568c-d3c2-daee-235b-467ff1cd74e2")
+	t.Setenv("APPROLE_SECRET_ID", "731d4403-55e1-e87a-3425-ab4eb9ebb337")
+	vault := startFakeVault(t)
+	vault.AddApproleLogin("/approle", "d162568c-d3c2-daee-235b-467ff1cd74e2", "731d4403-55e1-e87a-3425-ab4eb9ebb337")
 	output := captureOutput(func() {
 		// create root command
-		cmd := New(true)
+		cmd := New()
 		// Execute the command with arguments
 		cmd.SetArgs([]string{"federate", "approle",
-			"--vault-address=https://vault.corp.guardanet.net:8200",
+			"--vault-address=" + vault.URL,
 		})
 		err := cmd.Execute()
 		assert.NoError(t, err)
@@ -53,4 +56,39 @@ func TestFederateApproleEndToEnd(t *testing.T) {
 	if err != nil || execCredential.Status.Token == "" {
 		t.Error("Didn't receive a valid ExecCredential")
 	}
+	assert.Equal(t, []string{"POST auth/approle/login", "POST kubernetes/k8s/creds/kvl-edit-role"}, vault.Requests())
+}
+
+func TestFederateApproleWrappedSecretID(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	vault.AddApproleLogin("/approle", "role-id", "secret-id")
+	dir := t.TempDir()
+	roleIDFile := filepath.Join(dir, "role-id")
+	tokenFile := filepath.Join(dir, "wrapping-token")
+	assert.NoError(t, os.WriteFile(roleIDFile, []byte("role-id\n"), 0600))
+	assert.NoError(t, os.WriteFile(tokenFile, []byte(vault.WrapSecretID("/approle", "ci", "secret-id")), 0600))
+
+	// a wrapping token is single use, the next federate call run by kubectl could not unwrap it again
+	t.Setenv("APPROLE_SECRET_ID_WRAPPING_TOKEN", "wrapping-token")
+	for _, args := range [][]string{
+		{"approle", "--secret-id-wrapping-token-file=" + tokenFile},
+		{"approle"},
+		{"auto", "--auth-methods=approle"},
+	} {
+		var err error
+		output := captureOutput(func() {
+			cmd := New()
+			cmd.SetArgs(append([]string{"federate", args[0],
+				"--vault-address=" + vault.URL,
+				"--role-id-file=" + roleIDFile,
+				"--approle-role-name=ci",
+			}, args[1:]...))
+			err = cmd.Execute()
+		})
+		assert.ErrorContains(t, err, "a response-wrapped secret id can only be presented once, it is accepted by the long-lived broker, proxy and exec commands")
+		assert.Equal(t, kvlerrors.InputValidation, kvlerrors.CategoryOf(err))
+		assert.Empty(t, output)
+	}
+	assert.Empty(t, vault.Requests())
 }
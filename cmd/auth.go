@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// vaultHTTPClient is used for requests to Vault when set, tests point it at a fake Vault
+var vaultHTTPClient *http.Client
+
+// authSubcommands generates one cobra subcommand per registered Vault authentication method.
+// Each subcommand carries the flags declared by its Authenticator, run is invoked once they are parsed.
+func authSubcommands(run func(cmd *cobra.Command, opts federate.Options) error) []*cobra.Command {
+	var cmds []*cobra.Command
+	for _, name := range federate.Authenticators() {
+		authenticator, err := federate.NewAuthenticator(name)
+		if err != nil {
+			// Authenticators only lists registered methods, this is a bug in the registry
+			panic(fmt.Sprintf("cmd: authenticator %s is listed but cannot be created: %s", name, err))
+		}
+		short, long := authenticator.Usage()
+
+		cmd := &cobra.Command{
+			Use:   name,
+			Args:  cobra.NoArgs,
+			Short: short,
+			Long:  long,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				// In order to differentiate between "cobra command line" errors and actual program errors
+				// as well as print Usage ONLY when the error results from imnproper command specification
+				// and not from the actual program, flags required by the authenticator are enforced by cobra
+				// and we switch OFF SilenceUsage and SilenceErrors once they are parsed
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				vaultConfig, err := loadVaultConfig()
+				if err != nil {
+					return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+				}
+				httpClient := vaultHTTPClient
+				if httpClient == nil {
+					if httpClient, err = vaultConfig.HTTPClient(); err != nil {
+						return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+					}
+				}
+				environment, profile, err := loadProfile()
+				if err != nil {
+					return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+				}
+				opts := federate.Options{
+					VaultAddress:     vaultConfig.Address,
+					ClusterName:      viper.GetString(federate.FlagClusterName),
+					Authenticator:    authenticator,
+					HTTPClient:       httpClient,
+					Timeout:          vaultConfig.Timeout,
+					AllowHTTP:        vaultConfig.AllowHTTP,
+					Namespace:        vaultConfig.Namespace,
+					AuthNamespace:    viper.GetString(federate.FlagVaultAuthNamespace),
+					SecretsNamespace: viper.GetString(federate.FlagVaultSecretsNamespace),
+					SecretsMount:     viper.GetString(federate.FlagVaultSecretsMount),
+					SecretRole:       viper.GetString(federate.FlagVaultSecretRole),
+					Environment:      environment,
+					Profile:          profile,
+				}
+				sources, err := loadSources()
+				if err != nil {
+					return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+				}
+				opts.Sources = sources
+				return run(cmd, opts)
+			},
+		}
+
+		// init - configure flags that only apply to this subcommand and its children (if any)
+		for _, flag := range authenticator.AddFlags(cmd.PersistentFlags()) {
+			cmd.MarkPersistentFlagRequired(flag)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
@@ -0,0 +1,50 @@
+// cmd/auto_test.go
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests federate auto skips the missing vault token and psat and federates with approle credentials
+func TestFederateAutoEndToEnd(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("APPROLE_ROLE_ID", "role-id")
+	t.Setenv("APPROLE_SECRET_ID", "secret-id")
+	vault := startFakeVault(t)
+	vault.AddApproleLogin("/approle", "role-id", "secret-id")
+	var err error
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate", "auto",
+			"--vault-address=" + vault.URL,
+			"--vault-kubernetes-auth-mount=/kubernetes/argocd",
+			"--psat-path=" + filepath.Join(t.TempDir(), "missing"),
+		})
+		err = cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.Contains(t, output, "fake-k8s-token")
+	assert.Equal(t, []string{"POST auth/approle/login", "POST kubernetes/k8s/creds/kvl-edit-role"}, vault.Requests())
+
+	// an existing vault token comes first
+	tokenFile := filepath.Join(t.TempDir(), "vault-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(vault.IssueToken()), 0600))
+	output = captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate", "auto",
+			"--vault-address=" + vault.URL,
+			"--vault-token-file=" + tokenFile,
+		})
+		err = cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.Contains(t, output, "fake-k8s-token")
+	assert.Equal(t, []string{"GET auth/token/lookup-self", "POST kubernetes/k8s/creds/kvl-edit-role"}, vault.Requests()[2:])
+}
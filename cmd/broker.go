@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/broker"
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/cobra"
+)
+
+// const to define cobra command flag names that only apply to the broker subcommand
+const (
+	flagBrokerSocket           = "socket"
+	flagBrokerPrefetchClusters = "prefetch-clusters"
+	flagBrokerAllowedUIDs      = "allowed-uids"
+	flagBrokerRefreshMargin    = "refresh-margin"
+)
+
+// Broker() creates a broker cobra subcommand that runs a resident credential broker.
+// It has one subcommand per registered vault authentication method, which the broker logs in with
+func Broker() *cobra.Command {
+	var (
+		socketPath       string
+		prefetchClusters []string
+		allowedUIDs      []int
+		refreshMargin    time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "broker [command]",
+		Args:  cobra.NoArgs,
+		Short: "Runs a resident credential broker serving kubernetes bearer tokens over a unix socket",
+		Long: `Runs a resident credential broker. The broker logs in to Hashicorp Vault once, renews its vault token
+and keeps per cluster kubernetes bearer tokens fresh, refreshing them before they expire.
+federate subcommands started with --broker-socket ask the broker first and only fall back to direct federation
+when the broker is unavailable. Only processes running as one of --allowed-uids may request tokens.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringVar(&socketPath, flagBrokerSocket, broker.DefaultSocketPath(), "unix socket the broker listens on")
+	cmd.PersistentFlags().StringSliceVar(&prefetchClusters, flagBrokerPrefetchClusters, nil, "downstream clusters whose tokens are requested before any client asks for them")
+	cmd.PersistentFlags().IntSliceVar(&allowedUIDs, flagBrokerAllowedUIDs, nil, "uids allowed to request tokens from the broker, checked with peer credentials. Defaults to the uid running the broker, other uids make the socket connectable by any local user")
+	cmd.PersistentFlags().DurationVar(&refreshMargin, flagBrokerRefreshMargin, 5*time.Minute, "how long before expiration a cached kubernetes bearer token gets replaced")
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		if err := opts.LoadEnv(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		federator, err := federate.NewFederator(opts)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		server, err := broker.NewServer(broker.Config{
+			SocketPath:       socketPath,
+			AllowedUIDs:      allowedUIDs,
+			PrefetchClusters: prefetchClusters,
+			TokenDuration:    federator.Options().TokenDuration,
+			RefreshMargin:    refreshMargin,
+			Login:            federator.BrokerLogin(),
+			Logger:           log.New(os.Stderr, "[kubectl-vaultlogin broker] ", log.LstdFlags),
+		})
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := server.ListenAndServe(ctx); err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		return nil
+	})...)
+
+	return cmd
+}
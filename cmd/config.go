@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/viper"
+)
+
+// configClustersKey is the key of the configuration file holding per cluster settings
+const configClustersKey = "clusters"
+
+// configEnvironmentKey and configProfileKey are the keys of the configuration file holding the environment
+// and profile fields that templates refer to
+const (
+	configEnvironmentKey = "environment"
+	configProfileKey     = "profile"
+)
+
+// configVaultKey is the key of the configuration file holding the settings of the connection to Vault
+const configVaultKey = "vault"
+
+// defaultConfigFile returns $HOME/.kube/vaultlogin.yaml, the configuration file read when --config is unset
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "vaultlogin.yaml")
+}
+
+// loadSources reads the configuration file and returns the credential source of every cluster listed under clusters, ex.:
+//
+//	clusters:
+//	  dev:
+//	    source: kubernetes
+//	    role: kvl-edit-role
+//	  legacy:
+//	    source: kv
+//	    mount: /secret
+//	    path: kubernetes/legacy
+//
+// A missing default configuration file is not an error, clusters then use the kubernetes secret engine
+func loadSources() (map[string]federate.CredentialSource, error) {
+	config, path, err := readConfig()
+	if config == nil {
+		return nil, err
+	}
+	var clusters map[string]federate.ClusterConfig
+	if err := config.UnmarshalKey(configClustersKey, &clusters); err != nil {
+		return nil, fmt.Errorf("malformed clusters in configuration file %s: %s", path, err)
+	}
+	sources := make(map[string]federate.CredentialSource, len(clusters))
+	for clusterName, clusterConfig := range clusters {
+		source, err := federate.NewCredentialSource(clusterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s in configuration file %s: %s", clusterName, path, err)
+		}
+		sources[clusterName] = source
+	}
+	return sources, nil
+}
+
+// loadVaultConfig returns the settings of the connection to Vault. Each is taken from its flag, else from the
+// vault section of the configuration file, else from the VAULT_* environment variables, else from its default, ex.:
+//
+//	vault:
+//	  address: https://vault.example.com:8200
+//	  namespace: team-a
+//	  cacert: /etc/ssl/vault-ca.pem
+//	  timeout: 60s
+//	  proxy: socks5://127.0.0.1:1080
+func loadVaultConfig() (federate.VaultConfig, error) {
+	vaultConfig := federate.VaultConfig{
+		Address:       viper.GetString(federate.FlagVaultAddress),
+		Namespace:     viper.GetString(federate.FlagVaultNamespace),
+		CACert:        viper.GetString(federate.FlagVaultCACert),
+		CAPath:        viper.GetString(federate.FlagVaultCAPath),
+		ClientCert:    viper.GetString(federate.FlagVaultClientCert),
+		ClientKey:     viper.GetString(federate.FlagVaultClientKey),
+		TLSServerName: viper.GetString(federate.FlagVaultTLSServerName),
+		Timeout:       viper.GetDuration(federate.FlagVaultTimeout),
+		Proxy:         viper.GetString(federate.FlagVaultProxy),
+		AllowHTTP:     viper.GetBool(federate.FlagAllowHTTP),
+	}
+	config, path, err := readConfig()
+	if err != nil {
+		return vaultConfig, err
+	}
+	if config != nil {
+		var profile federate.VaultConfig
+		if err := config.UnmarshalKey(configVaultKey, &profile); err != nil {
+			return vaultConfig, fmt.Errorf("malformed vault settings in configuration file %s: %s", path, err)
+		}
+		vaultConfig.Merge(profile)
+	}
+	if err := vaultConfig.LoadEnv(); err != nil {
+		return vaultConfig, err
+	}
+	vaultConfig.Merge(federate.VaultConfig{Address: federate.DefaultVaultAddress})
+	return vaultConfig, nil
+}
+
+// loadProfile returns the environment and profile fields templates refer to, ex.:
+//
+//	environment: prod
+//	profile:
+//	  team: payments
+//
+// --environment takes precedence over the configuration file and --profile fields over those of the same key
+func loadProfile() (string, map[string]string, error) {
+	environment := viper.GetString(federate.FlagEnvironment)
+	profile := map[string]string{}
+	config, _, err := readConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	if config != nil {
+		if environment == "" {
+			environment = config.GetString(configEnvironmentKey)
+		}
+		for key, value := range config.GetStringMapString(configProfileKey) {
+			profile[key] = value
+		}
+	}
+	for key, value := range viper.GetStringMapString(federate.FlagProfile) {
+		profile[key] = value
+	}
+	return environment, profile, nil
+}
+
+// readConfig reads the configuration file set by --config, else the default one. It returns a nil config
+// without error when the default configuration file does not exist
+func readConfig() (*viper.Viper, string, error) {
+	path := viper.GetString(federate.FlagConfig)
+	if path == "" {
+		path = defaultConfigFile()
+		if _, err := os.Stat(path); path == "" || errors.Is(err, os.ErrNotExist) {
+			return nil, path, nil
+		}
+	}
+	config := viper.New()
+	config.SetConfigFile(path)
+	if err := config.ReadInConfig(); err != nil {
+		return nil, path, fmt.Errorf("failure reading configuration file %s: %s", path, err)
+	}
+	return config, path, nil
+}
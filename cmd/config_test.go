@@ -0,0 +1,122 @@
+// cmd/config_test.go
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vaultlogin.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+clusters:
+  dev:
+    role: kvl-view-role
+    namespace: monitoring
+  legacy:
+    source: kv
+    path: clusters/legacy
+  prod:
+    source: oidc
+`), 0600))
+	viper.Set(federate.FlagConfig, path)
+	defer viper.Set(federate.FlagConfig, "")
+
+	sources, err := loadSources()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]federate.CredentialSource{
+		"dev":    &federate.KubernetesSecretsSource{Role: "kvl-view-role", Namespace: "monitoring"},
+		"legacy": &federate.KVSource{Path: "clusters/legacy"},
+		"prod":   &federate.OIDCSource{},
+	}, sources)
+
+	require.NoError(t, os.WriteFile(path, []byte("clusters:\n  dev:\n    source: ldap\n"), 0600))
+	_, err = loadSources()
+	assert.ErrorContains(t, err, `cluster dev in configuration file`)
+}
+
+func TestLoadSourcesMissingDefault(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	sources, err := loadSources()
+	assert.NoError(t, err)
+	assert.Empty(t, sources)
+}
+
+func TestLoadVaultConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vaultlogin.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+vault:
+  address: https://profile.example.com:8200
+  namespace: team-a
+  timeout: 45s
+`), 0600))
+	viper.Set(federate.FlagConfig, path)
+	defer viper.Set(federate.FlagConfig, "")
+	// bind the flags of a root command to viper, unlike viper.Set flag values do not outlive the test
+	root := New()
+	require.NoError(t, root.PersistentFlags().Set(federate.FlagVaultAddress, "https://flag.example.com:8200"))
+	t.Setenv("VAULT_ADDR", "https://env.example.com:8200")
+	t.Setenv("VAULT_NAMESPACE", "team-b")
+	t.Setenv("VAULT_CACERT", "/etc/ssl/vault-ca.pem")
+	t.Setenv("VAULT_CLIENT_TIMEOUT", "10")
+
+	// flag > profile > VAULT_* env
+	vaultConfig, err := loadVaultConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://flag.example.com:8200", vaultConfig.Address)
+	assert.Equal(t, "team-a", vaultConfig.Namespace)
+	assert.Equal(t, 45*time.Second, vaultConfig.Timeout)
+	assert.Equal(t, "/etc/ssl/vault-ca.pem", vaultConfig.CACert)
+
+	// VAULT_* env > default
+	New()
+	require.NoError(t, os.WriteFile(path, []byte("clusters: {}\n"), 0600))
+	vaultConfig, err = loadVaultConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://env.example.com:8200", vaultConfig.Address)
+	assert.Equal(t, "team-b", vaultConfig.Namespace)
+	assert.Equal(t, 10*time.Second, vaultConfig.Timeout)
+
+	os.Unsetenv("VAULT_ADDR")
+	vaultConfig, err = loadVaultConfig()
+	require.NoError(t, err)
+	assert.Equal(t, federate.DefaultVaultAddress, vaultConfig.Address)
+
+	t.Setenv("VAULT_CLIENT_TIMEOUT", "soon")
+	_, err = loadVaultConfig()
+	assert.ErrorContains(t, err, "malformed VAULT_CLIENT_TIMEOUT")
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vaultlogin.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+environment: prod
+profile:
+  team: payments
+  region: eu-west-1
+`), 0600))
+	viper.Set(federate.FlagConfig, path)
+	defer viper.Set(federate.FlagConfig, "")
+
+	environment, profile, err := loadProfile()
+	require.NoError(t, err)
+	assert.Equal(t, "prod", environment)
+	assert.Equal(t, map[string]string{"team": "payments", "region": "eu-west-1"}, profile)
+
+	// flags take precedence over the configuration file
+	root := New()
+	require.NoError(t, root.PersistentFlags().Set(federate.FlagEnvironment, "stage"))
+	require.NoError(t, root.PersistentFlags().Set(federate.FlagProfile, "team=checkout"))
+	defer New()
+	environment, profile, err = loadProfile()
+	require.NoError(t, err)
+	assert.Equal(t, "stage", environment)
+	assert.Equal(t, map[string]string{"team": "checkout", "region": "eu-west-1"}, profile)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/execwrap"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/rest"
+)
+
+// Exec() creates an exec cobra subcommand that runs a command with a temporary kubeconfig holding a Vault issued
+// token of a downstream cluster. It has one subcommand per registered vault authentication method
+func Exec() *cobra.Command {
+	var (
+		clusterName   string
+		server        string
+		caFile        string
+		kubeconfig    string
+		kubeContext   string
+		refreshMargin time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "exec [command]",
+		Args:  cobra.NoArgs,
+		Short: "Runs a command with a temporary kubeconfig holding a Vault issued kubernetes bearer token",
+		Long: `kubectl-vaultlogin exec runs the command following -- with KUBECONFIG pointing at a temporary kubeconfig holding
+the API server, its CA and the cluster's kubernetes bearer token issued by Vault, for tools that only accept a static token,
+ex. kubectl vaultlogin exec token --cluster dev -- helm upgrade --install app ./chart
+The kubeconfig is readable by its owner only, its token is replaced before it expires while the command runs,
+and it is overwritten and removed once the command exits. Signals are forwarded to the command and its exit code is passed on.
+The API server and its CA are --server and --certificate-authority, else those of the kubeconfig context.
+The cluster name defaults to --cluster-name, else to the first label of the API server's host, as federate derives it.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringVar(&clusterName, flagDownstreamCluster, "", "downstream cluster name tokens are requested for, defaults to --cluster-name, then the first label of the API server's host")
+	cmd.PersistentFlags().StringVar(&server, flagServer, "", "URL of the downstream API server, defaults to the server of the kubeconfig context")
+	cmd.PersistentFlags().StringVar(&caFile, flagCertificateAuthority, "", "PEM file of the CA of the downstream API server, defaults to the one of the kubeconfig context with --server unset, else the system roots")
+	cmd.PersistentFlags().StringVar(&kubeconfig, flagKubeconfig, "", "kubeconfig of the downstream cluster, defaults to KUBECONFIG, then ~/.kube/config")
+	cmd.PersistentFlags().StringVar(&kubeContext, flagKubeContext, "", "kubeconfig context of the downstream cluster, defaults to the current context")
+	cmd.PersistentFlags().DurationVar(&refreshMargin, flagRefreshMargin, 2*time.Minute, "how long before expiration the token of the temporary kubeconfig gets replaced")
+
+	// Add subcommands, one per registered vault authentication method, taking the command to run as arguments
+	subcommands := authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		if err := opts.LoadEnv(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		federator, err := federate.NewFederator(opts)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		config, err := downstreamServer(server, caFile, kubeconfig, kubeContext)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		// the CA is embedded, the kubeconfig does not depend on files that may be gone by the time it is read
+		if err := rest.LoadTLSFiles(config); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		if clusterName, err = downstreamClusterName(federator, opts, clusterName, config.Host); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(signals)
+		args := cmd.Flags().Args()
+		code, err := execwrap.Run(cmd.Context(), execwrap.Config{
+			ClusterName:   clusterName,
+			Server:        config.Host,
+			CAData:        config.CAData,
+			TLSServerName: config.ServerName,
+			Insecure:      config.Insecure,
+			Issuer:        federator,
+			RefreshMargin: refreshMargin,
+			Signals:       signals,
+			Stdin:         cmd.InOrStdin(),
+			Stdout:        cmd.OutOrStdout(),
+			Stderr:        cmd.ErrOrStderr(),
+			Logger:        log.New(cmd.ErrOrStderr(), "[kubectl-vaultlogin exec] ", log.LstdFlags),
+		}, args[0], args[1:]...)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		if code != 0 {
+			return kvlerrors.ExitStatus{Code: code}
+		}
+		return nil
+	})
+	for _, subcommand := range subcommands {
+		subcommand.Use += " -- command [args...]"
+		subcommand.Args = cobra.MinimumNArgs(1)
+	}
+	cmd.AddCommand(subcommands...)
+
+	return cmd
+}
@@ -0,0 +1,50 @@
+// cmd/exec_test.go
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// tests exec runs the command with a kubeconfig holding a token of the kubernetes secret engine and passes
+// its exit code on
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the command is run with sh")
+	}
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	out := t.TempDir()
+	t.Setenv("OUT", out)
+
+	cmd := New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs([]string{"exec", "token", "--vault-address=" + vault.URL, "--cluster=k8s", "--server=https://k8s.example.com:6443",
+		"--", "sh", "-c", `cp "$KUBECONFIG" "$OUT/kubeconfig"; exit 4`})
+	err := cmd.Execute()
+	assert.Equal(t, kvlerrors.ExitStatus{Code: 4}, err)
+	assert.Equal(t, 4, kvlerrors.ExitCode(err))
+
+	kubeconfig, err := clientcmd.LoadFromFile(filepath.Join(out, "kubeconfig"))
+	require.NoError(t, err)
+	assert.Equal(t, "https://k8s.example.com:6443", kubeconfig.Clusters["k8s"].Server)
+	assert.Regexp(t, `^fake-k8s-token-\d+$`, kubeconfig.AuthInfos["k8s"].Token)
+}
+
+// tests exec requires a command
+func TestExecNoCommand(t *testing.T) {
+	cmd := New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetErr(new(bytes.Buffer))
+	cmd.SetArgs([]string{"exec", "token", "--cluster=k8s", "--server=https://k8s.example.com:6443"})
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "requires at least 1 arg(s)")
+	assert.Equal(t, kvlerrors.Usage, kvlerrors.CategoryOf(err))
+}
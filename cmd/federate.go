@@ -1,29 +1,167 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/broker"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/credcache"
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 )
 
+var BrokerSocket string
+var DryRun bool
+
 // Federate() creates a federate cobra subcommand
-// test bool is used to designate if the instance is a test run (true) or actual request (false)
-func Federate(test bool) *cobra.Command {
+func Federate() *cobra.Command {
 
 	cmd := &cobra.Command{
 		Use:   "federate [command]",
 		Args:  cobra.NoArgs,
 		Short: "Federates an identity artifact with Hashicorp Vault to obtain a kubernetes beaer token",
 		Long: `kubectl-vaultlogin federate federates an existing identity credential and exchanges it for a just-in-time short-lived kubernetes bearer token.
-It supports two types of identity credentials, namely: 
-- kubernetes projected service account tokens (PSATs) and 
-- approle role-id/secret-id.`,
+It supports one subcommand per Vault authentication method, among others: 
+- psat for kubernetes projected service account tokens (PSATs) and 
+- approle for approle role-id/secret-id.`,
 	}
 
 	// init
-	// nothing here
+	cmd.PersistentFlags().StringVar(&BrokerSocket, federate.FlagBrokerSocket, "", "unix socket of a resident kubectl-vaultlogin broker to ask first, direct federation is used if the broker is unavailable")
+	viper.BindPFlag(federate.FlagBrokerSocket, cmd.PersistentFlags().Lookup(federate.FlagBrokerSocket))
+
+	cmd.PersistentFlags().BoolVar(&DryRun, federate.FlagDryRun, false, "print the resolved configuration and the Vault API requests to STDERR instead of federating, Vault is not contacted")
+	viper.BindPFlag(federate.FlagDryRun, cmd.PersistentFlags().Lookup(federate.FlagDryRun))
+
+	cmd.PersistentFlags().StringP(federate.FlagOutput, "o", federate.OutputExecCredential, "format of the issued credential written to STDOUT: "+strings.Join(federate.OutputFormats(), ", ")+". Formats other than exec-credential do not need KUBERNETES_EXEC_INFO with --cluster-name set")
+	viper.BindPFlag(federate.FlagOutput, cmd.PersistentFlags().Lookup(federate.FlagOutput))
 
-	// Add subcommands
-	cmd.AddCommand(Psat(test))
-	cmd.AddCommand(Approle(test))
+	cmd.PersistentFlags().Bool(federate.FlagForceTTY, false, "write the token to STDOUT even when it is a terminal, in any --output format but exec-credential")
+	viper.BindPFlag(federate.FlagForceTTY, cmd.PersistentFlags().Lookup(federate.FlagForceTTY))
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		return runFederation(opts)
+	})...)
 
 	return cmd
 }
+
+// runFederation perfoms all actions resulting from a federate subcommand to request a new kubernetes bearer token
+// and responds with a corresponding ExecCredetnial, or the format of the output flag, written to STDOUT
+// opts - are federation options assembled by the generated authentication subcommand
+func runFederation(opts federate.Options) error {
+	// kubectl runs the plugin once per credential, single-use credentials would not be available to the next run
+	opts.OneShot = true
+	if err := opts.LoadEnv(); err != nil {
+		return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+	federator, err := federate.NewFederator(opts)
+	if err != nil {
+		return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+
+	format := viper.GetString(federate.FlagOutput)
+	if !slices.Contains(federate.OutputFormats(), format) {
+		return kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("--%s must be one of %s: %s", federate.FlagOutput, strings.Join(federate.OutputFormats(), ", "), format))
+	}
+	// a token on a terminal ends up in the scrollback and on screen sharing. The ExecCredential carries it in
+	// plain text too, it is exempt as it is meant for kubectl, which reads it from a pipe, not for a terminal
+	if format != federate.OutputExecCredential && !viper.GetBool(federate.FlagForceTTY) && stdoutIsTerminal() {
+		return kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("refusing to write a raw token to a terminal, redirect STDOUT or set --%s", federate.FlagForceTTY))
+	}
+
+	// capture received ExecCredential, formats meant for scripts run without kubectl and rely on the cluster-name flag
+	execCredential, err := federate.ExecCredentialFromEnv()
+	if err != nil && format == federate.OutputExecCredential {
+		return kvlerrors.Wrap(kvlerrors.ExecInfo, err)
+	}
+	if err != nil {
+		execCredential = &clientauthentication.ExecCredential{}
+	}
+	// a dry run explains what federation would do, nothing is written to STDOUT
+	if viper.GetBool(federate.FlagDryRun) {
+		plan, err := federator.Plan(execCredential)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.ExecInfo, err)
+		}
+		if err := plan.Print(os.Stderr); err != nil {
+			return kvlerrors.Wrap(kvlerrors.Output, err)
+		}
+		return nil
+	}
+	clusterName, err := federator.ClusterName(execCredential)
+	if err != nil {
+		return kvlerrors.Wrap(kvlerrors.ExecInfo, err)
+	}
+
+	var cache *credcache.Cache
+	var cacheKey string
+	if cacheDir := viper.GetString(federate.FlagCacheDir); cacheDir != "" {
+		cache = credcache.New(cacheDir)
+		// cached credentials are only handed out to calls with the configuration they were issued with
+		var server string
+		if execCredential.Spec.Cluster != nil {
+			server = execCredential.Spec.Cluster.Server
+		}
+		if cacheKey, err = federator.CacheKey(clusterName, server); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+	}
+
+	ctx := context.Background()
+	var credential *federate.Credential
+	if cache != nil {
+		credential, _ = cache.Get(clusterName, cacheKey)
+	}
+	switch {
+	// a valid token in the credential cache is handed out without contacting Vault
+	case credential != nil:
+	// a resident broker, when configured, is asked first and direct federation is the fallback
+	case viper.GetString(federate.FlagBrokerSocket) != "":
+		brokered, err := broker.Fetch(ctx, viper.GetString(federate.FlagBrokerSocket), clusterName)
+		if err == nil {
+			credential = &federate.Credential{
+				ClusterName:             clusterName,
+				Token:                   brokered.Token,
+				LeaseID:                 brokered.LeaseID,
+				LeaseDuration:           brokered.LeaseDuration,
+				ServiceAccountName:      brokered.ServiceAccountName,
+				ServiceAccountNamespace: brokered.ServiceAccountNamespace,
+				ExpirationTimestamp:     brokered.ExpirationTimestamp,
+			}
+			break
+		}
+		fmt.Fprintf(os.Stderr, "kubectl-vaultlogin: falling back to direct federation: %s\n", err)
+		fallthrough
+	default:
+		// the ExecCredential's API server URL is available to templates as .ClusterServer
+		credential, err = federator.FederateCredential(ctx, execCredential)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		if cache != nil {
+			if err := cache.Put(credential, cacheKey); err != nil {
+				fmt.Fprintf(os.Stderr, "kubectl-vaultlogin: %s\n", err)
+			}
+		}
+	}
+
+	// Output the credential, by default as an ExecCredential
+	if err := federate.PrintCredential(os.Stdout, format, execCredential, credential); err != nil {
+		return kvlerrors.Wrap(kvlerrors.Output, err)
+	}
+	return nil
+}
+
+// stdoutIsTerminal reports whether STDOUT is a terminal, tests replace it
+var stdoutIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
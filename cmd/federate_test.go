@@ -3,10 +3,14 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"regexp"
 	"testing"
 
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/pkg/apis/clientauthentication"
 )
 
 func TestFederateSubcmd(t *testing.T) {
@@ -14,7 +18,7 @@ func TestFederateSubcmd(t *testing.T) {
 	buf := new(bytes.Buffer)
 
 	// create root command
-	cmd := New(true)
+	cmd := New()
 
 	// Set the output of the command to the buffer
 	cmd.SetOut(buf)
@@ -32,3 +36,136 @@ func TestFederateSubcmd(t *testing.T) {
 	// assert.Equal(t, expectedOutput, output)
 	assert.Regexp(t, regexp.MustCompile(expectedPattern), output)
 }
+
+// tests the issued credential is written in the format of --output, without KUBERNETES_EXEC_INFO
+// for formats other than exec-credential
+func TestFederateOutput(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", "")
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	federateArgs := []string{"federate", "token", "--vault-address=" + vault.URL, "--cluster-name=k8s"}
+
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs(append(federateArgs, "--output=json"))
+		assert.NoError(t, cmd.Execute())
+	})
+	var credential map[string]any
+	require.NoError(t, json.Unmarshal([]byte(output), &credential))
+	assert.Equal(t, "k8s", credential["clusterName"])
+	assert.Regexp(t, `^fake-k8s-token-\d+$`, credential["token"])
+	assert.Regexp(t, `^v-kvl-edit-role-\d+$`, credential["serviceAccountName"])
+	assert.Equal(t, "kube-priv", credential["serviceAccountNamespace"])
+	assert.EqualValues(t, 600, credential["leaseDurationSeconds"])
+
+	cmd := New()
+	cmd.SetArgs(append(federateArgs, "--output=yaml"))
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "--output must be one of exec-credential, token, env, kubeconfig, json: yaml")
+	assert.Equal(t, kvlerrors.InputValidation, kvlerrors.CategoryOf(err))
+
+	cmd = New()
+	cmd.SetArgs(federateArgs)
+	err = cmd.Execute()
+	assert.Equal(t, kvlerrors.ExecInfo, kvlerrors.CategoryOf(err))
+}
+
+// tests a raw token is only written to a terminal with --force-tty, in any format but exec-credential
+func TestFederateOutputTerminal(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", "")
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	isTerminal := stdoutIsTerminal
+	stdoutIsTerminal = func() bool { return true }
+	t.Cleanup(func() { stdoutIsTerminal = isTerminal })
+	federateArgs := []string{"federate", "token", "--vault-address=" + vault.URL, "--cluster-name=k8s", "-o", "token"}
+
+	for _, format := range []string{"token", "env", "json", "kubeconfig"} {
+		var err error
+		output := captureOutput(func() {
+			cmd := New()
+			cmd.SetArgs([]string{"federate", "token", "--vault-address=" + vault.URL, "--cluster-name=k8s", "-o", format})
+			err = cmd.Execute()
+		})
+		assert.ErrorContains(t, err, "refusing to write a raw token to a terminal, redirect STDOUT or set --force-tty", format)
+		assert.Empty(t, output, format)
+	}
+	assert.Empty(t, vault.Requests())
+
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs(append(federateArgs, "--force-tty"))
+		assert.NoError(t, cmd.Execute())
+	})
+	assert.Regexp(t, `^fake-k8s-token-\d+\n$`, output)
+}
+
+// tests templates render .ClusterServer from the ExecCredential in the requests to Vault, as in the dry run
+func TestFederateClusterServerTemplate(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	vault.AddKubernetesCreds("/k8s/k8s.example.com", "kvl-edit-role")
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate", "token", "--vault-address=" + vault.URL,
+			"--vault-secrets-mount=/k8s/{{if .ClusterServer}}k8s.example.com{{else}}EMPTY{{end}}",
+		})
+		assert.NoError(t, cmd.Execute())
+	})
+	assert.Contains(t, output, `"token":"fake-k8s-token-`)
+	assert.Equal(t, []string{"GET auth/token/lookup-self", "POST k8s/k8s.example.com/creds/kvl-edit-role"}, vault.Requests())
+}
+
+// tests a cached credential is only handed out to calls with the configuration it was issued with
+func TestFederateCacheKey(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	vault.AddKubernetesCreds("/kubernetes/k8s", "kvl-view-role")
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	federateArgs := []string{"federate", "token", "--vault-address=" + vault.URL, "--cache-dir=" + t.TempDir()}
+
+	federateToken := func(role string) string {
+		var execCredential clientauthentication.ExecCredential
+		output := captureOutput(func() {
+			cmd := New()
+			cmd.SetArgs(append(federateArgs, "--vault-secret-role="+role))
+			require.NoError(t, cmd.Execute())
+		})
+		require.NoError(t, json.Unmarshal([]byte(output), &execCredential))
+		return execCredential.Status.Token
+	}
+	editToken := federateToken("kvl-edit-role")
+	viewToken := federateToken("kvl-view-role")
+	assert.NotEqual(t, editToken, viewToken)
+	assert.Equal(t, []string{"GET auth/token/lookup-self", "POST kubernetes/k8s/creds/kvl-edit-role",
+		"GET auth/token/lookup-self", "POST kubernetes/k8s/creds/kvl-view-role"}, vault.Requests())
+
+	// both are answered from the cache
+	assert.Equal(t, editToken, federateToken("kvl-edit-role"))
+	assert.Equal(t, viewToken, federateToken("kvl-view-role"))
+	assert.Len(t, vault.Requests(), 4)
+
+	// another vault token is another identity, its credential is not the cached one
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	assert.NotEqual(t, editToken, federateToken("kvl-edit-role"))
+	assert.Len(t, vault.Requests(), 6)
+}
+
+// tests an ExecCredential is written to a terminal, kubectl runs the plugin with STDOUT piped
+func TestFederateExecCredentialTerminal(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	isTerminal := stdoutIsTerminal
+	stdoutIsTerminal = func() bool { return true }
+	t.Cleanup(func() { stdoutIsTerminal = isTerminal })
+
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate", "token", "--vault-address=" + vault.URL})
+		assert.NoError(t, cmd.Execute())
+	})
+	assert.Contains(t, output, `"kind":"ExecCredential"`)
+}
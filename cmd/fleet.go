@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/credcache"
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// const to define cobra command flag names that only apply to the federate-all subcommand
+const (
+	flagFleetClusters      = "clusters"
+	flagFleetAllFromConfig = "all-from-config"
+	flagFleetParallelism   = "parallelism"
+)
+
+// FederateAll() creates a federate-all cobra subcommand that requests kubernetes bearer tokens for many downstream clusters.
+// It has one subcommand per registered vault authentication method, which it logs in with once
+func FederateAll() *cobra.Command {
+	var (
+		clusters      []string
+		allFromConfig bool
+		parallelism   int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "federate-all [command]",
+		Args:  cobra.NoArgs,
+		Short: "Federates an identity artifact with Hashicorp Vault once to obtain kubernetes bearer tokens for many clusters",
+		Long: `kubectl-vaultlogin federate-all logs in to Hashicorp Vault once and requests kubernetes bearer tokens for the clusters
+listed in --clusters, or for every cluster of the configuration file with --all-from-config, at most --parallelism at a time.
+It continues past failures and prints a per cluster summary to STDOUT, tokens themselves are never printed.
+With --cache-dir the tokens are stored in the credential cache, ex. to pre-warm it before a mass ArgoCD sync.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringSliceVar(&clusters, flagFleetClusters, nil, "downstream clusters to request kubernetes bearer tokens for, ex. dev,prod")
+	cmd.PersistentFlags().BoolVar(&allFromConfig, flagFleetAllFromConfig, false, "request kubernetes bearer tokens for every cluster listed in the configuration file")
+	cmd.PersistentFlags().IntVar(&parallelism, flagFleetParallelism, 4, "maximum number of concurrent requests to Vault")
+	cmd.MarkFlagsOneRequired(flagFleetClusters, flagFleetAllFromConfig)
+	cmd.MarkFlagsMutuallyExclusive(flagFleetClusters, flagFleetAllFromConfig)
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		opts.OneShot = true
+		if err := opts.LoadEnv(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		federator, err := federate.NewFederator(opts)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		clusterNames := clusters
+		if allFromConfig {
+			for clusterName := range opts.Sources {
+				clusterNames = append(clusterNames, clusterName)
+			}
+			slices.Sort(clusterNames)
+			if len(clusterNames) == 0 {
+				return kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("--%s is set but the configuration file lists no clusters", flagFleetAllFromConfig))
+			}
+		}
+
+		results, err := federator.FederateAll(context.Background(), clusterNames, parallelism)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		return summarizeFleet(federator, results, viper.GetString(federate.FlagCacheDir))
+	})...)
+
+	return cmd
+}
+
+// summarizeFleet stores issued credentials in the cache in cacheDir, when set, under the cache key of federator and prints
+// a per cluster summary to STDOUT. It returns an error carrying the first failure when any cluster failed
+func summarizeFleet(federator *federate.Federator, results []federate.FleetResult, cacheDir string) error {
+	var cache *credcache.Cache
+	if cacheDir != "" {
+		cache = credcache.New(cacheDir)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tSTATUS\tEXPIRES\tERROR")
+	var failed int
+	var firstErr error
+	for _, result := range results {
+		err := result.Err
+		if err == nil && cache != nil {
+			var key string
+			if key, err = federator.CacheKey(result.ClusterName, ""); err == nil {
+				err = cache.Put(result.Credential, key)
+			}
+		}
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			fmt.Fprintf(tw, "%s\tfailed\t-\t%s\n", result.ClusterName, err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tok\t%s\t\n", result.ClusterName, result.Credential.ExpirationTimestamp.UTC().Format(time.RFC3339))
+	}
+	if err := tw.Flush(); err != nil {
+		return kvlerrors.Wrap(kvlerrors.Output, err)
+	}
+	if failed > 0 {
+		return kvlerrors.Wrap(kvlerrors.General, fmt.Errorf("%d of %d clusters failed, first failure: %w", failed, len(results), firstErr))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// cmd/fleet_test.go
+package cmd
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/pkg/apis/clientauthentication"
+)
+
+func TestFederateAllSubcmd(t *testing.T) {
+	cmd := New()
+	cmd.SetArgs([]string{"federate-all", "approle"})
+	err := cmd.Execute()
+	assert.ErrorContains(t, err, "at least one of the flags in the group [clusters all-from-config] is required")
+}
+
+func TestFederateAllWarmsCache(t *testing.T) {
+	t.Setenv("APPROLE_ROLE_ID", "role-id")
+	t.Setenv("APPROLE_SECRET_ID", "secret-id")
+	vault := startFakeVault(t)
+	vault.AddApproleLogin("/approle", "role-id", "secret-id")
+	cacheDir := t.TempDir()
+
+	var err error
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate-all", "approle",
+			"--vault-address=" + vault.URL,
+			"--cache-dir=" + cacheDir,
+			"--clusters=k8s,prod",
+		})
+		err = cmd.Execute()
+	})
+	// prod has no kubernetes secret engine, k8s is still federated
+	assert.ErrorContains(t, err, "1 of 2 clusters failed")
+	assert.Regexp(t, regexp.MustCompile(`(?m)^k8s\s+ok\s+\S+Z\s*$`), output)
+	assert.Regexp(t, regexp.MustCompile(`(?m)^prod\s+failed\s+-\s+.*404`), output)
+	assert.NotContains(t, output, "fake-k8s-token")
+	cached, err := filepath.Glob(filepath.Join(cacheDir, "k8s.*.json"))
+	require.NoError(t, err)
+	assert.Len(t, cached, 1)
+
+	// federate answers from the warmed cache without contacting Vault
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	requests := len(vault.Requests())
+	output = captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate", "approle",
+			"--vault-address=" + vault.URL,
+			"--cache-dir=" + cacheDir,
+		})
+		require.NoError(t, cmd.Execute())
+	})
+	var execCredential clientauthentication.ExecCredential
+	require.NoError(t, json.Unmarshal([]byte(output), &execCredential))
+	assert.Equal(t, "fake-k8s-token-2", execCredential.Status.Token)
+	assert.Len(t, vault.Requests(), requests)
+}
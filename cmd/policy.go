@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+
+	"github.com/spf13/cobra"
+)
+
+// const to define cobra command flag names that only apply to the policy subcommands
+const (
+	flagPolicyClusters = "clusters"
+	flagPolicyFormat   = "format"
+)
+
+// Policy() creates a policy cobra subcommand grouping the commands that derive the Vault policy federation needs
+func Policy() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "policy [command]",
+		Args:  cobra.NoArgs,
+		Short: "Generates and checks the least-privilege Vault policy of the login role",
+		Long: `kubectl-vaultlogin policy derives the Vault policy the login role needs from the effective configuration:
+the configuration file, profile, environment and templated mounts and roles, as federate resolves them.
+Run it with the flags federate is run with in the kubeconfig.`,
+	}
+	cmd.AddCommand(PolicyGenerate())
+	cmd.AddCommand(PolicyCheck())
+	return cmd
+}
+
+// PolicyGenerate() creates a generate cobra subcommand printing the Vault policy allowing the credential requests
+// of the clusters. It has one subcommand per registered vault authentication method, Vault is not contacted
+func PolicyGenerate() *cobra.Command {
+	var (
+		clusters []string
+		format   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "generate [command]",
+		Args:  cobra.NoArgs,
+		Short: "Prints the least-privilege Vault policy allowing the credential requests of the clusters",
+		Long: `kubectl-vaultlogin policy generate prints to STDOUT the Vault policy allowing the credential requests of --clusters,
+by default of every cluster of the configuration file and --cluster-name, in Vault's HCL or JSON syntax. Vault is not contacted.
+Logins need no policy, and the renewal of the token is allowed by Vault's default policy.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringSliceVar(&clusters, flagPolicyClusters, nil, "downstream clusters the policy allows credential requests for, ex. dev,prod. Defaults to the clusters of the configuration file and --cluster-name")
+	cmd.PersistentFlags().StringVar(&format, flagPolicyFormat, "hcl", "syntax of the policy, hcl or json")
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		if format != "hcl" && format != "json" {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("--%s must be hcl or json: %s", flagPolicyFormat, format))
+		}
+		_, policy, err := loadPolicy(opts, clusters)
+		if err != nil {
+			return err
+		}
+		if format == "hcl" {
+			fmt.Fprint(cmd.OutOrStdout(), policy.HCL())
+			return nil
+		}
+		encoded, err := policy.JSON()
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.Output, err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(encoded))
+		return nil
+	})...)
+
+	return cmd
+}
+
+// PolicyCheck() creates a check cobra subcommand comparing the generated Vault policy with the capabilities of a live
+// token. It has one subcommand per registered vault authentication method, which it logs in with
+func PolicyCheck() *cobra.Command {
+	var clusters []string
+
+	cmd := &cobra.Command{
+		Use:   "check [command]",
+		Args:  cobra.NoArgs,
+		Short: "Compares the generated Vault policy with the capabilities of a live token",
+		Long: `kubectl-vaultlogin policy check logs in to Vault, asks sys/capabilities-self for the capabilities of the token
+on each path of the generated policy and prints them next to the required ones.
+It fails when the token lacks a required capability. Capabilities beyond the policy are reported as extra.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringSliceVar(&clusters, flagPolicyClusters, nil, "downstream clusters whose credential requests are checked, ex. dev,prod. Defaults to the clusters of the configuration file and --cluster-name")
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		opts.OneShot = true
+		federator, policy, err := loadPolicy(opts, clusters)
+		if err != nil {
+			return err
+		}
+		checks, err := checkPolicy(context.Background(), federator, policy)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tREQUIRED\tGRANTED\tSTATUS")
+		var failed int
+		for _, check := range checks {
+			status := "ok"
+			switch {
+			case len(check.Missing) > 0:
+				failed++
+				status = "missing " + strings.Join(check.Missing, ",")
+			case len(check.Extra) > 0:
+				status = "extra " + strings.Join(check.Extra, ",")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", check.Rule.Path, strings.Join(check.Rule.Capabilities, ","), strings.Join(check.Granted, ","), status)
+		}
+		if err := tw.Flush(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.Output, err)
+		}
+		if failed > 0 {
+			return kvlerrors.Wrap(kvlerrors.General, fmt.Errorf("the token lacks required capabilities on %d of %d paths", failed, len(checks)))
+		}
+		return nil
+	})...)
+
+	return cmd
+}
+
+// checkPolicy logs in and checks the capabilities of the token on the paths of policy. When the login depends on the
+// downstream cluster, the token of each cluster is checked on the paths of its credential requests
+func checkPolicy(ctx context.Context, federator *federate.Federator, policy *federate.Policy) ([]federate.PolicyCheck, error) {
+	if !federator.LoginDependsOnCluster() {
+		session, err := federator.Login(ctx, federate.TemplateData{})
+		if err != nil {
+			return nil, kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		checks, err := policy.Check(ctx, session.Client())
+		if err != nil {
+			return nil, kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		return checks, nil
+	}
+
+	var checks []federate.PolicyCheck
+	for _, clusterName := range policy.Clusters() {
+		clusterPolicy, err := federator.Policy([]string{clusterName})
+		if err != nil {
+			return nil, kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		session, err := federator.Login(ctx, federator.TemplateData(clusterName))
+		if err != nil {
+			return nil, kvlerrors.Wrap(kvlerrors.General, fmt.Errorf("cluster %s: %w", clusterName, err))
+		}
+		clusterChecks, err := clusterPolicy.Check(ctx, session.Client())
+		if err != nil {
+			return nil, kvlerrors.Wrap(kvlerrors.General, fmt.Errorf("cluster %s: %w", clusterName, err))
+		}
+		checks = append(checks, clusterChecks...)
+	}
+	return checks, nil
+}
+
+// loadPolicy returns the federator of opts and the policy of clusters, else of the clusters of the configuration file
+// and the cluster-name flag
+func loadPolicy(opts federate.Options, clusters []string) (*federate.Federator, *federate.Policy, error) {
+	if err := opts.LoadEnv(); err != nil {
+		return nil, nil, kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+	federator, err := federate.NewFederator(opts)
+	if err != nil {
+		return nil, nil, kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+	clusterNames := clusters
+	if len(clusterNames) == 0 {
+		for clusterName := range opts.Sources {
+			clusterNames = append(clusterNames, clusterName)
+		}
+		if opts.ClusterName != "" && !slices.Contains(clusterNames, opts.ClusterName) {
+			clusterNames = append(clusterNames, opts.ClusterName)
+		}
+		slices.Sort(clusterNames)
+	}
+	if len(clusterNames) == 0 {
+		return nil, nil, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("no clusters, set --%s, --%s or list clusters in the configuration file", flagPolicyClusters, federate.FlagClusterName))
+	}
+	policy, err := federator.Policy(clusterNames)
+	if err != nil {
+		return nil, nil, kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+	return federator, policy, nil
+}
@@ -0,0 +1,91 @@
+// cmd/policy_test.go
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePolicyConfig selects a configuration file listing a kubernetes and a kv cluster in the environment live
+func writePolicyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vaultlogin.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+environment: live
+clusters:
+  dev:
+    role: kvl-view-role
+  legacy:
+    source: kv
+    path: clusters/legacy
+`), 0600))
+	viper.Set(federate.FlagConfig, path)
+	t.Cleanup(func() { viper.Set(federate.FlagConfig, "") })
+}
+
+// tests the policy of the clusters of the configuration file is printed as HCL and JSON
+func TestPolicyGenerate(t *testing.T) {
+	writePolicyConfig(t)
+	run := func(args ...string) string {
+		buf := new(bytes.Buffer)
+		cmd := New()
+		cmd.SetOut(buf)
+		cmd.SetArgs(append([]string{"policy", "generate", "token", "--vault-secrets-mount=/k8s-creds/{{.Environment}}/{{.ClusterName}}"}, args...))
+		require.NoError(t, cmd.Execute())
+		return buf.String()
+	}
+
+	output := run()
+	assert.Contains(t, output, "# clusters: dev\npath \"k8s-creds/live/dev/creds/kvl-view-role\" {\n  capabilities = [\"update\"]\n}\n")
+	assert.Contains(t, output, "path \"secret/data/clusters/legacy\" {\n  capabilities = [\"read\"]\n}\n")
+
+	output = run("--format=json", "--clusters=prod")
+	var decoded map[string]map[string]map[string][]string
+	require.NoError(t, json.Unmarshal([]byte(output), &decoded))
+	assert.Equal(t, map[string]map[string][]string{"k8s-creds/live/prod/creds/kvl-edit-role": {"capabilities": {"update"}}}, decoded["path"])
+}
+
+// tests invalid formats and missing clusters are rejected
+func TestPolicyGenerateErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cmd := New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs([]string{"policy", "generate", "token"})
+	assert.ErrorContains(t, cmd.Execute(), "no clusters, set --clusters, --cluster-name or list clusters in the configuration file")
+
+	cmd = New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs([]string{"policy", "generate", "token", "--cluster-name=dev", "--format=yaml"})
+	assert.ErrorContains(t, cmd.Execute(), "--format must be hcl or json: yaml")
+}
+
+// tests a check reports the capabilities of a live token and fails when one is missing
+func TestPolicyCheck(t *testing.T) {
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	vault.SetCapabilities("kubernetes/dev/creds/kvl-view-role", "update")
+	vault.SetCapabilities("secret/data/clusters/legacy", "read", "list")
+	writePolicyConfig(t)
+
+	buf := new(bytes.Buffer)
+	cmd := New()
+	cmd.SetOut(buf)
+	cmd.SetArgs([]string{"policy", "check", "token", "--vault-address=" + vault.URL})
+	require.NoError(t, cmd.Execute())
+	assert.Regexp(t, `kubernetes/dev/creds/kvl-view-role +update +update +ok\n`, buf.String())
+	assert.Regexp(t, `secret/data/clusters/legacy +read +read,list +extra list\n`, buf.String())
+
+	buf.Reset()
+	cmd = New()
+	cmd.SetOut(buf)
+	cmd.SetArgs([]string{"policy", "check", "token", "--vault-address=" + vault.URL, "--clusters=dev,prod"})
+	assert.ErrorContains(t, cmd.Execute(), "the token lacks required capabilities on 1 of 2 paths")
+	assert.Regexp(t, `kubernetes/prod/creds/kvl-edit-role +update +deny +missing update\n`, buf.String())
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/clientgo"
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/proxy"
+
+	"github.com/spf13/cobra"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/client-go/rest"
+)
+
+// const to define cobra command flag names that apply to the proxy and exec subcommands
+const (
+	flagDownstreamCluster    = "cluster"
+	flagServer               = "server"
+	flagCertificateAuthority = "certificate-authority"
+	flagRefreshMargin        = "refresh-margin"
+)
+
+// const to define cobra command flag names that only apply to the proxy subcommand
+const (
+	flagProxyListen = "listen"
+)
+
+// defaultProxyListen is the address the proxy listens on, the one of kubectl proxy
+const defaultProxyListen = "127.0.0.1:8001"
+
+// proxyListening is notified of the proxy's listener, tests use it to learn the address of --listen=127.0.0.1:0
+var proxyListening = func(l net.Listener) {}
+
+// Proxy() creates a proxy cobra subcommand that serves a downstream kubernetes API server on a local address,
+// authenticating the requests it forwards. It has one subcommand per registered vault authentication method
+func Proxy() *cobra.Command {
+	var (
+		clusterName   string
+		listen        string
+		server        string
+		caFile        string
+		kubeconfig    string
+		kubeContext   string
+		refreshMargin time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "proxy [command]",
+		Args:  cobra.NoArgs,
+		Short: "Serves a downstream kubernetes API server locally, authenticating requests with Vault issued bearer tokens",
+		Long: `kubectl-vaultlogin proxy forwards the plain http requests of local clients unable to run exec credential plugins,
+ex. dashboards and curl scripts, to a downstream kubernetes API server. Each request is sent with the cluster's
+kubernetes bearer token issued by Vault, which is replaced before it expires, and credentials supplied by clients are removed.
+Watches and logs are streamed, and exec, attach and port-forward connections are upgraded.
+The API server and its CA are --server and --certificate-authority, else those of the kubeconfig context.
+The cluster name defaults to --cluster-name, else to the first label of the API server's host, as federate derives it.
+Anyone able to connect to --listen acts with the token's permissions, keep it on a loopback address.`,
+	}
+
+	// init - configure flags that apply to this subcommand and its children
+	cmd.PersistentFlags().StringVar(&clusterName, flagDownstreamCluster, "", "downstream cluster name tokens are requested for, defaults to --cluster-name, then the first label of the API server's host")
+	cmd.PersistentFlags().StringVar(&listen, flagProxyListen, defaultProxyListen, "address the proxy listens on for plain http requests")
+	cmd.PersistentFlags().StringVar(&server, flagServer, "", "URL of the downstream API server, defaults to the server of the kubeconfig context")
+	cmd.PersistentFlags().StringVar(&caFile, flagCertificateAuthority, "", "PEM file of the CA of the downstream API server, defaults to the one of the kubeconfig context with --server unset, else the system roots")
+	cmd.PersistentFlags().StringVar(&kubeconfig, flagKubeconfig, "", "kubeconfig of the downstream cluster, defaults to KUBECONFIG, then ~/.kube/config")
+	cmd.PersistentFlags().StringVar(&kubeContext, flagKubeContext, "", "kubeconfig context of the downstream cluster, defaults to the current context")
+	cmd.PersistentFlags().DurationVar(&refreshMargin, flagRefreshMargin, 2*time.Minute, "how long before expiration the kubernetes bearer token gets replaced, but not before half of its lifetime")
+
+	// Add subcommands, one per registered vault authentication method
+	cmd.AddCommand(authSubcommands(func(cmd *cobra.Command, opts federate.Options) error {
+		if err := opts.LoadEnv(); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		federator, err := federate.NewFederator(opts)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		config, err := downstreamServer(server, caFile, kubeconfig, kubeContext)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		tlsConfig, err := rest.TLSConfigFor(config)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		if clusterName, err = downstreamClusterName(federator, opts, clusterName, config.Host); err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+
+		p, err := proxy.New(proxy.Config{
+			Server:    config.Host,
+			TLSConfig: tlsConfig,
+			Tokens:    clientgo.NewTokenSource(federator, clusterName, refreshMargin),
+			Logger:    log.New(os.Stderr, "[kubectl-vaultlogin proxy] ", log.LstdFlags),
+		})
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, err)
+		}
+		l, err := net.Listen("tcp", listen)
+		if err != nil {
+			return kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("cannot listen on %s: %w", listen, err))
+		}
+		proxyListening(l)
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := p.Serve(ctx, l); err != nil {
+			return kvlerrors.Wrap(kvlerrors.General, err)
+		}
+		return nil
+	})...)
+
+	return cmd
+}
+
+// downstreamServer returns the API server and CA of a downstream cluster: server and caFile, else those of
+// kubeContext in kubeconfig. Only the API server and its CA are taken from the kubeconfig, its credentials are never used
+func downstreamServer(server string, caFile string, kubeconfig string, kubeContext string) (*rest.Config, error) {
+	if server != "" {
+		return &rest.Config{Host: server, TLSClientConfig: rest.TLSClientConfig{CAFile: caFile}}, nil
+	}
+	kubeconfigConfig, err := kubeconfigContext(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return &rest.Config{
+		Host: kubeconfigConfig.Host,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure:   kubeconfigConfig.Insecure,
+			ServerName: kubeconfigConfig.ServerName,
+			CAFile:     kubeconfigConfig.CAFile,
+			CAData:     kubeconfigConfig.CAData,
+		},
+	}, nil
+}
+
+// downstreamClusterName returns clusterName, else the cluster-name flag, else the name federate derives from the
+// API server's host
+func downstreamClusterName(federator *federate.Federator, opts federate.Options, clusterName string, host string) (string, error) {
+	if clusterName != "" {
+		return clusterName, nil
+	}
+	if opts.ClusterName != "" {
+		return opts.ClusterName, nil
+	}
+	execCredential := &clientauthentication.ExecCredential{Spec: clientauthentication.ExecCredentialSpec{Cluster: &clientauthentication.Cluster{Server: host}}}
+	return federator.ClusterName(execCredential)
+}
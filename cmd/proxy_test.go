@@ -0,0 +1,61 @@
+// cmd/proxy_test.go
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests the proxy forwards requests to the API server of --server with a token of the kubernetes secret engine
+func TestProxy(t *testing.T) {
+	vault := startFakeVault(t)
+	t.Setenv("VAULT_TOKEN", vault.IssueToken())
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(apiServer.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: apiServer.Certificate().Raw}), 0600))
+
+	listening := make(chan net.Listener, 1)
+	proxyListening = func(l net.Listener) { listening <- l }
+	t.Cleanup(func() { proxyListening = func(net.Listener) {} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	cmd := New()
+	cmd.SetOut(new(bytes.Buffer))
+	cmd.SetArgs([]string{"proxy", "token", "--vault-address=" + vault.URL, "--cluster=k8s", "--listen=127.0.0.1:0",
+		"--server=" + apiServer.URL, "--certificate-authority=" + caFile})
+	go func() { done <- cmd.ExecuteContext(ctx) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	var l net.Listener
+	select {
+	case l = <-listening:
+	case err := <-done:
+		t.Fatalf("proxy exited: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/api/v1/pods", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer client-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Regexp(t, `^/api/v1/pods Bearer fake-k8s-token-\d+$`, string(body))
+}
@@ -6,19 +6,45 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/client-go/pkg/apis/clientauthentication"
 )
 
+// mockExecInfo is an ExecCredential for the downstream cluster k8s
+const mockExecInfo = `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com","config":null},"interactive":false}}`
+
+// startFakeVault starts a fake Vault issuing kubernetes bearer tokens for the downstream cluster k8s
+// and points the commands at it for the duration of the test
+func startFakeVault(t *testing.T) *vaultfake.Server {
+	vault := vaultfake.New()
+	vault.AddKubernetesCreds("/kubernetes/k8s", "kvl-edit-role")
+	vaultHTTPClient = vault.Client()
+	t.Cleanup(func() {
+		vaultHTTPClient = nil
+		vault.Close()
+	})
+	return vault
+}
+
+// writePsat writes a projected service account token to a temporary file and returns its path
+func writePsat(t *testing.T, token string) string {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte(token), 0600))
+	return path
+}
+
 func TestFederatePsatSubcmd(t *testing.T) {
 	// Create a buffer to capture command output
 	buf := new(bytes.Buffer)
 
 	// create root command
-	cmd := New(true)
+	cmd := New()
 
 	// Set the output of the command to the buffer
 	cmd.SetOut(buf)
@@ -54,14 +80,19 @@ func captureOutput(f func()) string {
 
 func TestFederatePsatEndToEnd(t *testing.T) {
 	var execCredential clientauthentication.ExecCredential
-	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com","config":null},"interactive":false}}`)
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	vault.AddKubernetesLogin("/kubernetes/argocd", "kvl-login", "psat-jwt")
+	psatPath := writePsat(t, "psat-jwt")
 
 	output := captureOutput(func() {
 		// create root command
-		cmd := New(true)
+		cmd := New()
 		// Execute the command with arguments
 		cmd.SetArgs([]string{"federate", "psat",
+			"--vault-address=" + vault.URL,
 			"--vault-kubernetes-auth-mount=/kubernetes/argocd",
+			"--psat-path=" + psatPath,
 		})
 		err := cmd.Execute()
 		assert.NoError(t, err)
@@ -72,4 +103,64 @@ func TestFederatePsatEndToEnd(t *testing.T) {
 	if err != nil || execCredential.Status.Token == "" {
 		t.Error("Didn't receive a valid ExecCredential")
 	}
+	assert.Equal(t, "fake-k8s-token-2", execCredential.Status.Token)
+	assert.Equal(t, []string{"POST auth/kubernetes/argocd/login", "POST kubernetes/k8s/creds/kvl-edit-role"}, vault.Requests())
+}
+
+func TestFederatePsatVaultFailures(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	vault.AddKubernetesLogin("/kubernetes/argocd", "kvl-login", "psat-jwt")
+
+	tests := []struct {
+		name          string
+		psat          string
+		failPath      string
+		failure       vaultfake.Failure
+		expectedError string
+	}{
+		{name: "login denied", psat: "another-jwt", expectedError: "KubernetesLogin: .*permission denied"},
+		{name: "creds denied", psat: "psat-jwt", failPath: "kubernetes/k8s/creds/kvl-edit-role", failure: vaultfake.Forbidden, expectedError: "cluster=k8s, role=kvl-edit-role, .*permission denied"},
+		{name: "malformed login response", psat: "psat-jwt", failPath: "auth/kubernetes/argocd/login", failure: vaultfake.Malformed, expectedError: "KubernetesLogin: "},
+		{name: "sealed", psat: "psat-jwt", failPath: vaultfake.AnyPath, failure: vaultfake.Sealed, expectedError: "Vault is sealed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.failPath != "" {
+				vault.Fail(tt.failPath, tt.failure)
+				defer vault.Recover(tt.failPath)
+			}
+			var err error
+			output := captureOutput(func() {
+				cmd := New()
+				cmd.SetArgs([]string{"federate", "psat",
+					"--vault-address=" + vault.URL,
+					"--vault-kubernetes-auth-mount=/kubernetes/argocd",
+					"--psat-path=" + writePsat(t, tt.psat),
+				})
+				err = cmd.Execute()
+			})
+			assert.Regexp(t, tt.expectedError, err)
+			assert.Empty(t, output)
+		})
+	}
+}
+
+func TestFederatePsatDryRun(t *testing.T) {
+	t.Setenv("KUBERNETES_EXEC_INFO", mockExecInfo)
+	vault := startFakeVault(t)
+	vault.AddKubernetesLogin("/kubernetes/argocd", "kvl-login", "psat-jwt")
+
+	output := captureOutput(func() {
+		cmd := New()
+		cmd.SetArgs([]string{"federate", "psat", "--dry-run",
+			"--vault-address=" + vault.URL,
+			"--vault-kubernetes-auth-mount=/kubernetes/argocd",
+			"--psat-path=" + writePsat(t, "psat-jwt"),
+		})
+		assert.NoError(t, cmd.Execute())
+	})
+	// the plan goes to STDERR and Vault is not contacted
+	assert.Empty(t, output)
+	assert.Empty(t, vault.Requests())
 }
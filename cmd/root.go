@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
 	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
@@ -14,10 +16,27 @@ import (
 
 var VaultAddress string
 var DownstreamClusterName string
+var ConfigFile string
+var ErrorFormat string
+var CacheDir string
+var VaultNamespace string
+var VaultAuthNamespace string
+var VaultSecretsNamespace string
+var VaultCACert string
+var VaultCAPath string
+var VaultClientCert string
+var VaultClientKey string
+var VaultTLSServerName string
+var VaultTimeout time.Duration
+var VaultProxy string
+var AllowHTTP bool
+var SecretsMount string
+var SecretRole string
+var Environment string
+var Profile map[string]string
 
 // New() creates a new cobra Root Command
-// test bool is used to designate if the instance is a test run (true) or actual request (false)
-func New(test bool) *cobra.Command {
+func New() *cobra.Command {
 
 	cmd := &cobra.Command{
 		Use:     "kubectl-vaultlogin",
@@ -28,37 +47,141 @@ func New(test bool) *cobra.Command {
 that leverages Hashicorp Vault to request just-in-time short-lived kubernetes bearer tokens.
 It expects an ExecCredential to be passed in KUBERNETES_EXEC_INFO environment variable
 and produces a corresponding ExecCredential with a token and expiration, which it prints to STDOUT`,
+		// errors are printed by Execute according to --error-format
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if format := viper.GetString(federate.FlagErrorFormat); format != "text" && format != "json" {
+				return kvlerrors.Wrap(kvlerrors.Usage, fmt.Errorf("invalid argument %q for \"--%s\" flag: expected text or json", format, federate.FlagErrorFormat))
+			}
+			// cobra checks required and grouped flags after this hook, they are checked here to classify the errors
+			if err := cmd.ValidateRequiredFlags(); err != nil {
+				return kvlerrors.Wrap(kvlerrors.Usage, err)
+			}
+			if err := cmd.ValidateFlagGroups(); err != nil {
+				return kvlerrors.Wrap(kvlerrors.Usage, err)
+			}
+			return nil
+		},
 	}
 
 	// init
-	cmd.PersistentFlags().StringVarP(&VaultAddress, federate.FlagVaultAddress, "v", "https://localhost:8200", "full URL with port to Hashicorp Vault.")
-	cmd.MarkFlagRequired(federate.FlagVaultAddress)
+	cmd.PersistentFlags().StringVarP(&VaultAddress, federate.FlagVaultAddress, "v", "", "full URL with port to Hashicorp Vault. Defaults to the configuration file, then VAULT_ADDR, then "+federate.DefaultVaultAddress)
 	viper.BindPFlag(federate.FlagVaultAddress, cmd.PersistentFlags().Lookup(federate.FlagVaultAddress))
 
+	cmd.PersistentFlags().BoolVar(&AllowHTTP, federate.FlagAllowHTTP, false, "allow a plain http vault-address, ex. of a local dev server. Never use it with a production Vault")
+	viper.BindPFlag(federate.FlagAllowHTTP, cmd.PersistentFlags().Lookup(federate.FlagAllowHTTP))
+
+	cmd.PersistentFlags().StringVar(&VaultCACert, federate.FlagVaultCACert, "", "PEM file of the CA certificates trusted to sign Vault's certificate. Defaults to the configuration file, then VAULT_CACERT")
+	viper.BindPFlag(federate.FlagVaultCACert, cmd.PersistentFlags().Lookup(federate.FlagVaultCACert))
+
+	cmd.PersistentFlags().StringVar(&VaultCAPath, federate.FlagVaultCAPath, "", "directory of PEM files of the CA certificates trusted to sign Vault's certificate. Defaults to the configuration file, then VAULT_CAPATH")
+	viper.BindPFlag(federate.FlagVaultCAPath, cmd.PersistentFlags().Lookup(federate.FlagVaultCAPath))
+
+	cmd.PersistentFlags().StringVar(&VaultClientCert, federate.FlagVaultClientCert, "", "PEM file of the client certificate presented to Vault. Defaults to the configuration file, then VAULT_CLIENT_CERT")
+	viper.BindPFlag(federate.FlagVaultClientCert, cmd.PersistentFlags().Lookup(federate.FlagVaultClientCert))
+
+	cmd.PersistentFlags().StringVar(&VaultClientKey, federate.FlagVaultClientKey, "", "PEM file of the private key of the client certificate. Defaults to the configuration file, then VAULT_CLIENT_KEY")
+	viper.BindPFlag(federate.FlagVaultClientKey, cmd.PersistentFlags().Lookup(federate.FlagVaultClientKey))
+
+	cmd.PersistentFlags().StringVar(&VaultTLSServerName, federate.FlagVaultTLSServerName, "", "name expected in Vault's certificate, defaults to the configuration file, then VAULT_TLS_SERVER_NAME, then the host of vault-address")
+	viper.BindPFlag(federate.FlagVaultTLSServerName, cmd.PersistentFlags().Lookup(federate.FlagVaultTLSServerName))
+
+	cmd.PersistentFlags().DurationVar(&VaultTimeout, federate.FlagVaultTimeout, 0, "timeout of each request to Vault. Defaults to the configuration file, then VAULT_CLIENT_TIMEOUT, then 30s")
+	viper.BindPFlag(federate.FlagVaultTimeout, cmd.PersistentFlags().Lookup(federate.FlagVaultTimeout))
+
+	cmd.PersistentFlags().StringVar(&VaultProxy, federate.FlagVaultProxy, "", "URL of an http, https or socks5 proxy to Vault, ex. socks5://127.0.0.1:1080. Defaults to the configuration file, then VAULT_PROXY_ADDR, then HTTPS_PROXY and NO_PROXY")
+	viper.BindPFlag(federate.FlagVaultProxy, cmd.PersistentFlags().Lookup(federate.FlagVaultProxy))
+
+	cmd.PersistentFlags().StringVar(&VaultNamespace, federate.FlagVaultNamespace, "", "Vault Enterprise namespace of all requests, ex. team-a/dev. Defaults to the configuration file, then VAULT_NAMESPACE, then the root namespace")
+	viper.BindPFlag(federate.FlagVaultNamespace, cmd.PersistentFlags().Lookup(federate.FlagVaultNamespace))
+
+	cmd.PersistentFlags().StringVar(&VaultAuthNamespace, federate.FlagVaultAuthNamespace, "", "Vault Enterprise namespace of the auth method login, overrides --"+federate.FlagVaultNamespace)
+	viper.BindPFlag(federate.FlagVaultAuthNamespace, cmd.PersistentFlags().Lookup(federate.FlagVaultAuthNamespace))
+
+	cmd.PersistentFlags().StringVar(&VaultSecretsNamespace, federate.FlagVaultSecretsNamespace, "", "Vault Enterprise namespace of the kubernetes secrets engine, overrides --"+federate.FlagVaultNamespace)
+	viper.BindPFlag(federate.FlagVaultSecretsNamespace, cmd.PersistentFlags().Lookup(federate.FlagVaultSecretsNamespace))
+
 	cmd.PersistentFlags().StringVarP(&DownstreamClusterName, federate.FlagClusterName, "c", "", "a downstream cluster name, this must be consistent with the name that is used in the kubernetes secret engine path /kubernetes/<clustername>")
 	viper.BindPFlag(federate.FlagClusterName, cmd.PersistentFlags().Lookup(federate.FlagClusterName))
 
+	cmd.PersistentFlags().StringVar(&SecretsMount, federate.FlagVaultSecretsMount, "", "mount point of the kubernetes secret engine, a Go template over .ClusterName, .ClusterServer, .Environment and .Profile ex. /k8s-creds/{{.Environment}}/{{.ClusterName}}. Defaults to /kubernetes/{{.ClusterName}}")
+	viper.BindPFlag(federate.FlagVaultSecretsMount, cmd.PersistentFlags().Lookup(federate.FlagVaultSecretsMount))
+
+	cmd.PersistentFlags().StringVar(&SecretRole, federate.FlagVaultSecretRole, "", "role in the kubernetes secret engine, a Go template ex. {{.Profile.team}}-deployer. Defaults to VAULT_K8S_SECRET_ROLE, then kvl-edit-role")
+	viper.BindPFlag(federate.FlagVaultSecretRole, cmd.PersistentFlags().Lookup(federate.FlagVaultSecretRole))
+
+	cmd.PersistentFlags().StringVar(&Environment, federate.FlagEnvironment, "", "environment templates refer to as {{.Environment}}, defaults to environment in the configuration file")
+	viper.BindPFlag(federate.FlagEnvironment, cmd.PersistentFlags().Lookup(federate.FlagEnvironment))
+
+	cmd.PersistentFlags().StringToStringVar(&Profile, federate.FlagProfile, nil, "profile fields templates refer to as {{.Profile.<key>}}, ex. team=payments. They override profile in the configuration file")
+	viper.BindPFlag(federate.FlagProfile, cmd.PersistentFlags().Lookup(federate.FlagProfile))
+
+	cmd.PersistentFlags().StringVar(&ConfigFile, federate.FlagConfig, "", "configuration file selecting a credential source per downstream cluster, defaults to $HOME/.kube/vaultlogin.yaml when it exists")
+	viper.BindPFlag(federate.FlagConfig, cmd.PersistentFlags().Lookup(federate.FlagConfig))
+
+	cmd.PersistentFlags().StringVar(&CacheDir, federate.FlagCacheDir, "", "directory of the on-disk credential cache, federate answers from it while a cached token is valid and federate-all fills it. Disabled when unset")
+	viper.BindPFlag(federate.FlagCacheDir, cmd.PersistentFlags().Lookup(federate.FlagCacheDir))
+
+	cmd.PersistentFlags().StringVar(&ErrorFormat, federate.FlagErrorFormat, "text", "format of errors printed to STDERR, text or json. The exit code reflects the error category either way")
+	viper.BindPFlag(federate.FlagErrorFormat, cmd.PersistentFlags().Lookup(federate.FlagErrorFormat))
+
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 
 	// Add subcommands
-	cmd.AddCommand(Federate(test))
+	cmd.AddCommand(Federate())
+	cmd.AddCommand(FederateAll())
+	cmd.AddCommand(Broker())
+	cmd.AddCommand(Admin())
+	cmd.AddCommand(Policy())
+	cmd.AddCommand(Proxy())
+	cmd.AddCommand(Exec())
 	cmd.AddCommand(version.WithFont(""))
 
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return kvlerrors.Wrap(kvlerrors.Usage, err)
+	})
+	classifyArgsErrors(cmd)
+
 	return cmd
 }
 
+// classifyArgsErrors wraps the positional argument validators of cmd and its subcommands, so that their
+// errors, ex. an unknown command, are reported as usage errors
+func classifyArgsErrors(cmd *cobra.Command) {
+	if validate := cmd.Args; validate != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			return kvlerrors.Wrap(kvlerrors.Usage, validate(cmd, args))
+		}
+	}
+	for _, subcommand := range cmd.Commands() {
+		classifyArgsErrors(subcommand)
+	}
+}
+
+// Execute runs the root command and exits with the code of the error category, see pkg/errors, when it fails
 func Execute() {
 	log.SetPrefix("Error [kubectl-vaultlogin]: ")
 	// log.SetFlags(log.Ldate | log.Ltime | log.LUTC | log.Lmsgprefix)
 	log.SetFlags(log.Lmsgprefix)
-	var kvlErr kvlerrors.KvlError
-	// launching New command and passing false to indicate this is not a test run but a legitimate request
-	if err := New(false).Execute(); err != nil {
-		if errors.As(err, &kvlErr) {
-			log.Fatalf("%v", err)
-		} else {
-			os.Exit(2)
+	if err := New().Execute(); err != nil {
+		// the exit code of a command run by exec is passed on, the command reported its own errors
+		if !errors.As(err, &kvlerrors.ExitStatus{}) {
+			printError(err)
 		}
+		os.Exit(kvlerrors.ExitCode(err))
+	}
+}
+
+// printError writes err to STDERR as JSON when --error-format=json is set. Otherwise plugin errors are logged
+// and command line errors are printed the way cobra does
+func printError(err error) {
+	switch {
+	case viper.GetString(federate.FlagErrorFormat) == "json":
+		kvlerrors.WriteJSON(os.Stderr, err)
+	case kvlerrors.CategoryOf(err) == kvlerrors.Usage:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	default:
+		log.Printf("%v", err)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"testing"
 
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,7 +15,7 @@ func TestRootCmd(t *testing.T) {
 	buf := new(bytes.Buffer)
 
 	// create root command
-	cmd := New(true)
+	cmd := New()
 
 	// Set the output of the command to the buffer
 	cmd.SetOut(buf)
@@ -32,3 +33,22 @@ func TestRootCmd(t *testing.T) {
 	// assert.Equal(t, expectedOutput, output)
 	assert.Regexp(t, regexp.MustCompile(expectedPattern), output)
 }
+
+// tests errors of the command line parser are usage errors, whichever command reports them
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"--bogus"},
+		{"federate", "token", "--vault-timeout=soon"},
+		{"federate", "token", "bogus"},
+		{"federate-all", "token"},
+		{"federate", "token", "--error-format=xml"},
+	} {
+		cmd := New()
+		cmd.SetOut(new(bytes.Buffer))
+		cmd.SetErr(new(bytes.Buffer))
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		assert.Error(t, err, args)
+		assert.Equal(t, kvlerrors.Usage, kvlerrors.CategoryOf(err), args)
+	}
+}
@@ -0,0 +1,540 @@
+// Package admin provisions Hashicorp Vault and downstream clusters for kubectl-vaultlogin. Every operation is
+// idempotent: objects that already exist as desired are left unchanged, so it can be rerun safely
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaults of a ClusterRegistration
+const (
+	DefaultServiceAccountNamespace = "kube-system"
+	DefaultServiceAccountName      = "kvl-vault"
+	DefaultKubernetesRole          = "edit"
+	defaultTokenTimeout            = 30 * time.Second
+)
+
+// managedByKey is the key of the label marking the kubernetes objects created by kubectl-vaultlogin, Teardown only deletes those
+const managedByKey = "app.kubernetes.io/managed-by"
+
+// managedByLabel marks the kubernetes objects created by kubectl-vaultlogin
+var managedByLabel = map[string]string{managedByKey: "kubectl-vaultlogin"}
+
+// tokenPollInterval is how often the service account token secret is checked until kubernetes populates it
+var tokenPollInterval = time.Second
+
+// Action is a change made to a downstream cluster or to Vault, or planned in a dry run
+type Action struct {
+	// Verb is create, update, unchanged, delete or absent
+	Verb string
+	// Object describes the kubernetes object or Vault path, ex. ServiceAccount kube-system/kvl-vault
+	Object string
+	// Changes describe the fields set by a create or update, ex. audience: "" -> "vault"
+	Changes []string
+}
+
+func (a Action) String() string {
+	return a.Verb + " " + a.Object
+}
+
+// ClusterRegistration provisions Vault's kubernetes secret engine for a downstream cluster: a service account
+// and RBAC for Vault in the cluster, the secret engine mount, its config and the role federate requests tokens of
+type ClusterRegistration struct {
+	// ClusterName is the downstream cluster name
+	ClusterName string
+	// Mount and Role are the secret engine mount point and the role federate requests tokens of
+	Mount string
+	Role  string
+	// KubernetesHost is the URL Vault reaches the cluster's API server at
+	KubernetesHost string
+	// KubernetesCACert is the PEM encoded CA of the API server, the one of the service account token secret when empty
+	KubernetesCACert string
+	// ServiceAccountNamespace and ServiceAccountName name the service account Vault manages the cluster with,
+	// default to kube-system and kvl-vault. Its token secret, ClusterRole and ClusterRoleBinding share its name
+	ServiceAccountNamespace string
+	ServiceAccountName      string
+	// AllowedNamespaces are the namespaces tokens can be requested for, they are created when missing
+	AllowedNamespaces []string
+	// KubernetesRole is the ClusterRole bound to the service accounts Vault generates, defaults to edit
+	KubernetesRole string
+	// TokenDefaultTTL and TokenMaxTTL of the generated tokens, Vault's defaults when 0
+	TokenDefaultTTL time.Duration
+	TokenMaxTTL     time.Duration
+	// TokenTimeout is how long to wait for kubernetes to populate the service account token secret, defaults to 30s
+	TokenTimeout time.Duration
+	// DryRun reads the cluster and Vault and reports the actions without making them
+	DryRun bool
+
+	Kubernetes kubernetes.Interface
+	Vault      federate.VaultAPI
+}
+
+// Validate checks the registration and applies defaults
+func (r *ClusterRegistration) Validate() error {
+	if r.ClusterName == "" {
+		return fmt.Errorf("the downstream cluster name is unset")
+	}
+	if !strings.HasPrefix(r.Mount, "/") || strings.Trim(r.Mount, "/") == "" {
+		return fmt.Errorf("secrets mount must be an absolute path, ex. /kubernetes/%s: %s", r.ClusterName, r.Mount)
+	}
+	if r.Role == "" {
+		return fmt.Errorf("the secret role is unset")
+	}
+	if u, err := url.Parse(r.KubernetesHost); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("the kubernetes API server must be an https URL: %s", r.KubernetesHost)
+	}
+	if len(r.AllowedNamespaces) == 0 {
+		return fmt.Errorf("at least one allowed kubernetes namespace is required")
+	}
+	if r.ServiceAccountNamespace == "" {
+		r.ServiceAccountNamespace = DefaultServiceAccountNamespace
+	}
+	if r.ServiceAccountName == "" {
+		r.ServiceAccountName = DefaultServiceAccountName
+	}
+	if r.KubernetesRole == "" {
+		r.KubernetesRole = DefaultKubernetesRole
+	}
+	if r.TokenTimeout <= 0 {
+		r.TokenTimeout = defaultTokenTimeout
+	}
+	if r.Kubernetes == nil || r.Vault == nil {
+		return fmt.Errorf("a kubernetes and a vault client are required")
+	}
+	return nil
+}
+
+// Register provisions the service account and RBAC of Vault in the cluster, then enables and configures
+// the secret engine and writes the role. It returns the actions made, or that would be made in a dry run
+func (r *ClusterRegistration) Register(ctx context.Context) ([]Action, error) {
+	var actions []Action
+	record := func(action Action, err error) error {
+		if err == nil {
+			actions = append(actions, action)
+		}
+		return err
+	}
+
+	namespaces := append([]string{r.ServiceAccountNamespace}, r.AllowedNamespaces...)
+	for i, namespace := range namespaces {
+		if namespace == "*" || slices.Contains(namespaces[:i], namespace) {
+			continue
+		}
+		if err := record(r.applyNamespace(ctx, namespace)); err != nil {
+			return actions, err
+		}
+	}
+	if err := record(r.applyServiceAccount(ctx)); err != nil {
+		return actions, err
+	}
+	tokenSecret, err := r.applyTokenSecret(ctx)
+	if err := record(tokenSecret, err); err != nil {
+		return actions, err
+	}
+	for _, apply := range []func(context.Context) (Action, error){r.applyClusterRole, r.applyClusterRoleBinding} {
+		if err := record(apply(ctx)); err != nil {
+			return actions, err
+		}
+	}
+
+	if err := record(r.enableSecretEngine(ctx)); err != nil {
+		return actions, err
+	}
+	config, token, err := r.engineConfig(ctx)
+	if err != nil {
+		return actions, err
+	}
+	var writeOnly map[string]any
+	if token != "" {
+		writeOnly = map[string]any{"service_account_jwt": token}
+	}
+	action, err := applyVaultObject(ctx, r.Vault, r.DryRun, r.path("config"), config, writeOnly)
+	if err != nil {
+		return actions, fmt.Errorf("Register() %w", err)
+	}
+	// Vault does not return the token, a recreated token secret is the only sign it changed
+	if action.Verb == "unchanged" && tokenSecret.Verb == "create" {
+		action.Verb, action.Changes = "update", []string{"service_account_jwt: (write-only)"}
+		if !r.DryRun {
+			if _, err := r.Vault.Write(ctx, action.Object, mergeFields(config, writeOnly)); err != nil {
+				return actions, fmt.Errorf("Register() Write %s: %w", action.Object, err)
+			}
+		}
+	}
+	actions = append(actions, action)
+	if err := record(applyVaultObject(ctx, r.Vault, r.DryRun, r.path("roles", r.Role), r.roleConfig(), nil)); err != nil {
+		return actions, fmt.Errorf("Register() %w", err)
+	}
+	return actions, nil
+}
+
+// Teardown disables the secret engine, which revokes the tokens it issued, then deletes the service account
+// and RBAC of Vault from the cluster. Namespaces are kept. Objects lacking the managed-by label of kubectl-vaultlogin
+// were not created by it, Teardown then fails before changing anything. It returns the actions made, or that would
+// be made in a dry run
+func (r *ClusterRegistration) Teardown(ctx context.Context) ([]Action, error) {
+	var actions []Action
+	name, namespace := r.ServiceAccountName, r.ServiceAccountNamespace
+	deletions := []struct {
+		object string
+		get    func(ctx context.Context) (metav1.Object, error)
+		delete func(ctx context.Context) error
+	}{
+		{
+			object: "ClusterRoleBinding " + name,
+			get: func(ctx context.Context) (metav1.Object, error) {
+				return r.Kubernetes.RbacV1().ClusterRoleBindings().Get(ctx, name, metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context) error {
+				return r.Kubernetes.RbacV1().ClusterRoleBindings().Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			object: "ClusterRole " + name,
+			get: func(ctx context.Context) (metav1.Object, error) {
+				return r.Kubernetes.RbacV1().ClusterRoles().Get(ctx, name, metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context) error {
+				return r.Kubernetes.RbacV1().ClusterRoles().Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+		{
+			object: "Secret " + namespace + "/" + r.tokenSecretName(),
+			get: func(ctx context.Context) (metav1.Object, error) {
+				return r.Kubernetes.CoreV1().Secrets(namespace).Get(ctx, r.tokenSecretName(), metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context) error {
+				return r.Kubernetes.CoreV1().Secrets(namespace).Delete(ctx, r.tokenSecretName(), metav1.DeleteOptions{})
+			},
+		},
+		{
+			object: "ServiceAccount " + namespace + "/" + name,
+			get: func(ctx context.Context) (metav1.Object, error) {
+				return r.Kubernetes.CoreV1().ServiceAccounts(namespace).Get(ctx, name, metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context) error {
+				return r.Kubernetes.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			},
+		},
+	}
+	// every object is checked before anything is deleted, so a refusal leaves the registration intact
+	present := make([]bool, len(deletions))
+	for i, deletion := range deletions {
+		object, err := deletion.get(ctx)
+		switch {
+		case apierrors.IsNotFound(err):
+			continue
+		case err != nil:
+			return actions, fmt.Errorf("Teardown() %w", wrapKubernetesError("Get", deletion.object, err))
+		case object.GetLabels()[managedByKey] != managedByLabel[managedByKey]:
+			return actions, fmt.Errorf("Teardown() %s lacks the label %s=%s, refusing to delete an object kubectl-vaultlogin did not create",
+				deletion.object, managedByKey, managedByLabel[managedByKey])
+		}
+		present[i] = true
+	}
+
+	// the secret engine goes first, Vault needs its service account to delete the service accounts it generated
+	enabled, err := r.secretEngineEnabled(ctx)
+	if err != nil {
+		return actions, err
+	}
+	action := Action{Verb: "absent", Object: "secret engine " + r.Mount}
+	if enabled {
+		action.Verb = "delete"
+		if !r.DryRun {
+			if _, err := r.Vault.Delete(ctx, vaultPath("sys/mounts", r.Mount)); err != nil {
+				return actions, fmt.Errorf("Teardown() DisableSecretEngine: %w", err)
+			}
+		}
+	}
+	actions = append(actions, action)
+
+	for i, deletion := range deletions {
+		var err error
+		if present[i] && !r.DryRun {
+			err = deletion.delete(ctx)
+		}
+		switch {
+		case !present[i] || apierrors.IsNotFound(err):
+			actions = append(actions, Action{Verb: "absent", Object: deletion.object})
+		case err != nil:
+			return actions, fmt.Errorf("Teardown() %w", wrapKubernetesError("Delete", deletion.object, err))
+		default:
+			actions = append(actions, Action{Verb: "delete", Object: deletion.object})
+		}
+	}
+	return actions, nil
+}
+
+// applyNamespace creates namespace when it is missing
+func (r *ClusterRegistration) applyNamespace(ctx context.Context, namespace string) (Action, error) {
+	action := Action{Verb: "unchanged", Object: "Namespace " + namespace}
+	_, err := r.Kubernetes.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return action, wrapKubernetesError("Get", action.Object, err)
+	}
+	if action.Verb = "create"; r.DryRun {
+		return action, nil
+	}
+	_, err = r.Kubernetes.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: r.objectMeta(namespace, "")}, metav1.CreateOptions{})
+	return action, wrapKubernetesError("Create", action.Object, err)
+}
+
+// applyServiceAccount creates the service account of Vault when it is missing
+func (r *ClusterRegistration) applyServiceAccount(ctx context.Context) (Action, error) {
+	accounts := r.Kubernetes.CoreV1().ServiceAccounts(r.ServiceAccountNamespace)
+	action := Action{Verb: "unchanged", Object: "ServiceAccount " + r.ServiceAccountNamespace + "/" + r.ServiceAccountName}
+	_, err := accounts.Get(ctx, r.ServiceAccountName, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return action, wrapKubernetesError("Get", action.Object, err)
+	}
+	if action.Verb = "create"; r.DryRun {
+		return action, nil
+	}
+	_, err = accounts.Create(ctx, &corev1.ServiceAccount{ObjectMeta: r.objectMeta(r.ServiceAccountName, r.ServiceAccountNamespace)}, metav1.CreateOptions{})
+	return action, wrapKubernetesError("Create", action.Object, err)
+}
+
+// applyTokenSecret creates the long-lived token secret of Vault's service account when it is missing
+func (r *ClusterRegistration) applyTokenSecret(ctx context.Context) (Action, error) {
+	secrets := r.Kubernetes.CoreV1().Secrets(r.ServiceAccountNamespace)
+	action := Action{Verb: "unchanged", Object: "Secret " + r.ServiceAccountNamespace + "/" + r.tokenSecretName()}
+	_, err := secrets.Get(ctx, r.tokenSecretName(), metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return action, wrapKubernetesError("Get", action.Object, err)
+	}
+	if action.Verb = "create"; r.DryRun {
+		return action, nil
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: r.objectMeta(r.tokenSecretName(), r.ServiceAccountNamespace),
+		Type:       corev1.SecretTypeServiceAccountToken,
+	}
+	secret.Annotations = map[string]string{corev1.ServiceAccountNameKey: r.ServiceAccountName}
+	_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	return action, wrapKubernetesError("Create", action.Object, err)
+}
+
+// applyClusterRole creates or updates the ClusterRole allowing Vault to generate service accounts, their
+// tokens and the bindings to KubernetesRole
+func (r *ClusterRegistration) applyClusterRole(ctx context.Context) (Action, error) {
+	roles := r.Kubernetes.RbacV1().ClusterRoles()
+	desired := &rbacv1.ClusterRole{ObjectMeta: r.objectMeta(r.ServiceAccountName, ""), Rules: secretEngineRules}
+	action := Action{Verb: "unchanged", Object: "ClusterRole " + r.ServiceAccountName}
+	existing, err := roles.Get(ctx, r.ServiceAccountName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if action.Verb = "create"; r.DryRun {
+			return action, nil
+		}
+		_, err = roles.Create(ctx, desired, metav1.CreateOptions{})
+		return action, wrapKubernetesError("Create", action.Object, err)
+	case err != nil:
+		return action, wrapKubernetesError("Get", action.Object, err)
+	case equality.Semantic.DeepEqual(existing.Rules, desired.Rules):
+		return action, nil
+	}
+	if action.Verb = "update"; r.DryRun {
+		return action, nil
+	}
+	existing.Rules = desired.Rules
+	_, err = roles.Update(ctx, existing, metav1.UpdateOptions{})
+	return action, wrapKubernetesError("Update", action.Object, err)
+}
+
+// applyClusterRoleBinding creates or updates the binding of the ClusterRole to Vault's service account
+func (r *ClusterRegistration) applyClusterRoleBinding(ctx context.Context) (Action, error) {
+	return applyClusterRoleBinding(ctx, r.Kubernetes, r.DryRun, &rbacv1.ClusterRoleBinding{
+		ObjectMeta: r.objectMeta(r.ServiceAccountName, ""),
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: r.ServiceAccountName},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: r.ServiceAccountName, Namespace: r.ServiceAccountNamespace}},
+	})
+}
+
+// applyClusterRoleBinding creates desired or updates its subjects. The role of an existing binding cannot change
+func applyClusterRoleBinding(ctx context.Context, client kubernetes.Interface, dryRun bool, desired *rbacv1.ClusterRoleBinding) (Action, error) {
+	bindings := client.RbacV1().ClusterRoleBindings()
+	action := Action{Verb: "unchanged", Object: "ClusterRoleBinding " + desired.Name}
+	existing, err := bindings.Get(ctx, desired.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if action.Verb = "create"; dryRun {
+			return action, nil
+		}
+		_, err = bindings.Create(ctx, desired, metav1.CreateOptions{})
+		return action, wrapKubernetesError("Create", action.Object, err)
+	case err != nil:
+		return action, wrapKubernetesError("Get", action.Object, err)
+	case existing.RoleRef != desired.RoleRef:
+		return action, fmt.Errorf("%s binds %s %s, the role of a binding cannot be changed, delete it first", action.Object, existing.RoleRef.Kind, existing.RoleRef.Name)
+	case equality.Semantic.DeepEqual(existing.Subjects, desired.Subjects):
+		return action, nil
+	}
+	if action.Verb = "update"; dryRun {
+		return action, nil
+	}
+	existing.Subjects = desired.Subjects
+	_, err = bindings.Update(ctx, existing, metav1.UpdateOptions{})
+	return action, wrapKubernetesError("Update", action.Object, err)
+}
+
+// enableSecretEngine enables Vault's kubernetes secret engine at Mount when it is not yet
+func (r *ClusterRegistration) enableSecretEngine(ctx context.Context) (Action, error) {
+	action := Action{Verb: "unchanged", Object: "secret engine " + r.Mount}
+	enabled, err := r.secretEngineEnabled(ctx)
+	if err != nil || enabled {
+		return action, err
+	}
+	action.Verb = "create"
+	if r.DryRun {
+		return action, nil
+	}
+	_, err = r.Vault.Write(ctx, vaultPath("sys/mounts", r.Mount), map[string]any{
+		"type":        "kubernetes",
+		"description": "kubectl-vaultlogin credentials of cluster " + r.ClusterName,
+	})
+	if err != nil {
+		return action, fmt.Errorf("Register() EnableSecretEngine: %w", err)
+	}
+	return action, nil
+}
+
+// secretEngineEnabled reports whether a kubernetes secret engine is enabled at Mount. Another engine there is an error
+func (r *ClusterRegistration) secretEngineEnabled(ctx context.Context) (bool, error) {
+	engine, err := mountedType(ctx, r.Vault, "sys/mounts", r.Mount)
+	if err != nil {
+		return false, fmt.Errorf("secretEngineEnabled() MountsListSecretsEngines: %w", err)
+	}
+	if engine != "" && engine != "kubernetes" {
+		return false, fmt.Errorf("a %s secret engine is enabled at %s, expected a kubernetes one", engine, r.Mount)
+	}
+	return engine != "", nil
+}
+
+// mountedType returns the type of what is mounted at mount among those listed by listPath, sys/mounts or sys/auth,
+// empty when nothing is
+func mountedType(ctx context.Context, vault federate.VaultAPI, listPath string, mount string) (string, error) {
+	resp, err := vault.Read(ctx, listPath)
+	if err != nil {
+		return "", err
+	}
+	mounted, exists := resp.Data[strings.Trim(mount, "/")+"/"].(map[string]any)
+	if !exists {
+		return "", nil
+	}
+	return fmt.Sprint(mounted["type"]), nil
+}
+
+// engineConfig returns the secret engine config, the API server and its CA, and apart the token of Vault's service
+// account, which Vault does not return on reads
+func (r *ClusterRegistration) engineConfig(ctx context.Context) (map[string]any, string, error) {
+	config := map[string]any{
+		"kubernetes_host":      r.KubernetesHost,
+		"disable_local_ca_jwt": true,
+	}
+	token, caCert, err := r.serviceAccountToken(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if r.KubernetesCACert != "" {
+		caCert = r.KubernetesCACert
+	}
+	if caCert != "" {
+		config["kubernetes_ca_cert"] = caCert
+	}
+	return config, token, nil
+}
+
+// serviceAccountToken waits for kubernetes to populate the token secret of Vault's service account and returns
+// the token and CA it holds. A dry run does not wait for a secret it did not create
+func (r *ClusterRegistration) serviceAccountToken(ctx context.Context) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.TokenTimeout)
+	defer cancel()
+	for {
+		secret, err := r.Kubernetes.CoreV1().Secrets(r.ServiceAccountNamespace).Get(ctx, r.tokenSecretName(), metav1.GetOptions{})
+		switch {
+		case r.DryRun && apierrors.IsNotFound(err):
+			return "", "", nil
+		case err != nil && !errors.Is(err, context.DeadlineExceeded):
+			return "", "", wrapKubernetesError("Get", "Secret "+r.ServiceAccountNamespace+"/"+r.tokenSecretName(), err)
+		case err == nil && len(secret.Data[corev1.ServiceAccountTokenKey]) > 0:
+			return string(secret.Data[corev1.ServiceAccountTokenKey]), string(secret.Data[corev1.ServiceAccountRootCAKey]), nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", "", fmt.Errorf("Register() the token of Secret %s/%s was not populated within %s", r.ServiceAccountNamespace, r.tokenSecretName(), r.TokenTimeout)
+		case <-time.After(tokenPollInterval):
+		}
+	}
+}
+
+// roleConfig returns the secret engine role generating service accounts bound to KubernetesRole
+func (r *ClusterRegistration) roleConfig() map[string]any {
+	role := map[string]any{
+		"allowed_kubernetes_namespaces": r.AllowedNamespaces,
+		"kubernetes_role_type":          "ClusterRole",
+		"kubernetes_role_name":          r.KubernetesRole,
+	}
+	if r.TokenDefaultTTL > 0 {
+		role["token_default_ttl"] = r.TokenDefaultTTL.String()
+	}
+	if r.TokenMaxTTL > 0 {
+		role["token_max_ttl"] = r.TokenMaxTTL.String()
+	}
+	return role
+}
+
+// path returns the Vault path of elements under the secret engine
+func (r *ClusterRegistration) path(elements ...string) string {
+	return vaultPath(append([]string{r.Mount}, elements...)...)
+}
+
+func (r *ClusterRegistration) tokenSecretName() string {
+	return r.ServiceAccountName + "-token"
+}
+
+func (r *ClusterRegistration) objectMeta(name, namespace string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: managedByLabel}
+}
+
+// secretEngineRules allow Vault's kubernetes secret engine to generate service accounts, their tokens and
+// bindings to an existing role, as documented for the kubernetes_role_name role option
+var secretEngineRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"get"}},
+	{APIGroups: []string{""}, Resources: []string{"serviceaccounts", "serviceaccounts/token"}, Verbs: []string{"create", "update", "delete"}},
+	{APIGroups: []string{rbacv1.GroupName}, Resources: []string{"rolebindings", "clusterrolebindings"}, Verbs: []string{"create", "update", "delete"}},
+	{APIGroups: []string{rbacv1.GroupName}, Resources: []string{"roles", "clusterroles"}, Verbs: []string{"bind", "escalate", "create", "update", "delete"}},
+}
+
+// wrapKubernetesError prefixes err with the call and the object it failed on, nil stays nil
+func wrapKubernetesError(call string, object string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s %s: %w", call, object, err)
+}
+
+// vaultPath joins path elements into a Vault API path without leading or trailing slashes
+func vaultPath(elements ...string) string {
+	trimmed := make([]string, 0, len(elements))
+	for _, element := range elements {
+		if element = strings.Trim(element, "/"); element != "" {
+			trimmed = append(trimmed, element)
+		}
+	}
+	return strings.Join(trimmed, "/")
+}
@@ -0,0 +1,271 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	vaultcg "github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// mockCACert is the CA of the downstream cluster's API server in the kubeconfig
+const mockCACert = "-----BEGIN CERTIFICATE-----\nkubeconfig\n-----END CERTIFICATE-----\n"
+
+// fakeCluster returns a fake clientset populating service account token secrets as kubernetes' token controller does
+func fakeCluster(objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*corev1.Secret)
+		if secret.Type == corev1.SecretTypeServiceAccountToken {
+			secret.Data = map[string][]byte{
+				corev1.ServiceAccountTokenKey:  []byte("vault-sa-jwt"),
+				corev1.ServiceAccountRootCAKey: []byte("secret-ca"),
+			}
+		}
+		return false, nil, nil
+	})
+	return clientset
+}
+
+// mockVault starts a fake Vault serving sys/mounts and returns it along with a client holding an admin token
+func mockVault(t *testing.T) (*vaultfake.Server, *vaultcg.Client) {
+	vault := vaultfake.New()
+	t.Cleanup(vault.Close)
+	vault.EnableMounts()
+	client, err := vaultcg.New(vaultcg.WithAddress(vault.URL), vaultcg.WithHTTPClient(vault.Client()))
+	require.NoError(t, err)
+	require.NoError(t, client.SetToken(vault.IssueToken()))
+	return vault, client
+}
+
+// mockRegistration returns a registration of the cluster prod under /kubernetes/prod
+func mockRegistration(t *testing.T, clientset *fake.Clientset, client *vaultcg.Client) *ClusterRegistration {
+	registration := &ClusterRegistration{
+		ClusterName:       "prod",
+		Mount:             "/kubernetes/prod",
+		Role:              "kvl-edit-role",
+		KubernetesHost:    "https://prod.example.com:6443",
+		KubernetesCACert:  mockCACert,
+		AllowedNamespaces: []string{"kube-priv"},
+		TokenDefaultTTL:   10 * time.Minute,
+		Kubernetes:        clientset,
+		Vault:             client,
+	}
+	require.NoError(t, registration.Validate())
+	return registration
+}
+
+// actionStrings returns actions as strings for assertions
+func actionStrings(actions []Action) []string {
+	var described []string
+	for _, action := range actions {
+		described = append(described, action.String())
+	}
+	return described
+}
+
+// tests a registration provisions the cluster and Vault, and changes nothing when rerun
+func TestRegister(t *testing.T) {
+	clientset := fakeCluster(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}})
+	vault, client := mockVault(t)
+	registration := mockRegistration(t, clientset, client)
+
+	actions, err := registration.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"unchanged Namespace kube-system",
+		"create Namespace kube-priv",
+		"create ServiceAccount kube-system/kvl-vault",
+		"create Secret kube-system/kvl-vault-token",
+		"create ClusterRole kvl-vault",
+		"create ClusterRoleBinding kvl-vault",
+		"create secret engine /kubernetes/prod",
+		"create kubernetes/prod/config",
+		"create kubernetes/prod/roles/kvl-edit-role",
+	}, actionStrings(actions))
+	assert.Contains(t, actions[7].Changes, "service_account_jwt: (write-only)")
+	assert.NotContains(t, actions[7].Changes, "vault-sa-jwt")
+
+	assert.Equal(t, map[string]string{"kubernetes/prod": "kubernetes"}, vault.Mounts())
+	assert.Equal(t, map[string]any{
+		"kubernetes_host":      "https://prod.example.com:6443",
+		"kubernetes_ca_cert":   mockCACert,
+		"service_account_jwt":  "vault-sa-jwt",
+		"disable_local_ca_jwt": true,
+	}, vault.Stored("kubernetes/prod/config"))
+	assert.Equal(t, map[string]any{
+		"allowed_kubernetes_namespaces": []any{"kube-priv"},
+		"kubernetes_role_type":          "ClusterRole",
+		"kubernetes_role_name":          "edit",
+		"token_default_ttl":             "10m0s",
+	}, vault.Stored("kubernetes/prod/roles/kvl-edit-role"))
+
+	binding, err := clientset.RbacV1().ClusterRoleBindings().Get(context.Background(), "kvl-vault", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Name: "kvl-vault", Namespace: "kube-system"}}, binding.Subjects)
+	assert.Equal(t, "kubectl-vaultlogin", binding.Labels["app.kubernetes.io/managed-by"])
+
+	actions, err = registration.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"unchanged Namespace kube-system",
+		"unchanged Namespace kube-priv",
+		"unchanged ServiceAccount kube-system/kvl-vault",
+		"unchanged Secret kube-system/kvl-vault-token",
+		"unchanged ClusterRole kvl-vault",
+		"unchanged ClusterRoleBinding kvl-vault",
+		"unchanged secret engine /kubernetes/prod",
+		"unchanged kubernetes/prod/config",
+		"unchanged kubernetes/prod/roles/kvl-edit-role",
+	}, actionStrings(actions))
+
+	// a drifted role is updated, a recreated token secret rewrites the config with its new token
+	registration.KubernetesRole = "view"
+	require.NoError(t, clientset.CoreV1().Secrets("kube-system").Delete(context.Background(), "kvl-vault-token", metav1.DeleteOptions{}))
+	actions, err = registration.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "update kubernetes/prod/config", actions[7].String())
+	assert.Equal(t, []string{"service_account_jwt: (write-only)"}, actions[7].Changes)
+	assert.Equal(t, "update kubernetes/prod/roles/kvl-edit-role", actions[8].String())
+	assert.Equal(t, []string{`kubernetes_role_name: "edit" -> "view"`}, actions[8].Changes)
+	assert.Equal(t, "view", vault.Stored("kubernetes/prod/roles/kvl-edit-role")["kubernetes_role_name"])
+}
+
+// tests a dry run reports the actions without making them, and drifted RBAC is updated
+func TestRegisterDryRun(t *testing.T) {
+	clientset := fakeCluster(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "kvl-vault"}})
+	vault, client := mockVault(t)
+	registration := mockRegistration(t, clientset, client)
+	registration.DryRun = true
+
+	actions, err := registration.Register(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, actionStrings(actions), "update ClusterRole kvl-vault")
+	assert.Contains(t, actionStrings(actions), "create secret engine /kubernetes/prod")
+	assert.Contains(t, actionStrings(actions), "create kubernetes/prod/roles/kvl-edit-role")
+	assert.Empty(t, vault.Mounts())
+	_, err = clientset.CoreV1().ServiceAccounts("kube-system").Get(context.Background(), "kvl-vault", metav1.GetOptions{})
+	assert.Error(t, err)
+
+	registration.DryRun = false
+	_, err = registration.Register(context.Background())
+	require.NoError(t, err)
+	role, err := clientset.RbacV1().ClusterRoles().Get(context.Background(), "kvl-vault", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, secretEngineRules, role.Rules)
+}
+
+// tests the CA of the token secret is used when the kubeconfig has none
+func TestRegisterSecretCA(t *testing.T) {
+	vault, client := mockVault(t)
+	registration := mockRegistration(t, fakeCluster(), client)
+	registration.KubernetesCACert = ""
+
+	_, err := registration.Register(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "secret-ca", vault.Stored("kubernetes/prod/config")["kubernetes_ca_cert"])
+}
+
+// tests registration failures
+func TestRegisterErrors(t *testing.T) {
+	tokenPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { tokenPollInterval = time.Second })
+
+	_, client := mockVault(t)
+	_, err := client.Write(context.Background(), "sys/mounts/kubernetes/prod", map[string]any{"type": "kv"})
+	require.NoError(t, err)
+	_, err = mockRegistration(t, fakeCluster(), client).Register(context.Background())
+	assert.ErrorContains(t, err, "a kv secret engine is enabled at /kubernetes/prod, expected a kubernetes one")
+
+	_, client = mockVault(t)
+	registration := mockRegistration(t, fake.NewSimpleClientset(), client)
+	registration.TokenTimeout = 50 * time.Millisecond
+	_, err = registration.Register(context.Background())
+	assert.ErrorContains(t, err, "the token of Secret kube-system/kvl-vault-token was not populated within 50ms")
+}
+
+// tests a teardown disables the secret engine and deletes the objects of Vault, and changes nothing when rerun
+func TestTeardown(t *testing.T) {
+	clientset := fakeCluster()
+	vault, client := mockVault(t)
+	registration := mockRegistration(t, clientset, client)
+	_, err := registration.Register(context.Background())
+	require.NoError(t, err)
+
+	registration.DryRun = true
+	actions, err := registration.Teardown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "delete secret engine /kubernetes/prod", actions[0].String())
+	assert.NotEmpty(t, vault.Mounts())
+
+	registration.DryRun = false
+	actions, err = registration.Teardown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"delete secret engine /kubernetes/prod",
+		"delete ClusterRoleBinding kvl-vault",
+		"delete ClusterRole kvl-vault",
+		"delete Secret kube-system/kvl-vault-token",
+		"delete ServiceAccount kube-system/kvl-vault",
+	}, actionStrings(actions))
+	assert.Empty(t, vault.Mounts())
+	assert.Nil(t, vault.Stored("kubernetes/prod/config"))
+	_, err = clientset.CoreV1().Namespaces().Get(context.Background(), "kube-priv", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	actions, err = registration.Teardown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"absent secret engine /kubernetes/prod",
+		"absent ClusterRoleBinding kvl-vault",
+		"absent ClusterRole kvl-vault",
+		"absent Secret kube-system/kvl-vault-token",
+		"absent ServiceAccount kube-system/kvl-vault",
+	}, actionStrings(actions))
+}
+
+// tests a teardown refuses to delete objects kubectl-vaultlogin did not create, before changing anything
+func TestTeardownUnmanaged(t *testing.T) {
+	clientset := fakeCluster(&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "kvl-vault"}})
+	vault, client := mockVault(t)
+	registration := mockRegistration(t, clientset, client)
+	_, err := registration.Register(context.Background())
+	require.NoError(t, err)
+
+	_, err = registration.Teardown(context.Background())
+	assert.ErrorContains(t, err, "ClusterRole kvl-vault lacks the label app.kubernetes.io/managed-by=kubectl-vaultlogin")
+	assert.NotEmpty(t, vault.Mounts())
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get(context.Background(), "kvl-vault", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+// tests invalid registrations are rejected
+func TestRegistrationValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(r *ClusterRegistration)
+		expectedError string
+	}{
+		{name: "relative mount", modify: func(r *ClusterRegistration) { r.Mount = "kubernetes/prod" }, expectedError: "secrets mount must be an absolute path"},
+		{name: "missing role", modify: func(r *ClusterRegistration) { r.Role = "" }, expectedError: "the secret role is unset"},
+		{name: "http host", modify: func(r *ClusterRegistration) { r.KubernetesHost = "http://prod.example.com" }, expectedError: "the kubernetes API server must be an https URL"},
+		{name: "no namespace", modify: func(r *ClusterRegistration) { r.AllowedNamespaces = nil }, expectedError: "at least one allowed kubernetes namespace is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registration := &ClusterRegistration{ClusterName: "prod", Mount: "/kubernetes/prod", Role: "kvl-edit-role",
+				KubernetesHost: "https://prod.example.com", AllowedNamespaces: []string{"kube-priv"}}
+			tt.modify(registration)
+			assert.ErrorContains(t, registration.Validate(), tt.expectedError)
+		})
+	}
+}
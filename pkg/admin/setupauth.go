@@ -0,0 +1,235 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	vaultcg "github.com/hashicorp/vault-client-go"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultKubernetesLoginRole is the login role the psat authentication method presents its token to by default
+const DefaultKubernetesLoginRole = "kvl-login"
+
+// authDelegatorRole is the ClusterRole allowing TokenReviews, Vault reviews the presented token with the token itself
+const authDelegatorRole = "system:auth-delegator"
+
+// AuthSetup configures Vault's kubernetes auth method for the cluster ArgoCD runs in: the auth mount, its config
+// with the cluster's API server and the login role bound to ArgoCD's service account and token audience
+type AuthSetup struct {
+	// Mount is the auth method mount point, the one of --vault-kubernetes-auth-mount, ex. /kubernetes/argocd
+	Mount string
+	// Role is the login role, defaults to kvl-login
+	Role string
+	// KubernetesHost is the URL Vault reaches the cluster's API server at
+	KubernetesHost string
+	// KubernetesCACert is the PEM encoded CA of the API server
+	KubernetesCACert string
+	// ServiceAccountNamespace and ServiceAccountName name ArgoCD's service account, the only one allowed to log in
+	ServiceAccountNamespace string
+	ServiceAccountName      string
+	// Audience the service account token must be issued for
+	Audience string
+	// Policies attached to the vault tokens issued by the login role
+	Policies []string
+	// TokenTTL of the issued vault tokens, Vault's default when 0
+	TokenTTL time.Duration
+	// DryRun reads the cluster and Vault and reports the changes without making them
+	DryRun bool
+
+	Kubernetes kubernetes.Interface
+	Vault      federate.VaultAPI
+}
+
+// Validate checks the setup and applies defaults
+func (a *AuthSetup) Validate() error {
+	if !strings.HasPrefix(a.Mount, "/") || strings.Trim(a.Mount, "/") == "" {
+		return fmt.Errorf("%s must be an absolute path, ex. /kubernetes/argocd: %s", federate.FlagVaultKubernetesAuthMount, a.Mount)
+	}
+	if a.Role == "" {
+		a.Role = DefaultKubernetesLoginRole
+	}
+	if u, err := url.Parse(a.KubernetesHost); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("the kubernetes API server must be an https URL: %s", a.KubernetesHost)
+	}
+	if a.ServiceAccountNamespace == "" || a.ServiceAccountName == "" {
+		return fmt.Errorf("the service account of ArgoCD is unset")
+	}
+	if a.Audience == "" {
+		return fmt.Errorf("the audience of the service account token is unset, the login role must bind one")
+	}
+	if len(a.Policies) == 0 {
+		return fmt.Errorf("at least one policy is required, the default policy does not allow federation")
+	}
+	if a.Kubernetes == nil || a.Vault == nil {
+		return fmt.Errorf("a kubernetes and a vault client are required")
+	}
+	return nil
+}
+
+// Setup lets the service account review tokens, enables the auth method and writes its config and login role
+// where they differ from what Vault holds. It returns the actions made, with the changed fields, or that would
+// be made in a dry run
+func (a *AuthSetup) Setup(ctx context.Context) ([]Action, error) {
+	var actions []Action
+	binding, err := applyClusterRoleBinding(ctx, a.Kubernetes, a.DryRun, &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: a.delegatorBindingName(), Labels: managedByLabel},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: authDelegatorRole},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: a.ServiceAccountName, Namespace: a.ServiceAccountNamespace}},
+	})
+	if err != nil {
+		return actions, fmt.Errorf("Setup() %w", err)
+	}
+	actions = append(actions, binding)
+
+	method, err := mountedType(ctx, a.Vault, "sys/auth", a.Mount)
+	if err != nil {
+		return actions, fmt.Errorf("Setup() AuthListEnabledMethods: %w", err)
+	}
+	enable := Action{Verb: "unchanged", Object: "auth method " + a.Mount}
+	switch method {
+	case "kubernetes":
+	case "":
+		enable.Verb = "create"
+		if !a.DryRun {
+			if _, err := a.Vault.Write(ctx, vaultPath("sys/auth", a.Mount), map[string]any{
+				"type":        "kubernetes",
+				"description": "kubectl-vaultlogin logins of ArgoCD",
+			}); err != nil {
+				return actions, fmt.Errorf("Setup() AuthEnableMethod: %w", err)
+			}
+		}
+	default:
+		return actions, fmt.Errorf("Setup() a %s auth method is enabled at %s, expected a kubernetes one", method, a.Mount)
+	}
+	actions = append(actions, enable)
+
+	for _, object := range []struct {
+		path    string
+		desired map[string]any
+	}{
+		{path: vaultPath("auth", a.Mount, "config"), desired: a.config()},
+		{path: vaultPath("auth", a.Mount, "role", a.Role), desired: a.role()},
+	} {
+		action, err := applyVaultObject(ctx, a.Vault, a.DryRun, object.path, object.desired, nil)
+		if err != nil {
+			return actions, fmt.Errorf("Setup() %w", err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// config returns the auth method config. Without a token_reviewer_jwt, Vault reviews a login's token with the
+// token itself, which the auth-delegator binding allows
+func (a *AuthSetup) config() map[string]any {
+	config := map[string]any{
+		"kubernetes_host":      a.KubernetesHost,
+		"disable_local_ca_jwt": true,
+	}
+	if a.KubernetesCACert != "" {
+		config["kubernetes_ca_cert"] = a.KubernetesCACert
+	}
+	return config
+}
+
+// role returns the login role bound to ArgoCD's service account and token audience
+func (a *AuthSetup) role() map[string]any {
+	role := map[string]any{
+		"bound_service_account_names":      []string{a.ServiceAccountName},
+		"bound_service_account_namespaces": []string{a.ServiceAccountNamespace},
+		"audience":                         a.Audience,
+		"token_policies":                   a.Policies,
+	}
+	if a.TokenTTL > 0 {
+		role["token_ttl"] = int(a.TokenTTL.Seconds())
+	}
+	return role
+}
+
+func (a *AuthSetup) delegatorBindingName() string {
+	return "kvl-auth-delegator-" + a.ServiceAccountNamespace + "-" + a.ServiceAccountName
+}
+
+// applyVaultObject writes desired to path unless Vault already holds its fields with the same values. Fields
+// Vault holds and desired omits are left as they are. The fields of writeOnly, which Vault does not return on
+// reads, are written along with desired but never compared
+func applyVaultObject(ctx context.Context, vault federate.VaultAPI, dryRun bool, path string, desired map[string]any, writeOnly map[string]any) (Action, error) {
+	action := Action{Verb: "unchanged", Object: path}
+	existing := map[string]any{}
+	resp, err := vault.Read(ctx, path)
+	switch {
+	case vaultcg.IsErrorStatus(err, http.StatusNotFound):
+		action.Verb = "create"
+	case err != nil:
+		return action, fmt.Errorf("Read %s: %w", path, err)
+	default:
+		existing = resp.Data
+	}
+
+	fields := make([]string, 0, len(desired))
+	for field := range desired {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	for _, field := range fields {
+		if encodeValue(existing[field]) != encodeValue(desired[field]) {
+			action.Changes = append(action.Changes, fmt.Sprintf("%s: %s -> %s", field, describeValue(existing[field]), describeValue(desired[field])))
+		}
+	}
+	if len(action.Changes) == 0 {
+		return action, nil
+	}
+	if action.Verb == "unchanged" {
+		action.Verb = "update"
+	}
+	writeOnlyFields := make([]string, 0, len(writeOnly))
+	for field := range writeOnly {
+		writeOnlyFields = append(writeOnlyFields, field+": (write-only)")
+	}
+	slices.Sort(writeOnlyFields)
+	action.Changes = append(action.Changes, writeOnlyFields...)
+	if !dryRun {
+		if _, err := vault.Write(ctx, path, mergeFields(desired, writeOnly)); err != nil {
+			return action, fmt.Errorf("Write %s: %w", path, err)
+		}
+	}
+	return action, nil
+}
+
+// mergeFields returns the fields of desired and writeOnly in a single body
+func mergeFields(desired map[string]any, writeOnly map[string]any) map[string]any {
+	body := maps.Clone(desired)
+	maps.Copy(body, writeOnly)
+	return body
+}
+
+// encodeValue renders a value read from or written to Vault as JSON, so both compare equal
+func encodeValue(value any) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// describeValue renders a value for a diff, PEM certificates are shortened to keep it readable
+func describeValue(value any) string {
+	if value == nil {
+		return "(unset)"
+	}
+	if text, ok := value.(string); ok && strings.HasPrefix(text, "-----BEGIN") {
+		return fmt.Sprintf("(PEM, %d bytes)", len(text))
+	}
+	return encodeValue(value)
+}
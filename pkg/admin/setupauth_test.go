@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	vaultcg "github.com/hashicorp/vault-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// mockAuthSetup returns a setup of /kubernetes/argocd for the service account argocd/argocd-repo-server
+func mockAuthSetup(t *testing.T, clientset *fake.Clientset, client *vaultcg.Client) *AuthSetup {
+	setup := &AuthSetup{
+		Mount:                   "/kubernetes/argocd",
+		KubernetesHost:          "https://argocd.example.com:6443",
+		KubernetesCACert:        mockCACert,
+		ServiceAccountNamespace: "argocd",
+		ServiceAccountName:      "argocd-repo-server",
+		Audience:                "vault",
+		Policies:                []string{"kvl-federate"},
+		TokenTTL:                time.Hour,
+		Kubernetes:              clientset,
+		Vault:                   client,
+	}
+	require.NoError(t, setup.Validate())
+	return setup
+}
+
+// tests a setup enables and configures the auth method, and changes nothing when rerun
+func TestSetup(t *testing.T) {
+	clientset := fakeCluster()
+	vault, client := mockVault(t)
+	setup := mockAuthSetup(t, clientset, client)
+
+	actions, err := setup.Setup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"create ClusterRoleBinding kvl-auth-delegator-argocd-argocd-repo-server",
+		"create auth method /kubernetes/argocd",
+		"create auth/kubernetes/argocd/config",
+		"create auth/kubernetes/argocd/role/kvl-login",
+	}, actionStrings(actions))
+	assert.Equal(t, []string{
+		`audience: (unset) -> "vault"`,
+		`bound_service_account_names: (unset) -> ["argocd-repo-server"]`,
+		`bound_service_account_namespaces: (unset) -> ["argocd"]`,
+		`token_policies: (unset) -> ["kvl-federate"]`,
+		`token_ttl: (unset) -> 3600`,
+	}, actions[3].Changes)
+
+	assert.Equal(t, map[string]string{"auth/kubernetes/argocd": "kubernetes"}, vault.Mounts())
+	assert.Equal(t, map[string]any{
+		"kubernetes_host":      "https://argocd.example.com:6443",
+		"kubernetes_ca_cert":   mockCACert,
+		"disable_local_ca_jwt": true,
+	}, vault.Stored("auth/kubernetes/argocd/config"))
+	assert.Equal(t, []any{"kvl-federate"}, vault.Stored("auth/kubernetes/argocd/role/kvl-login")["token_policies"])
+
+	binding, err := clientset.RbacV1().ClusterRoleBindings().Get(context.Background(), "kvl-auth-delegator-argocd-argocd-repo-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "system:auth-delegator", binding.RoleRef.Name)
+	assert.Equal(t, []rbacv1.Subject{{Kind: "ServiceAccount", Name: "argocd-repo-server", Namespace: "argocd"}}, binding.Subjects)
+
+	actions, err = setup.Setup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"unchanged ClusterRoleBinding kvl-auth-delegator-argocd-argocd-repo-server",
+		"unchanged auth method /kubernetes/argocd",
+		"unchanged auth/kubernetes/argocd/config",
+		"unchanged auth/kubernetes/argocd/role/kvl-login",
+	}, actionStrings(actions))
+}
+
+// tests drift is reported as a diff and corrected, unless in a dry run
+func TestSetupDrift(t *testing.T) {
+	vault, client := mockVault(t)
+	setup := mockAuthSetup(t, fakeCluster(), client)
+	_, err := setup.Setup(context.Background())
+	require.NoError(t, err)
+
+	setup.Audience = "argocd"
+	setup.Policies = []string{"kvl-federate", "kvl-audit"}
+	setup.DryRun = true
+	actions, err := setup.Setup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "update auth/kubernetes/argocd/role/kvl-login", actions[3].String())
+	assert.Equal(t, []string{
+		`audience: "vault" -> "argocd"`,
+		`token_policies: ["kvl-federate"] -> ["kvl-federate","kvl-audit"]`,
+	}, actions[3].Changes)
+	assert.Empty(t, actions[2].Changes)
+	assert.Equal(t, "vault", vault.Stored("auth/kubernetes/argocd/role/kvl-login")["audience"])
+
+	setup.DryRun = false
+	_, err = setup.Setup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "argocd", vault.Stored("auth/kubernetes/argocd/role/kvl-login")["audience"])
+}
+
+// tests a dry run changes neither the cluster nor Vault
+func TestSetupDryRun(t *testing.T) {
+	clientset := fakeCluster()
+	vault, client := mockVault(t)
+	setup := mockAuthSetup(t, clientset, client)
+	setup.DryRun = true
+
+	actions, err := setup.Setup(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "create auth method /kubernetes/argocd", actions[1].String())
+	assert.Equal(t, "create auth/kubernetes/argocd/config", actions[2].String())
+	assert.Contains(t, actions[2].Changes, "kubernetes_ca_cert: (unset) -> (PEM, 65 bytes)")
+	assert.Empty(t, vault.Mounts())
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get(context.Background(), "kvl-auth-delegator-argocd-argocd-repo-server", metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+// tests another auth method at the mount is an error
+func TestSetupWrongMethod(t *testing.T) {
+	_, client := mockVault(t)
+	_, err := client.Write(context.Background(), "sys/auth/kubernetes/argocd", map[string]any{"type": "jwt"})
+	require.NoError(t, err)
+	_, err = mockAuthSetup(t, fakeCluster(), client).Setup(context.Background())
+	assert.ErrorContains(t, err, "a jwt auth method is enabled at /kubernetes/argocd, expected a kubernetes one")
+}
+
+// tests invalid setups are rejected
+func TestAuthSetupValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(a *AuthSetup)
+		expectedError string
+	}{
+		{name: "relative mount", modify: func(a *AuthSetup) { a.Mount = "kubernetes/argocd" }, expectedError: "must be an absolute path"},
+		{name: "http host", modify: func(a *AuthSetup) { a.KubernetesHost = "http://argocd.example.com" }, expectedError: "the kubernetes API server must be an https URL"},
+		{name: "no service account", modify: func(a *AuthSetup) { a.ServiceAccountName = "" }, expectedError: "the service account of ArgoCD is unset"},
+		{name: "no audience", modify: func(a *AuthSetup) { a.Audience = "" }, expectedError: "the audience of the service account token is unset"},
+		{name: "no policy", modify: func(a *AuthSetup) { a.Policies = nil }, expectedError: "at least one policy is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup := &AuthSetup{Mount: "/kubernetes/argocd", KubernetesHost: "https://argocd.example.com",
+				ServiceAccountNamespace: "argocd", ServiceAccountName: "argocd-repo-server", Audience: "vault",
+				Policies: []string{"kvl-federate"}, Kubernetes: fake.NewSimpleClientset(), Vault: &vaultcg.Client{}}
+			tt.modify(setup)
+			assert.ErrorContains(t, setup.Validate(), tt.expectedError)
+		})
+	}
+}
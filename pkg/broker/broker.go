@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// defaultDialTimeout bounds how long a thin client waits for the broker before falling back to direct federation
+const defaultDialTimeout = 5 * time.Second
+
+// maxMessageSize limits the size of a single request or response exchanged over the broker socket
+const maxMessageSize = 1 << 20
+
+// Request is sent by a thin client to the broker to ask for a kubernetes bearer token for a downstream cluster
+type Request struct {
+	ClusterName string `json:"clusterName"`
+}
+
+// Response is returned by the broker. Either Status and Credential or Error are set
+type Response struct {
+	Status *clientauthentication.ExecCredentialStatus `json:"status,omitempty"`
+	// Credential carries the token of Status along with its Vault lease and service account
+	Credential *Credential `json:"credential,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Credential is a kubernetes bearer token issued by Vault along with its lease and service account, when known
+type Credential struct {
+	Token                   string        `json:"token"`
+	LeaseID                 string        `json:"leaseID,omitempty"`
+	LeaseDuration           time.Duration `json:"leaseDuration,omitempty"`
+	ServiceAccountName      string        `json:"serviceAccountName,omitempty"`
+	ServiceAccountNamespace string        `json:"serviceAccountNamespace,omitempty"`
+	// ExpirationTimestamp is the expiration reported to kubectl, set by the broker
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+}
+
+// DefaultSocketPath returns the unix socket path used by the broker when none is supplied.
+// It prefers XDG_RUNTIME_DIR, which is private to the user, and falls back to the temp directory
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "kubectl-vaultlogin.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kubectl-vaultlogin-%d.sock", os.Getuid()))
+}
+
+// Fetch asks the broker listening on socketPath for a Credential for the given downstream cluster.
+// If ctx has no deadline a default of 5 seconds is applied to the whole exchange
+func Fetch(ctx context.Context, socketPath string, clusterName string) (*Credential, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDialTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("broker.Fetch(): cannot connect to broker at %s: %w", socketPath, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(Request{ClusterName: clusterName}); err != nil {
+		return nil, fmt.Errorf("broker.Fetch(): cannot send request to broker: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(io.LimitReader(conn, maxMessageSize)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("broker.Fetch(): cannot read response from broker: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("broker.Fetch(): broker responded with an error: %s", resp.Error)
+	}
+	if resp.Status == nil || resp.Status.Token == "" {
+		return nil, errors.New("broker.Fetch(): broker responded without a token")
+	}
+	// brokers of earlier versions only respond with the status
+	credential := resp.Credential
+	if credential == nil || credential.Token != resp.Status.Token {
+		credential = &Credential{Token: resp.Status.Token}
+	}
+	if resp.Status.ExpirationTimestamp != nil {
+		credential.ExpirationTimestamp = resp.Status.ExpirationTimestamp.Time
+	}
+	return credential, nil
+}
@@ -0,0 +1,202 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSession issues numbered tokens and counts calls to Vault
+type fakeSession struct {
+	issued  atomic.Int32
+	renewed atomic.Int32
+	ttl     time.Duration
+	// failing makes every request fail as Vault is unreachable
+	failing atomic.Bool
+}
+
+func (f *fakeSession) Issue(ctx context.Context, clusterName string) (*Credential, error) {
+	if clusterName == "denied" {
+		return nil, errors.New("403 permission denied")
+	}
+	if f.failing.Load() {
+		return nil, errors.New("connection refused")
+	}
+	n := f.issued.Add(1)
+	return &Credential{
+		Token:                   fmt.Sprintf("%s-token-%d", clusterName, n),
+		LeaseID:                 fmt.Sprintf("kubernetes/%s/creds/kvl-edit-role/%d", clusterName, n),
+		LeaseDuration:           f.ttl,
+		ServiceAccountName:      "v-kvl-edit-role",
+		ServiceAccountNamespace: "kube-priv",
+	}, nil
+}
+
+func (f *fakeSession) Renew(ctx context.Context) (time.Duration, error) {
+	f.renewed.Add(1)
+	return time.Hour, nil
+}
+
+// startBroker runs a broker with a fakeSession on a temporary socket
+func startBroker(t *testing.T, config Config) (*fakeSession, string) {
+	dir, err := os.MkdirTemp("", "kvl")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	session := &fakeSession{ttl: time.Hour}
+	config.SocketPath = filepath.Join(dir, "broker.sock")
+	config.Login = func(ctx context.Context) (Session, time.Duration, error) {
+		return session, time.Hour, nil
+	}
+	if config.TokenDuration == 0 {
+		config.TokenDuration = 15 * time.Minute
+	}
+	server, err := NewServer(config)
+	require.NoError(t, err)
+	l, err := server.Listen()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Serve(ctx, l)
+	return session, config.SocketPath
+}
+
+// tests if a token is served and subsequent requests are answered from the cache
+func TestFetchCachesToken(t *testing.T) {
+	session, socket := startBroker(t, Config{})
+
+	first, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "dev-token-1", first.Token)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), first.ExpirationTimestamp, 5*time.Second)
+	// the lease and service account are served along with the token
+	assert.Equal(t, "kubernetes/dev/creds/kvl-edit-role/1", first.LeaseID)
+	assert.Equal(t, time.Hour, first.LeaseDuration)
+	assert.Equal(t, "v-kvl-edit-role", first.ServiceAccountName)
+	assert.Equal(t, "kube-priv", first.ServiceAccountNamespace)
+
+	second, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, first.Token, second.Token)
+	assert.Equal(t, int32(1), session.issued.Load())
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+// tests if a vault lease shorter than the token duration caps the expiration and if a token living shorter than
+// the refresh margin is served until half of its lifetime, not replaced at every request
+func TestFetchRefreshesExpiringToken(t *testing.T) {
+	session, socket := startBroker(t, Config{RefreshMargin: 5 * time.Minute})
+	session.ttl = 4 * time.Minute
+
+	first, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(4*time.Minute), first.ExpirationTimestamp, 5*time.Second)
+
+	second, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, first.Token, second.Token)
+	assert.Equal(t, int32(1), session.issued.Load())
+}
+
+// tests if a token is replaced halfway through its lifetime and if it is still served while its refresh fails
+func TestFetchRefreshesHalfway(t *testing.T) {
+	session, socket := startBroker(t, Config{RefreshMargin: 5 * time.Minute})
+	session.ttl = 400 * time.Millisecond
+
+	first, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	time.Sleep(250 * time.Millisecond)
+	second, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Token, second.Token)
+
+	session.failing.Store(true)
+	time.Sleep(250 * time.Millisecond)
+	third, err := Fetch(context.Background(), socket, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, second.Token, third.Token)
+
+	time.Sleep(250 * time.Millisecond)
+	_, err = Fetch(context.Background(), socket, "dev")
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+// tests if vault errors are relayed to the thin client
+func TestFetchRelaysError(t *testing.T) {
+	_, socket := startBroker(t, Config{})
+
+	_, err := Fetch(context.Background(), socket, "denied")
+	assert.ErrorContains(t, err, "403 permission denied")
+}
+
+// tests if connections from uids that are not allowed are rejected
+func TestFetchRejectsUID(t *testing.T) {
+	session, socket := startBroker(t, Config{AllowedUIDs: []int{os.Getuid() + 1}})
+
+	_, err := Fetch(context.Background(), socket, "dev")
+	assert.ErrorContains(t, err, "is not allowed to use this broker")
+	assert.Equal(t, int32(0), session.issued.Load())
+}
+
+// tests if the socket of a broker allowing other uids can be connected to by them, peer credentials enforcing the
+// list, and if it is removed once the broker stops
+func TestFetchAllowsOtherUID(t *testing.T) {
+	otherUID := os.Getuid() + 1
+	t.Cleanup(func() { peerCredential = peerUID })
+	peerCredential = func(*net.UnixConn) (int, error) { return otherUID, nil }
+	dir := t.TempDir()
+	server, err := NewServer(Config{
+		SocketPath:    filepath.Join(dir, "broker.sock"),
+		AllowedUIDs:   []int{os.Getuid(), otherUID},
+		TokenDuration: 15 * time.Minute,
+		Login: func(ctx context.Context) (Session, time.Duration, error) {
+			return &fakeSession{ttl: time.Hour}, time.Hour, nil
+		},
+	})
+	require.NoError(t, err)
+	l, err := server.Listen()
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- server.Serve(ctx, l) }()
+
+	info, err := os.Stat(filepath.Join(dir, "broker.sock"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0666), info.Mode().Perm())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	_, err = Fetch(context.Background(), filepath.Join(dir, "broker.sock"), "dev")
+	assert.NoError(t, err)
+
+	cancel()
+	require.NoError(t, <-served)
+	_, err = os.Stat(filepath.Join(dir, "broker.sock"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// tests if an error is reported when no broker listens on the socket
+func TestFetchNoBroker(t *testing.T) {
+	_, err := Fetch(context.Background(), filepath.Join(t.TempDir(), "missing.sock"), "dev")
+	assert.ErrorContains(t, err, "cannot connect to broker")
+}
+
+// tests if prefetched clusters are requested before any client asks for them
+func TestPrefetch(t *testing.T) {
+	session, _ := startBroker(t, Config{PrefetchClusters: []string{"dev", "prod"}})
+
+	assert.Eventually(t, func() bool { return session.issued.Load() == 2 }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package broker
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerUID returns the uid of the process on the other end of a unix socket connection using SO_PEERCRED
+func peerUID(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, fmt.Errorf("peerUID(): %w", err)
+	}
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, fmt.Errorf("peerUID(): %w", err)
+	}
+	if credErr != nil {
+		return -1, fmt.Errorf("peerUID(): SO_PEERCRED: %w", credErr)
+	}
+	return int(cred.Uid), nil
+}
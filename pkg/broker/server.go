@@ -0,0 +1,355 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// defaultRefreshMargin is how long before expiration a cached kubernetes bearer token gets replaced
+const defaultRefreshMargin = 5 * time.Minute
+
+// connectionTimeout bounds a single request/response exchange with a thin client
+const connectionTimeout = 30 * time.Second
+
+// maxMaintenanceInterval caps how often the broker checks its vault token and cached credentials
+const maxMaintenanceInterval = 30 * time.Second
+
+// Session is an authenticated Vault session held by the broker
+type Session interface {
+	// Issue requests a kubernetes bearer token for a downstream cluster. Its LeaseDuration caps the expiration
+	Issue(ctx context.Context, clusterName string) (*Credential, error)
+	// Renew extends the session's vault token and returns its new ttl
+	Renew(ctx context.Context) (time.Duration, error)
+}
+
+// LoginFunc authenticates to Vault and returns a new Session along with the ttl of its vault token.
+// A ttl of 0 designates a vault token that does not expire
+type LoginFunc func(ctx context.Context) (Session, time.Duration, error)
+
+// Config holds the settings of a broker Server
+type Config struct {
+	// SocketPath is the unix socket the broker listens on
+	SocketPath string
+	// AllowedUIDs lists the uids of processes allowed to request tokens. Defaults to the uid of the broker itself.
+	// When it lists other uids, every local user may connect to the socket and peer credentials enforce the list
+	AllowedUIDs []int
+	// PrefetchClusters lists downstream clusters whose tokens are requested before any client asks for them
+	PrefetchClusters []string
+	// TokenDuration is the expiration set in the served ExecCredentialStatus, capped by the vault lease duration
+	TokenDuration time.Duration
+	// RefreshMargin is how long before expiration a cached token gets replaced, but not before half of its lifetime
+	RefreshMargin time.Duration
+	// Login authenticates to Vault
+	Login LoginFunc
+	// Logger receives operational messages, if nil they are discarded
+	Logger *log.Logger
+}
+
+// Server is a resident credential broker. It keeps a logged in Vault session, renews its vault token
+// and serves cached kubernetes bearer tokens to thin clients over a unix socket
+type Server struct {
+	config Config
+
+	sessionMu sync.RWMutex
+	session   Session
+	renewAt   time.Time
+
+	credsMu sync.Mutex
+	creds   map[string]*cachedCredential
+}
+
+// cachedCredential holds the last Credential issued for a downstream cluster and when it gets replaced.
+// mu serialises refreshes so that concurrent clients do not trigger duplicate Vault requests
+type cachedCredential struct {
+	mu         sync.Mutex
+	credential *Credential
+	refreshAt  time.Time
+}
+
+// NewServer validates config, applies defaults and returns a Server ready to ListenAndServe
+func NewServer(config Config) (*Server, error) {
+	if config.Login == nil {
+		return nil, errors.New("NewServer(): a login function is required")
+	}
+	if config.SocketPath == "" {
+		config.SocketPath = DefaultSocketPath()
+	}
+	if len(config.AllowedUIDs) == 0 {
+		config.AllowedUIDs = []int{os.Getuid()}
+	}
+	if config.RefreshMargin <= 0 {
+		config.RefreshMargin = defaultRefreshMargin
+	}
+	if config.TokenDuration <= config.RefreshMargin {
+		return nil, fmt.Errorf("NewServer(): token duration %s must be longer than refresh margin %s", config.TokenDuration, config.RefreshMargin)
+	}
+	if config.Logger == nil {
+		config.Logger = log.New(io.Discard, "", 0)
+	}
+	return &Server{
+		config: config,
+		creds:  make(map[string]*cachedCredential),
+	}, nil
+}
+
+// peerCredential returns the uid of the process on the other end of a connection, tests replace it
+var peerCredential = peerUID
+
+// Listen creates the broker's unix socket, replacing a stale socket file left behind by a previous broker.
+// The socket is bound inside a private directory and only moved to SocketPath once its mode is set, so that it is
+// never reachable with a wider mode. It is accessible to its owner only unless AllowedUIDs lists other uids,
+// connections are checked against AllowedUIDs either way
+func (s *Server) Listen() (*net.UnixListener, error) {
+	path := s.config.SocketPath
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("Listen(): another broker is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("Listen(): cannot remove stale socket %s: %w", path, err)
+		}
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".kvl-broker-")
+	if err != nil {
+		return nil, fmt.Errorf("Listen(): %w", err)
+	}
+	defer os.RemoveAll(dir)
+	bound := filepath.Join(dir, "broker.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Listen(): %w", err)
+	}
+	// the socket is removed from SocketPath by Serve, closing l would only unlink the path it was bound to
+	l.SetUnlinkOnClose(false)
+	mode := os.FileMode(0600)
+	if slices.ContainsFunc(s.config.AllowedUIDs, func(uid int) bool { return uid != os.Getuid() }) {
+		mode = 0666
+	}
+	if err := os.Chmod(bound, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Listen(): cannot set permissions of %s: %w", path, err)
+	}
+	if err := os.Rename(bound, path); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Listen(): %w", err)
+	}
+	return l, nil
+}
+
+// ListenAndServe logs in to Vault, listens on the configured socket and serves thin clients until ctx is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	l, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve logs in to Vault and serves thin clients connecting to l until ctx is cancelled. l is closed and the socket
+// removed on return
+func (s *Server) Serve(ctx context.Context, l *net.UnixListener) error {
+	defer os.Remove(s.config.SocketPath)
+	defer l.Close()
+
+	if err := s.login(ctx); err != nil {
+		return err
+	}
+	s.config.Logger.Printf("listening on %s", s.config.SocketPath)
+
+	go s.maintain(ctx)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("Serve(): %w", err)
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+// handle serves a single request from a thin client after verifying its peer credentials
+func (s *Server) handle(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connectionTimeout))
+	enc := json.NewEncoder(conn)
+
+	uid, err := peerCredential(conn)
+	if err != nil {
+		s.config.Logger.Printf("rejecting connection: %s", err)
+		enc.Encode(Response{Error: "cannot verify peer credentials"})
+		return
+	}
+	if !slices.Contains(s.config.AllowedUIDs, uid) {
+		s.config.Logger.Printf("rejecting connection from uid %d", uid)
+		enc.Encode(Response{Error: fmt.Sprintf("uid %d is not allowed to use this broker", uid)})
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(io.LimitReader(conn, maxMessageSize)).Decode(&req); err != nil {
+		enc.Encode(Response{Error: fmt.Sprintf("malformed request: %s", err)})
+		return
+	}
+	credential, err := s.credential(ctx, req.ClusterName)
+	if err != nil {
+		s.config.Logger.Printf("cluster=%s uid=%d: %s", req.ClusterName, uid, err)
+		enc.Encode(Response{Error: err.Error()})
+		return
+	}
+	status := &clientauthentication.ExecCredentialStatus{
+		ExpirationTimestamp: &metav1.Time{Time: credential.ExpirationTimestamp},
+		Token:               credential.Token,
+	}
+	enc.Encode(Response{Status: status, Credential: credential})
+}
+
+// credential returns a copy of the cached Credential for clusterName, requesting a new token from Vault when there
+// is none or when the cached one is due for a refresh. A cached token that is still valid is served when the refresh fails
+func (s *Server) credential(ctx context.Context, clusterName string) (*Credential, error) {
+	s.credsMu.Lock()
+	c, known := s.creds[clusterName]
+	if !known {
+		c = &cachedCredential{}
+		s.creds[clusterName] = c
+	}
+	s.credsMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credential != nil && time.Now().Before(c.refreshAt) {
+		credential := *c.credential
+		return &credential, nil
+	}
+
+	s.sessionMu.RLock()
+	session := s.session
+	s.sessionMu.RUnlock()
+
+	issued, err := session.Issue(ctx, clusterName)
+	if err != nil && c.credential != nil && time.Now().Before(c.credential.ExpirationTimestamp) {
+		s.config.Logger.Printf("refresh cluster=%s failed, serving the cached token until it expires: %s", clusterName, err)
+		credential := *c.credential
+		return &credential, nil
+	}
+	if err != nil {
+		if c.credential == nil {
+			// never served this cluster successfully, do not keep it warm
+			s.credsMu.Lock()
+			delete(s.creds, clusterName)
+			s.credsMu.Unlock()
+		}
+		return nil, err
+	}
+	duration := s.config.TokenDuration
+	if issued.LeaseDuration > 0 && issued.LeaseDuration < duration {
+		duration = issued.LeaseDuration
+	}
+	issued.ExpirationTimestamp = time.Now().Add(duration)
+	c.credential = issued
+	// tokens shorter lived than the refresh margin are replaced halfway, not at every request
+	c.refreshAt = time.Now().Add(max(duration-s.config.RefreshMargin, duration/2))
+	credential := *c.credential
+	return &credential, nil
+}
+
+// login authenticates to Vault and replaces the current session
+func (s *Server) login(ctx context.Context) error {
+	session, ttl, err := s.config.Login(ctx)
+	if err != nil {
+		return fmt.Errorf("login(): %w", err)
+	}
+	s.sessionMu.Lock()
+	s.session = session
+	s.renewAt = renewalTime(ttl)
+	s.sessionMu.Unlock()
+	s.config.Logger.Printf("logged in to vault, token ttl %s", ttl)
+	return nil
+}
+
+// maintain renews the vault token and refreshes cached credentials until ctx is cancelled
+func (s *Server) maintain(ctx context.Context) {
+	interval := min(s.config.RefreshMargin/2, maxMaintenanceInterval)
+	for _, cluster := range s.config.PrefetchClusters {
+		if _, err := s.credential(ctx, cluster); err != nil {
+			s.config.Logger.Printf("prefetch cluster=%s: %s", cluster, err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.renew(ctx, interval)
+
+		s.credsMu.Lock()
+		clusters := make([]string, 0, len(s.creds))
+		for cluster := range s.creds {
+			clusters = append(clusters, cluster)
+		}
+		s.credsMu.Unlock()
+		for _, cluster := range append(clusters, s.config.PrefetchClusters...) {
+			if _, err := s.credential(ctx, cluster); err != nil {
+				s.config.Logger.Printf("refresh cluster=%s: %s", cluster, err)
+			}
+		}
+	}
+}
+
+// renew extends the vault token once it reached two thirds of its ttl. When the token cannot be renewed,
+// or renewal no longer extends it past the next maintenance interval, the broker logs in again
+func (s *Server) renew(ctx context.Context, interval time.Duration) {
+	s.sessionMu.RLock()
+	session, renewAt := s.session, s.renewAt
+	s.sessionMu.RUnlock()
+	if time.Now().Before(renewAt) {
+		return
+	}
+
+	ttl, err := session.Renew(ctx)
+	if err == nil && (ttl == 0 || ttl > 2*interval) {
+		s.sessionMu.Lock()
+		s.renewAt = renewalTime(ttl)
+		s.sessionMu.Unlock()
+		return
+	}
+	if err != nil {
+		s.config.Logger.Printf("vault token renewal failed, logging in again: %s", err)
+	}
+	if err := s.login(ctx); err != nil {
+		s.config.Logger.Printf("%s", err)
+	}
+}
+
+// renewalTime returns when a vault token with the given ttl should be renewed
+func renewalTime(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		// non expiring token
+		return time.Now().Add(100 * 365 * 24 * time.Hour)
+	}
+	return time.Now().Add(ttl * 2 / 3)
+}
@@ -0,0 +1,126 @@
+// Package clientgo lets programs built on client-go use Vault issued kubernetes bearer tokens in-process,
+// without running kubectl-vaultlogin as an exec credential plugin
+package clientgo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"k8s.io/client-go/rest"
+)
+
+// defaultRefreshMargin is how long before expiration a cached kubernetes bearer token gets replaced
+const defaultRefreshMargin = 2 * time.Minute
+
+// CredentialIssuer issues kubernetes bearer tokens for a downstream cluster, it is implemented by *federate.Federator
+type CredentialIssuer interface {
+	Credential(ctx context.Context, clusterName string) (*federate.Credential, error)
+}
+
+// TokenSource caches the kubernetes bearer token of a downstream cluster and requests a new one from Vault
+// before it expires or once the API server rejected it. It is safe for concurrent use
+type TokenSource struct {
+	issuer        CredentialIssuer
+	clusterName   string
+	refreshMargin time.Duration
+
+	mu         sync.Mutex
+	credential *federate.Credential
+	refreshAt  time.Time
+}
+
+// NewTokenSource returns a TokenSource for clusterName backed by issuer.
+// refreshMargin is how long before expiration the token gets replaced, but not before half of its lifetime.
+// 0 applies a default of 2 minutes
+func NewTokenSource(issuer CredentialIssuer, clusterName string, refreshMargin time.Duration) *TokenSource {
+	if refreshMargin <= 0 {
+		refreshMargin = defaultRefreshMargin
+	}
+	return &TokenSource{
+		issuer:        issuer,
+		clusterName:   clusterName,
+		refreshMargin: refreshMargin,
+	}
+}
+
+// Token returns a cached kubernetes bearer token, requesting a new one when there is none or when it is due for a refresh
+func (s *TokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credential != nil && time.Now().Before(s.refreshAt) {
+		return s.credential.Token, nil
+	}
+
+	credential, err := s.issuer.Credential(ctx, s.clusterName)
+	if err != nil {
+		return "", fmt.Errorf("TokenSource.Token(): cluster=%s: %w", s.clusterName, err)
+	}
+	s.credential = credential
+	// the token is valid for the shorter of the configured token duration and its vault lease
+	lifetime := time.Until(credential.ExpirationTimestamp)
+	if credential.LeaseDuration > 0 {
+		lifetime = min(lifetime, credential.LeaseDuration)
+	}
+	// tokens shorter lived than the refresh margin are replaced halfway, not at every call
+	s.refreshAt = time.Now().Add(max(lifetime-s.refreshMargin, lifetime/2))
+	return credential.Token, nil
+}
+
+// Invalidate drops token from the cache, so that the next call to Token requests a new one.
+// It is a no-op when the cache already holds a different token
+func (s *TokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.credential != nil && s.credential.Token == token {
+		s.credential = nil
+	}
+}
+
+// WrapTransport returns a http.RoundTripper that authenticates requests with the TokenSource's bearer token.
+// It matches the signature of rest.Config.WrapTransport
+func (s *TokenSource) WrapTransport(rt http.RoundTripper) http.RoundTripper {
+	return &roundTripper{source: s, next: rt}
+}
+
+// Configure sets up config to authenticate with the TokenSource, replacing any other credentials it carries
+func Configure(config *rest.Config, source *TokenSource) {
+	config.BearerToken = ""
+	config.BearerTokenFile = ""
+	config.Username = ""
+	config.Password = ""
+	config.ExecProvider = nil
+	config.AuthProvider = nil
+	config.Wrap(source.WrapTransport)
+}
+
+// roundTripper adds the bearer token to each request and invalidates the token when the API server responds with 401
+type roundTripper struct {
+	source *TokenSource
+	next   http.RoundTripper
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// a RoundTripper must not modify the request it was given
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := rt.next.RoundTrip(authenticated)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		rt.source.Invalidate(token)
+	}
+	return resp, err
+}
+
+// WrappedRoundTripper lets client-go utilities unwrap the transport chain
+func (rt *roundTripper) WrappedRoundTripper() http.RoundTripper {
+	return rt.next
+}
@@ -0,0 +1,95 @@
+package clientgo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+// fakeIssuer issues numbered tokens for a configurable duration
+type fakeIssuer struct {
+	mu       sync.Mutex
+	issued   int
+	duration time.Duration
+	lease    time.Duration
+}
+
+func (f *fakeIssuer) Credential(ctx context.Context, clusterName string) (*federate.Credential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.issued++
+	return &federate.Credential{
+		ClusterName:         clusterName,
+		Token:               fmt.Sprintf("%s-token-%d", clusterName, f.issued),
+		LeaseDuration:       f.lease,
+		ExpirationTimestamp: time.Now().Add(f.duration),
+	}, nil
+}
+
+// tests if the token is cached until it expires within the refresh margin, or until half of its lifetime
+// when it is shorter lived than the refresh margin
+func TestTokenSourceCaches(t *testing.T) {
+	issuer := &fakeIssuer{duration: 15 * time.Minute}
+	source := NewTokenSource(issuer, "dev", 0)
+
+	first, err := source.Token(context.Background())
+	require.NoError(t, err)
+	second, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "dev-token-1", first)
+	assert.Equal(t, first, second)
+
+	// a vault lease shorter than the refresh margin is used for half of its lifetime
+	issuer.lease = 400 * time.Millisecond
+	source.Invalidate(first)
+	third, err := source.Token(context.Background())
+	require.NoError(t, err)
+	fourth, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, third, fourth)
+	time.Sleep(250 * time.Millisecond)
+	fifth, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, fourth, fifth)
+	assert.Equal(t, 3, issuer.issued)
+}
+
+// tests if a rest.Config configured with a TokenSource authenticates requests
+// and requests a new token after the API server responded with 401
+func TestConfigureInvalidatesOn401(t *testing.T) {
+	var seen []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		if len(seen) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &rest.Config{
+		Host:            server.URL,
+		BearerToken:     "static",
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+	}
+	Configure(config, NewTokenSource(&fakeIssuer{duration: 15 * time.Minute}, "dev", 0))
+	client, err := rest.HTTPClientFor(config)
+	require.NoError(t, err)
+
+	for range 3 {
+		resp, err := client.Get(server.URL + "/api")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"Bearer dev-token-1", "Bearer dev-token-2", "Bearer dev-token-2"}, seen)
+}
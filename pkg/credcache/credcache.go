@@ -0,0 +1,114 @@
+// Package credcache keeps kubernetes bearer tokens issued by Vault on disk, one file per downstream cluster
+// and configuration, so that federate can answer kubectl without contacting Vault while a token is still valid
+package credcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+)
+
+// DefaultMargin is how long before its expiration a cached credential is no longer handed out
+const DefaultMargin = 2 * time.Minute
+
+// Cache stores credentials as <Dir>/<cluster name>.<key>.json, readable only by their owner. The key is a digest
+// of the configuration the credential was issued with, see federate.Federator.CacheKey, so that a credential is
+// never handed out to a call with another Vault, auth identity, secrets mount or role
+type Cache struct {
+	Dir string
+	// Margin before expiration after which Get ignores a credential, defaults to DefaultMargin
+	Margin time.Duration
+}
+
+// New returns a Cache storing credentials in dir
+func New(dir string) *Cache {
+	return &Cache{Dir: dir, Margin: DefaultMargin}
+}
+
+// Get returns the credential of clusterName cached under key. It reports false when there is none or it expires within the margin
+func (c *Cache) Get(clusterName, key string) (*federate.Credential, bool) {
+	path, err := c.path(clusterName, key)
+	if err != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var credential federate.Credential
+	if err := json.Unmarshal(data, &credential); err != nil || credential.Token == "" {
+		return nil, false
+	}
+	if time.Until(credential.ExpirationTimestamp) <= c.margin() {
+		return nil, false
+	}
+	return &credential, true
+}
+
+// Put stores credential under key, replacing the one cached for its cluster and key atomically
+func (c *Cache) Put(credential *federate.Credential, key string) error {
+	path, err := c.path(credential.ClusterName, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return fmt.Errorf("credcache: cannot create %s: %w", c.Dir, err)
+	}
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("credcache: cannot marshal credential of %s: %w", credential.ClusterName, err)
+	}
+	tmp, err := os.CreateTemp(c.Dir, "."+credential.ClusterName+"-*.json")
+	if err != nil {
+		return fmt.Errorf("credcache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp creates the file with mode 0600
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("credcache: cannot write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("credcache: cannot write %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("credcache: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the credential of clusterName cached under key, if any
+func (c *Cache) Delete(clusterName, key string) error {
+	path, err := c.path(clusterName, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("credcache: %w", err)
+	}
+	return nil
+}
+
+// path returns the file of clusterName and key, refusing names that would escape Dir
+func (c *Cache) path(clusterName, key string) (string, error) {
+	if clusterName == "" || clusterName != filepath.Base(clusterName) || clusterName[0] == '.' {
+		return "", fmt.Errorf("credcache: invalid cluster name: %q", clusterName)
+	}
+	if key == "" || key != filepath.Base(key) || strings.Contains(key, ".") {
+		return "", fmt.Errorf("credcache: invalid key: %q", key)
+	}
+	return filepath.Join(c.Dir, clusterName+"."+key+".json"), nil
+}
+
+func (c *Cache) margin() time.Duration {
+	if c.Margin <= 0 {
+		return DefaultMargin
+	}
+	return c.Margin
+}
@@ -0,0 +1,84 @@
+package credcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutGet(t *testing.T) {
+	cache := New(filepath.Join(t.TempDir(), "cache"))
+	credential := &federate.Credential{
+		ClusterName:         "dev",
+		Token:               "k8s-token",
+		LeaseID:             "kubernetes/dev/creds/kvl-edit-role/1",
+		ExpirationTimestamp: time.Now().Add(15 * time.Minute).Round(time.Second),
+	}
+	require.NoError(t, cache.Put(credential, "key1"))
+
+	cached, ok := cache.Get("dev", "key1")
+	require.True(t, ok)
+	assert.Equal(t, credential.Token, cached.Token)
+	assert.Equal(t, credential.LeaseID, cached.LeaseID)
+	assert.True(t, credential.ExpirationTimestamp.Equal(cached.ExpirationTimestamp))
+
+	// tokens are only readable by their owner
+	info, err := os.Stat(filepath.Join(cache.Dir, "dev.key1.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	info, err = os.Stat(cache.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	_, ok = cache.Get("prod", "key1")
+	assert.False(t, ok)
+
+	require.NoError(t, cache.Delete("dev", "key1"))
+	_, ok = cache.Get("dev", "key1")
+	assert.False(t, ok)
+	assert.NoError(t, cache.Delete("dev", "key1"))
+}
+
+func TestGetExpiring(t *testing.T) {
+	cache := New(t.TempDir())
+	require.NoError(t, cache.Put(&federate.Credential{ClusterName: "dev", Token: "k8s-token", ExpirationTimestamp: time.Now().Add(time.Minute)}, "key1"))
+	_, ok := cache.Get("dev", "key1")
+	assert.False(t, ok)
+
+	cache.Margin = 30 * time.Second
+	_, ok = cache.Get("dev", "key1")
+	assert.True(t, ok)
+}
+
+func TestInvalidClusterName(t *testing.T) {
+	cache := New(t.TempDir())
+	for _, clusterName := range []string{"", "../dev", ".hidden", "a/b"} {
+		assert.Error(t, cache.Put(&federate.Credential{ClusterName: clusterName, Token: "k8s-token", ExpirationTimestamp: time.Now().Add(time.Hour)}, "key1"))
+		_, ok := cache.Get(clusterName, "key1")
+		assert.False(t, ok)
+	}
+	for _, key := range []string{"", "../key", "a.b"} {
+		assert.Error(t, cache.Put(&federate.Credential{ClusterName: "dev", Token: "k8s-token", ExpirationTimestamp: time.Now().Add(time.Hour)}, key))
+	}
+}
+
+// tests a credential is only handed out under the key it was cached with
+func TestGetOtherKey(t *testing.T) {
+	cache := New(t.TempDir())
+	require.NoError(t, cache.Put(&federate.Credential{ClusterName: "dev", Token: "edit-token", ExpirationTimestamp: time.Now().Add(time.Hour)}, "edit"))
+	_, ok := cache.Get("dev", "view")
+	assert.False(t, ok)
+
+	require.NoError(t, cache.Put(&federate.Credential{ClusterName: "dev", Token: "view-token", ExpirationTimestamp: time.Now().Add(time.Hour)}, "view"))
+	cached, ok := cache.Get("dev", "edit")
+	require.True(t, ok)
+	assert.Equal(t, "edit-token", cached.Token)
+	cached, ok = cache.Get("dev", "view")
+	require.True(t, ok)
+	assert.Equal(t, "view-token", cached.Token)
+}
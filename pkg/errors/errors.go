@@ -1,8 +1,70 @@
 package errors
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Category classifies kubectl-vaultlogin errors so wrappers and ArgoCD hooks can react to them, each category
+// has a stable exit code:
+//
+//	1 general             an error that falls in no other category
+//	2 usage               improper command line, ex. an unknown or missing flag
+//	3 input_validation    malformed flags, environment variables or configuration file
+//	4 exec_info           missing or malformed ExecCredential in KUBERNETES_EXEC_INFO
+//	5 vault_unreachable   Vault cannot be reached, is sealed or fails with a 5xx status
+//	6 vault_auth_denied   Vault rejects the login
+//	7 secrets_denied      Vault rejects the request for a kubernetes bearer token
+//	8 output              the ExecCredential cannot be written
+type Category int
+
+const (
+	General Category = iota
+	Usage
+	InputValidation
+	ExecInfo
+	VaultUnreachable
+	VaultAuthDenied
+	SecretsDenied
+	Output
+)
+
+// categoryNames are the names of categories as reported in machine-readable errors
+var categoryNames = map[Category]string{
+	General:          "general",
+	Usage:            "usage",
+	InputValidation:  "input_validation",
+	ExecInfo:         "exec_info",
+	VaultUnreachable: "vault_unreachable",
+	VaultAuthDenied:  "vault_auth_denied",
+	SecretsDenied:    "secrets_denied",
+	Output:           "output",
+}
+
+// String returns the name of the category
+func (c Category) String() string {
+	if name, exists := categoryNames[c]; exists {
+		return name
+	}
+	return categoryNames[General]
+}
+
+// ExitCode returns the exit code of the category
+func (c Category) ExitCode() int {
+	if _, exists := categoryNames[c]; !exists {
+		return 1
+	}
+	return int(c) + 1
+}
+
 // implements kubectl-vaultlogin error type to be able to differentiate between errors from cobra command and the kubectl-vaultlogin plugin
 type KvlError struct {
-	Message string
+	Message  string
+	Category Category
+	// Cause is the wrapped error, if any
+	Cause error
 }
 
 // KvlError is a Error type
@@ -10,6 +72,70 @@ func (e KvlError) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the cause of the error
+func (e KvlError) Unwrap() error {
+	return e.Cause
+}
+
 func New(message string) error {
 	return KvlError{Message: message}
 }
+
+// Wrap returns err as a KvlError of category. When err already is, or wraps, a KvlError of a category other
+// than General, that more specific category is kept
+func Wrap(category Category, err error) error {
+	if err == nil {
+		return nil
+	}
+	var kvlErr KvlError
+	if errors.As(err, &kvlErr) && kvlErr.Category != General {
+		category = kvlErr.Category
+	}
+	return KvlError{Message: err.Error(), Category: category, Cause: err}
+}
+
+// CategoryOf returns the category of err, General for errors that are not KvlErrors. The command line parser's
+// errors are wrapped as Usage errors by the commands
+func CategoryOf(err error) Category {
+	var kvlErr KvlError
+	if errors.As(err, &kvlErr) {
+		return kvlErr.Category
+	}
+	return General
+}
+
+// ExitStatus is the exit code of a command run by kubectl-vaultlogin, ex. by exec, which is passed on as is
+type ExitStatus struct {
+	Code int
+}
+
+// ExitStatus is a Error type
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// ExitCode returns the exit code for err, 0 for a nil error and the code of an ExitStatus
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var status ExitStatus
+	if errors.As(err, &status) {
+		return status.Code
+	}
+	return CategoryOf(err).ExitCode()
+}
+
+// WriteJSON writes err to w as a single line JSON object carrying its message, category and exit code
+func WriteJSON(w io.Writer, err error) error {
+	category := CategoryOf(err)
+	return json.NewEncoder(w).Encode(struct {
+		Message  string `json:"message"`
+		Category string `json:"category"`
+		ExitCode int    `json:"exit_code"`
+	}{
+		Message:  err.Error(),
+		Category: category.String(),
+		ExitCode: category.ExitCode(),
+	})
+}
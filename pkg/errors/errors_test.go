@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -20,3 +21,37 @@ func TestError(t *testing.T) {
 	errMessage := kvlerr.Error()
 	assert.Equal(t, kvlTestError, errMessage)
 }
+
+func TestWrapKeepsSpecificCategory(t *testing.T) {
+	cause := fmt.Errorf("connection refused")
+	err := Wrap(VaultUnreachable, cause)
+	assert.Equal(t, "connection refused", err.Error())
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, VaultUnreachable, CategoryOf(err))
+
+	// a more specific category from a deeper layer wins over the caller's
+	assert.Equal(t, VaultUnreachable, CategoryOf(Wrap(General, fmt.Errorf("login: %w", err))))
+	assert.Equal(t, InputValidation, CategoryOf(Wrap(InputValidation, New(kvlTestError))))
+	assert.NoError(t, Wrap(Output, nil))
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, 1, ExitCode(New(kvlTestError)))
+	assert.Equal(t, 1, ExitCode(fmt.Errorf("unexpected EOF")))
+	assert.Equal(t, 2, ExitCode(Wrap(Usage, fmt.Errorf("unknown flag: --bogus"))))
+	assert.Equal(t, 3, ExitCode(Wrap(InputValidation, fmt.Errorf("malformed TOKEN_DURATION"))))
+	assert.Equal(t, 4, ExitCode(Wrap(ExecInfo, fmt.Errorf("KUBERNETES_EXEC_INFO is unset"))))
+	assert.Equal(t, 5, ExitCode(Wrap(VaultUnreachable, fmt.Errorf("Vault is sealed"))))
+	assert.Equal(t, 6, ExitCode(Wrap(VaultAuthDenied, fmt.Errorf("permission denied"))))
+	assert.Equal(t, 7, ExitCode(Wrap(SecretsDenied, fmt.Errorf("permission denied"))))
+	assert.Equal(t, 8, ExitCode(Wrap(Output, fmt.Errorf("broken pipe"))))
+	assert.Equal(t, 42, ExitCode(ExitStatus{Code: 42}))
+	assert.Equal(t, 130, ExitCode(fmt.Errorf("helm: %w", ExitStatus{Code: 130})))
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteJSON(&buf, Wrap(VaultAuthDenied, fmt.Errorf("permission denied"))))
+	assert.JSONEq(t, `{"message":"permission denied","category":"vault_auth_denied","exit_code":6}`, buf.String())
+}
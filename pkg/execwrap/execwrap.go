@@ -0,0 +1,226 @@
+// Package execwrap runs commands that only accept a kubeconfig holding a static token, ex. some Terraform and Helm
+// setups, with a temporary kubeconfig holding a Vault issued kubernetes bearer token
+package execwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/clientgo"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// defaultRefreshMargin is how long before expiration the token of the kubeconfig gets replaced
+const defaultRefreshMargin = 2 * time.Minute
+
+// retryInterval is how long a failed refresh waits before the next attempt
+var retryInterval = 10 * time.Second
+
+// Config holds the settings of a wrapped command
+type Config struct {
+	// ClusterName is the downstream cluster tokens are requested for
+	ClusterName string
+	// Server is the URL of the downstream kubernetes API server, CAData its PEM encoded CA. TLSServerName and
+	// Insecure are written to the kubeconfig as they are
+	Server        string
+	CAData        []byte
+	TLSServerName string
+	Insecure      bool
+	// Issuer requests kubernetes bearer tokens, ex. a *federate.Federator
+	Issuer clientgo.CredentialIssuer
+	// RefreshMargin is how long before expiration the token gets replaced, defaults to 2 minutes
+	RefreshMargin time.Duration
+	// Dir holds the temporary kubeconfig, defaults to the temp directory
+	Dir string
+	// Signals received on Signals are forwarded to the command, ex. from signal.Notify
+	Signals <-chan os.Signal
+	// Stdin, Stdout and Stderr of the command, default to those of the process
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Logger receives operational messages, if nil they are discarded
+	Logger *log.Logger
+}
+
+// Run requests a token, writes it along with the API server to a temporary kubeconfig readable by its owner only
+// and runs name with args and KUBECONFIG pointing at it. The token is replaced before it expires while the command
+// runs, the kubeconfig is overwritten and removed once it exits. It returns the exit code of the command, 128 plus
+// the signal number when a signal ended it
+func Run(ctx context.Context, config Config, name string, args ...string) (int, error) {
+	if config.Issuer == nil {
+		return 0, errors.New("Run() a credential issuer is required")
+	}
+	if config.RefreshMargin <= 0 {
+		config.RefreshMargin = defaultRefreshMargin
+	}
+	if config.Logger == nil {
+		config.Logger = log.New(io.Discard, "", 0)
+	}
+	credential, err := config.Issuer.Credential(ctx, config.ClusterName)
+	if err != nil {
+		return 0, fmt.Errorf("Run() %w", err)
+	}
+
+	file, err := os.CreateTemp(config.Dir, "kubeconfig-*.yaml")
+	if err != nil {
+		return 0, fmt.Errorf("Run() cannot create the temporary kubeconfig: %w", err)
+	}
+	file.Close()
+	path := file.Name()
+	defer func() {
+		if err := shred(path); err != nil {
+			config.Logger.Printf("%s", err)
+		}
+	}()
+	if err := config.writeKubeconfig(path, credential.Token); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = config.Stdin, config.Stdout, config.Stderr
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("Run() cannot start %s: %w", name, err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	refresh := time.NewTimer(config.refreshIn(credential))
+	defer refresh.Stop()
+	for {
+		select {
+		case err := <-exited:
+			return exitCode(err)
+		case sig := <-config.Signals:
+			cmd.Process.Signal(sig)
+		case <-refresh.C:
+			credential, err := config.Issuer.Credential(ctx, config.ClusterName)
+			if err == nil {
+				err = config.writeKubeconfig(path, credential.Token)
+			}
+			if err != nil {
+				config.Logger.Printf("token refresh failed, retrying in %s: %s", retryInterval, err)
+				refresh.Reset(retryInterval)
+				continue
+			}
+			config.Logger.Printf("token of cluster %s refreshed", config.ClusterName)
+			refresh.Reset(config.refreshIn(credential))
+		}
+	}
+}
+
+// refreshIn returns how long until the token of credential gets replaced: the refresh margin before it expires,
+// but not before half of its lifetime, so short-lived tokens are not requested over and over
+func (c *Config) refreshIn(credential *federate.Credential) time.Duration {
+	lifetime := time.Until(credential.ExpirationTimestamp)
+	if credential.LeaseDuration > 0 && credential.LeaseDuration < lifetime {
+		lifetime = credential.LeaseDuration
+	}
+	return max(lifetime-c.RefreshMargin, lifetime/2)
+}
+
+// writeKubeconfig replaces the kubeconfig at path with one holding token. The new kubeconfig is written next to
+// it and renamed over it, so readers never see a partial file, then the content of the previous one is overwritten
+func (c *Config) writeKubeconfig(path string, token string) error {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[c.ClusterName] = &clientcmdapi.Cluster{
+		Server:                   c.Server,
+		CertificateAuthorityData: c.CAData,
+		TLSServerName:            c.TLSServerName,
+		InsecureSkipTLSVerify:    c.Insecure,
+	}
+	kubeconfig.AuthInfos[c.ClusterName] = &clientcmdapi.AuthInfo{Token: token}
+	kubeconfig.Contexts[c.ClusterName] = &clientcmdapi.Context{Cluster: c.ClusterName, AuthInfo: c.ClusterName}
+	kubeconfig.CurrentContext = c.ClusterName
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("writeKubeconfig() %w", err)
+	}
+
+	next, err := os.CreateTemp(filepath.Dir(path), "kubeconfig-*.yaml")
+	if err != nil {
+		return fmt.Errorf("writeKubeconfig() %w", err)
+	}
+	_, err = next.Write(content)
+	if closeErr := next.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(next.Name())
+		return fmt.Errorf("writeKubeconfig() %w", err)
+	}
+	previous, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		os.Remove(next.Name())
+		return fmt.Errorf("writeKubeconfig() %w", err)
+	}
+	defer previous.Close()
+	if err := os.Rename(next.Name(), path); err != nil {
+		os.Remove(next.Name())
+		return fmt.Errorf("writeKubeconfig() %w", err)
+	}
+	return overwrite(previous)
+}
+
+// shred overwrites the content of the file at path and removes it
+func shred(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("shred() %w", err)
+	}
+	err = overwrite(file)
+	file.Close()
+	if removeErr := os.Remove(path); err == nil && removeErr != nil {
+		err = fmt.Errorf("shred() %w", removeErr)
+	}
+	return err
+}
+
+// overwrite writes zeros over the content of file and syncs it to disk
+func overwrite(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("overwrite() %w", err)
+	}
+	if _, err := file.WriteAt(make([]byte, info.Size()), 0); err != nil {
+		return fmt.Errorf("overwrite() %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("overwrite() %w", err)
+	}
+	return nil
+}
+
+// exitCode returns the exit code of a command that ended with err, as returned by Wait
+func exitCode(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		if err != nil {
+			return 0, fmt.Errorf("Run() %w", err)
+		}
+		return 0, nil
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
@@ -0,0 +1,160 @@
+package execwrap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// fakeIssuer issues numbered tokens valid for validity, 15 minutes when unset, or fails once err is set
+type fakeIssuer struct {
+	mu       sync.Mutex
+	issued   int
+	validity time.Duration
+	err      error
+}
+
+func (f *fakeIssuer) Credential(ctx context.Context, clusterName string) (*federate.Credential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.validity == 0 {
+		f.validity = 15 * time.Minute
+	}
+	f.issued++
+	return &federate.Credential{
+		ClusterName:         clusterName,
+		Token:               fmt.Sprintf("%s-token-%d", clusterName, f.issued),
+		ExpirationTimestamp: time.Now().Add(f.validity),
+	}, nil
+}
+
+// testConfig returns the Config of a command of cluster dev keeping its kubeconfig in a temporary directory, and
+// sets OUT to another one the command copies files to
+func testConfig(t *testing.T, issuer *fakeIssuer) (Config, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are run with sh")
+	}
+	out := t.TempDir()
+	t.Setenv("OUT", out)
+	return Config{
+		ClusterName: "dev",
+		Server:      "https://dev.example.com:6443",
+		CAData:      []byte("dev-ca"),
+		Issuer:      issuer,
+		Dir:         t.TempDir(),
+		Stdin:       strings.NewReader(""),
+		Stdout:      io.Discard,
+		Stderr:      io.Discard,
+	}, out
+}
+
+// tokenOf returns the token of the kubeconfig at path
+func tokenOf(t *testing.T, path string) string {
+	kubeconfig, err := clientcmd.LoadFromFile(path)
+	require.NoError(t, err)
+	return kubeconfig.AuthInfos[kubeconfig.Contexts[kubeconfig.CurrentContext].AuthInfo].Token
+}
+
+// tests the command reads a kubeconfig of its owner only holding the API server, its CA and the token, which is
+// removed once the command exits with its exit code passed on
+func TestRun(t *testing.T) {
+	config, out := testConfig(t, &fakeIssuer{})
+	code, err := Run(context.Background(), config, "sh", "-c", `ls -l "$KUBECONFIG" > "$OUT/mode"; cp "$KUBECONFIG" "$OUT/kubeconfig"; exit 3`)
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+
+	mode, err := os.ReadFile(filepath.Join(out, "mode"))
+	require.NoError(t, err)
+	assert.Regexp(t, `^-rw------- `, string(mode))
+	kubeconfig, err := clientcmd.LoadFromFile(filepath.Join(out, "kubeconfig"))
+	require.NoError(t, err)
+	assert.Equal(t, "dev", kubeconfig.CurrentContext)
+	assert.Equal(t, "https://dev.example.com:6443", kubeconfig.Clusters["dev"].Server)
+	assert.Equal(t, []byte("dev-ca"), kubeconfig.Clusters["dev"].CertificateAuthorityData)
+	assert.Equal(t, "dev-token-1", kubeconfig.AuthInfos["dev"].Token)
+
+	left, err := os.ReadDir(config.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+
+	code, err = Run(context.Background(), config, "true")
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+}
+
+// tests the token of the kubeconfig is replaced before it expires while the command runs
+func TestRunRefreshes(t *testing.T) {
+	config, out := testConfig(t, &fakeIssuer{validity: 2 * time.Second})
+	config.RefreshMargin = 1500 * time.Millisecond
+	code, err := Run(context.Background(), config, "sh", "-c", `cp "$KUBECONFIG" "$OUT/first"; sleep 2; cp "$KUBECONFIG" "$OUT/second"`)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+
+	assert.Equal(t, "dev-token-1", tokenOf(t, filepath.Join(out, "first")))
+	assert.NotEqual(t, "dev-token-1", tokenOf(t, filepath.Join(out, "second")))
+	left, err := os.ReadDir(config.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+// tests signals are forwarded to the command and a command ended by a signal exits with 128 plus its number
+func TestRunSignals(t *testing.T) {
+	config, _ := testConfig(t, &fakeIssuer{})
+	signals := make(chan os.Signal, 1)
+	config.Signals = signals
+	stdout, stdoutWriter := io.Pipe()
+	config.Stdout = stdoutWriter
+	done := make(chan int)
+	go func() {
+		code, err := Run(context.Background(), config, "sh", "-c", `trap 'exit 7' TERM; echo ready; while :; do sleep 0.1; done`)
+		assert.NoError(t, err)
+		stdoutWriter.Close()
+		done <- code
+	}()
+	ready, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ready\n", ready)
+	signals <- syscall.SIGTERM
+	go io.Copy(io.Discard, stdout)
+	assert.Equal(t, 7, <-done)
+
+	code, err := Run(context.Background(), Config{ClusterName: "dev", Issuer: &fakeIssuer{}, Dir: config.Dir, Stdin: strings.NewReader("")}, "sh", "-c", `kill -TERM $$`)
+	require.NoError(t, err)
+	assert.Equal(t, 128+int(syscall.SIGTERM), code)
+}
+
+// tests no command is run when no token is issued and failures leave no kubeconfig behind
+func TestRunErrors(t *testing.T) {
+	config, _ := testConfig(t, &fakeIssuer{err: errors.New("permission denied")})
+	_, err := Run(context.Background(), config, "true")
+	assert.ErrorContains(t, err, "permission denied")
+
+	config.Issuer = &fakeIssuer{}
+	_, err = Run(context.Background(), config, "kubectl-vaultlogin-no-such-command")
+	assert.ErrorContains(t, err, "cannot start kubectl-vaultlogin-no-such-command")
+	left, err := os.ReadDir(config.Dir)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+
+	config.Issuer = nil
+	_, err = Run(context.Background(), config, "true")
+	assert.ErrorContains(t, err, "a credential issuer is required")
+}
@@ -3,67 +3,489 @@ package federate
 import (
 	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	vaultcg "github.com/hashicorp/vault-client-go"
+	"github.com/spf13/pflag"
 )
 
+// AuthMethodApprole is the name of the approle authentication method
+const AuthMethodApprole = "approle"
+
 // const to define cobra command flag name that supplies mount point path for Vault's approle authentication
 const FlagVaultApproleAuthMount = "vault-approle-auth-mount"
 
-// FederateWithApprole() perfoms all actions resulting from the federate approle subcommand to request a new kubernetes bearer token
-// and responds with a corresponding ExecCredetnial written to STDOUT
-// ctx - is context created by cobra subcommand RunE function
-// args - are args passed from the cobra subcommand RunE function
-// test - indicates if this is a test run (true) or actual request (false). If true no communication with Vault is perfomed, instead a fake beaer token is created
-func FederateWithApprole(ctx *context.Context, args map[string]any, test bool) error {
-	// fmt.Printf("Path to PSAT: %s\nAuth Mount Point: %s\n", args["psat-path"], args[FlagVaultApproleAuthMount])
+// const to define cobra command flag names that supply files holding approle credentials, - designates STDIN
+const (
+	FlagRoleIDFile                = "role-id-file"
+	FlagSecretIDFile              = "secret-id-file"
+	FlagSecretIDWrappingTokenFile = "secret-id-wrapping-token-file"
+)
+
+// const to define cobra command flag name that supplies the name of the approle role
+const FlagApproleRoleName = "approle-role-name"
+
+// const to define cobra command flag names that turn on SecretID rotation and supply the age after which the SecretID is rotated
+const (
+	FlagRotateSecretID    = "rotate-secret-id"
+	FlagSecretIDMaxAge    = "secret-id-max-age"
+	DefaultSecretIDMaxAge = 24 * time.Hour
+)
+
+// DefaultVaultApproleAuthMount defines Vault's default approle mount point
+const DefaultVaultApproleAuthMount = "/approle"
+
+// stdinFile designates STDIN in place of a file path
+const stdinFile = "-"
+
+// stdin is where credentials designated by stdinFile are read from
+var stdin io.Reader = os.Stdin
+
+func init() {
+	RegisterAuthenticator(AuthMethodApprole, func() Authenticator { return &ApproleAuth{} })
+}
+
+// ApproleAuth authenticates to Vault's approle authentication backend with a RoleID and SecretID
+type ApproleAuth struct {
+	// Mount is Vault's approle authentication mount point, defaults to /approle
+	Mount string
+	// RoleID and SecretID are the approle credentials
+	RoleID   string
+	SecretID string
+	// RoleIDFile and SecretIDFile are files holding the credentials, read at every login and preferred
+	// over RoleID and SecretID. - designates STDIN, which is read once by Validate
+	RoleIDFile   string
+	SecretIDFile string
+	// SecretIDWrappingToken is a response-wrapping token of a SecretID, it is unwrapped at the first login and the
+	// SecretID kept in memory, so it is refused by Federators with Options.OneShot.
+	// SecretIDWrappingTokenFile is a file holding it, preferred over SecretIDWrappingToken
+	SecretIDWrappingToken     string
+	SecretIDWrappingTokenFile string
+	// RoleName is the name of the approle role. When set, a wrapped SecretID must have been created for it
+	RoleName string
+	// RotateSecretID turns on the rotation of the SecretID in SecretIDFile once it is older than SecretIDMaxAge:
+	// after a login a new SecretID of the role is generated, replaces the file and the old one is destroyed.
+	// The role's policy must allow it to generate, look up and destroy its own SecretIDs
+	RotateSecretID bool
+	SecretIDMaxAge time.Duration
+	// Logger receives warnings of failed rotations, which do not fail the login. Defaults to STDERR
+	Logger *log.Logger
+
+	// mu guards SecretID and SecretIDWrappingToken, which are replaced once the SecretID is unwrapped
+	mu sync.Mutex
+}
+
+// Name returns the name of the approle authentication method
+func (a *ApproleAuth) Name() string {
+	return AuthMethodApprole
+}
+
+// loginFields returns the mount point, it may be a Go template over TemplateData. approle logins have no role
+func (a *ApproleAuth) loginFields() (string, string) {
+	return a.Mount, ""
+}
+
+// withLoginFields returns the authenticator logging in at mount. It shares the credentials of a, including
+// a SecretID unwrapped or rotated by any of its logins
+func (a *ApproleAuth) withLoginFields(mount string, _ string) Authenticator {
+	return &approleAtMount{ApproleAuth: a, mount: mount}
+}
+
+// approleAtMount is an ApproleAuth logging in at another mount point than its Mount
+type approleAtMount struct {
+	*ApproleAuth
+	mount string
+}
+
+// Login authenticates to Vault at the mount point, see ApproleAuth.Login
+func (a *approleAtMount) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	return a.login(ctx, client, a.mount)
+}
+
+// PlanLogin describes the login at the mount point for dry runs
+func (a *approleAtMount) PlanLogin() LoginPlan {
+	return a.planLogin(a.mount)
+}
+
+// Usage returns the description of the approle subcommand
+func (a *ApproleAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using approle authentication",
+		`Authenticates to Hashicorp Vault using approle authentication.
+It expects the Role ID and Secret ID to be suupplied in environemtn variables:
+APPROLE_ROLE_ID and APPROLE_SECRET_ID respectively, or in files given with --role-id-file and --secret-id-file,
+where - designates STDIN. A response-wrapped Secret ID is accepted with --secret-id-wrapping-token-file
+or APPROLE_SECRET_ID_WRAPPING_TOKEN, it is unwrapped after verifying it was created by approle's secret-id endpoint.
+As a wrapping token is single use and the Secret ID is only kept in memory, it is accepted by the long-lived
+broker, proxy and exec commands only.`
+}
+
+// AddFlags registers the flags of the approle subcommand
+func (a *ApproleAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultApproleAuthMount, "a", DefaultVaultApproleAuthMount, "vault approle authentication mountpoint, ex: /approle")
+	flags.StringVar(&a.RoleIDFile, FlagRoleIDFile, "", "file holding the approle role id, - reads it from STDIN. Preferred over APPROLE_ROLE_ID")
+	flags.StringVar(&a.SecretIDFile, FlagSecretIDFile, "", "file holding the approle secret id, - reads it from STDIN. Preferred over APPROLE_SECRET_ID")
+	flags.StringVar(&a.SecretIDWrappingTokenFile, FlagSecretIDWrappingTokenFile, "", "file holding a response-wrapping token of the approle secret id, - reads it from STDIN. Preferred over APPROLE_SECRET_ID_WRAPPING_TOKEN. Single use, only accepted by the broker, proxy and exec commands")
+	flags.StringVar(&a.RoleName, FlagApproleRoleName, "", "name of the approle role, a wrapped secret id must have been created for it. Defaults to the role_name metadata of the vault token when rotating the secret id")
+	flags.BoolVar(&a.RotateSecretID, FlagRotateSecretID, false, "rotate the secret id in --secret-id-file after login once it is older than --secret-id-max-age, destroying the old secret id")
+	flags.DurationVar(&a.SecretIDMaxAge, FlagSecretIDMaxAge, DefaultSecretIDMaxAge, "age of the secret id file after which the secret id is rotated")
+	return nil
+}
+
+// LoadEnv fills unset credentials from APPROLE_ROLE_ID, APPROLE_SECRET_ID and APPROLE_SECRET_ID_WRAPPING_TOKEN
+func (a *ApproleAuth) LoadEnv() error {
+	if a.RoleID == "" {
+		a.RoleID = os.Getenv("APPROLE_ROLE_ID")
+	}
+	if a.SecretID == "" {
+		a.SecretID = os.Getenv("APPROLE_SECRET_ID")
+	}
+	if a.SecretIDWrappingToken == "" {
+		a.SecretIDWrappingToken = os.Getenv("APPROLE_SECRET_ID_WRAPPING_TOKEN")
+	}
+	return nil
+}
 
-	// verify supplied flags
-	// check vault-address
-	if err := isValidURL(args["vault-address"].(string)); err != nil {
-		return kvlerrors.New(err.Error())
+// Validate checks the mount point, applying the default one when unset, and reads credentials designated as STDIN
+func (a *ApproleAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultApproleAuthMount
 	}
 	// check vault mount points
-	if !isAbsolutePath(args[FlagVaultApproleAuthMount].(string)) {
-		return kvlerrors.New(fmt.Errorf("vault-approle-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /approle: %s", args[FlagVaultApproleAuthMount].(string)).Error())
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-approle-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /approle: %s", a.Mount)
+	}
+	if a.RoleName != "" && !pathElement.MatchString(a.RoleName) {
+		return fmt.Errorf("approle-role-name must be a valid path element of letters, digits, _ and -: %s", a.RoleName)
+	}
+	if a.SecretIDFile != "" && a.SecretIDWrappingTokenFile != "" {
+		return fmt.Errorf("%s and %s are mutually exclusive", FlagSecretIDFile, FlagSecretIDWrappingTokenFile)
+	}
+	if a.RotateSecretID && (a.SecretIDFile == "" || a.SecretIDFile == stdinFile) {
+		return fmt.Errorf("%s requires the secret id to be read from a file given with %s", FlagRotateSecretID, FlagSecretIDFile)
+	}
+	if a.SecretIDMaxAge < 0 {
+		return fmt.Errorf("%s must not be negative: %s", FlagSecretIDMaxAge, a.SecretIDMaxAge)
 	}
 
-	// perform preparation tasks
-	if err := prepFederation(&args); err != nil {
-		return kvlerrors.New(err.Error())
+	// STDIN can only be read once, it is read upfront and the credential kept in memory
+	files := map[*string]*string{&a.RoleIDFile: &a.RoleID, &a.SecretIDFile: &a.SecretID, &a.SecretIDWrappingTokenFile: &a.SecretIDWrappingToken}
+	fromStdin := 0
+	for file, value := range files {
+		if *file != stdinFile {
+			continue
+		}
+		if fromStdin++; fromStdin > 1 {
+			return fmt.Errorf("only one of %s, %s and %s can be read from STDIN", FlagRoleIDFile, FlagSecretIDFile, FlagSecretIDWrappingTokenFile)
+		}
+		credential, err := readCredential(*file)
+		if err != nil {
+			return err
+		}
+		*file, *value = "", credential
 	}
+	return nil
+}
 
-	// only actual resques and not a test run
-	if !test {
-		var err error
-		// now authenticate to vault leveraging PSAT
-		if err = prepVaultClient(args["vault-address"].(string)); err != nil {
-			return kvlerrors.New(err.Error())
+// applicable reports which approle credential is missing, nil when a RoleID and a SecretID are supplied
+func (a *ApproleAuth) applicable() error {
+	if a.RoleID == "" && a.RoleIDFile == "" {
+		return fmt.Errorf("neither APPROLE_ROLE_ID nor %s is set", FlagRoleIDFile)
+	}
+	if a.SecretID == "" && a.SecretIDFile == "" && a.SecretIDWrappingToken == "" && a.SecretIDWrappingTokenFile == "" {
+		return fmt.Errorf("neither APPROLE_SECRET_ID, APPROLE_SECRET_ID_WRAPPING_TOKEN, %s nor %s is set", FlagSecretIDFile, FlagSecretIDWrappingTokenFile)
+	}
+	return nil
+}
+
+// Login authenticates to Vault with the RoleID and SecretID, unwrapping the SecretID first when it is response-wrapped
+func (a *ApproleAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	return a.login(ctx, client, a.Mount)
+}
+
+// login authenticates to Vault at mount with the RoleID and SecretID
+func (a *ApproleAuth) login(ctx context.Context, client VaultAPI, mount string) (time.Duration, error) {
+	roleID, err := a.roleID()
+	if err != nil {
+		return 0, err
+	}
+	secretID, err := a.secretID(ctx, client, mount)
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := authToVaultWithApprole(ctx, client, mount, roleID, secretID)
+	if err != nil || !a.RotateSecretID {
+		return ttl, err
+	}
+	if err := a.rotateSecretID(ctx, client, mount, secretID); err != nil {
+		a.logger().Printf("SecretID rotation failed, the login succeeded: %s", err)
+	}
+	return ttl, nil
+}
+
+// rotateSecretID replaces oldSecretID in SecretIDFile with a new SecretID of the role at mount once the file is older
+// than SecretIDMaxAge, then destroys oldSecretID. client must be logged in with oldSecretID
+func (a *ApproleAuth) rotateSecretID(ctx context.Context, client VaultAPI, mount string, oldSecretID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	info, err := os.Stat(a.SecretIDFile)
+	if err != nil {
+		return fmt.Errorf("rotateSecretID() %w", err)
+	}
+	maxAge := a.SecretIDMaxAge
+	if maxAge == 0 {
+		maxAge = DefaultSecretIDMaxAge
+	}
+	if time.Since(info.ModTime()) < maxAge {
+		return nil
+	}
+
+	roleName := a.RoleName
+	if roleName == "" {
+		if roleName, err = tokenRoleName(ctx, client); err != nil {
+			return err
 		}
-		if err = authToVaultWithApprole(ctx, client, args[FlagVaultApproleAuthMount].(string)); err != nil {
-			return kvlerrors.New(err.Error())
+	}
+	rolePath := vaultPath("auth", mount, "role", roleName)
+	resp, err := client.Write(ctx, vaultPath(rolePath, "secret-id"), nil)
+	if err != nil {
+		return fmt.Errorf("rotateSecretID() AppRoleWriteSecretId: %w", err)
+	}
+	newSecretID, _ := resp.Data["secret_id"].(string)
+	if newSecretID == "" {
+		return fmt.Errorf("rotateSecretID() AppRoleWriteSecretId: response does not contain a secret_id")
+	}
+	if err := writeFileAtomic(a.SecretIDFile, []byte(newSecretID+"\n")); err != nil {
+		return fmt.Errorf("rotateSecretID() %w", err)
+	}
+
+	// the new SecretID is at rest, the old one is destroyed through its accessor
+	resp, err = client.Write(ctx, vaultPath(rolePath, "secret-id", "lookup"), map[string]any{"secret_id": oldSecretID})
+	if err != nil {
+		return fmt.Errorf("rotateSecretID() AppRoleLookUpSecretId: %w", err)
+	}
+	accessor, _ := resp.Data["secret_id_accessor"].(string)
+	if accessor == "" {
+		return fmt.Errorf("rotateSecretID() AppRoleLookUpSecretId: response does not contain a secret_id_accessor")
+	}
+	if _, err := client.Write(ctx, vaultPath(rolePath, "secret-id-accessor", "destroy"), map[string]any{"secret_id_accessor": accessor}); err != nil {
+		return fmt.Errorf("rotateSecretID() AppRoleDestroySecretIdByAccessor: %w", err)
+	}
+	return nil
+}
+
+func (a *ApproleAuth) logger() *log.Logger {
+	if a.Logger == nil {
+		return log.New(os.Stderr, "kubectl-vaultlogin: ", 0)
+	}
+	return a.Logger
+}
+
+// tokenRoleName returns the approle role the vault token of client was issued for, from the token's role_name metadata
+func tokenRoleName(ctx context.Context, client VaultAPI) (string, error) {
+	resp, err := client.Read(ctx, "auth/token/lookup-self")
+	if err != nil {
+		return "", fmt.Errorf("tokenRoleName() TokenLookUpSelf: %w", err)
+	}
+	meta, _ := resp.Data["meta"].(map[string]any)
+	roleName, _ := meta["role_name"].(string)
+	if roleName == "" {
+		return "", fmt.Errorf("tokenRoleName() vault token carries no role_name metadata, set %s", FlagApproleRoleName)
+	}
+	return roleName, nil
+}
+
+// writeFileAtomic replaces file with data, readable only by its owner, through a temporary file renamed over it
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp creates the file with mode 0600
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// secretID returns the SecretID from SecretIDFile, from a wrapping token created by the role at mount or SecretID
+// in that order of preference
+func (a *ApproleAuth) secretID(ctx context.Context, client VaultAPI, mount string) (string, error) {
+	if a.SecretIDFile != "" {
+		return readCredential(a.SecretIDFile)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	wrappingToken := a.SecretIDWrappingToken
+	if a.SecretIDWrappingTokenFile != "" {
+		var err error
+		if wrappingToken, err = readCredential(a.SecretIDWrappingTokenFile); err != nil {
+			return "", err
 		}
+	}
+	if wrappingToken == "" {
+		return a.SecretID, nil
+	}
+	secretID, err := unwrapSecretID(ctx, client, mount, a.RoleName, wrappingToken)
+	if err != nil {
+		return "", err
+	}
+	// a wrapping token is single use, the SecretID is kept for later logins
+	a.SecretID, a.SecretIDWrappingToken, a.SecretIDWrappingTokenFile = secretID, "", ""
+	return secretID, nil
+}
 
-		// now that we are authenticated, lets generate a token to authenticate to k8s cluster
-		k8sToken, err = generateK8sToken(ctx, client, vaultK8sSecretRole, args["cluster-name"].(string))
-		if err != nil {
-			return kvlerrors.New(err.Error())
+// singleUse describes a response-wrapped SecretID, which is unwrapped once and only kept in memory
+func (a *ApproleAuth) singleUse() string {
+	if a.SecretIDFile == "" && (a.SecretIDWrappingToken != "" || a.SecretIDWrappingTokenFile != "") {
+		return "a response-wrapped secret id"
+	}
+	return ""
+}
+
+// roleID returns the RoleID, read from RoleIDFile when set
+func (a *ApproleAuth) roleID() (string, error) {
+	if a.RoleIDFile != "" {
+		return readCredential(a.RoleIDFile)
+	}
+	return a.RoleID, nil
+}
+
+// loginIdentity returns a digest of the RoleID. STDIN is not read, Validate already moved its RoleID in memory
+func (a *ApproleAuth) loginIdentity() string {
+	if a.RoleIDFile == stdinFile {
+		return ""
+	}
+	roleID, err := a.roleID()
+	if err != nil {
+		return ""
+	}
+	return identityDigest(roleID)
+}
+
+// PlanLogin describes the approle login for dry runs
+func (a *ApproleAuth) PlanLogin() LoginPlan {
+	return a.planLogin(a.Mount)
+}
+
+// planLogin describes the approle login at mount
+func (a *ApproleAuth) planLogin(mount string) LoginPlan {
+	roleID := "role-id from APPROLE_ROLE_ID"
+	if a.RoleIDFile != "" {
+		roleID = "role-id from " + a.RoleIDFile
+	} else if a.RoleID == "" {
+		roleID = "role-id is unset"
+	}
+	secretID := "secret-id from APPROLE_SECRET_ID"
+	requests := []string{}
+	switch {
+	case a.SecretIDFile != "":
+		secretID = "secret-id from " + a.SecretIDFile
+	case a.SecretIDWrappingTokenFile != "" || a.SecretIDWrappingToken != "":
+		secretID = "secret-id unwrapped from a wrapping token"
+		if a.SecretIDWrappingTokenFile != "" {
+			secretID += " in " + a.SecretIDWrappingTokenFile
+		}
+		requests = append(requests, "POST sys/wrapping/lookup", "POST sys/wrapping/unwrap")
+	case a.SecretID == "":
+		secretID = "secret-id is unset"
+	}
+	var postLogin []string
+	if a.RotateSecretID {
+		secretID += fmt.Sprintf(", rotated once older than %s", a.SecretIDMaxAge)
+		// without a role name the role is read from the token's metadata, + matches any role in a policy
+		roleName := a.RoleName
+		if roleName == "" {
+			roleName = "+"
 		}
+		rolePath := vaultPath("auth", mount, "role", roleName)
+		postLogin = []string{
+			"POST " + vaultPath(rolePath, "secret-id"),
+			"POST " + vaultPath(rolePath, "secret-id", "lookup"),
+			"POST " + vaultPath(rolePath, "secret-id-accessor", "destroy"),
+		}
+	}
+	return LoginPlan{
+		Mount:             mount,
+		Role:              a.RoleName,
+		Input:             roleID + ", " + secretID,
+		Requests:          append(requests, "POST "+vaultPath("auth", mount, "login")),
+		PostLoginRequests: postLogin,
+	}
+}
+
+// readCredential reads a credential from file, or from STDIN for -, and trims surrounding whitespace
+func readCredential(file string) (string, error) {
+	var data []byte
+	var err error
+	if file == stdinFile {
+		data, err = io.ReadAll(io.LimitReader(stdin, 64*1024))
 	} else {
-		k8sToken = generateFakeK8sToken()
+		data, err = os.ReadFile(file)
 	}
+	if err != nil {
+		return "", kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("readCredential() cannot read %s: %s", file, err))
+	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-	// Assemble a ExecCredential
-	err := assembleExecCredential(inputExecCredentialPtr, k8sToken)
+// unwrapSecretID verifies wrappingToken wraps a SecretID created by the secret-id endpoint of an approle role under mount,
+// of roleName when set, and unwraps it
+func unwrapSecretID(ctx context.Context, client VaultAPI, mount string, roleName string, wrappingToken string) (string, error) {
+	resp, err := client.Write(ctx, "sys/wrapping/lookup", map[string]any{"token": wrappingToken})
 	if err != nil {
-		return kvlerrors.New(err.Error())
+		return "", fmt.Errorf("unwrapSecretID() WrappingLookup: %w", err)
+	}
+	creationPath, _ := resp.Data["creation_path"].(string)
+	role := regexp.QuoteMeta(roleName)
+	if roleName == "" {
+		role = "[^/]+"
+	}
+	expected := regexp.MustCompile("^" + regexp.QuoteMeta(vaultPath("auth", mount, "role")) + "/" + role + "/secret-id$")
+	if !expected.MatchString(creationPath) {
+		if roleName == "" {
+			roleName = "<role>"
+		}
+		return "", kvlerrors.Wrap(kvlerrors.VaultAuthDenied, fmt.Errorf("unwrapSecretID() wrapping token was not created by %s: creation path is %q",
+			vaultPath("auth", mount, "role", roleName, "secret-id"), creationPath))
 	}
 
-	// Output the new ExecCredential
-	err = printExecCredential(os.Stdout, inputExecCredentialPtr)
+	resp, err = client.Write(ctx, "sys/wrapping/unwrap", nil, vaultcg.WithToken(wrappingToken))
 	if err != nil {
-		return kvlerrors.New(err.Error())
+		return "", fmt.Errorf("unwrapSecretID() WrappingUnwrap: %w", err)
 	}
-	return nil
+	secretID, ok := resp.Data["secret_id"].(string)
+	if !ok || secretID == "" {
+		return "", fmt.Errorf("unwrapSecretID() WrappingUnwrap: response does not contain a secret_id")
+	}
+	return secretID, nil
+}
+
+// authToVaultWithApprole authenticates to Vault using approle with the supplied RoleId and SecretId.
+// Upon successful authentication it popules the client with the recevied vault token and returns the token's ttl.
+func authToVaultWithApprole(ctx context.Context, client VaultAPI, mountPath string, roleID string, secretID string) (time.Duration, error) {
+	resp, err := client.Write(ctx, vaultPath("auth", mountPath, "login"), map[string]any{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithApprole() AppRoleLogin: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithApprole()")
 }
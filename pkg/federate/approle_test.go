@@ -0,0 +1,165 @@
+package federate
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests role and secret ids are read from files, and from STDIN, which is read once by Validate
+func TestApproleCredentialFiles(t *testing.T) {
+	vault, opts := mockVault(t)
+	dir := t.TempDir()
+	roleIDFile := filepath.Join(dir, "role-id")
+	require.NoError(t, os.WriteFile(roleIDFile, []byte("role-id\n"), 0600))
+
+	stdin = strings.NewReader("  secret-id\n")
+	t.Cleanup(func() { stdin = os.Stdin })
+	auth := &ApproleAuth{RoleID: "ignored", RoleIDFile: roleIDFile, SecretIDFile: "-"}
+	require.NoError(t, auth.Validate())
+	assert.Equal(t, "secret-id", auth.SecretID)
+	assert.Empty(t, auth.SecretIDFile)
+
+	opts.Authenticator = auth
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"POST auth/approle/login"}, vault.Requests())
+}
+
+// tests only one credential can be read from STDIN, a secret id file excludes a wrapping token file and role names are path elements
+func TestApproleValidate(t *testing.T) {
+	err := (&ApproleAuth{RoleIDFile: "-", SecretIDFile: "-"}).Validate()
+	assert.ErrorContains(t, err, "can be read from STDIN")
+	err = (&ApproleAuth{SecretIDFile: "secret-id", SecretIDWrappingTokenFile: "token"}).Validate()
+	assert.ErrorContains(t, err, "mutually exclusive")
+	err = (&ApproleAuth{RoleName: "a/b"}).Validate()
+	assert.ErrorContains(t, err, "approle-role-name")
+	err = (&ApproleAuth{Mount: "/approle", RoleName: "argocd_prod"}).Validate()
+	assert.NoError(t, err)
+	err = (&ApproleAuth{SecretIDFile: "-", RotateSecretID: true}).Validate()
+	assert.ErrorContains(t, err, "rotate-secret-id requires")
+}
+
+// tests a wrapped secret id is unwrapped once and kept for later logins
+func TestApproleWrappedSecretID(t *testing.T) {
+	vault, opts := mockVault(t)
+	tokenFile := filepath.Join(t.TempDir(), "wrapping-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(vault.WrapSecretID("/approle", "ci", "secret-id")), 0600))
+	opts.Authenticator = &ApproleAuth{RoleID: "role-id", SecretIDWrappingTokenFile: tokenFile, RoleName: "ci"}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = federator.Login(context.Background(), TemplateData{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"POST sys/wrapping/lookup", "POST sys/wrapping/unwrap", "POST auth/approle/login", "POST auth/approle/login"}, vault.Requests())
+
+	// a process logging in once would lose the unwrapped secret id
+	opts.OneShot = true
+	opts.Authenticator = &ApproleAuth{RoleID: "role-id", SecretIDWrappingTokenFile: tokenFile, RoleName: "ci"}
+	_, err = NewFederator(opts)
+	assert.ErrorContains(t, err, "a response-wrapped secret id can only be presented once")
+	opts.Authenticator = &ApproleAuth{RoleID: "role-id", SecretID: "secret-id"}
+	_, err = NewFederator(opts)
+	assert.NoError(t, err)
+}
+
+// tests wrapping tokens that were not created by the approle secret-id endpoint are not unwrapped
+func TestApproleWrappedSecretIDCreationPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		creationPath string
+		roleName     string
+	}{
+		{"other endpoint", "sys/wrapping/wrap", ""},
+		{"other mount", "auth/approle-ci/role/ci/secret-id", ""},
+		{"other role", "auth/approle/role/admin/secret-id", "ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, opts := mockVault(t)
+			token := vault.Wrap(tt.creationPath, map[string]any{"secret_id": "secret-id"})
+			opts.Authenticator = &ApproleAuth{RoleID: "role-id", SecretIDWrappingToken: token, RoleName: tt.roleName}
+			federator, err := NewFederator(opts)
+			require.NoError(t, err)
+
+			_, err = federator.Login(context.Background(), TemplateData{})
+			assert.ErrorContains(t, err, "wrapping token was not created by auth/approle/role/")
+			assert.Equal(t, kvlerrors.VaultAuthDenied, kvlerrors.CategoryOf(err))
+			assert.Equal(t, []string{"POST sys/wrapping/lookup"}, vault.Requests())
+		})
+	}
+}
+
+// tests a SecretID older than its max age is replaced in its file and destroyed, the role being read from the token metadata
+func TestApproleRotateSecretID(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.AddApproleRole("/approle", "ci", "role-id", "secret-id")
+	secretIDFile := filepath.Join(t.TempDir(), "secret-id")
+	require.NoError(t, os.WriteFile(secretIDFile, []byte("secret-id\n"), 0600))
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(secretIDFile, old, old))
+	opts.Authenticator = &ApproleAuth{RoleID: "role-id", SecretIDFile: secretIDFile, RotateSecretID: true, SecretIDMaxAge: time.Hour}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"POST auth/approle/login",
+		"GET auth/token/lookup-self",
+		"POST auth/approle/role/ci/secret-id",
+		"POST auth/approle/role/ci/secret-id/lookup",
+		"POST auth/approle/role/ci/secret-id-accessor/destroy",
+	}, vault.Requests())
+	data, err := os.ReadFile(secretIDFile)
+	require.NoError(t, err)
+	newSecretID := strings.TrimSpace(string(data))
+	assert.NotEqual(t, "secret-id", newSecretID)
+	info, err := os.Stat(secretIDFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the new SecretID logs in and is not rotated before its max age, the old one is destroyed
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Len(t, vault.Requests(), 6)
+	client, err := newVaultClient(vault.URL, vault.Client(), 0)
+	require.NoError(t, err)
+	_, err = authToVaultWithApprole(context.Background(), client, "/approle", "role-id", "secret-id")
+	assert.ErrorContains(t, err, "invalid role or secret ID")
+}
+
+// tests a failed rotation keeps the SecretID and does not fail the login
+func TestApproleRotateSecretIDFailure(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.AddApproleRole("/approle", "ci", "role-id", "secret-id")
+	vault.Fail("auth/approle/role/ci/secret-id", vaultfake.Forbidden)
+	secretIDFile := filepath.Join(t.TempDir(), "secret-id")
+	require.NoError(t, os.WriteFile(secretIDFile, []byte("secret-id"), 0600))
+	var logs bytes.Buffer
+	opts.Authenticator = &ApproleAuth{RoleID: "role-id", SecretIDFile: secretIDFile, RoleName: "ci", RotateSecretID: true,
+		SecretIDMaxAge: time.Nanosecond, Logger: log.New(&logs, "", 0)}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Contains(t, logs.String(), "SecretID rotation failed")
+	data, err := os.ReadFile(secretIDFile)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-id", string(data))
+}
@@ -0,0 +1,96 @@
+package federate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Authenticator logs in to Vault with one authentication method. Registered authenticators are exposed
+// as federate subcommands named after them, so a new Vault authentication method only needs to implement
+// this interface and call RegisterAuthenticator from an init function
+type Authenticator interface {
+	// Name returns the name of the authentication method, it is also the name of its subcommand
+	Name() string
+	// Usage returns the short and long description of the method's subcommand
+	Usage() (short string, long string)
+	// AddFlags registers the method's flags, bound to the authenticator's fields, and returns the names of required flags
+	AddFlags(flags *pflag.FlagSet) []string
+	// Validate checks the method's inputs and applies defaults
+	Validate() error
+	// Login authenticates client to Vault and returns the ttl of the obtained vault token
+	Login(ctx context.Context, client VaultAPI) (time.Duration, error)
+}
+
+// envLoader is implemented by authenticators whose inputs can also be supplied in environment variables
+type envLoader interface {
+	// LoadEnv fills unset fields from environment variables
+	LoadEnv() error
+}
+
+// singleUseChecker is implemented by authenticators that may present a credential Vault accepts only once.
+// singleUse describes that credential when it is configured, it returns an empty string otherwise
+type singleUseChecker interface {
+	singleUse() string
+}
+
+// loginIdentifier is implemented by authenticators that know whom they log in as before logging in.
+// loginIdentity returns a digest of that identity, never the credential itself, or an empty string when it is unknown
+type loginIdentifier interface {
+	loginIdentity() string
+}
+
+// identityDigest returns a hex encoded sha256 digest of identity, an empty string for an empty identity
+func identityDigest(identity string) string {
+	if identity == "" {
+		return ""
+	}
+	digest := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(digest[:])
+}
+
+// authenticators holds registered authentication methods by name
+var (
+	authenticatorsMu sync.RWMutex
+	authenticators   = map[string]func() Authenticator{}
+)
+
+// RegisterAuthenticator makes an authentication method available under name. It is meant to be called
+// from init functions and panics when name is already registered
+func RegisterAuthenticator(name string, factory func() Authenticator) {
+	authenticatorsMu.Lock()
+	defer authenticatorsMu.Unlock()
+	if _, exists := authenticators[name]; exists {
+		panic(fmt.Sprintf("federate: authenticator %s registered twice", name))
+	}
+	authenticators[name] = factory
+}
+
+// Authenticators returns the names of registered authentication methods in sorted order
+func Authenticators() []string {
+	authenticatorsMu.RLock()
+	defer authenticatorsMu.RUnlock()
+	names := make([]string, 0, len(authenticators))
+	for name := range authenticators {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewAuthenticator returns a new, unconfigured Authenticator for a registered authentication method
+func NewAuthenticator(name string) (Authenticator, error) {
+	authenticatorsMu.RLock()
+	factory, exists := authenticators[name]
+	authenticatorsMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unsupported auth method: %q, expected one of %v", name, Authenticators())
+	}
+	return factory(), nil
+}
@@ -0,0 +1,285 @@
+package federate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodAuto is the name of the authentication method trying a chain of methods
+const AuthMethodAuto = "auto"
+
+// const to define cobra command flag name that supplies the ordered authentication methods tried by auto
+const FlagAuthMethods = "auth-methods"
+
+// defaultAuthMethods is the chain tried by auto when none is configured: a vault token of the user, the
+// kubernetes identity of a pod, approle credentials of a CI runner and finally an interactive login
+var defaultAuthMethods = []string{AuthMethodToken, AuthMethodPsat, AuthMethodApprole, AuthMethodOIDC}
+
+func init() {
+	RegisterAuthenticator(AuthMethodAuto, func() Authenticator { return &AutoAuth{} })
+}
+
+// applicabilityChecker is implemented by authenticators that can tell, before logging in, whether the
+// credential they present is available. applicable returns why it is not, nil when it is
+type applicabilityChecker interface {
+	applicable() error
+}
+
+// interactiveAuthenticator is implemented by authenticators needing a user at the terminal
+type interactiveAuthenticator interface {
+	interactive()
+}
+
+// AutoAuth tries a chain of authentication methods in order and logs in with the first that succeeds.
+// Methods whose credentials are missing are skipped, a failed login moves on to the next method
+type AutoAuth struct {
+	// Methods are the names of the tried authentication methods in order, defaults to token, psat, approle and oidc
+	Methods []string
+	// Interactive allows interactive methods. It is also set by an interactive ExecCredential in KUBERNETES_EXEC_INFO
+	Interactive bool
+	// Logger receives the reason each method was skipped or failed. Defaults to STDERR
+	Logger *log.Logger
+
+	// authenticators holds an authenticator of every registered method but auto, the chain picks from them
+	authenticators map[string]Authenticator
+}
+
+// Name returns the name of the auto authentication method
+func (a *AutoAuth) Name() string {
+	return AuthMethodAuto
+}
+
+// Usage returns the description of the auto subcommand
+func (a *AutoAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault with the first applicable of a chain of authentication methods",
+		`Authenticates to Hashicorp Vault with the first applicable of a chain of authentication methods,
+so a single kubeconfig serves developer laptops, ArgoCD pods and CI runners. --auth-methods sets the chain,
+by default:
+  token    an existing vault token in VAULT_TOKEN or ~/.vault-token
+  psat     a projected service account token, when the token file exists
+  approle  approle credentials, when a role id and a secret id are supplied
+  oidc     an interactive browser login, when the ExecCredential is interactive
+It accepts the flags of every method. The reason each method was skipped or failed is printed to STDERR.`
+}
+
+// AddFlags registers the flags of every authentication method and the chain of methods. Flags shared by
+// several methods, ex. vault-jwt-auth-mount, set all of them, shorthands are dropped as they collide
+func (a *AutoAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringSliceVar(&a.Methods, FlagAuthMethods, defaultAuthMethods, "authentication methods tried in order, the first that succeeds is used")
+	a.newAuthenticators()
+	for _, name := range a.names() {
+		methodFlags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+		a.authenticators[name].AddFlags(methodFlags)
+		methodFlags.VisitAll(func(flag *pflag.Flag) {
+			if existing := flags.Lookup(flag.Name); existing != nil {
+				existing.Value = sharedValue{existing.Value, flag.Value}
+				return
+			}
+			flag.Shorthand = ""
+			flags.AddFlag(flag)
+		})
+	}
+	return nil
+}
+
+// LoadEnv fills unset fields of every authentication method from environment variables
+func (a *AutoAuth) LoadEnv() error {
+	a.newAuthenticators()
+	for _, name := range a.names() {
+		if loader, ok := a.authenticators[name].(envLoader); ok {
+			if err := loader.LoadEnv(); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the chain names registered methods. The methods themselves are validated when they are tried
+func (a *AutoAuth) Validate() error {
+	a.newAuthenticators()
+	if len(a.Methods) == 0 {
+		a.Methods = defaultAuthMethods
+	}
+	for _, method := range a.Methods {
+		if _, exists := a.authenticators[method]; !exists {
+			return fmt.Errorf("unsupported auth method in %s: %q, expected some of %v", FlagAuthMethods, method, a.names())
+		}
+	}
+	return nil
+}
+
+// singleUse describes the single-use credential of a method of the chain, if any
+func (a *AutoAuth) singleUse() string {
+	for _, method := range a.Methods {
+		if checker, ok := a.authenticators[method].(singleUseChecker); ok {
+			if credential := checker.singleUse(); credential != "" {
+				return method + ": " + credential
+			}
+		}
+	}
+	return ""
+}
+
+// loginIdentity returns the login identity of the first applicable method
+func (a *AutoAuth) loginIdentity() string {
+	a.newAuthenticators()
+	for _, method := range a.Methods {
+		authenticator, err := a.prepare(method)
+		if err != nil {
+			continue
+		}
+		if identifier, ok := authenticator.(loginIdentifier); ok {
+			return identifier.loginIdentity()
+		}
+		return ""
+	}
+	return ""
+}
+
+// delegates returns the authenticators of every method auto can try
+func (a *AutoAuth) delegates() map[string]Authenticator {
+	a.newAuthenticators()
+	return a.authenticators
+}
+
+// withDelegates returns a copy of the chain trying delegates, ex. with their login templates rendered
+func (a *AutoAuth) withDelegates(delegates map[string]Authenticator) Authenticator {
+	rendered := *a
+	rendered.authenticators = delegates
+	return &rendered
+}
+
+// Login logs in with the first method of the chain that is applicable and succeeds. The client token is cleared
+// before each method, so a token a previous method set and Vault rejected is not sent along with the next login
+func (a *AutoAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	var reasons []string
+	for _, method := range a.Methods {
+		authenticator, err := a.prepare(method)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s skipped: %s", method, err))
+			a.logger().Printf("auth method %s skipped: %s", method, err)
+			continue
+		}
+		if err := client.SetToken(""); err != nil {
+			return 0, fmt.Errorf("authToVaultWithAuto() SetToken: %s", err)
+		}
+		ttl, err := authenticator.Login(ctx, client)
+		if err == nil {
+			return ttl, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s failed: %s", method, err))
+		a.logger().Printf("auth method %s failed: %s", method, err)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+	}
+	return 0, fmt.Errorf("authToVaultWithAuto() no auth method succeeded: %s", strings.Join(reasons, "; "))
+}
+
+// PlanLogin describes the login of the first applicable method for dry runs, along with the skipped ones
+func (a *AutoAuth) PlanLogin() LoginPlan {
+	var skipped []string
+	for _, method := range a.Methods {
+		authenticator, err := a.prepare(method)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s skipped: %s", method, err))
+			continue
+		}
+		plan := LoginPlan{Input: method + " selected"}
+		if planner, ok := authenticator.(LoginPlanner); ok {
+			plan = planner.PlanLogin()
+			plan.Input = method + " selected, " + plan.Input
+		}
+		if len(skipped) > 0 {
+			plan.Input += " (" + strings.Join(skipped, "; ") + ")"
+		}
+		return plan
+	}
+	return LoginPlan{Input: "no applicable auth method (" + strings.Join(skipped, "; ") + ")"}
+}
+
+// prepare returns the authenticator of method once validated, or why it is not applicable
+func (a *AutoAuth) prepare(method string) (Authenticator, error) {
+	authenticator := a.authenticators[method]
+	if _, ok := authenticator.(interactiveAuthenticator); ok && !a.interactive() {
+		return nil, fmt.Errorf("the ExecCredential is not interactive")
+	}
+	if err := authenticator.Validate(); err != nil {
+		return nil, err
+	}
+	if checker, ok := authenticator.(applicabilityChecker); ok {
+		if err := checker.applicable(); err != nil {
+			return nil, err
+		}
+	}
+	return authenticator, nil
+}
+
+// interactive reports whether interactive methods may be tried
+func (a *AutoAuth) interactive() bool {
+	if a.Interactive {
+		return true
+	}
+	execCredential, err := ExecCredentialFromEnv()
+	return err == nil && execCredential.Spec.Interactive
+}
+
+// newAuthenticators creates the authenticators of every registered method but auto, once
+func (a *AutoAuth) newAuthenticators() {
+	if a.authenticators != nil {
+		return
+	}
+	a.authenticators = map[string]Authenticator{}
+	for _, name := range Authenticators() {
+		if name != AuthMethodAuto {
+			a.authenticators[name], _ = NewAuthenticator(name)
+		}
+	}
+}
+
+// names returns the methods auto can try in sorted order
+func (a *AutoAuth) names() []string {
+	names := make([]string, 0, len(a.authenticators))
+	for name := range a.authenticators {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// logger returns the Logger or a logger writing to STDERR
+func (a *AutoAuth) logger() *log.Logger {
+	if a.Logger == nil {
+		return log.New(os.Stderr, "kubectl-vaultlogin: ", 0)
+	}
+	return a.Logger
+}
+
+// sharedValue sets a flag shared by several authentication methods in all of them
+type sharedValue []pflag.Value
+
+func (v sharedValue) String() string {
+	return v[0].String()
+}
+
+func (v sharedValue) Set(value string) error {
+	for _, shared := range v {
+		if err := shared.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v sharedValue) Type() string {
+	return v[0].Type()
+}
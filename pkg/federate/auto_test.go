@@ -0,0 +1,272 @@
+package federate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAutoAuth returns an AutoAuth configured by args as the federate auto subcommand would be, with no
+// vault token, psat or approle credential found in the environment unless the test sets one
+func mockAutoAuth(t *testing.T, logs *bytes.Buffer, args ...string) *AutoAuth {
+	t.Setenv("HOME", t.TempDir())
+	for _, env := range []string{"VAULT_TOKEN", "APPROLE_ROLE_ID", "APPROLE_SECRET_ID", "APPROLE_SECRET_ID_WRAPPING_TOKEN", execInfoEnv} {
+		t.Setenv(env, "")
+	}
+	auth := &AutoAuth{Logger: log.New(logs, "", 0)}
+	flags := pflag.NewFlagSet(AuthMethodAuto, pflag.ContinueOnError)
+	auth.AddFlags(flags)
+	require.NoError(t, flags.Parse(args))
+	auth.authenticators[AuthMethodOIDC].(*OIDCAuth).Prompt = io.Discard
+	return auth
+}
+
+// mockOIDCListenAddress returns a free local address for the oidc callback listener
+func mockOIDCListenAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// mockBrowser makes openBrowser follow the login URL as a user completing the login would
+func mockBrowser(t *testing.T) {
+	openBrowser = func(url string) error {
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	t.Cleanup(func() { openBrowser = func(string) error { return nil } })
+}
+
+// tests an existing vault token is looked up, from VAULT_TOKEN and from the token file
+func TestTokenLogin(t *testing.T) {
+	vault, opts := mockVault(t)
+	tokenFile := filepath.Join(t.TempDir(), "vault-token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(vault.IssueToken()+"\n"), 0600))
+
+	for _, auth := range []*TokenAuth{{Token: vault.IssueToken()}, {TokenFile: tokenFile}} {
+		opts.Authenticator = auth
+		federator, err := NewFederator(opts)
+		require.NoError(t, err)
+		_, err = federator.Login(context.Background(), TemplateData{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"GET auth/token/lookup-self", "GET auth/token/lookup-self"}, vault.Requests())
+
+	opts.Authenticator = &TokenAuth{Token: "hvs.revoked"}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Login(context.Background(), TemplateData{})
+	assert.ErrorContains(t, err, "authToVaultWithToken() TokenLookupSelf")
+}
+
+// tests the oidc login exchanges the authorization code the provider redirects to the local listener with
+func TestOIDCLogin(t *testing.T) {
+	mockBrowser(t)
+	vault, opts := mockVault(t)
+	vault.AddOIDCAuth("/oidc", "developer")
+	var prompt bytes.Buffer
+	opts.Authenticator = &OIDCAuth{Role: "developer", ListenAddress: mockOIDCListenAddress(t), Prompt: &prompt}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"POST auth/oidc/oidc/auth_url", "GET auth/oidc/oidc/callback"}, vault.Requests())
+	assert.Contains(t, prompt.String(), "code=fake-code")
+
+	opts.Authenticator = &OIDCAuth{Role: "admin", ListenAddress: mockOIDCListenAddress(t), Prompt: &prompt}
+	federator, err = NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Login(context.Background(), TemplateData{})
+	assert.ErrorContains(t, err, "authToVaultWithOIDC() OidcRequestAuthorizationUrl")
+}
+
+// tests auto logs in with the first applicable method and reports why the previous ones were not used
+func TestAutoLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// setup configures the credentials found in the environment
+		setup            func(t *testing.T, auth *AutoAuth, vaultToken string)
+		expectedRequests []string
+		expectedLogs     []string
+		expectedError    string
+	}{
+		{
+			name:             "vault token",
+			setup:            func(t *testing.T, _ *AutoAuth, vaultToken string) { t.Setenv("VAULT_TOKEN", vaultToken) },
+			expectedRequests: []string{"GET auth/token/lookup-self"},
+		},
+		{
+			name: "psat file",
+			args: []string{"--vault-kubernetes-auth-mount", "/kubernetes/argocd"},
+			setup: func(t *testing.T, auth *AutoAuth, _ string) {
+				tokenPath := filepath.Join(t.TempDir(), "token")
+				require.NoError(t, os.WriteFile(tokenPath, []byte("psat-jwt"), 0600))
+				auth.authenticators[AuthMethodPsat].(*KubernetesAuth).TokenPath = tokenPath
+			},
+			expectedRequests: []string{"POST auth/kubernetes/argocd/login"},
+			expectedLogs:     []string{"auth method token skipped: VAULT_TOKEN is unset"},
+		},
+		{
+			name: "approle credentials",
+			args: []string{"--vault-kubernetes-auth-mount", "/kubernetes/argocd"},
+			setup: func(t *testing.T, _ *AutoAuth, _ string) {
+				t.Setenv("APPROLE_ROLE_ID", "role-id")
+				t.Setenv("APPROLE_SECRET_ID", "secret-id")
+			},
+			expectedRequests: []string{"POST auth/approle/login"},
+			expectedLogs:     []string{"auth method token skipped", "auth method psat skipped: the psat file /var/run/secrets/"},
+		},
+		{
+			name: "interactive oidc",
+			setup: func(t *testing.T, _ *AutoAuth, _ string) {
+				t.Setenv(execInfoEnv, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":true}}`)
+			},
+			expectedRequests: []string{"POST auth/oidc/oidc/auth_url", "GET auth/oidc/oidc/callback"},
+			expectedLogs:     []string{"auth method approle skipped: neither APPROLE_ROLE_ID nor role-id-file is set"},
+		},
+		{
+			name:          "not interactive",
+			expectedLogs:  []string{"auth method oidc skipped: the ExecCredential is not interactive"},
+			expectedError: "authToVaultWithAuto() no auth method succeeded",
+		},
+		{
+			name:             "skipped method",
+			args:             []string{"--auth-methods", "approle,token"},
+			setup:            func(t *testing.T, _ *AutoAuth, vaultToken string) { t.Setenv("VAULT_TOKEN", vaultToken) },
+			expectedRequests: []string{"GET auth/token/lookup-self"},
+			expectedLogs:     []string{"auth method approle skipped"},
+		},
+		{
+			name: "custom chain",
+			args: []string{"--auth-methods", "approle", "--vault-approle-auth-mount", "/ci"},
+			setup: func(t *testing.T, _ *AutoAuth, vaultToken string) {
+				t.Setenv("VAULT_TOKEN", vaultToken)
+				t.Setenv("APPROLE_ROLE_ID", "role-id")
+				t.Setenv("APPROLE_SECRET_ID", "secret-id")
+			},
+			expectedRequests: []string{"POST auth/ci/login"},
+			expectedError:    "approle failed: authToVaultWithApprole() AppRoleLogin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBrowser(t)
+			vault, opts := mockVault(t)
+			vault.AddKubernetesLogin("/kubernetes/argocd", defaultVaultKubernetesLoginRole, "psat-jwt")
+			vault.AddOIDCAuth("/oidc", "")
+			var logs bytes.Buffer
+			auth := mockAutoAuth(t, &logs, append([]string{"--" + FlagOIDCListenAddress, mockOIDCListenAddress(t)}, tt.args...)...)
+			if tt.setup != nil {
+				tt.setup(t, auth, vault.IssueToken())
+			}
+			require.NoError(t, auth.LoadEnv())
+			require.NoError(t, auth.Validate())
+			opts.Authenticator = auth
+			federator, err := NewFederator(opts)
+			require.NoError(t, err)
+
+			_, err = federator.Login(context.Background(), TemplateData{})
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedRequests, vault.Requests())
+			for _, expected := range tt.expectedLogs {
+				assert.Contains(t, logs.String(), expected)
+			}
+		})
+	}
+}
+
+// tests a vault token rejected by Vault is not sent along with the login of the next method
+func TestAutoLoginClearsRejectedToken(t *testing.T) {
+	vault, opts := mockVault(t)
+	var loginToken *string
+	vault.AddLogin("/ci", func(r *vaultfake.Request) bool {
+		loginToken = &r.Token
+		return true
+	})
+	var logs bytes.Buffer
+	auth := mockAutoAuth(t, &logs, "--auth-methods", "token,approle", "--vault-approle-auth-mount", "/ci")
+	t.Setenv("VAULT_TOKEN", "hvs.revoked")
+	t.Setenv("APPROLE_ROLE_ID", "role-id")
+	t.Setenv("APPROLE_SECRET_ID", "secret-id")
+	require.NoError(t, auth.LoadEnv())
+	require.NoError(t, auth.Validate())
+	opts.Authenticator = auth
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GET auth/token/lookup-self", "POST auth/ci/login"}, vault.Requests())
+	assert.Contains(t, logs.String(), "auth method token failed")
+	require.NotNil(t, loginToken)
+	assert.Empty(t, *loginToken)
+}
+
+// tests the login templates of the method auto picks are rendered for each cluster, as when the method is used directly
+func TestAutoLoginTemplates(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.AddKubernetesLogin("/k8s/dev", defaultVaultKubernetesLoginRole, "psat-jwt")
+	vault.AddKubernetesLogin("/k8s/prod", defaultVaultKubernetesLoginRole, "psat-jwt")
+	auth := mockAutoAuth(t, &bytes.Buffer{}, "--auth-methods", "psat", "--vault-kubernetes-auth-mount", "/k8s/{{.ClusterName}}")
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("psat-jwt"), 0600))
+	auth.authenticators[AuthMethodPsat].(*KubernetesAuth).TokenPath = tokenPath
+	require.NoError(t, auth.Validate())
+	opts.Authenticator = auth
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	assert.True(t, federator.LoginDependsOnCluster())
+
+	for _, clusterName := range []string{"dev", "prod"} {
+		_, err = federator.Login(context.Background(), federator.TemplateData(clusterName))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"POST auth/k8s/dev/login", "POST auth/k8s/prod/login"}, vault.Requests())
+	assert.Equal(t, "/k8s/{{.ClusterName}}", auth.authenticators[AuthMethodPsat].(*KubernetesAuth).Mount)
+}
+
+// tests auto rejects unknown methods and shares flags among the methods defining them
+func TestAutoValidate(t *testing.T) {
+	auth := mockAutoAuth(t, &bytes.Buffer{}, "--auth-methods", "token,ldap")
+	assert.ErrorContains(t, auth.Validate(), `unsupported auth method in auth-methods: "ldap"`)
+
+	auth = mockAutoAuth(t, &bytes.Buffer{}, "--vault-jwt-auth-mount", "/ci-jwt")
+	assert.Equal(t, "/ci-jwt", auth.authenticators[AuthMethodJWT].(*JWTAuth).Mount)
+	assert.Equal(t, "/ci-jwt", auth.authenticators[AuthMethodSpiffe].(*SpiffeAuth).Mount)
+}
+
+// tests a plan names the selected method and the skipped ones
+func TestAutoPlanLogin(t *testing.T) {
+	auth := mockAutoAuth(t, &bytes.Buffer{})
+	t.Setenv("APPROLE_ROLE_ID", "role-id")
+	t.Setenv("APPROLE_SECRET_ID", "secret-id")
+	require.NoError(t, auth.LoadEnv())
+	require.NoError(t, auth.Validate())
+
+	plan := auth.PlanLogin()
+	assert.Equal(t, "/approle", plan.Mount)
+	assert.Equal(t, []string{"POST auth/approle/login"}, plan.Requests)
+	assert.Contains(t, plan.Input, "approle selected")
+	assert.Contains(t, plan.Input, "token skipped: VAULT_TOKEN is unset")
+}
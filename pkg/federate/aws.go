@@ -0,0 +1,314 @@
+package federate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodAWS is the name of the aws iam authentication method
+const AuthMethodAWS = "aws"
+
+// const to define cobra command flag names that supply mount point path and role for Vault's aws authentication
+const (
+	FlagVaultAWSAuthMount = "vault-aws-auth-mount"
+	FlagVaultAWSRole      = "vault-aws-role"
+)
+
+// const to define cobra command flag names that configure the signed sts:GetCallerIdentity request and the instance metadata endpoint
+const (
+	FlagAWSRegion           = "aws-region"
+	FlagAWSSTSEndpoint      = "aws-sts-endpoint"
+	FlagAWSIAMServerID      = "aws-iam-server-id"
+	FlagAWSMetadataEndpoint = "aws-metadata-endpoint"
+)
+
+// DefaultVaultAWSAuthMount defines Vault's default aws mount point
+const DefaultVaultAWSAuthMount = "/aws"
+
+const (
+	defaultAWSRegion           = "us-east-1"
+	defaultAWSSTSEndpoint      = "https://sts.amazonaws.com/"
+	defaultAWSMetadataEndpoint = "http://169.254.169.254"
+	// getCallerIdentityBody is the body of the sts:GetCallerIdentity request Vault replays to identify the caller
+	getCallerIdentityBody = "Action=GetCallerIdentity&Version=2011-06-15"
+)
+
+func init() {
+	RegisterAuthenticator(AuthMethodAWS, func() Authenticator { return &AWSAuth{} })
+}
+
+// AWSCredentials are AWS access keys, SessionToken is set for temporary credentials
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSAuth authenticates to Vault's aws authentication backend with an sts:GetCallerIdentity request signed with the
+// credentials of the environment or of the EC2 instance profile
+type AWSAuth struct {
+	// Mount is Vault's aws authentication mount point, defaults to /aws
+	Mount string
+	// Role is the role in Vault's aws authentication backend
+	Role string
+	// Region and STSEndpoint are where the signed request is destined to, Vault must use the same STS endpoint
+	Region      string
+	STSEndpoint string
+	// IAMServerID is the value of the X-Vault-AWS-IAM-Server-ID header when Vault's aws backend requires it
+	IAMServerID string
+	// MetadataEndpoint is the EC2 instance metadata service, used when the environment carries no credentials
+	MetadataEndpoint string
+	// HTTPClient requests the instance metadata service, defaults to a client with a short timeout
+	HTTPClient *http.Client
+	// now returns the signing time, tests fix it
+	now func() time.Time
+}
+
+// Name returns the name of the aws authentication method
+func (a *AWSAuth) Name() string {
+	return AuthMethodAWS
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *AWSAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
+
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *AWSAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// Usage returns the description of the aws subcommand
+func (a *AWSAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using aws iam authentication",
+		`Authenticates to Hashicorp Vault using aws iam authentication.
+It signs an sts:GetCallerIdentity request with the credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+and AWS_SESSION_TOKEN or, when unset, with the credentials of the EC2 instance profile read from the instance metadata service.`
+}
+
+// AddFlags registers the flags of the aws subcommand
+func (a *AWSAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultAWSAuthMount, "a", DefaultVaultAWSAuthMount, "vault aws authentication mountpoint, ex: /aws")
+	flags.StringVarP(&a.Role, FlagVaultAWSRole, "r", "", "role in vault's aws authentication backend")
+	flags.StringVar(&a.Region, FlagAWSRegion, "", "region of the sts endpoint, defaults to AWS_REGION or us-east-1")
+	flags.StringVar(&a.STSEndpoint, FlagAWSSTSEndpoint, defaultAWSSTSEndpoint, "sts endpoint the sts:GetCallerIdentity request is signed for")
+	flags.StringVar(&a.IAMServerID, FlagAWSIAMServerID, "", "value of the X-Vault-AWS-IAM-Server-ID header required by vault's aws authentication backend")
+	flags.StringVar(&a.MetadataEndpoint, FlagAWSMetadataEndpoint, "", "EC2 instance metadata service, defaults to AWS_EC2_METADATA_SERVICE_ENDPOINT or http://169.254.169.254")
+	return []string{FlagVaultAWSRole}
+}
+
+// LoadEnv fills an unset region from AWS_REGION and an unset metadata endpoint from AWS_EC2_METADATA_SERVICE_ENDPOINT
+func (a *AWSAuth) LoadEnv() error {
+	if a.Region == "" {
+		a.Region = os.Getenv("AWS_REGION")
+	}
+	if a.MetadataEndpoint == "" {
+		a.MetadataEndpoint = os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	}
+	return nil
+}
+
+// Validate checks the mount point, role and endpoints and applies defaults
+func (a *AWSAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultAWSAuthMount
+	}
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-aws-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /aws: %s", a.Mount)
+	}
+	if a.Role == "" {
+		return fmt.Errorf("vault-aws-role must be set")
+	}
+	if a.Region == "" {
+		a.Region = defaultAWSRegion
+	}
+	if a.STSEndpoint == "" {
+		a.STSEndpoint = defaultAWSSTSEndpoint
+	}
+	if a.MetadataEndpoint == "" {
+		a.MetadataEndpoint = defaultAWSMetadataEndpoint
+	}
+	for flag, endpoint := range map[string]string{FlagAWSSTSEndpoint: a.STSEndpoint, FlagAWSMetadataEndpoint: a.MetadataEndpoint} {
+		if err := isValidEndpoint(endpoint); err != nil {
+			return fmt.Errorf("%s must be a URL: %s", flag, err)
+		}
+	}
+	return nil
+}
+
+// Login authenticates to Vault with a signed sts:GetCallerIdentity request
+func (a *AWSAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	credentials, err := a.credentials(ctx)
+	if err != nil {
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithAWS() cannot obtain aws credentials: %w", err))
+	}
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	headers, err := signGetCallerIdentity(credentials, a.STSEndpoint, a.Region, a.IAMServerID, now())
+	if err != nil {
+		return 0, err
+	}
+	encodedHeaders, err := json.Marshal(headers)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Write(ctx, vaultPath("auth", a.Mount, "login"), map[string]any{
+		"role":                    a.Role,
+		"iam_http_request_method": http.MethodPost,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(a.STSEndpoint)),
+		"iam_request_body":        base64.StdEncoding.EncodeToString([]byte(getCallerIdentityBody)),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(encodedHeaders),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithAWS() AwsLogin: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithAWS()")
+}
+
+// PlanLogin describes the aws login for dry runs
+func (a *AWSAuth) PlanLogin() LoginPlan {
+	input := "sts:GetCallerIdentity for " + a.STSEndpoint + " signed with credentials of AWS_ACCESS_KEY_ID"
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		input = "sts:GetCallerIdentity for " + a.STSEndpoint + " signed with the instance profile credentials of " + a.MetadataEndpoint
+	}
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    input,
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "login")},
+	}
+}
+
+// credentials returns the credentials of the environment, or else of the EC2 instance profile
+func (a *AWSAuth) credentials(ctx context.Context) (AWSCredentials, error) {
+	if accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID"); accessKeyID != "" {
+		return AWSCredentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	return instanceProfileCredentials(ctx, a.HTTPClient, a.MetadataEndpoint)
+}
+
+// instanceProfileCredentials reads the credentials of the EC2 instance profile from the instance metadata service with IMDSv2
+func instanceProfileCredentials(ctx context.Context, client *http.Client, endpoint string) (AWSCredentials, error) {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	token, err := metadataRequest(ctx, client, http.MethodPut, endpoint+"/latest/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("instance metadata session token: %w", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+	roles, err := metadataRequest(ctx, client, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/", headers)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("instance profile: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return AWSCredentials{}, fmt.Errorf("instance profile: no role is attached to the instance")
+	}
+	data, err := metadataRequest(ctx, client, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/"+url.PathEscape(role), headers)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("instance profile credentials: %w", err)
+	}
+	var credentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil || credentials.AccessKeyID == "" {
+		return AWSCredentials{}, fmt.Errorf("instance profile credentials: malformed response")
+	}
+	return AWSCredentials{AccessKeyID: credentials.AccessKeyID, SecretAccessKey: credentials.SecretAccessKey, SessionToken: credentials.Token}, nil
+}
+
+// signGetCallerIdentity signs an sts:GetCallerIdentity request to endpoint with AWS Signature Version 4 and returns its headers
+func signGetCallerIdentity(credentials AWSCredentials, endpoint string, region string, iamServerID string, now time.Time) (map[string][]string, error) {
+	headers := map[string]string{"content-type": "application/x-www-form-urlencoded; charset=utf-8"}
+	if iamServerID != "" {
+		headers["x-vault-aws-iam-server-id"] = iamServerID
+	}
+	signed, err := signV4(credentials, http.MethodPost, endpoint, getCallerIdentityBody, headers, region, "sts", now)
+	if err != nil {
+		return nil, fmt.Errorf("signGetCallerIdentity() %w", err)
+	}
+	return signed, nil
+}
+
+// signV4 signs a request with AWS Signature Version 4 and returns its headers, headers being lower case
+func signV4(credentials AWSCredentials, method string, endpoint string, body string, headers map[string]string, region string, service string, now time.Time) (map[string][]string, error) {
+	requestURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("malformed endpoint: %w", err)
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	all := map[string]string{"host": requestURL.Host, "x-amz-date": amzDate}
+	for name, value := range headers {
+		all[name] = value
+	}
+	if credentials.SessionToken != "" {
+		all["x-amz-security-token"] = credentials.SessionToken
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(all[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalPath := requestURL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	// url.Values.Encode sorts by key and escapes spaces as +, which AWS expects as %20
+	canonicalQuery := strings.ReplaceAll(requestURL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{method, canonicalPath, canonicalQuery, canonicalHeaders.String(), signedHeaders, sha256Hex(body)}, "\n")
+	scope := strings.Join([]string{amzDate[:8], region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, element := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, element)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	signed := map[string][]string{
+		"Authorization": {fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", credentials.AccessKeyID, scope, signedHeaders, signature)},
+	}
+	for name, value := range all {
+		signed[http.CanonicalHeaderKey(name)] = []string{value}
+	}
+	return signed, nil
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
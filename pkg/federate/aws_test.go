@@ -0,0 +1,91 @@
+package federate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests the signature of the IAM ListUsers example of the AWS Signature Version 4 documentation
+func TestSignV4(t *testing.T) {
+	credentials := AWSCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	headers, err := signV4(credentials, "GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", "",
+		map[string]string{"content-type": "application/x-www-form-urlencoded; charset=utf-8"}, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"}, headers["Authorization"])
+}
+
+// mockEC2Metadata stands in for the EC2 instance metadata service, serving credentials of the kvl instance profile with IMDSv2
+func mockEC2Metadata(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			io.WriteString(w, "imds-token")
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			io.WriteString(w, "kvl\n")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/kvl":
+			json.NewEncoder(w).Encode(map[string]string{"Code": "Success", "AccessKeyId": "ASIAINSTANCE", "SecretAccessKey": "instance-secret", "Token": "instance-session"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tests the aws login presents a GetCallerIdentity request signed with the instance profile credentials
+func TestAWSAuthInstanceProfile(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	metadata := mockEC2Metadata(t)
+	vault, opts := mockVault(t)
+	var login map[string]any
+	vault.AddLogin("/aws", func(r *vaultfake.Request) bool {
+		login = r.Body
+		return r.Body["role"] == "kvl"
+	})
+	authenticator := &AWSAuth{Role: "kvl", IAMServerID: "vault.example.com", MetadataEndpoint: metadata.URL}
+	authenticator.now = func() time.Time { return time.Date(2024, 5, 26, 11, 46, 28, 0, time.UTC) }
+	opts.Authenticator = authenticator
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	decode := func(field string) string {
+		data, err := base64.StdEncoding.DecodeString(login[field].(string))
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "POST", login["iam_http_request_method"])
+	assert.Equal(t, "https://sts.amazonaws.com/", decode("iam_request_url"))
+	assert.Equal(t, "Action=GetCallerIdentity&Version=2011-06-15", decode("iam_request_body"))
+	var headers map[string][]string
+	require.NoError(t, json.Unmarshal([]byte(decode("iam_request_headers")), &headers))
+	assert.Equal(t, []string{"instance-session"}, headers["X-Amz-Security-Token"])
+	assert.Equal(t, []string{"vault.example.com"}, headers["X-Vault-Aws-Iam-Server-Id"])
+	assert.Equal(t, []string{"sts.amazonaws.com"}, headers["Host"])
+	assert.Regexp(t, `^AWS4-HMAC-SHA256 Credential=ASIAINSTANCE/20240526/us-east-1/sts/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-vault-aws-iam-server-id, Signature=[0-9a-f]{64}$`, headers["Authorization"][0])
+}
+
+// tests environment credentials are preferred over the instance profile
+func TestAWSAuthEnvironmentCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	authenticator := &AWSAuth{Role: "kvl", MetadataEndpoint: "http://127.0.0.1:1"}
+	require.NoError(t, authenticator.Validate())
+	credentials, err := authenticator.credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, AWSCredentials{AccessKeyID: "AKIAENV", SecretAccessKey: "env-secret"}, credentials)
+}
@@ -0,0 +1,193 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodAzure is the name of the azure managed identity authentication method
+const AuthMethodAzure = "azure"
+
+// const to define cobra command flag names that supply mount point path and role for Vault's azure authentication
+const (
+	FlagVaultAzureAuthMount = "vault-azure-auth-mount"
+	FlagVaultAzureRole      = "vault-azure-role"
+)
+
+// const to define cobra command flag names that configure the instance metadata service and the managed identity token
+const (
+	FlagAzureMetadataEndpoint = "azure-metadata-endpoint"
+	FlagAzureResource         = "azure-resource"
+	FlagAzureClientID         = "azure-client-id"
+)
+
+// DefaultVaultAzureAuthMount defines Vault's default azure mount point
+const DefaultVaultAzureAuthMount = "/azure"
+
+const (
+	defaultAzureMetadataEndpoint = "http://169.254.169.254"
+	// defaultAzureResource is the resource Vault's azure authentication backend expects tokens for by default
+	defaultAzureResource = "https://management.azure.com/"
+)
+
+func init() {
+	RegisterAuthenticator(AuthMethodAzure, func() Authenticator { return &AzureAuth{} })
+}
+
+// AzureAuth authenticates to Vault's azure authentication backend with a managed identity token and the virtual machine's
+// identifiers, both fetched from the Azure instance metadata service (IMDS)
+type AzureAuth struct {
+	// Mount is Vault's azure authentication mount point, defaults to /azure
+	Mount string
+	// Role is the role in Vault's azure authentication backend
+	Role string
+	// MetadataEndpoint is the Azure instance metadata service
+	MetadataEndpoint string
+	// Resource is the resource the token is issued for, it must match Vault's azure resource
+	Resource string
+	// ClientID selects a user-assigned managed identity, the system-assigned one is used when unset
+	ClientID string
+	// HTTPClient requests the instance metadata service, defaults to a client with a short timeout
+	HTTPClient *http.Client
+}
+
+// Name returns the name of the azure authentication method
+func (a *AzureAuth) Name() string {
+	return AuthMethodAzure
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *AzureAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
+
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *AzureAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// Usage returns the description of the azure subcommand
+func (a *AzureAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using azure managed identity authentication",
+		`Authenticates to Hashicorp Vault using azure authentication.
+It fetches a managed identity token and the virtual machine's subscription, resource group and name
+from the Azure instance metadata service.`
+}
+
+// AddFlags registers the flags of the azure subcommand
+func (a *AzureAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultAzureAuthMount, "a", DefaultVaultAzureAuthMount, "vault azure authentication mountpoint, ex: /azure")
+	flags.StringVarP(&a.Role, FlagVaultAzureRole, "r", "", "role in vault's azure authentication backend")
+	flags.StringVar(&a.MetadataEndpoint, FlagAzureMetadataEndpoint, defaultAzureMetadataEndpoint, "Azure instance metadata service")
+	flags.StringVar(&a.Resource, FlagAzureResource, defaultAzureResource, "resource the managed identity token is issued for, must match vault's azure resource")
+	flags.StringVar(&a.ClientID, FlagAzureClientID, "", "client id of a user-assigned managed identity, the system-assigned identity is used when unset")
+	return []string{FlagVaultAzureRole}
+}
+
+// Validate checks the mount point, role and metadata endpoint and applies defaults
+func (a *AzureAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultAzureAuthMount
+	}
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-azure-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /azure: %s", a.Mount)
+	}
+	if a.Role == "" {
+		return fmt.Errorf("vault-azure-role must be set")
+	}
+	if a.Resource == "" {
+		a.Resource = defaultAzureResource
+	}
+	if a.MetadataEndpoint == "" {
+		a.MetadataEndpoint = defaultAzureMetadataEndpoint
+	}
+	if err := isValidEndpoint(a.MetadataEndpoint); err != nil {
+		return fmt.Errorf("azure-metadata-endpoint must be a URL: %s", err)
+	}
+	return nil
+}
+
+// Login authenticates to Vault with a managed identity token of the virtual machine
+func (a *AzureAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	body, err := a.loginBody(ctx)
+	if err != nil {
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithAzure() %w", err))
+	}
+	resp, err := client.Write(ctx, vaultPath("auth", a.Mount, "login"), body)
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithAzure() AzureLogin: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithAzure()")
+}
+
+// PlanLogin describes the azure login for dry runs
+func (a *AzureAuth) PlanLogin() LoginPlan {
+	identity := "system-assigned managed identity"
+	if a.ClientID != "" {
+		identity = "managed identity " + a.ClientID
+	}
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    fmt.Sprintf("token of the %s for %s from %s", identity, a.Resource, a.MetadataEndpoint),
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "login")},
+	}
+}
+
+// loginBody fetches the managed identity token and the identifiers of the virtual machine, or scale set, Vault verifies it against
+func (a *AzureAuth) loginBody(ctx context.Context) (map[string]any, error) {
+	endpoint := strings.TrimSuffix(a.MetadataEndpoint, "/")
+	headers := map[string]string{"Metadata": "true"}
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {a.Resource}}
+	if a.ClientID != "" {
+		query.Set("client_id", a.ClientID)
+	}
+	data, err := metadataRequest(ctx, a.HTTPClient, http.MethodGet, endpoint+"/metadata/identity/oauth2/token?"+query.Encode(), headers)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch managed identity token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return nil, fmt.Errorf("cannot fetch managed identity token: malformed response")
+	}
+
+	data, err = metadataRequest(ctx, a.HTTPClient, http.MethodGet, endpoint+"/metadata/instance?api-version=2021-02-01", headers)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch instance metadata: %w", err)
+	}
+	var instance struct {
+		Compute struct {
+			SubscriptionID    string `json:"subscriptionId"`
+			ResourceGroupName string `json:"resourceGroupName"`
+			Name              string `json:"name"`
+			VMScaleSetName    string `json:"vmScaleSetName"`
+		} `json:"compute"`
+	}
+	if err := json.Unmarshal(data, &instance); err != nil || instance.Compute.SubscriptionID == "" {
+		return nil, fmt.Errorf("cannot fetch instance metadata: malformed response")
+	}
+	body := map[string]any{
+		"role":                a.Role,
+		"jwt":                 token.AccessToken,
+		"subscription_id":     instance.Compute.SubscriptionID,
+		"resource_group_name": instance.Compute.ResourceGroupName,
+	}
+	if instance.Compute.VMScaleSetName != "" {
+		body["vmss_name"] = instance.Compute.VMScaleSetName
+	} else {
+		body["vm_name"] = instance.Compute.Name
+	}
+	return body, nil
+}
@@ -0,0 +1,70 @@
+package federate
+
+import (
+	"context"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/broker"
+)
+
+// const to define cobra command flag name that supplies path to the broker's unix socket
+const FlagBrokerSocket = "broker-socket"
+
+// BrokerLogin returns a broker.LoginFunc that authenticates to Vault with the Federator. When the login depends
+// on the downstream cluster, see LoginDependsOnCluster, every token is issued after a login for its cluster
+func (f *Federator) BrokerLogin() broker.LoginFunc {
+	return func(ctx context.Context) (broker.Session, time.Duration, error) {
+		if f.LoginDependsOnCluster() {
+			return clusterLoginSession{f}, 0, nil
+		}
+		session, err := f.Login(ctx, TemplateData{})
+		if err != nil {
+			return nil, 0, err
+		}
+		return brokerSession{session}, session.TTL(), nil
+	}
+}
+
+// brokerSession adapts a Session to broker.Session
+type brokerSession struct {
+	*Session
+}
+
+// Issue requests a kubernetes bearer token for clusterName along with its vault lease and service account
+func (b brokerSession) Issue(ctx context.Context, clusterName string) (*broker.Credential, error) {
+	credential, err := b.Credential(ctx, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return brokerCredential(credential), nil
+}
+
+// clusterLoginSession adapts a Federator whose login depends on the downstream cluster to broker.Session
+type clusterLoginSession struct {
+	federator *Federator
+}
+
+// Issue logs in for clusterName and requests a kubernetes bearer token for it
+func (c clusterLoginSession) Issue(ctx context.Context, clusterName string) (*broker.Credential, error) {
+	credential, err := c.federator.Credential(ctx, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return brokerCredential(credential), nil
+}
+
+// Renew has no vault token to renew, each Issue logs in
+func (c clusterLoginSession) Renew(ctx context.Context) (time.Duration, error) {
+	return 0, nil
+}
+
+// brokerCredential converts credential to a broker.Credential
+func brokerCredential(credential *Credential) *broker.Credential {
+	return &broker.Credential{
+		Token:                   credential.Token,
+		LeaseID:                 credential.LeaseID,
+		LeaseDuration:           credential.LeaseDuration,
+		ServiceAccountName:      credential.ServiceAccountName,
+		ServiceAccountNamespace: credential.ServiceAccountNamespace,
+	}
+}
@@ -0,0 +1,190 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// CI providers whose OIDC identity tokens can be presented to Vault's jwt authentication backend
+const (
+	CIProviderGitHub    = "github"
+	CIProviderGitLab    = "gitlab"
+	CIProviderBuildkite = "buildkite"
+)
+
+// defaultBuildkiteAgentEndpoint is the Buildkite agent API serving OIDC tokens of jobs
+const defaultBuildkiteAgentEndpoint = "https://agent.buildkite.com/v3"
+
+// gitLabTokenEnvs are the environment variables holding a GitLab OIDC token, in order of preference: VAULT_ID_TOKEN is the
+// conventional name of an id_tokens entry, CI_JOB_JWT_V2 is deprecated by GitLab
+var gitLabTokenEnvs = []string{"VAULT_ID_TOKEN", "CI_JOB_JWT_V2"}
+
+// DetectCIProvider returns the CI provider the process runs in, judging from the environment, or an empty string
+func DetectCIProvider() string {
+	switch {
+	case os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") != "":
+		return CIProviderGitHub
+	case os.Getenv("GITLAB_CI") != "":
+		return CIProviderGitLab
+	case os.Getenv("BUILDKITE") != "":
+		return CIProviderBuildkite
+	}
+	return ""
+}
+
+// NewCITokenSource returns the JWTSource fetching OIDC tokens of provider for audience. For GitLab, whose tokens are
+// issued before the job starts, tokenEnv names the environment variable holding the token
+func NewCITokenSource(provider string, audience string, tokenEnv string, httpClient *http.Client) (JWTSource, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	switch provider {
+	case CIProviderGitHub:
+		return &GitHubTokenSource{
+			RequestURL:   os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"),
+			RequestToken: os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"),
+			Audience:     audience,
+			HTTPClient:   httpClient,
+		}, nil
+	case CIProviderGitLab:
+		envs := gitLabTokenEnvs
+		if tokenEnv != "" {
+			envs = []string{tokenEnv}
+		}
+		for _, env := range envs {
+			if jwt := strings.TrimSpace(os.Getenv(env)); jwt != "" {
+				return PsatStatic{JWT: jwt, From: "GitLab id_token in env " + env}, nil
+			}
+		}
+		return nil, fmt.Errorf("no GitLab OIDC token in %s, declare an id_tokens entry in the job", strings.Join(envs, " or "))
+	case CIProviderBuildkite:
+		endpoint := os.Getenv("BUILDKITE_AGENT_ENDPOINT")
+		if endpoint == "" {
+			endpoint = defaultBuildkiteAgentEndpoint
+		}
+		return &BuildkiteTokenSource{
+			Endpoint:    endpoint,
+			AccessToken: os.Getenv("BUILDKITE_AGENT_ACCESS_TOKEN"),
+			JobID:       os.Getenv("BUILDKITE_JOB_ID"),
+			Audience:    audience,
+			HTTPClient:  httpClient,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported CI provider: %q, expected one of %v", provider, []string{CIProviderGitHub, CIProviderGitLab, CIProviderBuildkite})
+}
+
+// GitHubTokenSource requests an OIDC token of the running GitHub Actions job. The job needs the id-token: write permission
+type GitHubTokenSource struct {
+	// RequestURL and RequestToken are the job's ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN
+	RequestURL   string
+	RequestToken string
+	// Audience of the token, GitHub's default audience when empty
+	Audience   string
+	HTTPClient *http.Client
+}
+
+// Token requests an OIDC token from GitHub
+func (s *GitHubTokenSource) Token(ctx context.Context) (string, error) {
+	if s.RequestURL == "" || s.RequestToken == "" {
+		return "", fmt.Errorf("GitHub OIDC token: ACTIONS_ID_TOKEN_REQUEST_URL or ACTIONS_ID_TOKEN_REQUEST_TOKEN is unset, grant the job the id-token: write permission")
+	}
+	requestURL, err := url.Parse(s.RequestURL)
+	if err != nil {
+		return "", fmt.Errorf("GitHub OIDC token: malformed ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	if s.Audience != "" {
+		query := requestURL.Query()
+		query.Set("audience", s.Audience)
+		requestURL.RawQuery = query.Encode()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("GitHub OIDC token: %w", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+s.RequestToken)
+	request.Header.Set("Accept", "application/json")
+	var response struct {
+		Value string `json:"value"`
+	}
+	if err := doTokenRequest(s.HTTPClient, request, &response); err != nil {
+		return "", fmt.Errorf("GitHub OIDC token: %w", err)
+	}
+	if response.Value == "" {
+		return "", fmt.Errorf("GitHub OIDC token: response does not contain a token")
+	}
+	return response.Value, nil
+}
+
+func (s *GitHubTokenSource) String() string {
+	return fmt.Sprintf("GitHub Actions OIDC token, audience %q", s.Audience)
+}
+
+// BuildkiteTokenSource requests an OIDC token of the running Buildkite job from the agent API, as buildkite-agent oidc request-token does
+type BuildkiteTokenSource struct {
+	// Endpoint, AccessToken and JobID are the job's BUILDKITE_AGENT_ENDPOINT, BUILDKITE_AGENT_ACCESS_TOKEN and BUILDKITE_JOB_ID
+	Endpoint    string
+	AccessToken string
+	JobID       string
+	// Audience of the token, Buildkite's default audience when empty
+	Audience   string
+	HTTPClient *http.Client
+}
+
+// Token requests an OIDC token from Buildkite
+func (s *BuildkiteTokenSource) Token(ctx context.Context) (string, error) {
+	if s.AccessToken == "" || s.JobID == "" {
+		return "", fmt.Errorf("Buildkite OIDC token: BUILDKITE_AGENT_ACCESS_TOKEN or BUILDKITE_JOB_ID is unset")
+	}
+	body, err := json.Marshal(map[string]string{"audience": s.Audience})
+	if err != nil {
+		return "", fmt.Errorf("Buildkite OIDC token: %w", err)
+	}
+	requestURL := strings.TrimSuffix(s.Endpoint, "/") + "/jobs/" + url.PathEscape(s.JobID) + "/oidc/tokens"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, strings.NewReader(string(body)))
+	if err != nil {
+		return "", fmt.Errorf("Buildkite OIDC token: %w", err)
+	}
+	request.Header.Set("Authorization", "Token "+s.AccessToken)
+	request.Header.Set("Content-Type", "application/json")
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := doTokenRequest(s.HTTPClient, request, &response); err != nil {
+		return "", fmt.Errorf("Buildkite OIDC token: %w", err)
+	}
+	if response.Token == "" {
+		return "", fmt.Errorf("Buildkite OIDC token: response does not contain a token")
+	}
+	return response.Token, nil
+}
+
+func (s *BuildkiteTokenSource) String() string {
+	return fmt.Sprintf("Buildkite OIDC token of job %s, audience %q", s.JobID, s.Audience)
+}
+
+// doTokenRequest sends request and decodes a successful JSON response into response
+func doTokenRequest(client *http.Client, request *http.Request, response any) error {
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("%s %s: %s", request.Method, request.URL.Redacted(), resp.Status)
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("malformed response: %w", err)
+	}
+	return nil
+}
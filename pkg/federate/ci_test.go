@@ -0,0 +1,125 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clearCIEnv unsets the environment variables CI providers are detected from
+func clearCIEnv(t *testing.T) {
+	for _, env := range []string{"ACTIONS_ID_TOKEN_REQUEST_URL", "ACTIONS_ID_TOKEN_REQUEST_TOKEN", "GITLAB_CI", "VAULT_ID_TOKEN", "CI_JOB_JWT_V2", "BUILDKITE", "BUILDKITE_AGENT_ENDPOINT", "BUILDKITE_AGENT_ACCESS_TOKEN", "BUILDKITE_JOB_ID"} {
+		t.Setenv(env, "")
+	}
+}
+
+// mockGitHubTokenEndpoint stands in for the GitHub Actions token endpoint, issuing a token naming the requested audience
+func mockGitHubTokenEndpoint(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" || r.URL.Query().Get("api-version") != "2.0" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"count": "1", "value": "github-jwt-" + r.URL.Query().Get("audience")})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tests a GitHub Actions job logs in with a token requested for the configured audience
+func TestJWTAuthGitHub(t *testing.T) {
+	clearCIEnv(t)
+	endpoint := mockGitHubTokenEndpoint(t)
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", endpoint.URL+"/token?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+	vault, opts := mockVault(t)
+	vault.AddJWTLogin("/jwt", "deploy", "github-jwt-vault")
+	opts.Authenticator = &JWTAuth{Role: "deploy", CIAudience: "vault"}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"POST auth/jwt/login"}, vault.Requests())
+	assert.Equal(t, `GitHub Actions OIDC token, audience "vault"`, opts.Authenticator.(*JWTAuth).PlanLogin().Input)
+}
+
+// tests a rejected GitHub token request fails the login before contacting Vault
+func TestJWTAuthGitHubDenied(t *testing.T) {
+	clearCIEnv(t)
+	endpoint := mockGitHubTokenEndpoint(t)
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", endpoint.URL+"/token?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "stale-token")
+	vault, opts := mockVault(t)
+	opts.Authenticator = &JWTAuth{Role: "deploy"}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	assert.ErrorContains(t, err, "GitHub OIDC token: GET "+endpoint.URL+"/token?api-version=2.0: 401 Unauthorized")
+	assert.Empty(t, vault.Requests())
+}
+
+// tests a Buildkite job requests its token from the agent API
+func TestBuildkiteTokenSource(t *testing.T) {
+	clearCIEnv(t)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || r.URL.Path != "/v3/jobs/job-1/oidc/tokens" || r.Header.Get("Authorization") != "Token agent-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"token": "buildkite-jwt-" + body["audience"]})
+	}))
+	t.Cleanup(endpoint.Close)
+	t.Setenv("BUILDKITE", "true")
+	t.Setenv("BUILDKITE_AGENT_ENDPOINT", endpoint.URL+"/v3")
+	t.Setenv("BUILDKITE_AGENT_ACCESS_TOKEN", "agent-token")
+	t.Setenv("BUILDKITE_JOB_ID", "job-1")
+
+	assert.Equal(t, CIProviderBuildkite, DetectCIProvider())
+	source, err := NewCITokenSource(CIProviderBuildkite, "vault", "", nil)
+	require.NoError(t, err)
+	jwt, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "buildkite-jwt-vault", jwt)
+}
+
+// tests GitLab tokens are read from the id_tokens entry, falling back to CI_JOB_JWT_V2
+func TestGitLabTokenSource(t *testing.T) {
+	clearCIEnv(t)
+	t.Setenv("GITLAB_CI", "true")
+	assert.Equal(t, CIProviderGitLab, DetectCIProvider())
+
+	_, err := NewCITokenSource(CIProviderGitLab, "", "", nil)
+	assert.ErrorContains(t, err, "no GitLab OIDC token in VAULT_ID_TOKEN or CI_JOB_JWT_V2")
+
+	t.Setenv("CI_JOB_JWT_V2", "legacy-jwt")
+	source, err := NewCITokenSource(CIProviderGitLab, "", "", nil)
+	require.NoError(t, err)
+	jwt, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-jwt", jwt)
+
+	t.Setenv("KVL_ID_TOKEN", "id-token-jwt")
+	source, err = NewCITokenSource(CIProviderGitLab, "", "KVL_ID_TOKEN", nil)
+	require.NoError(t, err)
+	jwt, err = source.Token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "id-token-jwt", jwt)
+}
+
+// tests the jwt login requires a role and a detectable CI provider
+func TestJWTAuthValidate(t *testing.T) {
+	clearCIEnv(t)
+	assert.ErrorContains(t, (&JWTAuth{}).Validate(), "vault-jwt-role")
+	assert.ErrorContains(t, (&JWTAuth{Role: "deploy"}).Validate(), "no CI provider detected")
+	assert.ErrorContains(t, (&JWTAuth{Role: "deploy", CIProvider: "jenkins"}).Validate(), "unsupported CI provider")
+}
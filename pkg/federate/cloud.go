@@ -0,0 +1,49 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// metadataHTTPClient requests cloud instance metadata endpoints, which answer locally and quickly
+var metadataHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// metadataRequest sends a request with headers to a cloud instance metadata endpoint and returns the body of a 200 response
+func metadataRequest(ctx context.Context, client *http.Client, method string, url string, headers map[string]string) ([]byte, error) {
+	if client == nil {
+		client = metadataHTTPClient
+	}
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return data, nil
+}
+
+// isValidEndpoint checks an endpoint is an absolute http or https URL without query, metadata services being served over http
+func isValidEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+		return fmt.Errorf("not an absolute http or https URL without query: %s", endpoint)
+	}
+	return nil
+}
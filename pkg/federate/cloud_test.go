@@ -0,0 +1,119 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests the gcp login presents an identity token of the instance's service account for the role's audience
+func TestGCPAuthLogin(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" || r.URL.Query().Get("format") != "full" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, "gce-jwt-"+r.URL.Query().Get("audience"))
+	}))
+	t.Cleanup(metadata.Close)
+	t.Setenv("GCE_METADATA_HOST", metadata.Listener.Addr().String())
+	vault, opts := mockVault(t)
+	vault.AddJWTLogin("/gcp", "kvl", "gce-jwt-http://vault/kvl")
+	authenticator := &GCPAuth{Role: "kvl"}
+	require.NoError(t, authenticator.LoadEnv())
+	opts.Authenticator = authenticator
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"POST auth/gcp/login"}, vault.Requests())
+}
+
+// mockAzureIMDS stands in for the Azure instance metadata service of a virtual machine, or of a scale set instance
+func mockAzureIMDS(t *testing.T, scaleSet string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "msi-" + r.URL.Query().Get("resource") + r.URL.Query().Get("client_id"), "token_type": "Bearer"})
+		case "/metadata/instance":
+			json.NewEncoder(w).Encode(map[string]any{"compute": map[string]string{"subscriptionId": "sub-1", "resourceGroupName": "rg-argocd", "name": "argocd-0", "vmScaleSetName": scaleSet}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tests the azure login presents a managed identity token along with the identifiers of the virtual machine or scale set
+func TestAzureAuthLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     AzureAuth
+		scaleSet string
+		expected map[string]any
+	}{
+		{
+			name:     "system-assigned identity of a virtual machine",
+			auth:     AzureAuth{Role: "kvl"},
+			expected: map[string]any{"role": "kvl", "jwt": "msi-https://management.azure.com/", "subscription_id": "sub-1", "resource_group_name": "rg-argocd", "vm_name": "argocd-0"},
+		},
+		{
+			name:     "user-assigned identity of a scale set",
+			auth:     AzureAuth{Role: "kvl", ClientID: "client-1", Resource: "https://vault.example.com"},
+			scaleSet: "argocd",
+			expected: map[string]any{"role": "kvl", "jwt": "msi-https://vault.example.comclient-1", "subscription_id": "sub-1", "resource_group_name": "rg-argocd", "vmss_name": "argocd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.auth.MetadataEndpoint = mockAzureIMDS(t, tt.scaleSet).URL
+			vault, opts := mockVault(t)
+			var login map[string]any
+			vault.AddLogin("/azure", func(r *vaultfake.Request) bool {
+				login = r.Body
+				return true
+			})
+			opts.Authenticator = &tt.auth
+			federator, err := NewFederator(opts)
+			require.NoError(t, err)
+
+			_, err = federator.Login(context.Background(), TemplateData{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, login)
+		})
+	}
+}
+
+// tests an unreachable metadata service fails the login before contacting Vault
+func TestCloudAuthMetadataUnreachable(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	for _, authenticator := range []Authenticator{
+		&AWSAuth{Role: "kvl", MetadataEndpoint: "http://127.0.0.1:1"},
+		&GCPAuth{Role: "kvl", MetadataEndpoint: "http://127.0.0.1:1"},
+		&AzureAuth{Role: "kvl", MetadataEndpoint: "http://127.0.0.1:1"},
+	} {
+		t.Run(authenticator.Name(), func(t *testing.T) {
+			vault, opts := mockVault(t)
+			opts.Authenticator = authenticator
+			federator, err := NewFederator(opts)
+			require.NoError(t, err)
+
+			_, err = federator.Login(context.Background(), TemplateData{})
+			assert.ErrorContains(t, err, "127.0.0.1:1")
+			assert.Empty(t, vault.Requests())
+		})
+	}
+}
@@ -3,149 +3,46 @@ package federate
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/url"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
 	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
-
-	vaultcg "github.com/hashicorp/vault-client-go"
 )
 
 // const to define cobra command flag name that supplies vault address
 const FlagVaultAddress = "vault-address"
 
+// const to define cobra command flag names that supply the Vault Enterprise namespace of all requests,
+// of the login and of the kubernetes secrets engine
+const (
+	FlagVaultNamespace        = "vault-namespace"
+	FlagVaultAuthNamespace    = "vault-auth-namespace"
+	FlagVaultSecretsNamespace = "vault-secrets-namespace"
+)
+
 // const to define cobra command flag name that supplies downstream cluster name
 const FlagClusterName = "cluster-name"
 
-// tokenDuration represents expiration set in the ExecCredentialStatus
-var tokenDuration time.Duration
+// const to define cobra command flag name that supplies the configuration file
+const FlagConfig = "config"
 
-// minTokenDuration establishes a 15 min minimum allowed expiration for ExecCredentialStatus
-const minTokenDuration time.Duration = time.Minute * 15
+// const to define cobra command flag name that selects the format of errors printed to STDERR, text or json
+const FlagErrorFormat = "error-format"
 
-// vaultKubernetesLoginRole represents a role in Vault's kuberentes authentication backend used to authetinticate with k8s sourced PSAT and subsequently obtain a Vault token
-var vaultKubernetesLoginRole string
+// const to define cobra command flag name that supplies the directory of the on-disk credential cache
+const FlagCacheDir = "cache-dir"
 
-// vaultK8sSecretRole represents a role in Vault's kuberentes secret backend used to obtain k8sToken
-var vaultK8sSecretRole string
+// minTokenDuration establishes a 15 min minimum allowed expiration for ExecCredentialStatus
+const minTokenDuration time.Duration = time.Minute * 15
 
 // execInfoEnv defines a variable in which ExecCredential is passed
 const execInfoEnv = "KUBERNETES_EXEC_INFO"
 
-// hashicorp vault client
-var client *vaultcg.Client
-
-// pointer to received ExecCredential
-var inputExecCredentialPtr *clientauthentication.ExecCredential
-
-// kubernetes bearer token
-var k8sToken string
-
-// prepFederation performs the following preparation tasks:
-// 1. calls setVariables
-// 2. captures ExecCredential from KUBERNETES_EXEC_INFO
-// 3. prepares a vault client,
-// 4. checks if ExecCredential.Spec.Server exists and if it doesn't then uses the value supplied as part of cluser-name flag
-func prepFederation(args *map[string]any) error {
-	// func prepFederation(args *map[string]any) {
-
-	var err error
-
-	// set variables for vault kubernetes authentication and secret roles as well as token duration
-	setVariables()
-
-	// capture received ExecCredential
-	inputExecCredentialPtr, err = getExecCredentialFromEnv()
-	if err != nil {
-		return err
-	}
-
-	// We need to ensure that cluster name is set to inputExecCredentialPtr.Spec.Cluster.Server or to the value of the cluster-name flag in that order of preference
-	if cname, err := getDownstreamClusterName(inputExecCredentialPtr); err != nil {
-		if (*args)[FlagClusterName].(string) == "" {
-			return fmt.Errorf("%s and cluster-name flag is unset or empty", err)
-		} else {
-			if !isValidHostname((*args)[FlagClusterName].(string)) {
-				return fmt.Errorf("cluster-name must be a string that is a valid dns name: %s", (*args)[FlagClusterName].(string))
-			}
-		}
-	} else {
-		if !isValidHostname(cname) {
-			return fmt.Errorf("cluster-name must be an alphanumeric string: %s", cname)
-		}
-		(*args)[FlagClusterName] = cname
-	}
-	return nil
-}
-
-func prepVaultClient(vaddr string) error {
-	var err error
-	client, err = vaultcg.New(
-		vaultcg.WithAddress(vaddr),
-		vaultcg.WithRequestTimeout(30*time.Second),
-	)
-	if err != nil {
-		return fmt.Errorf("failure preparing vault client: %s", err)
-	}
-	return nil
-}
-
-// prepVaultAuthMount prepares a Vault authentication mount point only when kuberenetes authentication is used.
-// this function is not necessary when approle authentication is used as default value for the mount point is assigned
-func prepVaultAuthMount(args *map[string]any) error {
-
-	// first if the vaultAuthMount was not provided then assign it a value of the VAULT_AUTH_MOUNT env variable.
-	// we dont check if the env variable exists because right after we check it the vaultAuthMount a value that follows an expected pattern
-	if (*args)[FlagVaultKubernetesAuthMount].(string) == "" {
-		(*args)[FlagVaultKubernetesAuthMount] = os.Getenv("VAULT_AUTH_MOUNT")
-	}
-	pattern := "^/kubernetes.*$"
-	regex := regexp.MustCompile(pattern)
-	if !regex.MatchString((*args)[FlagVaultKubernetesAuthMount].(string)) {
-		return fmt.Errorf("malformed vault authentication mount path: %s. The value should be in the form of /kubernetes*", (*args)[FlagVaultKubernetesAuthMount].(string))
-	}
-	return nil
-}
-
-// Applies either values from environment variables or default to the following variables:
-// vaultKubernetesLoginRole, vaultK8sSecretRole, tokenDuration
-func setVariables() {
-	var (
-		err    error
-		exists bool
-	)
-
-	// Get vaultKubernetesLoginRole from an env variable or apply a default
-	if vaultKubernetesLoginRole, exists = os.LookupEnv("VAULT_K8S_LOGIN_ROLE"); !exists || vaultKubernetesLoginRole == "" {
-		vaultKubernetesLoginRole = "kvl-login"
-	}
-
-	// Get vaultK8sSecretRole from an env variable or apply a default
-	if vaultK8sSecretRole, exists = os.LookupEnv("VAULT_K8S_SECRET_ROLE"); !exists || vaultK8sSecretRole == "" {
-		vaultK8sSecretRole = "kvl-edit-role"
-	}
-
-	// Get tokenDuration from an env variable or apply a minTokenDuration. ExecCredenatialStatus.Expiration > Min vault TTL (10mins)
-	if value, exists := os.LookupEnv("TOKEN_DURATION"); exists {
-		tokenDuration, err = time.ParseDuration(value)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if tokenDuration < minTokenDuration {
-			tokenDuration = minTokenDuration
-		}
-	} else {
-		tokenDuration = minTokenDuration
-	}
-}
-
-// getDownstreamClusterName extracts downstream cluster name from inputExecCredentialPtr.Spec.Cluster.Server and in the case when it is not supplied
-// it assigns it a value passed by the downstreamClusterName command lime flag.
+// getDownstreamClusterName extracts downstream cluster name from execCredentialPointer.Spec.Cluster.Server.
+// It returns an error when the ExecCredential carries no cluster info, see Federator.ClusterName for the fallback.
 func getDownstreamClusterName(execCredentialPointer *clientauthentication.ExecCredential) (string, error) {
 	// first check if after JSON unmarshall executed by the GetExecCredentialFromEnv() function
 	// the Cluster is indeed part of the ExecCredential.
@@ -165,13 +62,24 @@ func getDownstreamClusterName(execCredentialPointer *clientauthentication.ExecCr
 	}
 }
 
-// isValidURL checks if URL is valid, uses https and doesn't include relative paths or queries
-func isValidURL(addr string) error {
+// clusterServer returns the downstream cluster's API server URL carried by execCredential, empty when it carries no cluster info
+func clusterServer(execCredential *clientauthentication.ExecCredential) string {
+	if execCredential == nil || execCredential.Spec.Cluster == nil {
+		return ""
+	}
+	return execCredential.Spec.Cluster.Server
+}
+
+// isValidURL checks if URL is valid, uses https, or http when allowHTTP is set, and doesn't include relative paths or queries
+func isValidURL(addr string, allowHTTP bool) error {
 	url, err := url.ParseRequestURI(addr)
 	if err != nil {
 		return fmt.Errorf("mal formed vault-address: %s", addr)
 	} else {
-		if url.Scheme != "https" {
+		if url.Scheme == "http" && !allowHTTP {
+			return fmt.Errorf("only https is allowed in vault-address, unless --%s is set: %s", FlagAllowHTTP, addr)
+		}
+		if url.Scheme != "https" && url.Scheme != "http" {
 			return fmt.Errorf("only https is allowed in vault-address: %s", addr)
 		}
 		if url.Path != "" {
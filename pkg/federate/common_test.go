@@ -10,18 +10,33 @@ import (
 	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 )
 
-var mockArgs = map[string]any{
-	FlagClusterName:  "dev",
-	FlagVaultAddress: "https://localhost:8200",
+var mockOptions = Options{
+	ClusterName:   "dev",
+	VaultAddress:  "https://localhost:8200",
+	Authenticator: &ApproleAuth{Mount: "/approle"},
 }
-var mockArgsNocluster = map[string]any{
-	FlagClusterName:  "",
-	FlagVaultAddress: "https://localhost:8200",
+
+// mockFederator returns a Federator created from mockOptions with the given cluster name
+func mockFederator(t *testing.T, clusterName string) *Federator {
+	opts := mockOptions
+	opts.ClusterName = clusterName
+	f, err := NewFederator(opts)
+	assert.NoError(t, err)
+	return f
+}
+
+// mockExecCredential unmarshals an ExecCredential the way kubectl passes it in KUBERNETES_EXEC_INFO
+func mockExecCredential(t *testing.T, execInfo string) *clientauthentication.ExecCredential {
+	os.Setenv("KUBERNETES_EXEC_INFO", execInfo)
+	execCredential, err := ExecCredentialFromEnv()
+	assert.NoError(t, err)
+	return execCredential
 }
 
 // tests if error is reported when KUBERNETES_EXEC_INFO is not set
-func TestPrepFederationNoExec(t *testing.T) {
-	err := prepFederation(&mockArgs)
+func TestExecCredentialFromEnvNoExec(t *testing.T) {
+	os.Unsetenv("KUBERNETES_EXEC_INFO")
+	_, err := ExecCredentialFromEnv()
 
 	expectedError := `GetExecCredentialFromEnv(): kubectl-vaultlogin is a kubectl credential plugin and requires an ExecCredetnial to be provided in the KUBERNETES_EXEC_INFO env variable. Exiting as the variable is unset or empty`
 	assert.EqualError(t, err, expectedError)
@@ -30,9 +45,9 @@ func TestPrepFederationNoExec(t *testing.T) {
 
 // tests if error is reported when KUBERNETES_EXEC_INFO holds a valid ExecCredential
 // but without Spec.Cluster and cluster-name flag isn't set
-func TestPrepFederationExecnospecclusterNoclustername(t *testing.T) {
-	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
-	err := prepFederation(&mockArgsNocluster)
+func TestClusterNameExecnospecclusterNoclustername(t *testing.T) {
+	execCredential := mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	_, err := mockFederator(t, "").ClusterName(execCredential)
 
 	expectedPattern := "and cluster-name flag is unset or empty"
 	assert.Regexp(t, regexp.MustCompile(expectedPattern), err)
@@ -41,59 +56,89 @@ func TestPrepFederationExecnospecclusterNoclustername(t *testing.T) {
 
 // tests if no error is reported and cluster name is set to the value provided in the cluster name flag
 // when KUBERNETES_EXEC_INFO holds a valid ExecCredential but without Spec.Cluster
-func TestPrepFederationExecnospecclusterClustername(t *testing.T) {
-	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
-	cname := mockArgs[FlagClusterName].(string)
-	err := prepFederation(&mockArgs)
+func TestClusterNameExecnospecclusterClustername(t *testing.T) {
+	execCredential := mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`)
+	cname, err := mockFederator(t, "dev").ClusterName(execCredential)
 	assert.NoError(t, err)
-	assert.Equal(t, cname, mockArgs[FlagClusterName].(string))
+	assert.Equal(t, "dev", cname)
 }
 
 // tests if no error is reported and cluster name is set to the value of Spec.Cluster
 // when cluster name flag is set to a value and at the same time
 // KUBERNETES_EXEC_INFO holds a valid ExecCredential with Spec.Cluster and Server value specified
-func TestPrepFederationExecClustername(t *testing.T) {
-	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com:443","config":null},"interactive":false}}`)
-	cname := "k8s"
-	err := prepFederation(&mockArgs)
+func TestClusterNameExecClustername(t *testing.T) {
+	execCredential := mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com:443","config":null},"interactive":false}}`)
+	cname, err := mockFederator(t, "dev").ClusterName(execCredential)
 	assert.NoError(t, err)
-	assert.Equal(t, cname, mockArgs[FlagClusterName].(string))
+	assert.Equal(t, "k8s", cname)
 }
 
 // tests if no error is reported and cluster name is set to the value of Spec.Cluster
 // when cluster name flag isn't set
 // KUBERNETES_EXEC_INFO holds a valid ExecCredential with Spec.Cluster and Server value specified
-func TestPrepFederationExecNoclustername(t *testing.T) {
-	os.Setenv("KUBERNETES_EXEC_INFO", `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com:443","config":null},"interactive":false}}`)
-	cname := "k8s"
-	err := prepFederation(&mockArgsNocluster)
+func TestClusterNameExecNoclustername(t *testing.T) {
+	execCredential := mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://k8s.example.com:443","config":null},"interactive":false}}`)
+	cname, err := mockFederator(t, "").ClusterName(execCredential)
 	assert.NoError(t, err)
-	assert.Equal(t, cname, mockArgs[FlagClusterName].(string))
+	assert.Equal(t, "k8s", cname)
 }
 
-// tests if no error is reported when setting up a Vault client
-func TestPrepVaultClient(t *testing.T) {
-	err := prepVaultClient(mockArgs[FlagVaultAddress].(string))
+// tests if defaults are applied to options left unset
+func TestNewFederatorDefaults(t *testing.T) {
+	opts := mockFederator(t, "dev").Options()
+	assert.Equal(t, "kvl-edit-role", opts.SecretRole)
+	assert.Equal(t, "kube-priv", opts.KubernetesNamespace)
+	assert.Equal(t, minTokenDuration, opts.TokenDuration)
+}
+
+// tests if invalid options are reported
+func TestNewFederatorInvalid(t *testing.T) {
+	opts := mockOptions
+	opts.VaultAddress = "http://localhost:8200"
+	_, err := NewFederator(opts)
+	assert.ErrorContains(t, err, "only https is allowed in vault-address")
+
+	opts = mockOptions
+	opts.Authenticator = &KubernetesAuth{Mount: "/kubernetes/argocd", TokenPath: "token"}
+	_, err = NewFederator(opts)
+	assert.ErrorContains(t, err, "psat-path must be an absolute path")
+
+	opts = mockOptions
+	opts.Authenticator = nil
+	_, err = NewFederator(opts)
+	assert.ErrorContains(t, err, "an authenticator is required")
+}
+
+// tests if the built-in authentication methods are registered and unknown ones are reported
+func TestAuthenticatorRegistry(t *testing.T) {
+	assert.Equal(t, []string{AuthMethodApprole, AuthMethodAuto, AuthMethodAWS, AuthMethodAzure, AuthMethodGCP, AuthMethodJWT, AuthMethodOIDC, AuthMethodPsat, AuthMethodSpiffe, AuthMethodToken}, Authenticators())
+
+	authenticator, err := NewAuthenticator(AuthMethodPsat)
 	assert.NoError(t, err)
+	assert.Equal(t, AuthMethodPsat, authenticator.Name())
+
+	_, err = NewAuthenticator("ldap")
+	assert.ErrorContains(t, err, "unsupported auth method")
 }
 
-// test if error is reported when improper path is supplied as vault-kubernetes-auth-mount
-func TestPrepVaultAuthMountWrong(t *testing.T) {
-	mockArgs := map[string]any{
-		"vault-kubernetes-auth-mount": "wrong",
-	}
-	err := prepVaultAuthMount(&mockArgs)
-	expectedPattern := "malformed vault authentication mount path"
-	assert.Regexp(t, regexp.MustCompile(expectedPattern), err)
+// tests if the kubernetes authenticator applies its default token path and role
+func TestKubernetesAuthDefaults(t *testing.T) {
+	authenticator := &KubernetesAuth{Mount: "/kubernetes/argocd"}
+	assert.NoError(t, authenticator.Validate())
+	assert.Equal(t, "kvl-login", authenticator.Role)
+	assert.Equal(t, "/var/run/secrets/kubernetes.io/serviceaccount/token", authenticator.TokenPath)
 }
 
-// test if no error is reported when a correct path is supplied as vault-kubernetes-auth-mount
+// tests if TOKEN_DURATION is reported when malformed instead of exiting the process
+func TestLoadEnvMalformedTokenDuration(t *testing.T) {
+	t.Setenv("TOKEN_DURATION", "fifteen")
+	opts := mockOptions
+	assert.ErrorContains(t, opts.LoadEnv(), "malformed TOKEN_DURATION")
+}
 
-func TestPrepVaultAuthMount(t *testing.T) {
-	mockArgs := map[string]any{
-		"vault-kubernetes-auth-mount": "/kubernetes/argocd",
-	}
-	err := prepVaultAuthMount(&mockArgs)
+// tests if no error is reported when setting up a Vault client
+func TestNewVaultClient(t *testing.T) {
+	_, err := newVaultClient(mockOptions.VaultAddress, nil, 0)
 	assert.NoError(t, err)
 }
 
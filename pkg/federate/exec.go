@@ -6,14 +6,12 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"time"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
 )
 
-// GetExecCredentialFromEnv populates ExecCredential with what it received from kubectl in the KUBERNETES_EXEC_INFO env var
-func getExecCredentialFromEnv() (*clientauthentication.ExecCredential, error) {
+// ExecCredentialFromEnv populates ExecCredential with what it received from kubectl in the KUBERNETES_EXEC_INFO env var
+func ExecCredentialFromEnv() (*clientauthentication.ExecCredential, error) {
 	var execCredential clientauthentication.ExecCredential
 
 	env := os.Getenv(execInfoEnv)
@@ -28,32 +26,18 @@ func getExecCredentialFromEnv() (*clientauthentication.ExecCredential, error) {
 	return &execCredential, nil
 }
 
-// printExecCredential prints ExecCredential struct in JSON format
-func printExecCredential(w io.Writer, execCredentialPointer *clientauthentication.ExecCredential) error {
+// PrintExecCredential prints ExecCredential struct in JSON format
+func PrintExecCredential(w io.Writer, execCredentialPointer *clientauthentication.ExecCredential) error {
 	// marshal execCredentialPointer struct to JSON
 	data, err := json.Marshal(*execCredentialPointer)
 	if err != nil {
-		return fmt.Errorf("printExecCredential: cannot marshal ExecCredential to JSON: %w", err)
+		return fmt.Errorf("PrintExecCredential: cannot marshal ExecCredential to JSON: %w", err)
 	}
 
 	// Write JSON to io.Writer (could be file or STDOUT)
 	_, err = fmt.Fprintf(w, "%s\n", data)
 	if err != nil {
-		return fmt.Errorf("printExecCredential: could not write JSON to %T: %w", w, err)
-	}
-	return nil
-}
-
-// assembleExecCredential prepares ExecCredential by adding ExecCredentialStatus with a bearer token and expiration
-func assembleExecCredential(execCredentialPointer *clientauthentication.ExecCredential, token string) error {
-	// take a pointer to the received ExecCredential and add .Status field to it containing the received k8s token
-	// var execCredentialStatus clientauthentication.ExecCredentialStatus
-	// execCredentialStatus.Token = token
-	// (*execCredentialPointer).Status = &execCredentialStatus
-	// return nil
-	(*execCredentialPointer).Status = &clientauthentication.ExecCredentialStatus{
-		ExpirationTimestamp: &metav1.Time{Time: time.Now().Add(tokenDuration)},
-		Token:               token,
+		return fmt.Errorf("PrintExecCredential: could not write JSON to %T: %w", w, err)
 	}
 	return nil
 }
@@ -0,0 +1,486 @@
+package federate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// defaultVaultK8sSecretRole is the default role in Vault's kubernetes secret backend
+const defaultVaultK8sSecretRole = "kvl-edit-role"
+
+// defaultK8sNamespace is the kubernetes namespace requested from Vault's kubernetes secret backend
+const defaultK8sNamespace = "kube-priv"
+
+// Options configures a Federator. Zero values are replaced with defaults by NewFederator
+type Options struct {
+	// VaultAddress is the full URL with port to Hashicorp Vault
+	VaultAddress string
+	// ClusterName is the downstream cluster name used when the ExecCredential does not carry Spec.Cluster.Server
+	ClusterName string
+	// Authenticator logs in to Vault, ex. a *KubernetesAuth or an *ApproleAuth
+	Authenticator Authenticator
+
+	// SecretsMount is the mount point of Vault's kubernetes secret backend, defaults to /kubernetes/{{.ClusterName}}
+	SecretsMount string
+	// SecretRole is the role in Vault's kubernetes secret backend, defaults to kvl-edit-role
+	SecretRole string
+	// KubernetesNamespace is the namespace the kubernetes bearer token is requested for, defaults to kube-priv
+	KubernetesNamespace string
+	// TokenDuration is the expiration set in the ExecCredentialStatus, at least 15 minutes
+	TokenDuration time.Duration
+
+	// Environment and Profile are the fields of TemplateData that mount points and role names may refer to,
+	// ex. /k8s-creds/{{.Environment}}/{{.ClusterName}} or {{.Profile.team}}-deployer
+	Environment string
+	Profile     map[string]string
+
+	// Sources selects the CredentialSource per downstream cluster name
+	Sources map[string]CredentialSource
+	// DefaultSource is used for clusters missing from Sources, defaults to a *KubernetesSecretsSource
+	DefaultSource CredentialSource
+
+	// HTTPClient is used for requests to Vault when set, ex. to trust a private CA, see VaultConfig.HTTPClient
+	HTTPClient *http.Client
+	// Timeout limits each request to Vault, defaults to 30 seconds
+	Timeout time.Duration
+	// AllowHTTP accepts an http VaultAddress, meant for local dev servers
+	AllowHTTP bool
+
+	// OneShot designates a process logging in once before it exits, ex. a federate call by kubectl. Single-use
+	// credentials, ex. a response-wrapped SecretID, are then refused as the next process could not present them again
+	OneShot bool
+
+	// Namespace is the Vault Enterprise namespace of all requests, the root namespace when empty.
+	// AuthNamespace and SecretsNamespace override it for the login and for credential requests respectively
+	Namespace        string
+	AuthNamespace    string
+	SecretsNamespace string
+}
+
+// LoadEnv fills unset options from the environment variables understood by the kubectl-vaultlogin plugin:
+// VAULT_K8S_SECRET_ROLE, TOKEN_DURATION, VAULT_NAMESPACE and those of the Authenticator
+func (o *Options) LoadEnv() error {
+	if o.SecretRole == "" {
+		o.SecretRole = os.Getenv("VAULT_K8S_SECRET_ROLE")
+	}
+	if o.Namespace == "" {
+		o.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if value, exists := os.LookupEnv("TOKEN_DURATION"); exists && o.TokenDuration == 0 {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("malformed TOKEN_DURATION: %s", err)
+		}
+		o.TokenDuration = duration
+	}
+	if loader, ok := o.Authenticator.(envLoader); ok {
+		return loader.LoadEnv()
+	}
+	return nil
+}
+
+// Federator exchanges a Vault identity for kubernetes bearer tokens. It holds no Vault state between calls
+// and is safe for concurrent use
+type Federator struct {
+	opts Options
+	// loginPerCluster is set when the authenticator's mount point or login role depend on the downstream
+	// cluster, each login then renders them into a copy of the authenticator
+	loginPerCluster bool
+}
+
+// NewFederator validates opts, applies defaults and returns a Federator
+func NewFederator(opts Options) (*Federator, error) {
+	// verify supplied options
+	// check vault-address
+	if err := isValidURL(opts.VaultAddress, opts.AllowHTTP); err != nil {
+		return nil, err
+	}
+	if opts.Timeout < 0 {
+		return nil, fmt.Errorf("%s must not be negative: %s", FlagVaultTimeout, opts.Timeout)
+	}
+	if opts.ClusterName != "" && !isValidHostname(opts.ClusterName) {
+		return nil, fmt.Errorf("cluster-name must be a string that is a valid dns name: %s", opts.ClusterName)
+	}
+
+	for flag, namespace := range map[string]*string{FlagVaultNamespace: &opts.Namespace, FlagVaultAuthNamespace: &opts.AuthNamespace, FlagVaultSecretsNamespace: &opts.SecretsNamespace} {
+		normalized, err := normalizeNamespace(*namespace)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s", flag, err)
+		}
+		*namespace = normalized
+	}
+
+	if opts.Authenticator == nil {
+		return nil, fmt.Errorf("an authenticator is required, expected one of %v", Authenticators())
+	}
+	if err := opts.Authenticator.Validate(); err != nil {
+		return nil, err
+	}
+	if checker, ok := opts.Authenticator.(singleUseChecker); ok && opts.OneShot {
+		if credential := checker.singleUse(); credential != "" {
+			return nil, fmt.Errorf("%s can only be presented once, it is accepted by the long-lived broker, proxy and exec commands", credential)
+		}
+	}
+
+	if opts.SecretsMount == "" {
+		opts.SecretsMount = defaultSecretsMount
+	}
+	if opts.SecretRole == "" {
+		opts.SecretRole = defaultVaultK8sSecretRole
+	}
+	if opts.KubernetesNamespace == "" {
+		opts.KubernetesNamespace = defaultK8sNamespace
+	}
+	// ExecCredenatialStatus.Expiration > Min vault TTL (10mins)
+	if opts.TokenDuration < minTokenDuration {
+		opts.TokenDuration = minTokenDuration
+	}
+
+	if opts.DefaultSource == nil {
+		opts.DefaultSource = &KubernetesSecretsSource{}
+	}
+	// templates of the default source are checked for a sample cluster, those of a cluster's source for the cluster
+	sources := map[string]CredentialSource{"cluster": opts.DefaultSource}
+	for clusterName, source := range opts.Sources {
+		if !isValidHostname(clusterName) {
+			return nil, fmt.Errorf("cluster-name must be a string that is a valid dns name: %s", clusterName)
+		}
+		sources[clusterName] = source
+	}
+	for clusterName, source := range sources {
+		if defaulter, ok := source.(sourceDefaulter); ok {
+			defaulter.setDefaults(opts)
+		}
+		if err := source.Validate(); err != nil {
+			return nil, fmt.Errorf("%s credential source: %s", source.Name(), err)
+		}
+		if renderer, ok := source.(sourceRenderer); ok {
+			if _, err := renderer.render(opts.templateData(clusterName, "")); err != nil {
+				return nil, fmt.Errorf("%s credential source: %s", source.Name(), err)
+			}
+		}
+	}
+
+	federator := &Federator{opts: opts}
+	if err := federator.prepareLoginTemplates(); err != nil {
+		return nil, err
+	}
+	return federator, nil
+}
+
+// prepareLoginTemplates checks the templates of the authenticator's mount point and login role, or of those of
+// its delegates. Templates independent of the downstream cluster are rendered once, the others before each login
+func (f *Federator) prepareLoginTemplates() error {
+	data := f.opts.templateData("cluster", "")
+	perCluster, err := loginDependsOnCluster(f.opts.Authenticator, data)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderLogin(f.opts.Authenticator, data)
+	if err != nil {
+		return err
+	}
+	if f.loginPerCluster = perCluster; !perCluster {
+		f.opts.Authenticator = rendered
+	}
+	return nil
+}
+
+// authenticator returns the Authenticator logging in for data, a copy with the mount point and login role
+// rendered for data when they depend on the downstream cluster
+func (f *Federator) authenticator(data TemplateData) (Authenticator, error) {
+	if !f.loginPerCluster {
+		return f.opts.Authenticator, nil
+	}
+	if data.ClusterName == "" {
+		return nil, fmt.Errorf("the auth mount or login role template depends on the downstream cluster, a cluster name is required to log in")
+	}
+	return renderLogin(f.opts.Authenticator, data)
+}
+
+// LoginDependsOnCluster reports whether the auth mount or login role template depends on the downstream cluster,
+// a Session then only serves the cluster it logged in for
+func (f *Federator) LoginDependsOnCluster() bool {
+	return f.loginPerCluster
+}
+
+// TemplateData returns the data templates are rendered with for clusterName, see Login
+func (f *Federator) TemplateData(clusterName string) TemplateData {
+	return f.opts.templateData(clusterName, "")
+}
+
+// Options returns the effective options of the Federator, including applied defaults
+func (f *Federator) Options() Options {
+	return f.opts
+}
+
+// Federate authenticates to Vault, requests a kubernetes bearer token for the downstream cluster designated
+// by execCredential and returns a corresponding ExecCredentialStatus
+func (f *Federator) Federate(ctx context.Context, execCredential *clientauthentication.ExecCredential) (*clientauthentication.ExecCredentialStatus, error) {
+	credential, err := f.FederateCredential(ctx, execCredential)
+	if err != nil {
+		return nil, err
+	}
+	return credential.ExecCredentialStatus(), nil
+}
+
+// FederateCredential is Federate returning the issued Credential with its lease and service account details.
+// Templates are rendered with the API server URL of execCredential as .ClusterServer
+func (f *Federator) FederateCredential(ctx context.Context, execCredential *clientauthentication.ExecCredential) (*Credential, error) {
+	clusterName, err := f.ClusterName(execCredential)
+	if err != nil {
+		return nil, err
+	}
+	data := f.opts.templateData(clusterName, clusterServer(execCredential))
+	session, err := f.Login(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return session.credential(ctx, data)
+}
+
+// Credential authenticates to Vault and requests a kubernetes bearer token for clusterName. The API server
+// URL is unknown, templates referring to .ClusterServer render it empty, see FederateCredential
+func (f *Federator) Credential(ctx context.Context, clusterName string) (*Credential, error) {
+	data := f.opts.templateData(clusterName, "")
+	session, err := f.Login(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return session.credential(ctx, data)
+}
+
+// CacheKey returns a digest of the settings a credential for clusterName, whose API server is clusterServer, is issued
+// with: the Vault address and namespaces, the auth method with its mount, role and input and the credential source
+// with its rendered settings. A credential cached under one key must not be handed out under another.
+// Authenticators that know whom they log in as add a digest of that identity, ex. of the approle RoleID, so that
+// different credentials read from the same input do not share a key
+func (f *Federator) CacheKey(clusterName, clusterServer string) (string, error) {
+	data := f.opts.templateData(clusterName, clusterServer)
+	source, err := f.opts.renderedSource(data)
+	if err != nil {
+		return "", err
+	}
+	authenticator, err := f.authenticator(data)
+	if err != nil {
+		return "", err
+	}
+	var login LoginPlan
+	if planner, ok := authenticator.(LoginPlanner); ok {
+		login = planner.PlanLogin()
+	}
+	var identity string
+	if identifier, ok := authenticator.(loginIdentifier); ok {
+		identity = identifier.loginIdentity()
+	}
+	settings, err := json.Marshal([]any{
+		f.opts.VaultAddress, f.opts.authNamespace(), f.opts.secretsNamespace(),
+		f.opts.Authenticator.Name(), login.Mount, login.Role, login.Input, identity,
+		source.Name(), source, f.opts.KubernetesNamespace,
+	})
+	if err != nil {
+		return "", fmt.Errorf("CacheKey(): %s", err)
+	}
+	digest := sha256.Sum256(settings)
+	return hex.EncodeToString(digest[:16]), nil
+}
+
+// ClusterName returns the downstream cluster name. It is taken from execCredential.Spec.Cluster.Server
+// or, when the ExecCredential carries no cluster info, from Options.ClusterName in that order of preference
+func (f *Federator) ClusterName(execCredential *clientauthentication.ExecCredential) (string, error) {
+	cname, _, err := f.clusterName(execCredential)
+	return cname, err
+}
+
+// clusterName returns the downstream cluster name along with the rule that picked it
+func (f *Federator) clusterName(execCredential *clientauthentication.ExecCredential) (string, string, error) {
+	cname, err := getDownstreamClusterName(execCredential)
+	if err != nil {
+		if f.opts.ClusterName == "" {
+			return "", "", fmt.Errorf("%s and cluster-name flag is unset or empty", err)
+		}
+		return f.opts.ClusterName, "cluster-name flag, ExecCredential.Spec.Cluster is not provided", nil
+	}
+	if !isValidHostname(cname) {
+		return "", "", fmt.Errorf("cluster-name must be an alphanumeric string: %s", cname)
+	}
+	return cname, fmt.Sprintf("first label of ExecCredential.Spec.Cluster.Server %s", execCredential.Spec.Cluster.Server), nil
+}
+
+// Login authenticates to Vault with the configured method and returns a Session holding the vault token.
+// The authenticator's mount point and login role are rendered for data, see TemplateData. When they depend
+// on the downstream cluster, see LoginDependsOnCluster, the Session only serves data.ClusterName, otherwise
+// it serves any number of clusters and data may be empty
+func (f *Federator) Login(ctx context.Context, data TemplateData) (*Session, error) {
+	authenticator, err := f.authenticator(data)
+	if err != nil {
+		return nil, kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+	authNamespace, secretsNamespace := f.opts.authNamespace(), f.opts.secretsNamespace()
+	authClient, err := newVaultClient(f.opts.VaultAddress, f.opts.HTTPClient, f.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := authClient.SetNamespace(authNamespace); err != nil {
+		return nil, err
+	}
+
+	ttl, err := authenticator.Login(ctx, authClient)
+	if err != nil {
+		return nil, categorizeVaultError(withNamespace(authNamespace, err), kvlerrors.VaultAuthDenied)
+	}
+	session := &Session{opts: f.opts, client: authClient, authClient: authClient, ttl: ttl}
+	if secretsNamespace != authNamespace {
+		// the token is used in the secrets namespace and renewed in the namespace it was issued in
+		secretsClient := authClient.Clone()
+		if err := secretsClient.SetNamespace(secretsNamespace); err != nil {
+			return nil, err
+		}
+		session.client = secretsClient
+	}
+	return session, nil
+}
+
+// Session is a Vault client authenticated by a Federator. It can request kubernetes bearer tokens for
+// any number of downstream clusters and is safe for concurrent use
+type Session struct {
+	opts Options
+	// client requests credentials in the secrets namespace, authClient renews the token in the auth namespace
+	client     VaultAPI
+	authClient VaultAPI
+	ttl        time.Duration
+}
+
+// authNamespace returns the Vault namespace of the login
+func (o *Options) authNamespace() string {
+	if o.AuthNamespace != "" {
+		return o.AuthNamespace
+	}
+	return o.Namespace
+}
+
+// secretsNamespace returns the Vault namespace of credential requests
+func (o *Options) secretsNamespace() string {
+	if o.SecretsNamespace != "" {
+		return o.SecretsNamespace
+	}
+	return o.Namespace
+}
+
+// TTL returns the ttl of the session's vault token at login, 0 designates a token that does not expire
+func (s *Session) TTL() time.Duration {
+	return s.ttl
+}
+
+// Client returns the Vault client of the session, authenticated in the secrets namespace
+func (s *Session) Client() VaultAPI {
+	return s.client
+}
+
+// Source returns the CredentialSource selected for clusterName
+func (f *Federator) Source(clusterName string) CredentialSource {
+	return f.opts.source(clusterName)
+}
+
+// source returns the CredentialSource of clusterName or the default source
+func (o *Options) source(clusterName string) CredentialSource {
+	if source, exists := o.Sources[clusterName]; exists {
+		return source
+	}
+	return o.DefaultSource
+}
+
+// KubernetesSecrets returns the kubernetes secret engine source of clusterName with its mount point and role
+// rendered, it fails when the cluster is configured with another credential source
+func (f *Federator) KubernetesSecrets(clusterName string) (*KubernetesSecretsSource, error) {
+	source, err := f.opts.renderedSource(f.opts.templateData(clusterName, ""))
+	if err != nil {
+		return nil, err
+	}
+	secrets, ok := source.(*KubernetesSecretsSource)
+	if !ok {
+		return nil, fmt.Errorf("cluster %s uses the %s credential source, not Vault's kubernetes secret engine", clusterName, source.Name())
+	}
+	secrets.Mount = secrets.mount(clusterName)
+	return secrets, nil
+}
+
+// renderedSource returns the CredentialSource of data.ClusterName with its templates rendered for data
+func (o *Options) renderedSource(data TemplateData) (CredentialSource, error) {
+	source := o.source(data.ClusterName)
+	if renderer, ok := source.(sourceRenderer); ok {
+		return renderer.render(data)
+	}
+	return source, nil
+}
+
+// Credential requests a kubernetes bearer token for clusterName from the cluster's CredentialSource
+func (s *Session) Credential(ctx context.Context, clusterName string) (*Credential, error) {
+	return s.credential(ctx, s.opts.templateData(clusterName, ""))
+}
+
+// credential requests a kubernetes bearer token for data.ClusterName with the source's templates rendered for data
+func (s *Session) credential(ctx context.Context, data TemplateData) (*Credential, error) {
+	clusterName := data.ClusterName
+	if !isValidHostname(clusterName) {
+		return nil, fmt.Errorf("cluster-name must be a string that is a valid dns name: %s", clusterName)
+	}
+	source, err := s.opts.renderedSource(data)
+	if err != nil {
+		return nil, kvlerrors.Wrap(kvlerrors.InputValidation, err)
+	}
+	credential, err := source.Credential(ctx, s.client, clusterName)
+	if err != nil {
+		return nil, categorizeVaultError(withNamespace(s.opts.secretsNamespace(), err), kvlerrors.SecretsDenied)
+	}
+	// sources issuing tokens with a known expiry set it, the earlier of both is reported to kubectl
+	expiration := time.Now().Add(s.opts.TokenDuration)
+	if credential.ExpirationTimestamp.IsZero() || expiration.Before(credential.ExpirationTimestamp) {
+		credential.ExpirationTimestamp = expiration
+	}
+	return credential, nil
+}
+
+// Renew extends the session's vault token and returns its new ttl
+func (s *Session) Renew(ctx context.Context) (time.Duration, error) {
+	resp, err := s.authClient.Write(ctx, "auth/token/renew-self", nil)
+	if err != nil {
+		return 0, categorizeVaultError(withNamespace(s.opts.authNamespace(), fmt.Errorf("Renew() TokenRenewSelf: %w", err)), kvlerrors.VaultAuthDenied)
+	}
+	if resp.Auth == nil {
+		return 0, fmt.Errorf("Renew() TokenRenewSelf: response does not contain auth information")
+	}
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// Credential is a kubernetes bearer token issued by Vault for a downstream cluster
+type Credential struct {
+	ClusterName string `json:"clusterName"`
+	Token       string `json:"token"`
+	// LeaseID and LeaseDuration describe the Vault lease of the token
+	LeaseID       string        `json:"leaseID,omitempty"`
+	LeaseDuration time.Duration `json:"leaseDuration,omitempty"`
+	// ServiceAccountName and ServiceAccountNamespace name the service account of the token, when Vault returns them
+	ServiceAccountName      string `json:"serviceAccountName,omitempty"`
+	ServiceAccountNamespace string `json:"serviceAccountNamespace,omitempty"`
+	// ExpirationTimestamp is the expiration reported to kubectl, at the latest after Options.TokenDuration
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+}
+
+// ExecCredentialStatus converts the credential to an ExecCredentialStatus
+func (c *Credential) ExecCredentialStatus() *clientauthentication.ExecCredentialStatus {
+	return &clientauthentication.ExecCredentialStatus{
+		ExpirationTimestamp: &metav1.Time{Time: c.ExpirationTimestamp},
+		Token:               c.Token,
+	}
+}
@@ -0,0 +1,182 @@
+package federate
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests a full federation with approle authentication against the fake Vault
+func TestFederate(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.AddKubernetesCreds("/kubernetes/dev", "kvl-edit-role")
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	status, err := federator.Federate(context.Background(), mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "fake-k8s-token-2", status.Token)
+	assert.WithinDuration(t, time.Now().Add(minTokenDuration), status.ExpirationTimestamp.Time, time.Minute)
+	assert.Equal(t, []string{"POST auth/approle/login", "POST kubernetes/dev/creds/kvl-edit-role"}, vault.Requests())
+}
+
+// tests kubernetes authentication reads the psat and sends it along with the login role
+func TestLoginKubernetesAuth(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.AddKubernetesLogin("/kubernetes/argocd", "kvl-login", "psat-jwt")
+	vault.SetTokenTTL(20 * time.Minute)
+	tokenPath := t.TempDir() + "/token"
+	require.NoError(t, os.WriteFile(tokenPath, []byte("psat-jwt"), 0600))
+	opts.Authenticator = &KubernetesAuth{Mount: "/kubernetes/argocd", TokenPath: tokenPath}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	session, err := federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, session.TTL())
+
+	ttl, err := session.Renew(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, ttl)
+}
+
+// tests Vault failures are reported as errors
+func TestFederateVaultFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		clusterName   string
+		secretID      string
+		failPath      string
+		failure       vaultfake.Failure
+		expectedError string
+		category      kvlerrors.Category
+	}{
+		{name: "invalid secret id", clusterName: "dev", secretID: "wrong", expectedError: "AppRoleLogin: .*invalid role or secret ID", category: kvlerrors.VaultAuthDenied},
+		{name: "login denied", clusterName: "dev", secretID: "secret-id", failPath: "auth/approle/login", failure: vaultfake.Forbidden, expectedError: "AppRoleLogin: .*permission denied", category: kvlerrors.VaultAuthDenied},
+		{name: "creds denied", clusterName: "dev", secretID: "secret-id", failPath: "kubernetes/dev/creds/kvl-edit-role", failure: vaultfake.Forbidden, expectedError: "KubernetesGenerateCredentials: cluster=dev, .*permission denied", category: kvlerrors.SecretsDenied},
+		{name: "malformed creds response", clusterName: "dev", secretID: "secret-id", failPath: "kubernetes/dev/creds/kvl-edit-role", failure: vaultfake.Malformed, expectedError: "KubernetesGenerateCredentials: cluster=dev", category: kvlerrors.General},
+		{name: "unknown cluster", clusterName: "prod", secretID: "secret-id", expectedError: "cluster=prod, .*404", category: kvlerrors.SecretsDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, opts := mockVault(t)
+			vault.AddKubernetesCreds("/kubernetes/dev", "kvl-edit-role")
+			if tt.failPath != "" {
+				vault.Fail(tt.failPath, tt.failure)
+			}
+			opts.Authenticator = &ApproleAuth{Mount: "/approle", RoleID: "role-id", SecretID: tt.secretID}
+			federator, err := NewFederator(opts)
+			require.NoError(t, err)
+
+			_, err = federator.Credential(context.Background(), tt.clusterName)
+			assert.Regexp(t, tt.expectedError, err)
+			assert.Equal(t, tt.category, kvlerrors.CategoryOf(err))
+		})
+	}
+}
+
+// tests a sealed or unreachable Vault is reported as unreachable
+func TestFederateVaultUnreachable(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.Fail(vaultfake.AnyPath, vaultfake.Sealed)
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Credential(context.Background(), "dev")
+	assert.ErrorContains(t, err, "Vault is sealed")
+	assert.Equal(t, kvlerrors.VaultUnreachable, kvlerrors.CategoryOf(err))
+
+	_, err = net.Dial("tcp", "127.0.0.1:1")
+	assert.Equal(t, kvlerrors.VaultUnreachable, kvlerrors.CategoryOf(categorizeVaultError(err, kvlerrors.VaultAuthDenied)))
+}
+
+// tests the login and the credential request are sent to their Vault namespaces and the token is renewed in the namespace of the login
+func TestFederateNamespaces(t *testing.T) {
+	tests := []struct {
+		name               string
+		namespace          string
+		authNamespace      string
+		secretsNamespace   string
+		expectedNamespaces []string
+	}{
+		{name: "root namespace", expectedNamespaces: []string{"", "", ""}},
+		{name: "shared namespace", namespace: "/team-a/", expectedNamespaces: []string{"team-a", "team-a", "team-a"}},
+		{name: "separate namespaces", authNamespace: "platform", secretsNamespace: "team-a/dev", expectedNamespaces: []string{"platform", "team-a/dev", "platform"}},
+		{name: "secrets namespace overrides", namespace: "team-a", secretsNamespace: "team-a/dev", expectedNamespaces: []string{"team-a", "team-a/dev", "team-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, opts := mockVault(t)
+			vault.AddKubernetesCreds("/kubernetes/dev", "kvl-edit-role")
+			opts.Namespace, opts.AuthNamespace, opts.SecretsNamespace = tt.namespace, tt.authNamespace, tt.secretsNamespace
+			federator, err := NewFederator(opts)
+			require.NoError(t, err)
+
+			session, err := federator.Login(context.Background(), TemplateData{})
+			require.NoError(t, err)
+			_, err = session.Credential(context.Background(), "dev")
+			require.NoError(t, err)
+			_, err = session.Renew(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, []string{"POST auth/approle/login", "POST kubernetes/dev/creds/kvl-edit-role", "POST auth/token/renew-self"}, vault.Requests())
+			assert.Equal(t, tt.expectedNamespaces, vault.Namespaces())
+		})
+	}
+}
+
+// tests failures name the Vault namespace of the request and invalid namespaces are refused
+func TestFederateNamespaceErrors(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.Fail("kubernetes/dev/creds/kvl-edit-role", vaultfake.Forbidden)
+	opts.AuthNamespace, opts.SecretsNamespace = "platform", "team-a/dev"
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Credential(context.Background(), "dev")
+	assert.ErrorContains(t, err, "vault namespace team-a/dev: ")
+	assert.Equal(t, kvlerrors.SecretsDenied, kvlerrors.CategoryOf(err))
+
+	for _, namespace := range []string{"team a", "team-a//dev", "sys", "team-a/identity", "team-a/.."} {
+		opts.Namespace = namespace
+		_, err := NewFederator(opts)
+		assert.ErrorContains(t, err, FlagVaultNamespace+" must be a path of alphanumeric elements", namespace)
+	}
+
+	t.Setenv("VAULT_NAMESPACE", "team-b")
+	opts = Options{Authenticator: &ApproleAuth{}}
+	require.NoError(t, opts.LoadEnv())
+	assert.Equal(t, "team-b", opts.Namespace)
+}
+
+// tests if credentials of different identities read from the same input get different cache keys,
+// and if a psat reissued for the same service account keeps its key
+func TestCacheKeyIdentity(t *testing.T) {
+	_, opts := mockVault(t)
+	cacheKey := func(authenticator Authenticator) string {
+		opts.Authenticator = authenticator
+		federator, err := NewFederator(opts)
+		require.NoError(t, err)
+		key, err := federator.CacheKey("dev", "")
+		require.NoError(t, err)
+		return key
+	}
+
+	first := cacheKey(&ApproleAuth{Mount: "/approle", RoleID: "role-id", SecretID: "secret-id"})
+	assert.Equal(t, first, cacheKey(&ApproleAuth{Mount: "/approle", RoleID: "role-id", SecretID: "other-secret-id"}))
+	assert.NotEqual(t, first, cacheKey(&ApproleAuth{Mount: "/approle", RoleID: "other-role-id", SecretID: "secret-id"}))
+
+	psat := filepath.Join(t.TempDir(), "token")
+	psatKey := func(subject string, audience string) string {
+		require.NoError(t, os.WriteFile(psat, []byte(mockJWTSVID(subject, []string{audience})), 0600))
+		return cacheKey(&KubernetesAuth{Mount: "/kubernetes", TokenPath: psat})
+	}
+	argocd := psatKey("system:serviceaccount:argocd:argocd-server", "vault")
+	assert.Equal(t, argocd, psatKey("system:serviceaccount:argocd:argocd-server", "vault-reissued"))
+	assert.NotEqual(t, argocd, psatKey("system:serviceaccount:ci:runner", "vault"))
+}
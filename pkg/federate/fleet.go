@@ -0,0 +1,51 @@
+package federate
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultParallelism bounds the number of concurrent requests to Vault in FederateAll
+const defaultParallelism = 4
+
+// FleetResult is the outcome of a request for a kubernetes bearer token in FederateAll
+type FleetResult struct {
+	ClusterName string
+	Credential  *Credential
+	Err         error
+}
+
+// FederateAll logs in to Vault once and requests kubernetes bearer tokens for clusterNames with at most
+// parallelism concurrent requests, 4 when unset. It continues past failures, which are reported per cluster,
+// and returns the results in the order of clusterNames. The returned error is only set when the login fails.
+// When the login depends on the downstream cluster, see LoginDependsOnCluster, it logs in once per cluster
+// and a failed login is reported for its cluster
+func (f *Federator) FederateAll(ctx context.Context, clusterNames []string, parallelism int) ([]FleetResult, error) {
+	credential := f.Credential
+	if !f.LoginDependsOnCluster() {
+		session, err := f.Login(ctx, TemplateData{})
+		if err != nil {
+			return nil, err
+		}
+		credential = session.Credential
+	}
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+
+	results := make([]FleetResult, len(clusterNames))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, clusterName := range clusterNames {
+		wg.Add(1)
+		go func(i int, clusterName string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			issued, err := credential(ctx, clusterName)
+			results[i] = FleetResult{ClusterName: clusterName, Credential: issued, Err: err}
+		}(i, clusterName)
+	}
+	wg.Wait()
+	return results, nil
+}
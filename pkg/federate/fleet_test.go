@@ -0,0 +1,59 @@
+package federate
+
+import (
+	"context"
+	"testing"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests FederateAll logs in once and continues past clusters that fail
+func TestFederateAll(t *testing.T) {
+	vault, opts := mockVault(t)
+	clusterNames := []string{"c1", "c2", "c3", "c4", "c5", "c6"}
+	for _, clusterName := range clusterNames {
+		vault.AddKubernetesCreds("/kubernetes/"+clusterName, "kvl-edit-role")
+	}
+	vault.Fail("kubernetes/c3/creds/kvl-edit-role", vaultfake.Forbidden)
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	results, err := federator.FederateAll(context.Background(), append(clusterNames, "unknown"), 2)
+	require.NoError(t, err)
+	require.Len(t, results, 7)
+	for i, result := range results {
+		assert.Equal(t, append(clusterNames, "unknown")[i], result.ClusterName)
+		switch result.ClusterName {
+		case "c3":
+			assert.Equal(t, kvlerrors.SecretsDenied, kvlerrors.CategoryOf(result.Err))
+		case "unknown":
+			assert.ErrorContains(t, result.Err, "404")
+		default:
+			require.NoError(t, result.Err)
+			assert.NotEmpty(t, result.Credential.Token)
+		}
+	}
+
+	logins := 0
+	for _, request := range vault.Requests() {
+		if request == "POST auth/approle/login" {
+			logins++
+		}
+	}
+	assert.Equal(t, 1, logins)
+}
+
+// tests FederateAll reports a failed login instead of per cluster results
+func TestFederateAllLoginFailure(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.Fail("auth/approle/login", vaultfake.Forbidden)
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	results, err := federator.FederateAll(context.Background(), []string{"c1"}, 0)
+	assert.Nil(t, results)
+	assert.Equal(t, kvlerrors.VaultAuthDenied, kvlerrors.CategoryOf(err))
+}
@@ -0,0 +1,154 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodGCP is the name of the gcp gce authentication method
+const AuthMethodGCP = "gcp"
+
+// const to define cobra command flag names that supply mount point path and role for Vault's gcp authentication
+const (
+	FlagVaultGCPAuthMount = "vault-gcp-auth-mount"
+	FlagVaultGCPRole      = "vault-gcp-role"
+)
+
+// const to define cobra command flag names that configure the metadata server and the service account of the identity token
+const (
+	FlagGCPMetadataEndpoint = "gcp-metadata-endpoint"
+	FlagGCPServiceAccount   = "gcp-service-account"
+)
+
+// DefaultVaultGCPAuthMount defines Vault's default gcp mount point
+const DefaultVaultGCPAuthMount = "/gcp"
+
+const (
+	defaultGCPMetadataEndpoint = "http://metadata.google.internal"
+	defaultGCPServiceAccount   = "default"
+)
+
+func init() {
+	RegisterAuthenticator(AuthMethodGCP, func() Authenticator { return &GCPAuth{} })
+}
+
+// GCPAuth authenticates to Vault's gcp authentication backend with an identity token of the instance's service account,
+// fetched from the GCE metadata server
+type GCPAuth struct {
+	// Mount is Vault's gcp authentication mount point, defaults to /gcp
+	Mount string
+	// Role is the role in Vault's gcp authentication backend, the identity token is requested for audience http://vault/<role>
+	Role string
+	// MetadataEndpoint is the GCE metadata server
+	MetadataEndpoint string
+	// ServiceAccount is the instance's service account the identity token is issued for, defaults to default
+	ServiceAccount string
+	// HTTPClient requests the metadata server, defaults to a client with a short timeout
+	HTTPClient *http.Client
+}
+
+// Name returns the name of the gcp authentication method
+func (a *GCPAuth) Name() string {
+	return AuthMethodGCP
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *GCPAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
+
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *GCPAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// Usage returns the description of the gcp subcommand
+func (a *GCPAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using gcp gce authentication",
+		`Authenticates to Hashicorp Vault using gcp gce authentication.
+It fetches an identity token of the instance's service account from the GCE metadata server.`
+}
+
+// AddFlags registers the flags of the gcp subcommand
+func (a *GCPAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultGCPAuthMount, "a", DefaultVaultGCPAuthMount, "vault gcp authentication mountpoint, ex: /gcp")
+	flags.StringVarP(&a.Role, FlagVaultGCPRole, "r", "", "role in vault's gcp authentication backend")
+	flags.StringVar(&a.MetadataEndpoint, FlagGCPMetadataEndpoint, "", "GCE metadata server, defaults to GCE_METADATA_HOST or http://metadata.google.internal")
+	flags.StringVar(&a.ServiceAccount, FlagGCPServiceAccount, defaultGCPServiceAccount, "service account of the instance the identity token is issued for")
+	return []string{FlagVaultGCPRole}
+}
+
+// LoadEnv fills an unset metadata endpoint from GCE_METADATA_HOST
+func (a *GCPAuth) LoadEnv() error {
+	if host := os.Getenv("GCE_METADATA_HOST"); a.MetadataEndpoint == "" && host != "" {
+		a.MetadataEndpoint = "http://" + host
+	}
+	return nil
+}
+
+// Validate checks the mount point, role and metadata endpoint and applies defaults
+func (a *GCPAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultGCPAuthMount
+	}
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-gcp-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /gcp: %s", a.Mount)
+	}
+	if a.Role == "" {
+		return fmt.Errorf("vault-gcp-role must be set")
+	}
+	if a.ServiceAccount == "" {
+		a.ServiceAccount = defaultGCPServiceAccount
+	}
+	if a.MetadataEndpoint == "" {
+		a.MetadataEndpoint = defaultGCPMetadataEndpoint
+	}
+	if err := isValidEndpoint(a.MetadataEndpoint); err != nil {
+		return fmt.Errorf("gcp-metadata-endpoint must be a URL: %s", err)
+	}
+	return nil
+}
+
+// Login authenticates to Vault with an identity token of the instance
+func (a *GCPAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	query := url.Values{"audience": {a.audience()}, "format": {"full"}}
+	jwt, err := metadataRequest(ctx, a.HTTPClient, http.MethodGet,
+		strings.TrimSuffix(a.MetadataEndpoint, "/")+"/computeMetadata/v1/instance/service-accounts/"+url.PathEscape(a.ServiceAccount)+"/identity?"+query.Encode(),
+		map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithGCP() cannot fetch identity token: %w", err))
+	}
+	resp, err := client.Write(ctx, vaultPath("auth", a.Mount, "login"), map[string]any{
+		"role": a.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithGCP() GoogleCloudLogin: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithGCP()")
+}
+
+// PlanLogin describes the gcp login for dry runs
+func (a *GCPAuth) PlanLogin() LoginPlan {
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    fmt.Sprintf("identity token of service account %s for audience %s from %s", a.ServiceAccount, a.audience(), a.MetadataEndpoint),
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "login")},
+	}
+}
+
+// audience is the audience Vault's gcp authentication backend expects for Role
+func (a *GCPAuth) audience() string {
+	return "http://vault/" + a.Role
+}
@@ -0,0 +1,163 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodJWT is the name of the jwt authentication method
+const AuthMethodJWT = "jwt"
+
+// const to define cobra command flag names that supply mount point path and role for Vault's jwt authentication
+const (
+	FlagVaultJWTAuthMount = "vault-jwt-auth-mount"
+	FlagVaultJWTRole      = "vault-jwt-role"
+)
+
+// const to define cobra command flag names that select the CI provider issuing the JWT and configure its token
+const (
+	FlagCIProvider = "ci-provider"
+	FlagCIAudience = "ci-audience"
+	FlagCITokenEnv = "ci-token-env"
+)
+
+// DefaultVaultJWTAuthMount defines Vault's default jwt mount point
+const DefaultVaultJWTAuthMount = "/jwt"
+
+// ciProviderAuto detects the CI provider from the environment
+const ciProviderAuto = "auto"
+
+func init() {
+	RegisterAuthenticator(AuthMethodJWT, func() Authenticator { return &JWTAuth{} })
+}
+
+// JWTAuth authenticates to Vault's jwt authentication backend with an OIDC identity token issued by a CI provider
+type JWTAuth struct {
+	// Mount is Vault's jwt authentication mount point, defaults to /jwt
+	Mount string
+	// Role is the role in Vault's jwt authentication backend
+	Role string
+	// CIProvider is github, gitlab, buildkite or auto, the default, to detect it from the environment
+	CIProvider string
+	// CIAudience is the audience of the requested token, it must match the bound_audiences of Role
+	CIAudience string
+	// CITokenEnv is the environment variable holding a GitLab id_token
+	CITokenEnv string
+	// Source supplies the JWT. When nil, Validate sets it from CIProvider
+	Source JWTSource
+}
+
+// Name returns the name of the jwt authentication method
+func (a *JWTAuth) Name() string {
+	return AuthMethodJWT
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *JWTAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
+
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *JWTAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// Usage returns the description of the jwt subcommand
+func (a *JWTAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using jwt authentication and a CI provider's OIDC token",
+		`Authenticates to Hashicorp Vault using jwt authentication.
+It presents the OIDC identity token of the running CI job, so pipelines federate without stored secrets:
+  github     requested with ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN, the job needs the id-token: write permission
+  gitlab     read from the id_tokens entry named by --ci-token-env, VAULT_ID_TOKEN or CI_JOB_JWT_V2
+  buildkite  requested from the agent API with BUILDKITE_AGENT_ACCESS_TOKEN and BUILDKITE_JOB_ID
+The provider is detected from the environment unless --ci-provider is given.`
+}
+
+// AddFlags registers the flags of the jwt subcommand
+func (a *JWTAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultJWTAuthMount, "a", DefaultVaultJWTAuthMount, "vault jwt authentication mountpoint, ex: /jwt")
+	flags.StringVarP(&a.Role, FlagVaultJWTRole, "r", "", "role in vault's jwt authentication backend, defaults to VAULT_JWT_ROLE")
+	flags.StringVar(&a.CIProvider, FlagCIProvider, ciProviderAuto, "CI provider issuing the OIDC token: github, gitlab, buildkite or auto")
+	flags.StringVar(&a.CIAudience, FlagCIAudience, "", "audience of the requested OIDC token, must match the bound_audiences of the vault role")
+	flags.StringVar(&a.CITokenEnv, FlagCITokenEnv, "", "environment variable holding the GitLab id_token")
+	return nil
+}
+
+// LoadEnv fills an unset role from VAULT_JWT_ROLE
+func (a *JWTAuth) LoadEnv() error {
+	if a.Role == "" {
+		a.Role = os.Getenv("VAULT_JWT_ROLE")
+	}
+	return nil
+}
+
+// Validate checks the mount point and role and resolves the CI provider
+func (a *JWTAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultJWTAuthMount
+	}
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-jwt-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /jwt: %s", a.Mount)
+	}
+	if a.Role == "" {
+		return fmt.Errorf("vault-jwt-role or VAULT_JWT_ROLE must be set")
+	}
+	if a.Source != nil {
+		return nil
+	}
+	provider := a.CIProvider
+	if provider == "" || provider == ciProviderAuto {
+		if provider = DetectCIProvider(); provider == "" {
+			return fmt.Errorf("no CI provider detected in the environment, set %s", FlagCIProvider)
+		}
+	}
+	source, err := NewCITokenSource(provider, a.CIAudience, a.CITokenEnv, nil)
+	if err != nil {
+		return err
+	}
+	a.Source = source
+	return nil
+}
+
+// Login authenticates to Vault with the JWT supplied by Source
+func (a *JWTAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	jwt, err := a.Source.Token(ctx)
+	if err != nil {
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithJWT() cannot obtain jwt: %w", err))
+	}
+	return authToVaultWithJWT(ctx, client, a.Role, a.Mount, jwt)
+}
+
+// PlanLogin describes the jwt login for dry runs
+func (a *JWTAuth) PlanLogin() LoginPlan {
+	input := "jwt of CI provider " + a.CIProvider
+	if a.Source != nil {
+		input = a.Source.String()
+	}
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    input,
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "login")},
+	}
+}
+
+// authToVaultWithJWT authenticates to Vault using jwt authentication and exchanging jwt for a vault token.
+// Upon successful authentication it popules the client with the recevied vault token and returns the token's ttl.
+func authToVaultWithJWT(ctx context.Context, client VaultAPI, role string, mountPath string, jwt string) (time.Duration, error) {
+	resp, err := client.Write(ctx, vaultPath("auth", mountPath, "login"), map[string]any{
+		"jwt":  jwt,
+		"role": role,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithJWT() JwtLogin: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithJWT()")
+}
@@ -0,0 +1,119 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SourceKubernetes is the name of the credential source backed by Vault's kubernetes secret engine
+const SourceKubernetes = "kubernetes"
+
+func init() {
+	RegisterCredentialSource(SourceKubernetes, func(config ClusterConfig) CredentialSource {
+		return &KubernetesSecretsSource{Mount: config.Mount, Role: config.Role, Namespace: config.Namespace}
+	})
+}
+
+// KubernetesSecretsSource requests service account tokens from Vault's kubernetes secret engine.
+// Mount and Role may be Go templates over TemplateData, ex. /k8s-creds/{{.Environment}}/{{.ClusterName}}
+type KubernetesSecretsSource struct {
+	// Mount is the secret engine mount point, defaults to Options.SecretsMount or else /kubernetes/<clusterName>
+	Mount string
+	// Role is the role in the secret engine, defaults to Options.SecretRole
+	Role string
+	// Namespace is the kubernetes namespace the token is requested for, defaults to Options.KubernetesNamespace
+	Namespace string
+}
+
+// Name returns the name of the kubernetes secret engine source
+func (s *KubernetesSecretsSource) Name() string {
+	return SourceKubernetes
+}
+
+func (s *KubernetesSecretsSource) setDefaults(opts Options) {
+	if s.Mount == "" {
+		s.Mount = opts.SecretsMount
+	}
+	if s.Role == "" {
+		s.Role = opts.SecretRole
+	}
+	if s.Namespace == "" {
+		s.Namespace = opts.KubernetesNamespace
+	}
+}
+
+// Validate checks the mount point, when set, is an absolute path. Templates are checked once rendered, see render
+func (s *KubernetesSecretsSource) Validate() error {
+	if s.Mount != "" && !strings.Contains(s.Mount, "{{") && !isAbsolutePath(s.Mount) {
+		return fmt.Errorf("kubernetes secret engine mount must be an absolute path, ex. /kubernetes/dev: %s", s.Mount)
+	}
+	if s.Role == "" {
+		return fmt.Errorf("kubernetes secret engine role is unset")
+	}
+	return nil
+}
+
+// Credential requests a service account token for clusterName
+func (s *KubernetesSecretsSource) Credential(ctx context.Context, client VaultAPI, clusterName string) (*Credential, error) {
+	return generateK8sToken(ctx, client, s.mount(clusterName), s.Role, s.Namespace, clusterName)
+}
+
+// PlanCredential describes the request for a service account token of clusterName for dry runs
+func (s *KubernetesSecretsSource) PlanCredential(clusterName string) CredentialPlan {
+	return CredentialPlan{
+		Mount:     s.mount(clusterName),
+		Role:      s.Role,
+		Namespace: s.Namespace,
+		Requests:  []string{"POST " + vaultPath(s.mount(clusterName), "creds", s.Role)},
+	}
+}
+
+// render returns a copy of the source with its mount point and role rendered for data
+func (s *KubernetesSecretsSource) render(data TemplateData) (CredentialSource, error) {
+	rendered := *s
+	var err error
+	if rendered.Mount, err = renderTemplate("secrets mount", s.Mount, data); err != nil {
+		return nil, err
+	}
+	if rendered.Mount != "" && !isAbsolutePath(rendered.Mount) {
+		return nil, fmt.Errorf("secrets mount must render to an absolute path, ex. /kubernetes/dev: %q renders to %q", s.Mount, rendered.Mount)
+	}
+	if rendered.Role, err = renderTemplate("secret role", s.Role, data); err != nil {
+		return nil, err
+	}
+	return &rendered, nil
+}
+
+// mount returns the secret engine mount point of clusterName
+func (s *KubernetesSecretsSource) mount(clusterName string) string {
+	if s.Mount == "" {
+		return "/kubernetes/" + clusterName
+	}
+	return s.Mount
+}
+
+// generateK8sToken returns a kubernetes bearer token that it obtained from Vault's kubernetes secret engine mounted under mount
+func generateK8sToken(ctx context.Context, client VaultAPI, mount string, roleName string, namespace string, clusterName string) (*Credential, error) {
+	resp, err := client.Write(ctx, vaultPath(mount, "creds", roleName), map[string]any{
+		"kubernetes_namespace": namespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generateK8sToken() KubernetesGenerateCredentials: cluster=%s, role=%s, error=%w", clusterName, roleName, err)
+	}
+	token, ok := resp.Data["service_account_token"].(string)
+	if !ok || token == "" {
+		return nil, fmt.Errorf("generateK8sToken() KubernetesGenerateCredentials: cluster=%s, role=%s, error=response does not contain a service_account_token", clusterName, roleName)
+	}
+	serviceAccountName, _ := resp.Data["service_account_name"].(string)
+	serviceAccountNamespace, _ := resp.Data["service_account_namespace"].(string)
+	return &Credential{
+		ClusterName:             clusterName,
+		Token:                   token,
+		LeaseID:                 resp.LeaseID,
+		LeaseDuration:           time.Duration(resp.LeaseDuration) * time.Second,
+		ServiceAccountName:      serviceAccountName,
+		ServiceAccountNamespace: serviceAccountNamespace,
+	}, nil
+}
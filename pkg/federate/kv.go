@@ -0,0 +1,89 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SourceKV is the name of the credential source reading static bearer tokens from a KV v2 secret engine
+const SourceKV = "kv"
+
+// defaults locating static tokens in a KV v2 secret engine
+const (
+	defaultKVMount = "/secret"
+	defaultKVField = "token"
+)
+
+func init() {
+	RegisterCredentialSource(SourceKV, func(config ClusterConfig) CredentialSource {
+		return &KVSource{Mount: config.Mount, Path: config.Path, Field: config.Field}
+	})
+}
+
+// KVSource reads a static bearer token from a KV v2 secret engine, for legacy clusters
+// where Vault cannot issue tokens. The token is reported to expire after Options.TokenDuration
+type KVSource struct {
+	// Mount is the KV v2 mount point, defaults to /secret
+	Mount string
+	// Path is the secret's path within the mount, defaults to kubernetes/<clusterName>
+	Path string
+	// Field is the secret's field holding the token, defaults to token
+	Field string
+}
+
+// Name returns the name of the KV v2 source
+func (s *KVSource) Name() string {
+	return SourceKV
+}
+
+// Validate applies defaults and checks the mount point is an absolute path
+func (s *KVSource) Validate() error {
+	if s.Mount == "" {
+		s.Mount = defaultKVMount
+	}
+	if s.Field == "" {
+		s.Field = defaultKVField
+	}
+	if !isAbsolutePath(s.Mount) {
+		return fmt.Errorf("kv mount must be an absolute path, ex. /secret: %s", s.Mount)
+	}
+	if strings.Contains(s.Path, "..") {
+		return fmt.Errorf("kv path must not contain relative elements: %s", s.Path)
+	}
+	return nil
+}
+
+// Credential reads the static token of clusterName
+func (s *KVSource) Credential(ctx context.Context, client VaultAPI, clusterName string) (*Credential, error) {
+	path := s.path(clusterName)
+	resp, err := client.Read(ctx, vaultPath(s.Mount, "data", path))
+	if err != nil {
+		return nil, fmt.Errorf("KVSource.Credential() KvV2Read: cluster=%s, path=%s/%s, error=%w", clusterName, s.Mount, path, err)
+	}
+	data, _ := resp.Data["data"].(map[string]any)
+	token, ok := data[s.Field].(string)
+	if !ok || token == "" {
+		return nil, fmt.Errorf("KVSource.Credential() KvV2Read: cluster=%s, path=%s/%s, error=field %s does not hold a token", clusterName, s.Mount, path, s.Field)
+	}
+	return &Credential{
+		ClusterName: clusterName,
+		Token:       token,
+	}, nil
+}
+
+// PlanCredential describes the read of the static token of clusterName for dry runs
+func (s *KVSource) PlanCredential(clusterName string) CredentialPlan {
+	return CredentialPlan{
+		Mount:    s.Mount,
+		Requests: []string{"GET " + vaultPath(s.Mount, "data", s.path(clusterName)) + " (field " + s.Field + ")"},
+	}
+}
+
+// path returns the secret's path of clusterName within the mount
+func (s *KVSource) path(clusterName string) string {
+	if s.Path == "" {
+		return "kubernetes/" + clusterName
+	}
+	return s.Path
+}
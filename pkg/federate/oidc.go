@@ -0,0 +1,76 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SourceOIDC is the name of the credential source backed by Vault's identity OIDC tokens
+const SourceOIDC = "oidc"
+
+func init() {
+	RegisterCredentialSource(SourceOIDC, func(config ClusterConfig) CredentialSource {
+		return &OIDCSource{Role: config.Role}
+	})
+}
+
+// OIDCSource requests identity tokens from Vault's identity/oidc/token/<role> endpoint,
+// for API servers configured to trust Vault as an OIDC issuer
+type OIDCSource struct {
+	// Role is the Vault OIDC role, defaults to the cluster name
+	Role string
+}
+
+// Name returns the name of the identity OIDC source
+func (s *OIDCSource) Name() string {
+	return SourceOIDC
+}
+
+// Validate checks the role, when set, is a valid path element
+func (s *OIDCSource) Validate() error {
+	if s.Role != "" && !isValidHostname(s.Role) {
+		return fmt.Errorf("oidc role must be a valid path element: %s", s.Role)
+	}
+	return nil
+}
+
+// Credential requests an identity token for clusterName. The token expires with its ttl
+func (s *OIDCSource) Credential(ctx context.Context, client VaultAPI, clusterName string) (*Credential, error) {
+	role := s.role(clusterName)
+	resp, err := client.Read(ctx, vaultPath("identity/oidc/token", role))
+	if err != nil {
+		return nil, fmt.Errorf("OIDCSource.Credential() OidcGenerateToken: cluster=%s, role=%s, error=%w", clusterName, role, err)
+	}
+	token, ok := resp.Data["token"].(string)
+	if !ok || token == "" {
+		return nil, fmt.Errorf("OIDCSource.Credential() OidcGenerateToken: cluster=%s, role=%s, error=response does not contain a token", clusterName, role)
+	}
+	ttl, err := durationField(resp.Data, "ttl")
+	if err != nil {
+		return nil, fmt.Errorf("OIDCSource.Credential() OidcGenerateToken: cluster=%s, role=%s, error=%s", clusterName, role, err)
+	}
+	return &Credential{
+		ClusterName:         clusterName,
+		Token:               token,
+		LeaseDuration:       ttl,
+		ExpirationTimestamp: time.Now().Add(ttl),
+	}, nil
+}
+
+// PlanCredential describes the request for an identity token of clusterName for dry runs
+func (s *OIDCSource) PlanCredential(clusterName string) CredentialPlan {
+	return CredentialPlan{
+		Mount:    "identity/oidc",
+		Role:     s.role(clusterName),
+		Requests: []string{"GET " + vaultPath("identity/oidc/token", s.role(clusterName))},
+	}
+}
+
+// role returns the OIDC role of clusterName
+func (s *OIDCSource) role(clusterName string) string {
+	if s.Role == "" {
+		return clusterName
+	}
+	return s.Role
+}
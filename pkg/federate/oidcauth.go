@@ -0,0 +1,227 @@
+package federate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	vaultcg "github.com/hashicorp/vault-client-go"
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodOIDC is the name of the interactive oidc authentication method
+const AuthMethodOIDC = "oidc"
+
+// const to define cobra command flag names that supply mount point path and role for Vault's oidc authentication
+const (
+	FlagVaultOIDCAuthMount    = "vault-oidc-auth-mount"
+	FlagVaultOIDCRole         = "vault-oidc-role"
+	DefaultVaultOIDCAuthMount = "/oidc"
+)
+
+// const to define cobra command flag names that supply the address of the local callback listener and the time left to the user to log in
+const (
+	FlagOIDCListenAddress = "oidc-listen-address"
+	FlagOIDCTimeout       = "oidc-timeout"
+)
+
+// defaultOIDCListenAddress is the callback listener of the vault CLI, it is the redirect URI OIDC roles usually allow
+const defaultOIDCListenAddress = "localhost:8250"
+
+// defaultOIDCTimeout is the time left to the user to complete the login in the browser
+const defaultOIDCTimeout = 2 * time.Minute
+
+// oidcCallbackPath is the path of the redirect URI
+const oidcCallbackPath = "/oidc/callback"
+
+// openBrowser opens url in the user's browser, tests replace it
+var openBrowser = func(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+func init() {
+	RegisterAuthenticator(AuthMethodOIDC, func() Authenticator { return &OIDCAuth{} })
+}
+
+// OIDCAuth authenticates to Vault's oidc authentication backend interactively: the user logs in to the OIDC
+// provider in a browser, which redirects to a local listener with an authorization code exchanged for a vault token
+type OIDCAuth struct {
+	// Mount is Vault's oidc authentication mount point, defaults to /oidc
+	Mount string
+	// Role is the role in Vault's oidc authentication backend, the backend's default role when unset
+	Role string
+	// ListenAddress is the address of the local callback listener, defaults to localhost:8250
+	ListenAddress string
+	// Timeout is the time left to the user to complete the login, defaults to 2 minutes
+	Timeout time.Duration
+	// Prompt receives the login URL, defaults to STDERR
+	Prompt io.Writer
+}
+
+// Name returns the name of the oidc authentication method
+func (a *OIDCAuth) Name() string {
+	return AuthMethodOIDC
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *OIDCAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
+
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *OIDCAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// interactive marks the oidc method as requiring a user at the terminal
+func (a *OIDCAuth) interactive() {}
+
+// Usage returns the description of the oidc subcommand
+func (a *OIDCAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using interactive oidc authentication in a browser",
+		`Authenticates to Hashicorp Vault using oidc authentication, as vault login -method=oidc does.
+It opens the OIDC provider's login page in a browser and waits for the provider to redirect to
+http://<oidc-listen-address>/oidc/callback, which must be an allowed_redirect_uris of the role.
+It needs a user at the terminal, kubectl provides one when the ExecCredential is interactive.`
+}
+
+// AddFlags registers the flags of the oidc subcommand
+func (a *OIDCAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVar(&a.Mount, FlagVaultOIDCAuthMount, DefaultVaultOIDCAuthMount, "vault oidc authentication mountpoint, ex: /oidc")
+	flags.StringVar(&a.Role, FlagVaultOIDCRole, "", "role in vault's oidc authentication backend, defaults to the backend's default_role")
+	flags.StringVar(&a.ListenAddress, FlagOIDCListenAddress, defaultOIDCListenAddress, "address of the local listener receiving the OIDC provider's redirect")
+	flags.DurationVar(&a.Timeout, FlagOIDCTimeout, defaultOIDCTimeout, "time left to complete the login in the browser")
+	return nil
+}
+
+// Validate checks the mount point and applies defaults
+func (a *OIDCAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultOIDCAuthMount
+	}
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("%s must be an absolute path, ex. /oidc: %s", FlagVaultOIDCAuthMount, a.Mount)
+	}
+	if a.ListenAddress == "" {
+		a.ListenAddress = defaultOIDCListenAddress
+	}
+	if _, _, err := net.SplitHostPort(a.ListenAddress); err != nil {
+		return fmt.Errorf("%s must be a host:port, ex. localhost:8250: %s", FlagOIDCListenAddress, a.ListenAddress)
+	}
+	if a.Timeout <= 0 {
+		a.Timeout = defaultOIDCTimeout
+	}
+	return nil
+}
+
+// Login requests the provider's login URL, opens it in a browser and exchanges the authorization code
+// received by the local listener for a vault token
+func (a *OIDCAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	listener, err := net.Listen("tcp", a.ListenAddress)
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithOIDC() cannot listen for the redirect: %w", err)
+	}
+	defer listener.Close()
+	redirectURI := "http://" + a.ListenAddress + oidcCallbackPath
+
+	nonce, err := randomNonce()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Write(ctx, vaultPath("auth", a.Mount, "oidc/auth_url"), map[string]any{
+		"role":         a.Role,
+		"redirect_uri": redirectURI,
+		"client_nonce": nonce,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithOIDC() OidcRequestAuthorizationUrl: %w", err)
+	}
+	authURL, _ := resp.Data["auth_url"].(string)
+	if authURL == "" {
+		return 0, fmt.Errorf("authToVaultWithOIDC() OidcRequestAuthorizationUrl: no auth_url returned, %s is likely not an allowed redirect uri of the role", redirectURI)
+	}
+
+	callback := make(chan url.Values, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != oidcCallbackPath {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "Vault login complete, you can close this window and return to kubectl.")
+		select {
+		case callback <- r.URL.Query():
+		default:
+		}
+	}), ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	defer server.Close()
+
+	prompt := a.Prompt
+	if prompt == nil {
+		prompt = os.Stderr
+	}
+	fmt.Fprintf(prompt, "Complete the login with your OIDC provider, opening the browser to:\n\n    %s\n\n", authURL)
+	if err := openBrowser(authURL); err != nil {
+		fmt.Fprintf(prompt, "Cannot open the browser, open the URL above manually: %s\n", err)
+	}
+
+	var params url.Values
+	select {
+	case params = <-callback:
+	case <-time.After(a.Timeout):
+		return 0, fmt.Errorf("authToVaultWithOIDC() the login was not completed within %s", a.Timeout)
+	case <-ctx.Done():
+		return 0, fmt.Errorf("authToVaultWithOIDC() %w", ctx.Err())
+	}
+	if message := params.Get("error_description"); message != "" || params.Get("error") != "" {
+		return 0, fmt.Errorf("authToVaultWithOIDC() the OIDC provider refused the login: %s %s", params.Get("error"), message)
+	}
+
+	resp, err = client.Read(ctx, vaultPath("auth", a.Mount, "oidc/callback"), vaultcg.WithQueryParameters(url.Values{
+		"state":        {params.Get("state")},
+		"code":         {params.Get("code")},
+		"id_token":     {params.Get("id_token")},
+		"client_nonce": {nonce},
+	}))
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithOIDC() OidcCallback: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithOIDC()")
+}
+
+// PlanLogin describes the oidc login for dry runs
+func (a *OIDCAuth) PlanLogin() LoginPlan {
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    "interactive browser login, redirected to http://" + a.ListenAddress + oidcCallbackPath,
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "oidc/auth_url"), "GET " + vaultPath("auth", a.Mount, "oidc/callback")},
+	}
+}
+
+// randomNonce returns a random client nonce binding the authorization code to this login
+func randomNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("authToVaultWithOIDC() cannot generate a nonce: %w", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
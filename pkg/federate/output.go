@@ -0,0 +1,134 @@
+package federate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// const to define cobra command flag names that select how the issued credential is written
+const (
+	FlagOutput   = "output"
+	FlagForceTTY = "force-tty"
+)
+
+// Output formats of the issued credential
+const (
+	// OutputExecCredential is the ExecCredential JSON kubectl expects from credential plugins
+	OutputExecCredential = "exec-credential"
+	// OutputToken is the raw kubernetes bearer token
+	OutputToken = "token"
+	// OutputEnv are shell export lines
+	OutputEnv = "env"
+	// OutputKubeconfig is a self-contained kubeconfig of the cluster of the ExecCredential
+	OutputKubeconfig = "kubeconfig"
+	// OutputJSON is the token along with its metadata
+	OutputJSON = "json"
+)
+
+// OutputFormats returns the supported output formats, the default first
+func OutputFormats() []string {
+	return []string{OutputExecCredential, OutputToken, OutputEnv, OutputKubeconfig, OutputJSON}
+}
+
+// PrintCredential writes credential to w in format. execCredential is the one received from kubectl, the
+// exec-credential format writes it with its status set and the kubeconfig format takes the API server from it
+func PrintCredential(w io.Writer, format string, execCredential *clientauthentication.ExecCredential, credential *Credential) error {
+	switch format {
+	case OutputExecCredential:
+		execCredential.Status = credential.ExecCredentialStatus()
+		return PrintExecCredential(w, execCredential)
+	case OutputToken:
+		return writeOutput(w, credential.Token+"\n")
+	case OutputEnv:
+		return writeOutput(w, credential.env())
+	case OutputKubeconfig:
+		content, err := credential.kubeconfig(execCredential)
+		if err != nil {
+			return err
+		}
+		return writeOutput(w, string(content))
+	case OutputJSON:
+		data, err := json.Marshal(credential.metadata())
+		if err != nil {
+			return fmt.Errorf("PrintCredential() cannot marshal the credential to JSON: %w", err)
+		}
+		return writeOutput(w, string(data)+"\n")
+	default:
+		return fmt.Errorf("PrintCredential() unknown output format %s, expected one of %s", format, strings.Join(OutputFormats(), ", "))
+	}
+}
+
+// writeOutput writes content to w
+func writeOutput(w io.Writer, content string) error {
+	if _, err := io.WriteString(w, content); err != nil {
+		return fmt.Errorf("PrintCredential() could not write to %T: %w", w, err)
+	}
+	return nil
+}
+
+// credentialMetadata is the json output format of a Credential
+type credentialMetadata struct {
+	ClusterName             string    `json:"clusterName"`
+	Token                   string    `json:"token"`
+	ExpirationTimestamp     time.Time `json:"expirationTimestamp"`
+	LeaseID                 string    `json:"leaseID,omitempty"`
+	LeaseDurationSeconds    int64     `json:"leaseDurationSeconds,omitempty"`
+	ServiceAccountName      string    `json:"serviceAccountName,omitempty"`
+	ServiceAccountNamespace string    `json:"serviceAccountNamespace,omitempty"`
+}
+
+// metadata returns the json output format of the credential
+func (c *Credential) metadata() credentialMetadata {
+	return credentialMetadata{
+		ClusterName:             c.ClusterName,
+		Token:                   c.Token,
+		ExpirationTimestamp:     c.ExpirationTimestamp.UTC(),
+		LeaseID:                 c.LeaseID,
+		LeaseDurationSeconds:    int64(c.LeaseDuration / time.Second),
+		ServiceAccountName:      c.ServiceAccountName,
+		ServiceAccountNamespace: c.ServiceAccountNamespace,
+	}
+}
+
+// env returns shell export lines of the credential, values are single quoted
+func (c *Credential) env() string {
+	quote := func(value string) string {
+		return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "export KVL_CLUSTER_NAME=%s\n", quote(c.ClusterName))
+	fmt.Fprintf(&b, "export KVL_TOKEN=%s\n", quote(c.Token))
+	fmt.Fprintf(&b, "export KVL_TOKEN_EXPIRATION=%s\n", quote(c.ExpirationTimestamp.UTC().Format(time.RFC3339)))
+	return b.String()
+}
+
+// kubeconfig returns a kubeconfig holding the credential's token and the API server of execCredential
+func (c *Credential) kubeconfig(execCredential *clientauthentication.ExecCredential) ([]byte, error) {
+	cluster := execCredential.Spec.Cluster
+	if cluster == nil || cluster.Server == "" {
+		return nil, fmt.Errorf("kubeconfig() the kubeconfig output needs the API server of the ExecCredential, set provideClusterInfo: true in the exec section of the kubeconfig")
+	}
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[c.ClusterName] = &clientcmdapi.Cluster{
+		Server:                   cluster.Server,
+		TLSServerName:            cluster.TLSServerName,
+		InsecureSkipTLSVerify:    cluster.InsecureSkipTLSVerify,
+		CertificateAuthorityData: cluster.CertificateAuthorityData,
+		ProxyURL:                 cluster.ProxyURL,
+	}
+	kubeconfig.AuthInfos[c.ClusterName] = &clientcmdapi.AuthInfo{Token: c.Token}
+	kubeconfig.Contexts[c.ClusterName] = &clientcmdapi.Context{Cluster: c.ClusterName, AuthInfo: c.ClusterName}
+	kubeconfig.CurrentContext = c.ClusterName
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig() %w", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,86 @@
+package federate
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// testCredential is a credential of the kubernetes secret engine for the cluster dev
+func testCredential() *Credential {
+	return &Credential{
+		ClusterName:             "dev",
+		Token:                   "dev-token",
+		LeaseID:                 "kubernetes/dev/creds/kvl-edit-role/1",
+		LeaseDuration:           10 * time.Minute,
+		ServiceAccountName:      "v-kvl-edit-role-1",
+		ServiceAccountNamespace: "default",
+		ExpirationTimestamp:     time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// tests the credential is written in each output format
+func TestPrintCredential(t *testing.T) {
+	execCredential := mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://dev.example.com","certificate-authority-data":"ZGV2LWNh","config":null},"interactive":false}}`)
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{OutputToken, "dev-token\n"},
+		{OutputEnv, "export KVL_CLUSTER_NAME='dev'\nexport KVL_TOKEN='dev-token'\nexport KVL_TOKEN_EXPIRATION='2026-10-19T12:00:00Z'\n"},
+		{OutputJSON, `{"clusterName":"dev","token":"dev-token","expirationTimestamp":"2026-10-19T12:00:00Z","leaseID":"kubernetes/dev/creds/kvl-edit-role/1","leaseDurationSeconds":600,"serviceAccountName":"v-kvl-edit-role-1","serviceAccountNamespace":"default"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, PrintCredential(&out, tt.format, execCredential, testCredential()))
+			assert.Equal(t, tt.expected, out.String())
+		})
+	}
+
+	t.Run(OutputExecCredential, func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, PrintCredential(&out, OutputExecCredential, execCredential, testCredential()))
+		var printed clientauthentication.ExecCredential
+		require.NoError(t, json.Unmarshal(out.Bytes(), &printed))
+		assert.Equal(t, "dev-token", printed.Status.Token)
+		assert.Equal(t, "https://dev.example.com", printed.Spec.Cluster.Server)
+	})
+
+	t.Run(OutputKubeconfig, func(t *testing.T) {
+		var out bytes.Buffer
+		require.NoError(t, PrintCredential(&out, OutputKubeconfig, execCredential, testCredential()))
+		kubeconfig, err := clientcmd.Load(out.Bytes())
+		require.NoError(t, err)
+		assert.Equal(t, "dev", kubeconfig.CurrentContext)
+		assert.Equal(t, "https://dev.example.com", kubeconfig.Clusters["dev"].Server)
+		assert.Equal(t, []byte("dev-ca"), kubeconfig.Clusters["dev"].CertificateAuthorityData)
+		assert.Equal(t, "dev-token", kubeconfig.AuthInfos["dev"].Token)
+	})
+}
+
+// tests values are quoted for the shell
+func TestPrintCredentialEnvQuotes(t *testing.T) {
+	credential := testCredential()
+	credential.Token = "it's"
+	var out bytes.Buffer
+	require.NoError(t, PrintCredential(&out, OutputEnv, &clientauthentication.ExecCredential{}, credential))
+	assert.Contains(t, out.String(), `export KVL_TOKEN='it'\''s'`+"\n")
+}
+
+// tests the kubeconfig format requires the API server and unknown formats are rejected
+func TestPrintCredentialErrors(t *testing.T) {
+	var out bytes.Buffer
+	err := PrintCredential(&out, OutputKubeconfig, &clientauthentication.ExecCredential{}, testCredential())
+	assert.ErrorContains(t, err, "set provideClusterInfo: true")
+	err = PrintCredential(&out, "yaml", &clientauthentication.ExecCredential{}, testCredential())
+	assert.ErrorContains(t, err, "unknown output format yaml, expected one of exec-credential, token, env, kubeconfig, json")
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,132 @@
+package federate
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// const to define cobra command flag name that turns on dry run mode
+const FlagDryRun = "dry-run"
+
+// Plan describes what a federation would do. It is resolved without contacting Vault and carries no credential
+type Plan struct {
+	VaultAddress string
+	// AuthNamespace and SecretsNamespace are the Vault namespaces of the login and of credential requests
+	AuthNamespace    string
+	SecretsNamespace string
+	AuthMethod       string
+	Login            LoginPlan
+	ClusterName      string
+	// ClusterNameRule explains where ClusterName was taken from
+	ClusterNameRule string
+	Source          string
+	Credential      CredentialPlan
+	// TokenDuration and ExpirationTimestamp are the expiration reported to kubectl
+	TokenDuration       time.Duration
+	ExpirationTimestamp time.Time
+}
+
+// LoginPlan describes a Vault login
+type LoginPlan struct {
+	Mount string
+	Role  string
+	// Input describes the identity credential presented to Vault, never the credential itself
+	Input string
+	// Requests are the Vault API requests of the login, as method and path
+	Requests []string
+	// PostLoginRequests are the Vault API requests made with the issued token, ex. to rotate a SecretID. Unlike
+	// the login they need the token's policy to allow them
+	PostLoginRequests []string
+}
+
+// CredentialPlan describes a request for a kubernetes bearer token
+type CredentialPlan struct {
+	Mount     string
+	Role      string
+	Namespace string
+	// Requests are the Vault API requests issuing the token, as method and path
+	Requests []string
+}
+
+// LoginPlanner is implemented by authenticators that can describe their login for dry runs
+type LoginPlanner interface {
+	PlanLogin() LoginPlan
+}
+
+// CredentialPlanner is implemented by credential sources that can describe their requests for dry runs
+type CredentialPlanner interface {
+	PlanCredential(clusterName string) CredentialPlan
+}
+
+// Plan resolves the configuration a Federate call with execCredential would use, without contacting Vault
+func (f *Federator) Plan(execCredential *clientauthentication.ExecCredential) (*Plan, error) {
+	clusterName, rule, err := f.clusterName(execCredential)
+	if err != nil {
+		return nil, err
+	}
+	data := f.opts.templateData(clusterName, clusterServer(execCredential))
+	source, err := f.opts.renderedSource(data)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{
+		VaultAddress:        f.opts.VaultAddress,
+		AuthNamespace:       f.opts.authNamespace(),
+		SecretsNamespace:    f.opts.secretsNamespace(),
+		AuthMethod:          f.opts.Authenticator.Name(),
+		ClusterName:         clusterName,
+		ClusterNameRule:     rule,
+		Source:              source.Name(),
+		TokenDuration:       f.opts.TokenDuration,
+		ExpirationTimestamp: time.Now().Add(f.opts.TokenDuration),
+	}
+	authenticator, err := f.authenticator(data)
+	if err != nil {
+		return nil, err
+	}
+	if planner, ok := authenticator.(LoginPlanner); ok {
+		plan.Login = planner.PlanLogin()
+	}
+	if planner, ok := source.(CredentialPlanner); ok {
+		plan.Credential = planner.PlanCredential(clusterName)
+	}
+	return plan, nil
+}
+
+// Print writes the plan in a human readable form to w
+func (p *Plan) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "kubectl-vaultlogin dry run, Vault is not contacted and no credential is issued")
+	lines := [][2]string{
+		{"vault address", p.VaultAddress},
+		{"auth namespace", p.AuthNamespace},
+		{"auth method", p.AuthMethod},
+		{"auth mount", p.Login.Mount},
+		{"login role", p.Login.Role},
+		{"login input", p.Login.Input},
+		{"cluster name", fmt.Sprintf("%s (%s)", p.ClusterName, p.ClusterNameRule)},
+		{"credential source", p.Source},
+		{"secrets namespace", p.SecretsNamespace},
+		{"secrets mount", p.Credential.Mount},
+		{"secret role", p.Credential.Role},
+		{"namespace", p.Credential.Namespace},
+		{"expiration", fmt.Sprintf("%s (token duration %s)", p.ExpirationTimestamp.UTC().Format(time.RFC3339), p.TokenDuration)},
+	}
+	for _, line := range lines {
+		if line[1] != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", line[0], line[1])
+		}
+	}
+	fmt.Fprintln(tw, "vault requests:")
+	for _, request := range slices.Concat(p.Login.Requests, p.Login.PostLoginRequests, p.Credential.Requests) {
+		method, path, _ := strings.Cut(request, " ")
+		fmt.Fprintf(tw, "  %s %s/v1/%s\n", method, p.VaultAddress, path)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,81 @@
+package federate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests a plan resolves the cluster name from the ExecCredential and lists the Vault requests without contacting Vault
+func TestPlanKubernetesAuth(t *testing.T) {
+	vault, opts := mockVault(t)
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("psat-jwt"), 0600))
+	opts.Authenticator = &KubernetesAuth{Mount: "/kubernetes/argocd", TokenPath: tokenPath}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	plan, err := federator.Plan(mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://prod.example.com","config":null},"interactive":false}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "prod", plan.ClusterName)
+	assert.Contains(t, plan.ClusterNameRule, "ExecCredential.Spec.Cluster.Server https://prod.example.com")
+	assert.Equal(t, LoginPlan{
+		Mount:    "/kubernetes/argocd",
+		Role:     defaultVaultKubernetesLoginRole,
+		Input:    "psat read from " + tokenPath,
+		Requests: []string{"POST auth/kubernetes/argocd/login"},
+	}, plan.Login)
+	assert.Equal(t, CredentialPlan{
+		Mount:     "/kubernetes/prod",
+		Role:      defaultVaultK8sSecretRole,
+		Namespace: defaultK8sNamespace,
+		Requests:  []string{"POST kubernetes/prod/creds/kvl-edit-role"},
+	}, plan.Credential)
+	assert.WithinDuration(t, time.Now().Add(minTokenDuration), plan.ExpirationTimestamp, time.Minute)
+	assert.Empty(t, vault.Requests())
+
+	var buf bytes.Buffer
+	require.NoError(t, plan.Print(&buf))
+	assert.Contains(t, buf.String(), "POST "+vault.URL+"/v1/auth/kubernetes/argocd/login")
+	assert.Contains(t, buf.String(), "POST "+vault.URL+"/v1/kubernetes/prod/creds/kvl-edit-role")
+	assert.NotContains(t, buf.String(), "psat-jwt")
+}
+
+// tests a plan falls back to the cluster-name flag and describes the cluster's credential source
+func TestPlanClusterNameFlag(t *testing.T) {
+	_, opts := mockVault(t)
+	opts.Sources = map[string]CredentialSource{"dev": &KVSource{Path: "clusters/dev"}}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	plan, err := federator.Plan(mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "dev", plan.ClusterName)
+	assert.Contains(t, plan.ClusterNameRule, "cluster-name flag")
+	assert.Equal(t, SourceKV, plan.Source)
+	assert.Equal(t, []string{"POST auth/approle/login"}, plan.Login.Requests)
+	assert.Equal(t, []string{"GET secret/data/clusters/dev (field token)"}, plan.Credential.Requests)
+}
+
+// tests a plan prints the Vault namespaces of the login and of the credential request
+func TestPlanNamespaces(t *testing.T) {
+	_, opts := mockVault(t)
+	opts.Namespace, opts.SecretsNamespace = "platform", "team-a/dev/"
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	plan, err := federator.Plan(mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"interactive":false}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "platform", plan.AuthNamespace)
+	assert.Equal(t, "team-a/dev", plan.SecretsNamespace)
+
+	var buf bytes.Buffer
+	require.NoError(t, plan.Print(&buf))
+	assert.Regexp(t, `auth namespace: +platform\n`, buf.String())
+	assert.Regexp(t, `secrets namespace: +team-a/dev\n`, buf.String())
+}
@@ -0,0 +1,208 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PolicyRule grants capabilities on a Vault API path
+type PolicyRule struct {
+	Path         string
+	Capabilities []string
+	// Clusters are the downstream clusters whose credential requests need the rule
+	Clusters []string
+}
+
+// Policy is the least-privilege Vault policy allowing the credential requests of downstream clusters.
+// Its paths are relative to Namespace, the Vault namespace it is to be written in
+type Policy struct {
+	Namespace string
+	Rules     []PolicyRule
+}
+
+// requestCapabilities maps the methods of planned Vault requests to the policy capability they require
+var requestCapabilities = map[string]string{
+	"GET":    "read",
+	"LIST":   "list",
+	"POST":   "update",
+	"PUT":    "update",
+	"DELETE": "delete",
+}
+
+// Policy returns the Vault policy allowing the credential requests of clusterNames, as the Federator would make them,
+// along with the requests the authenticator makes with the issued token, ex. approle's SecretID rotation.
+// Sources that cannot describe their requests, see CredentialPlanner, fail. Logins and the renewal of the session's
+// token are not included, Vault's default policy allows the latter
+func (f *Federator) Policy(clusterNames []string) (*Policy, error) {
+	policy := &Policy{Namespace: f.opts.secretsNamespace()}
+	rules := map[string]*PolicyRule{}
+	addRules := func(clusterName string, namespace string, requests []string) error {
+		for _, request := range requests {
+			// requests are "METHOD path" optionally followed by a note, ex. GET secret/data/dev (field token)
+			fields := strings.Fields(request)
+			if len(fields) < 2 {
+				return fmt.Errorf("cluster %s: malformed vault request %q", clusterName, request)
+			}
+			capability, exists := requestCapabilities[fields[0]]
+			if !exists {
+				return fmt.Errorf("cluster %s: unsupported method of vault request %q", clusterName, request)
+			}
+			path, err := policyPath(policy.Namespace, namespace, fields[1])
+			if err != nil {
+				return fmt.Errorf("cluster %s: %s", clusterName, err)
+			}
+			rule, exists := rules[path]
+			if !exists {
+				rule = &PolicyRule{Path: path}
+				rules[path] = rule
+			}
+			if !slices.Contains(rule.Capabilities, capability) {
+				rule.Capabilities = append(rule.Capabilities, capability)
+				slices.Sort(rule.Capabilities)
+			}
+			if !slices.Contains(rule.Clusters, clusterName) {
+				rule.Clusters = append(rule.Clusters, clusterName)
+				slices.Sort(rule.Clusters)
+			}
+		}
+		return nil
+	}
+
+	for _, clusterName := range clusterNames {
+		if !isValidHostname(clusterName) {
+			return nil, fmt.Errorf("cluster-name must be a string that is a valid dns name: %s", clusterName)
+		}
+		data := f.opts.templateData(clusterName, "")
+		authenticator, err := f.authenticator(data)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %s", clusterName, err)
+		}
+		if planner, ok := authenticator.(LoginPlanner); ok {
+			if err := addRules(clusterName, f.opts.authNamespace(), planner.PlanLogin().PostLoginRequests); err != nil {
+				return nil, err
+			}
+		}
+		source, err := f.opts.renderedSource(data)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %s", clusterName, err)
+		}
+		planner, ok := source.(CredentialPlanner)
+		if !ok {
+			return nil, fmt.Errorf("cluster %s: the %s credential source cannot describe its vault requests", clusterName, source.Name())
+		}
+		if err := addRules(clusterName, policy.Namespace, planner.PlanCredential(clusterName).Requests); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rule := range rules {
+		policy.Rules = append(policy.Rules, *rule)
+	}
+	slices.SortFunc(policy.Rules, func(a, b PolicyRule) int { return strings.Compare(a.Path, b.Path) })
+	return policy, nil
+}
+
+// policyPath returns path of a request made in the Vault namespace relative to a policy written in policyNamespace.
+// A policy covers the paths of its own namespace and, prefixed with their relative namespace, those of its children
+func policyPath(policyNamespace string, namespace string, path string) (string, error) {
+	policyNamespace, namespace = strings.Trim(policyNamespace, "/"), strings.Trim(namespace, "/")
+	switch {
+	case namespace == policyNamespace:
+		return path, nil
+	case policyNamespace == "":
+		return namespace + "/" + path, nil
+	case strings.HasPrefix(namespace, policyNamespace+"/"):
+		return strings.TrimPrefix(namespace, policyNamespace+"/") + "/" + path, nil
+	}
+	return "", fmt.Errorf("vault request %s is made in the namespace %s, which a policy in the namespace %s cannot allow", path, namespace, policyNamespace)
+}
+
+// Clusters returns the downstream clusters needing a rule of the policy in sorted order
+func (p *Policy) Clusters() []string {
+	var clusters []string
+	for _, rule := range p.Rules {
+		for _, clusterName := range rule.Clusters {
+			if !slices.Contains(clusters, clusterName) {
+				clusters = append(clusters, clusterName)
+			}
+		}
+	}
+	slices.Sort(clusters)
+	return clusters
+}
+
+// HCL returns the policy in Vault's HCL syntax, each rule commented with the clusters needing it
+func (p *Policy) HCL() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "# generated by kubectl-vaultlogin policy generate")
+	if p.Namespace != "" {
+		fmt.Fprintf(&b, "# to be written in the Vault namespace %s\n", p.Namespace)
+	}
+	for _, rule := range p.Rules {
+		capabilities, _ := json.Marshal(rule.Capabilities)
+		fmt.Fprintf(&b, "\n# clusters: %s\n", strings.Join(rule.Clusters, ", "))
+		fmt.Fprintf(&b, "path %q {\n  capabilities = %s\n}\n", rule.Path, strings.ReplaceAll(string(capabilities), ",", ", "))
+	}
+	return b.String()
+}
+
+// JSON returns the policy in Vault's JSON syntax, which Vault accepts in place of HCL
+func (p *Policy) JSON() ([]byte, error) {
+	paths := map[string]any{}
+	for _, rule := range p.Rules {
+		paths[rule.Path] = map[string]any{"capabilities": rule.Capabilities}
+	}
+	return json.MarshalIndent(map[string]any{"path": paths}, "", "  ")
+}
+
+// PolicyCheck compares the capabilities a rule requires with those of a token on the rule's path
+type PolicyCheck struct {
+	Rule PolicyRule
+	// Granted are the capabilities of the token on the path
+	Granted []string
+	// Missing are the required capabilities the token lacks, Extra those it holds beyond the rule
+	Missing []string
+	Extra   []string
+}
+
+// Check asks Vault for the capabilities of the client's token on the paths of the policy, through sys/capabilities-self,
+// and compares them with the rules. The client must be authenticated in the policy's namespace
+func (p *Policy) Check(ctx context.Context, client VaultAPI) ([]PolicyCheck, error) {
+	if len(p.Rules) == 0 {
+		return nil, nil
+	}
+	paths := make([]string, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		paths = append(paths, rule.Path)
+	}
+	resp, err := client.Write(ctx, "sys/capabilities-self", map[string]any{"paths": paths})
+	if err != nil {
+		return nil, fmt.Errorf("Check() QueryTokenSelfCapabilities: %w", err)
+	}
+
+	checks := make([]PolicyCheck, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		check := PolicyCheck{Rule: rule}
+		granted, _ := resp.Data[rule.Path].([]any)
+		for _, capability := range granted {
+			check.Granted = append(check.Granted, fmt.Sprint(capability))
+		}
+		// root grants every capability, deny none
+		root := slices.Contains(check.Granted, "root")
+		for _, capability := range rule.Capabilities {
+			if !root && !slices.Contains(check.Granted, capability) {
+				check.Missing = append(check.Missing, capability)
+			}
+		}
+		for _, capability := range check.Granted {
+			if capability != "deny" && !slices.Contains(rule.Capabilities, capability) {
+				check.Extra = append(check.Extra, capability)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
@@ -0,0 +1,119 @@
+package federate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests the policy covers the credential requests of every cluster with its templates rendered
+func TestPolicy(t *testing.T) {
+	_, opts := mockVault(t)
+	opts.SecretsMount = "/k8s-creds/{{.Environment}}/{{.ClusterName}}"
+	opts.Environment = "live"
+	opts.Sources = map[string]CredentialSource{
+		"legacy": &KVSource{Path: "clusters/legacy"},
+		"eks":    &OIDCSource{Role: "eks-audience"},
+	}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	policy, err := federator.Policy([]string{"prod", "legacy", "eks", "dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []PolicyRule{
+		{Path: "identity/oidc/token/eks-audience", Capabilities: []string{"read"}, Clusters: []string{"eks"}},
+		{Path: "k8s-creds/live/dev/creds/kvl-edit-role", Capabilities: []string{"update"}, Clusters: []string{"dev"}},
+		{Path: "k8s-creds/live/prod/creds/kvl-edit-role", Capabilities: []string{"update"}, Clusters: []string{"prod"}},
+		{Path: "secret/data/clusters/legacy", Capabilities: []string{"read"}, Clusters: []string{"legacy"}},
+	}, policy.Rules)
+
+	assert.Contains(t, policy.HCL(), "# clusters: prod\npath \"k8s-creds/live/prod/creds/kvl-edit-role\" {\n  capabilities = [\"update\"]\n}\n")
+	assert.NotContains(t, policy.HCL(), "namespace")
+
+	encoded, err := policy.JSON()
+	require.NoError(t, err)
+	var decoded map[string]map[string]map[string][]string
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, []string{"read"}, decoded["path"]["secret/data/clusters/legacy"]["capabilities"])
+	assert.Len(t, decoded["path"], 4)
+}
+
+// tests clusters sharing a path share its rule, and the namespace of credential requests is noted
+func TestPolicySharedPath(t *testing.T) {
+	_, opts := mockVault(t)
+	opts.SecretsMount = "/kubernetes/shared"
+	opts.Namespace, opts.SecretsNamespace = "platform", "team-a"
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	policy, err := federator.Policy([]string{"prod", "dev"})
+	require.NoError(t, err)
+	assert.Equal(t, "team-a", policy.Namespace)
+	assert.Equal(t, []PolicyRule{{Path: "kubernetes/shared/creds/kvl-edit-role", Capabilities: []string{"update"}, Clusters: []string{"dev", "prod"}}}, policy.Rules)
+	assert.Contains(t, policy.HCL(), "# to be written in the Vault namespace team-a\n")
+	assert.Contains(t, policy.HCL(), "# clusters: dev, prod\n")
+
+	_, err = federator.Policy([]string{"not_a_cluster"})
+	assert.ErrorContains(t, err, "cluster-name must be a string that is a valid dns name")
+}
+
+// tests the policy allows the SecretID rotation of an approle login, relative to the policy's namespace
+func TestPolicyApproleRotation(t *testing.T) {
+	_, opts := mockVault(t)
+	opts.SecretsMount = "/kubernetes/shared"
+	opts.Authenticator = &ApproleAuth{Mount: "/ci", RoleID: "role-id", SecretIDFile: "secret-id", RotateSecretID: true}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	policy, err := federator.Policy([]string{"dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []PolicyRule{
+		{Path: "auth/ci/role/+/secret-id", Capabilities: []string{"update"}, Clusters: []string{"dev"}},
+		{Path: "auth/ci/role/+/secret-id-accessor/destroy", Capabilities: []string{"update"}, Clusters: []string{"dev"}},
+		{Path: "auth/ci/role/+/secret-id/lookup", Capabilities: []string{"update"}, Clusters: []string{"dev"}},
+		{Path: "kubernetes/shared/creds/kvl-edit-role", Capabilities: []string{"update"}, Clusters: []string{"dev"}},
+	}, policy.Rules)
+
+	opts.Authenticator = &ApproleAuth{Mount: "/ci", RoleName: "argocd", RoleID: "role-id", SecretIDFile: "secret-id", RotateSecretID: true}
+	opts.AuthNamespace, opts.SecretsNamespace = "platform/ci", "platform"
+	federator, err = NewFederator(opts)
+	require.NoError(t, err)
+	policy, err = federator.Policy([]string{"dev"})
+	require.NoError(t, err)
+	assert.Equal(t, "ci/auth/ci/role/argocd/secret-id", policy.Rules[0].Path)
+
+	opts.AuthNamespace = "team-a"
+	federator, err = NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Policy([]string{"dev"})
+	assert.ErrorContains(t, err, "is made in the namespace team-a, which a policy in the namespace platform cannot allow")
+}
+
+// tests a check reports the capabilities a token lacks and those it holds beyond the policy
+func TestPolicyCheck(t *testing.T) {
+	vault, opts := mockVault(t)
+	opts.Sources = map[string]CredentialSource{"legacy": &KVSource{Path: "clusters/legacy"}}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	policy, err := federator.Policy([]string{"prod", "dev", "legacy"})
+	require.NoError(t, err)
+
+	vault.SetCapabilities("kubernetes/prod/creds/kvl-edit-role", "update")
+	vault.SetCapabilities("secret/data/clusters/legacy", "create", "read", "update")
+	session, err := federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	checks, err := policy.Check(context.Background(), session.Client())
+	require.NoError(t, err)
+	require.Len(t, checks, 3)
+
+	assert.Equal(t, "kubernetes/dev/creds/kvl-edit-role", checks[0].Rule.Path)
+	assert.Equal(t, []string{"deny"}, checks[0].Granted)
+	assert.Equal(t, []string{"update"}, checks[0].Missing)
+	assert.Empty(t, checks[1].Missing)
+	assert.Empty(t, checks[1].Extra)
+	assert.Empty(t, checks[2].Missing)
+	assert.Equal(t, []string{"create", "update"}, checks[2].Extra)
+}
@@ -2,78 +2,265 @@ package federate
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
 )
 
+// AuthMethodPsat is the name of the kubernetes authentication method
+const AuthMethodPsat = "psat"
+
 // const to define cobra command flag name that supplies mount point path for Vault's kubernetes authentication
 const FlagVaultKubernetesAuthMount = "vault-kubernetes-auth-mount"
 
 // const to define cobra command flag name that supplies path to PSAT
 const FlagPsatPath = "psat-path"
 
-// FederateWithPsat() perfoms all actions resulting from the federate psat subcommand to request a new kubernetes bearer token
-// and responds with a corresponding ExecCredetnial written to STDOUT
-// ctx - is context created by cobra subcommand RunE function
-// args - are args passed from the cobra subcommand RunE function
-// test - indicates if this is a test run (true) or actual request (false). If true no communication with Vault is perfomed, instead a fake beaer token is created
-func FederateWithPsat(ctx *context.Context, args map[string]any, test bool) error {
-	// fmt.Printf("Path to PSAT: %s\nAuth Mount Point: %s\n", args[FlagPsatPath], args[FlagVaultKubernetesAuthMount])
+// const to define cobra command flag name that supplies the environment variable holding the PSAT
+const FlagPsatEnv = "psat-env"
 
-	// verify supplied flags
-	// check vault-address
-	if err := isValidURL(args["vault-address"].(string)); err != nil {
-		return kvlerrors.New(err.Error())
-	}
-	// check vault mount point
-	if !isAbsolutePath(args[FlagVaultKubernetesAuthMount].(string)) {
-		return kvlerrors.New(fmt.Errorf("vault-kubernetes-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /kubernetes/argocd : %s", args[FlagVaultKubernetesAuthMount].(string)).Error())
+// const to define cobra command flag names that configure the TokenRequest issuing the PSAT
+const (
+	FlagTokenRequestServiceAccount    = "token-request-service-account"
+	FlagTokenRequestAudience          = "token-request-audience"
+	FlagTokenRequestExpirationSeconds = "token-request-expiration-seconds"
+	FlagTokenRequestKubeconfig        = "token-request-kubeconfig"
+)
+
+// minTokenRequestExpirationSeconds is the shortest token lifetime the kubernetes API server accepts
+const minTokenRequestExpirationSeconds = 600
+
+// defaultPsatPath is where kubernetes projects the default service account token
+const defaultPsatPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// defaultVaultKubernetesLoginRole is the default role in Vault's kubernetes authentication backend
+const defaultVaultKubernetesLoginRole = "kvl-login"
+
+func init() {
+	RegisterAuthenticator(AuthMethodPsat, func() Authenticator { return &KubernetesAuth{} })
+}
+
+// KubernetesAuth authenticates to Vault's kubernetes authentication backend exchanging a projected service account token (PSAT)
+type KubernetesAuth struct {
+	// Mount is Vault's kubernetes authentication mount point, ex. /kubernetes/argocd
+	Mount string
+	// TokenPath is the absolute path to the projected service account token
+	TokenPath string
+	// Role is the role in Vault's kubernetes authentication backend, defaults to kvl-login
+	Role string
+	// TokenEnv is an environment variable holding the token, read once by Validate
+	TokenEnv string
+	// TokenRequestServiceAccount is a service account, as namespace/name, the token is requested for through the
+	// kubernetes TokenRequest API with TokenRequestAudiences and TokenRequestExpirationSeconds, using
+	// TokenRequestKubeconfig or the in-cluster configuration
+	TokenRequestServiceAccount    string
+	TokenRequestAudiences         []string
+	TokenRequestExpirationSeconds int64
+	TokenRequestKubeconfig        string
+	// Source supplies the token. When nil, Validate sets it from the fields above, TokenPath being the default
+	Source JWTSource
+}
+
+// Name returns the name of the kubernetes authentication method
+func (a *KubernetesAuth) Name() string {
+	return AuthMethodPsat
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *KubernetesAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
 
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *KubernetesAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// Usage returns the description of the psat subcommand
+func (a *KubernetesAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using kubernetes authentication",
+		`Authenticates to Hashicorp Vault using kubernetes authentication
+	and its projected service account token.
+	The token is read from --psat-path, where - designates STDIN, from the environment variable named by --psat-env
+	or requested through the kubernetes TokenRequest API for --token-request-service-account.`
+}
+
+// AddFlags registers the flags of the psat subcommand
+func (a *KubernetesAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultKubernetesAuthMount, "a", "", "vault kuberentes authentication mountpoint, ex: /kubernetes/<clustername>")
+	flags.StringVarP(&a.TokenPath, FlagPsatPath, "p", defaultPsatPath, "absolute path to projected service account token used to authenticate to hashicorp vault, - reads it from STDIN")
+	flags.StringVar(&a.TokenEnv, FlagPsatEnv, "", "environment variable holding the service account token, in place of --psat-path")
+	flags.StringVar(&a.TokenRequestServiceAccount, FlagTokenRequestServiceAccount, "", "service account, as namespace/name, to request the token for through the kubernetes TokenRequest API, in place of --psat-path")
+	flags.StringSliceVar(&a.TokenRequestAudiences, FlagTokenRequestAudience, nil, "audience of the requested token, can be repeated. Defaults to the kubernetes API server's audiences")
+	flags.Int64Var(&a.TokenRequestExpirationSeconds, FlagTokenRequestExpirationSeconds, 0, "lifetime of the requested token in seconds, at least 600. Defaults to the kubernetes API server's default")
+	flags.StringVar(&a.TokenRequestKubeconfig, FlagTokenRequestKubeconfig, "", "kubeconfig to reach the kubernetes API server for token requests, the in-cluster configuration is used when unset")
+	return []string{FlagVaultKubernetesAuthMount}
+}
+
+// LoadEnv fills an unset mount point from VAULT_AUTH_MOUNT and an unset role from VAULT_K8S_LOGIN_ROLE
+func (a *KubernetesAuth) LoadEnv() error {
+	if a.Mount == "" {
+		a.Mount = os.Getenv("VAULT_AUTH_MOUNT")
 	}
-	if !isAbsolutePath(args[FlagPsatPath].(string)) {
-		return kvlerrors.New(fmt.Errorf("psat-path must be an absolute path to a token file: %s", args[FlagPsatPath].(string)).Error())
+	if a.Role == "" {
+		a.Role = os.Getenv("VAULT_K8S_LOGIN_ROLE")
 	}
+	return nil
+}
 
-	// perform preparation tasks
-	if err := prepFederation(&args); err != nil {
-		return kvlerrors.New(err.Error())
+// Validate checks the mount point and token path and applies the default role
+func (a *KubernetesAuth) Validate() error {
+	// check vault mount point
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-kubernetes-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /kubernetes/argocd : %s", a.Mount)
+	}
+	if a.TokenPath == "" {
+		a.TokenPath = defaultPsatPath
+	}
+	if a.Role == "" {
+		a.Role = defaultVaultKubernetesLoginRole
+	}
+	if a.Source != nil {
+		return nil
+	}
+	source, err := a.psatSource()
+	if err != nil {
+		return err
 	}
+	a.Source = source
+	return nil
+}
 
-	// ensure kubernetes authentication mont point is properly set
-	if err := prepVaultAuthMount(&args); err != nil {
-		return kvlerrors.New(err.Error())
+// applicable reports why no psat is available, nil when one is. Only a token file can be missing, other
+// sources are checked by Validate
+func (a *KubernetesAuth) applicable() error {
+	if file, ok := a.Source.(PsatFile); ok {
+		if _, err := os.Stat(string(file)); err != nil {
+			return fmt.Errorf("the psat file %s cannot be read: %s", string(file), errors.Unwrap(err))
+		}
 	}
+	return nil
+}
 
-	// only actual resques and not a test run
-	if !test {
-		var err error
-		// now authenticate to vault leveraging PSAT
-		if err := prepVaultClient(args["vault-address"].(string)); err != nil {
-			return kvlerrors.New(err.Error())
+// psatSource returns the token source configured by TokenEnv, TokenRequestServiceAccount or TokenPath in that order of preference
+func (a *KubernetesAuth) psatSource() (JWTSource, error) {
+	if a.TokenEnv != "" && a.TokenRequestServiceAccount != "" {
+		return nil, fmt.Errorf("%s and %s are mutually exclusive", FlagPsatEnv, FlagTokenRequestServiceAccount)
+	}
+	if a.TokenRequestServiceAccount == "" && (len(a.TokenRequestAudiences) > 0 || a.TokenRequestExpirationSeconds != 0 || a.TokenRequestKubeconfig != "") {
+		return nil, fmt.Errorf("%s, %s and %s require %s", FlagTokenRequestAudience, FlagTokenRequestExpirationSeconds, FlagTokenRequestKubeconfig, FlagTokenRequestServiceAccount)
+	}
+	switch {
+	case a.TokenEnv != "":
+		jwt := strings.TrimSpace(os.Getenv(a.TokenEnv))
+		if jwt == "" {
+			return nil, fmt.Errorf("psat-env names an unset or empty environment variable: %s", a.TokenEnv)
+		}
+		return PsatStatic{JWT: jwt, From: "env " + a.TokenEnv}, nil
+	case a.TokenRequestServiceAccount != "":
+		namespace, name, found := strings.Cut(a.TokenRequestServiceAccount, "/")
+		if !found || !isValidHostname(namespace) || !isValidHostname(name) {
+			return nil, fmt.Errorf("token-request-service-account must be of a form of namespace/name: %s", a.TokenRequestServiceAccount)
 		}
-		if err := authToVaultWithKubernetes(ctx, client, vaultKubernetesLoginRole, args[FlagVaultKubernetesAuthMount].(string), args[FlagPsatPath].(string)); err != nil {
-			return kvlerrors.New(err.Error())
+		if a.TokenRequestExpirationSeconds != 0 && a.TokenRequestExpirationSeconds < minTokenRequestExpirationSeconds {
+			return nil, fmt.Errorf("token-request-expiration-seconds must be at least %d: %d", minTokenRequestExpirationSeconds, a.TokenRequestExpirationSeconds)
 		}
-		// now that we are authenticated, lets generate a token to authenticate to k8s cluster
-		k8sToken, err = generateK8sToken(ctx, client, vaultK8sSecretRole, args["cluster-name"].(string))
+		return &TokenRequestSource{
+			Namespace:         namespace,
+			ServiceAccount:    name,
+			Audiences:         a.TokenRequestAudiences,
+			ExpirationSeconds: a.TokenRequestExpirationSeconds,
+			Kubeconfig:        a.TokenRequestKubeconfig,
+		}, nil
+	case a.TokenPath == stdinFile:
+		// STDIN can only be read once, the token is kept in memory
+		jwt, err := readCredential(stdinFile)
 		if err != nil {
-			return kvlerrors.New(err.Error())
+			return nil, err
 		}
-	} else {
-		k8sToken = generateFakeK8sToken()
+		return PsatStatic{JWT: jwt, From: "STDIN"}, nil
 	}
-	// Assemble a ExecCredential
-	err := assembleExecCredential(inputExecCredentialPtr, k8sToken)
+	if !isAbsolutePath(a.TokenPath) {
+		return nil, fmt.Errorf("psat-path must be an absolute path to a token file: %s", a.TokenPath)
+	}
+	return PsatFile(a.TokenPath), nil
+}
+
+// Login authenticates to Vault with the PSAT supplied by Source
+func (a *KubernetesAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	jwt, err := a.Source.Token(ctx)
 	if err != nil {
-		return kvlerrors.New(err.Error())
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithKubernetes() cannot read psat: %w", err))
 	}
+	return authToVaultWithKubernetes(ctx, client, a.Role, a.Mount, jwt)
+}
 
-	// Output the new ExecCredential
-	err = printExecCredential(os.Stdout, inputExecCredentialPtr)
+// loginIdentity returns a digest of the subject of a psat read from a file or held in memory, ex.
+// system:serviceaccount:argocd:argocd-server. A requested token's service account is part of the source's description
+func (a *KubernetesAuth) loginIdentity() string {
+	switch a.Source.(type) {
+	case PsatFile, PsatStatic:
+	default:
+		return ""
+	}
+	jwt, err := a.Source.Token(context.Background())
 	if err != nil {
-		return kvlerrors.New(err.Error())
+		return ""
 	}
-	return nil
+	return identityDigest(jwtSubject(jwt))
+}
+
+// jwtSubject returns the unverified sub claim of jwt, an empty string when it cannot be decoded
+func jwtSubject(jwt string) string {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// PlanLogin describes the kubernetes login for dry runs
+func (a *KubernetesAuth) PlanLogin() LoginPlan {
+	input := "psat read from " + a.TokenPath
+	if a.Source != nil {
+		input = a.Source.String()
+	}
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    input,
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "login")},
+	}
+}
+
+// authToVaultWithKubernetes authenticates to Vault using kubernetes authentication and exchanging its PSAT for a vault token.
+// Upon successful authentication it popules the client with the recevied vault token and returns the token's ttl.
+func authToVaultWithKubernetes(ctx context.Context, client VaultAPI, vaultKubernetesLoginRole string, mountPath string, jwt string) (time.Duration, error) {
+	resp, err := client.Write(ctx, vaultPath("auth", mountPath, "login"), map[string]any{
+		"jwt":  jwt,
+		"role": vaultKubernetesLoginRole,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithKubernetes() KubernetesLogin: %w", err)
+	}
+	return setVaultToken(client, resp, "authToVaultWithKubernetes()")
 }
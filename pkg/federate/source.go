@@ -0,0 +1,83 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// CredentialSource obtains a kubernetes bearer token for a downstream cluster from Vault.
+// Sources are selected per cluster, see Options.Sources and ClusterConfig
+type CredentialSource interface {
+	// Name returns the name of the source, it is how clusters select it in the configuration file
+	Name() string
+	// Validate checks the source's settings
+	Validate() error
+	// Credential requests a kubernetes bearer token for clusterName with an authenticated vault client
+	Credential(ctx context.Context, client VaultAPI, clusterName string) (*Credential, error)
+}
+
+// sourceDefaulter is implemented by sources that take their defaults from the Federator's Options
+type sourceDefaulter interface {
+	setDefaults(opts Options)
+}
+
+// ClusterConfig holds the settings of a downstream cluster as read from the configuration file.
+// Which fields apply depends on the selected Source
+type ClusterConfig struct {
+	// Source is the name of the CredentialSource used for the cluster, defaults to kubernetes
+	Source string `mapstructure:"source"`
+	// Mount is the mount point of the Vault secret engine the source reads from
+	Mount string `mapstructure:"mount"`
+	// Role is the role in the Vault secret engine
+	Role string `mapstructure:"role"`
+	// Namespace is the kubernetes namespace the token is requested for
+	Namespace string `mapstructure:"namespace"`
+	// Path and Field locate a static token in a KV v2 secret engine
+	Path  string `mapstructure:"path"`
+	Field string `mapstructure:"field"`
+}
+
+// credentialSources holds registered credential sources by name
+var (
+	credentialSourcesMu sync.RWMutex
+	credentialSources   = map[string]func(config ClusterConfig) CredentialSource{}
+)
+
+// RegisterCredentialSource makes a credential source available under name. It is meant to be called
+// from init functions and panics when name is already registered
+func RegisterCredentialSource(name string, factory func(config ClusterConfig) CredentialSource) {
+	credentialSourcesMu.Lock()
+	defer credentialSourcesMu.Unlock()
+	if _, exists := credentialSources[name]; exists {
+		panic(fmt.Sprintf("federate: credential source %s registered twice", name))
+	}
+	credentialSources[name] = factory
+}
+
+// CredentialSources returns the names of registered credential sources in sorted order
+func CredentialSources() []string {
+	credentialSourcesMu.RLock()
+	defer credentialSourcesMu.RUnlock()
+	names := make([]string, 0, len(credentialSources))
+	for name := range credentialSources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewCredentialSource returns the CredentialSource selected by config.Source, configured with config
+func NewCredentialSource(config ClusterConfig) (CredentialSource, error) {
+	if config.Source == "" {
+		config.Source = SourceKubernetes
+	}
+	credentialSourcesMu.RLock()
+	factory, exists := credentialSources[config.Source]
+	credentialSourcesMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unsupported credential source: %q, expected one of %v", config.Source, CredentialSources())
+	}
+	return factory(config), nil
+}
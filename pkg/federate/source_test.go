@@ -0,0 +1,98 @@
+package federate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/vaultfake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockVault starts a fake Vault accepting the approle credentials of mockApprole and returns it
+// along with options pointing at it
+func mockVault(t *testing.T) (*vaultfake.Server, Options) {
+	vault := vaultfake.New()
+	t.Cleanup(vault.Close)
+	vault.AddApproleLogin("/approle", "role-id", "secret-id")
+	opts := mockOptions
+	opts.VaultAddress = vault.URL
+	opts.HTTPClient = vault.Client()
+	opts.Authenticator = &ApproleAuth{Mount: "/approle", RoleID: "role-id", SecretID: "secret-id"}
+	return vault, opts
+}
+
+func TestNewCredentialSource(t *testing.T) {
+	assert.Equal(t, []string{SourceKubernetes, SourceKV, SourceOIDC}, CredentialSources())
+
+	source, err := NewCredentialSource(ClusterConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, &KubernetesSecretsSource{}, source)
+
+	source, err = NewCredentialSource(ClusterConfig{Source: SourceKV, Mount: "/static", Path: "legacy", Field: "bearer"})
+	require.NoError(t, err)
+	assert.Equal(t, &KVSource{Mount: "/static", Path: "legacy", Field: "bearer"}, source)
+
+	_, err = NewCredentialSource(ClusterConfig{Source: "ldap"})
+	assert.ErrorContains(t, err, `unsupported credential source: "ldap"`)
+}
+
+func TestNewFederatorSources(t *testing.T) {
+	opts := mockOptions
+	opts.Sources = map[string]CredentialSource{"legacy": &KVSource{}, "oidc": &OIDCSource{}}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	// defaults of the kubernetes source come from the options
+	assert.Equal(t, &KubernetesSecretsSource{Mount: defaultSecretsMount, Role: defaultVaultK8sSecretRole, Namespace: defaultK8sNamespace}, federator.Source("dev"))
+	assert.Equal(t, &KVSource{Mount: defaultKVMount, Field: defaultKVField}, federator.Source("legacy"))
+
+	opts.Sources = map[string]CredentialSource{"legacy": &KVSource{Mount: "secret"}}
+	_, err = NewFederator(opts)
+	assert.ErrorContains(t, err, "kv credential source: kv mount must be an absolute path")
+
+	opts.Sources = map[string]CredentialSource{"not_a_host": &KVSource{}}
+	_, err = NewFederator(opts)
+	assert.ErrorContains(t, err, "cluster-name must be a string that is a valid dns name")
+}
+
+func TestSessionCredentialSources(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.AddKubernetesCreds("/kubernetes/dev", "kvl-edit-role")
+	vault.SetKV("/secret", "kubernetes/legacy", map[string]any{"token": "static-token"})
+	vault.AddOIDCRole("apiserver", 5*time.Minute)
+	opts.Sources = map[string]CredentialSource{"legacy": &KVSource{}, "oidc": &OIDCSource{Role: "apiserver"}}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	ctx := context.Background()
+	session, err := federator.Login(ctx, TemplateData{})
+	require.NoError(t, err)
+
+	credential, err := session.Credential(ctx, "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "fake-k8s-token-2", credential.Token)
+	assert.Equal(t, "kubernetes/dev/creds/kvl-edit-role/2", credential.LeaseID)
+	assert.WithinDuration(t, time.Now().Add(minTokenDuration), credential.ExpirationTimestamp, time.Minute)
+
+	credential, err = session.Credential(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "static-token", credential.Token)
+	assert.WithinDuration(t, time.Now().Add(minTokenDuration), credential.ExpirationTimestamp, time.Minute)
+
+	// the oidc token expires before TokenDuration
+	credential, err = session.Credential(ctx, "oidc")
+	require.NoError(t, err)
+	assert.Equal(t, "fake-oidc-token-3", credential.Token)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), credential.ExpirationTimestamp, time.Minute)
+}
+
+func TestKVSourceMissingField(t *testing.T) {
+	vault, opts := mockVault(t)
+	vault.SetKV("/secret", "kubernetes/legacy", map[string]any{"token": "static-token"})
+	opts.Sources = map[string]CredentialSource{"legacy": &KVSource{Field: "bearer"}}
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+	_, err = federator.Credential(context.Background(), "legacy")
+	assert.ErrorContains(t, err, "field bearer does not hold a token")
+}
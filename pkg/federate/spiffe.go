@@ -0,0 +1,183 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// AuthMethodSpiffe is the name of the authentication method presenting a SPIFFE JWT-SVID to Vault's jwt authentication backend
+const AuthMethodSpiffe = "spiffe"
+
+// const to define cobra command flag names that supply the Workload API socket, the audience and the SPIFFE ID of the JWT-SVID
+const (
+	FlagSpiffeEndpointSocket = "spiffe-endpoint-socket"
+	FlagSpiffeAudience       = "spiffe-audience"
+	FlagSpiffeID             = "spiffe-id"
+)
+
+// defaultSpiffeAudience is the audience of the JWT-SVID when unset
+const defaultSpiffeAudience = "vault"
+
+func init() {
+	RegisterAuthenticator(AuthMethodSpiffe, func() Authenticator { return &SpiffeAuth{} })
+}
+
+// SpiffeAuth authenticates to Vault's jwt authentication backend with a JWT-SVID fetched from the SPIFFE Workload API
+type SpiffeAuth struct {
+	// Mount is Vault's jwt authentication mount point, defaults to /jwt
+	Mount string
+	// Role is the role in Vault's jwt authentication backend
+	Role string
+	// Source supplies the JWT-SVID. When nil, Validate sets it from the fields below
+	Source JWTSource
+
+	// EndpointSocket is the address of the Workload API, ex. unix:///run/spire/agent.sock, defaults to SPIFFE_ENDPOINT_SOCKET
+	EndpointSocket string
+	// Audience of the JWT-SVID, it must match the bound_audiences of Role. Defaults to vault
+	Audience string
+	// SpiffeID selects the JWT-SVID of a workload entitled to several identities
+	SpiffeID string
+}
+
+// Name returns the name of the spiffe authentication method
+func (a *SpiffeAuth) Name() string {
+	return AuthMethodSpiffe
+}
+
+// loginFields returns the mount point and login role, both may be Go templates over TemplateData
+func (a *SpiffeAuth) loginFields() (string, string) {
+	return a.Mount, a.Role
+}
+
+// withLoginFields returns a copy of the authenticator logging in with mount and role
+func (a *SpiffeAuth) withLoginFields(mount string, role string) Authenticator {
+	rendered := *a
+	rendered.Mount, rendered.Role = mount, role
+	return &rendered
+}
+
+// Usage returns the description of the spiffe subcommand
+func (a *SpiffeAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault using jwt authentication and a SPIFFE JWT-SVID",
+		`Authenticates to Hashicorp Vault using jwt authentication.
+It presents a JWT-SVID fetched from the SPIFFE Workload API, ex. of a SPIRE agent, found at --spiffe-endpoint-socket
+or SPIFFE_ENDPOINT_SOCKET. When the workload is entitled to several identities, --spiffe-id selects one.`
+}
+
+// AddFlags registers the flags of the spiffe subcommand
+func (a *SpiffeAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVarP(&a.Mount, FlagVaultJWTAuthMount, "a", DefaultVaultJWTAuthMount, "vault jwt authentication mountpoint, ex: /jwt")
+	flags.StringVarP(&a.Role, FlagVaultJWTRole, "r", "", "role in vault's jwt authentication backend, defaults to VAULT_JWT_ROLE")
+	flags.StringVar(&a.EndpointSocket, FlagSpiffeEndpointSocket, "", "address of the SPIFFE Workload API, ex. unix:///run/spire/agent.sock, defaults to SPIFFE_ENDPOINT_SOCKET")
+	flags.StringVar(&a.Audience, FlagSpiffeAudience, defaultSpiffeAudience, "audience of the JWT-SVID, must match the bound_audiences of the vault role")
+	flags.StringVar(&a.SpiffeID, FlagSpiffeID, "", "SPIFFE ID of the JWT-SVID, ex. spiffe://example.org/kvl, the workload's default identity when unset")
+	return nil
+}
+
+// LoadEnv fills an unset role from VAULT_JWT_ROLE and an unset socket from SPIFFE_ENDPOINT_SOCKET
+func (a *SpiffeAuth) LoadEnv() error {
+	if a.Role == "" {
+		a.Role = os.Getenv("VAULT_JWT_ROLE")
+	}
+	if a.EndpointSocket == "" {
+		a.EndpointSocket = os.Getenv("SPIFFE_ENDPOINT_SOCKET")
+	}
+	return nil
+}
+
+// Validate checks the mount point, role, socket and SPIFFE ID
+func (a *SpiffeAuth) Validate() error {
+	if a.Mount == "" {
+		a.Mount = DefaultVaultJWTAuthMount
+	}
+	if !isAbsolutePath(a.Mount) {
+		return fmt.Errorf("vault-jwt-auth-mount must be of a form of an absolute path with alphanumeric path elements, ex. /jwt: %s", a.Mount)
+	}
+	if a.Role == "" {
+		return fmt.Errorf("vault-jwt-role or VAULT_JWT_ROLE must be set")
+	}
+	if a.Source != nil {
+		return nil
+	}
+	if a.Audience == "" {
+		a.Audience = defaultSpiffeAudience
+	}
+	if a.EndpointSocket == "" {
+		return fmt.Errorf("spiffe-endpoint-socket or SPIFFE_ENDPOINT_SOCKET must be set")
+	}
+	source := &SpiffeJWTSource{EndpointSocket: a.EndpointSocket, Audience: a.Audience}
+	if a.SpiffeID != "" {
+		id, err := spiffeid.FromString(a.SpiffeID)
+		if err != nil {
+			return fmt.Errorf("spiffe-id must be a SPIFFE ID, ex. spiffe://example.org/kvl: %s", err)
+		}
+		source.SpiffeID = id
+	}
+	a.Source = source
+	return nil
+}
+
+// Login authenticates to Vault with the JWT-SVID supplied by Source
+func (a *SpiffeAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	jwt, err := a.Source.Token(ctx)
+	if err != nil {
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithJWT() cannot obtain jwt: %w", err))
+	}
+	return authToVaultWithJWT(ctx, client, a.Role, a.Mount, jwt)
+}
+
+// PlanLogin describes the spiffe login for dry runs
+func (a *SpiffeAuth) PlanLogin() LoginPlan {
+	input := "JWT-SVID from the SPIFFE Workload API"
+	if a.Source != nil {
+		input = a.Source.String()
+	}
+	return LoginPlan{
+		Mount:    a.Mount,
+		Role:     a.Role,
+		Input:    input,
+		Requests: []string{"POST " + vaultPath("auth", a.Mount, "login")},
+	}
+}
+
+// SpiffeJWTSource fetches a JWT-SVID from the SPIFFE Workload API at every login
+type SpiffeJWTSource struct {
+	// EndpointSocket is the address of the Workload API, ex. unix:///run/spire/agent.sock
+	EndpointSocket string
+	Audience       string
+	// SpiffeID selects the JWT-SVID, the workload's default identity when zero
+	SpiffeID spiffeid.ID
+}
+
+// Token fetches a JWT-SVID
+func (s *SpiffeJWTSource) Token(ctx context.Context) (string, error) {
+	svids, err := workloadapi.FetchJWTSVIDs(ctx, jwtsvid.Params{Audience: s.Audience, Subject: s.SpiffeID}, workloadapi.WithAddr(s.EndpointSocket))
+	if err != nil {
+		return "", fmt.Errorf("SPIFFE Workload API at %s: %w", s.EndpointSocket, err)
+	}
+	if s.SpiffeID.IsZero() {
+		return svids[0].Marshal(), nil
+	}
+	for _, svid := range svids {
+		if svid.ID == s.SpiffeID {
+			return svid.Marshal(), nil
+		}
+	}
+	return "", fmt.Errorf("SPIFFE Workload API at %s returned no JWT-SVID for %s", s.EndpointSocket, s.SpiffeID)
+}
+
+func (s *SpiffeJWTSource) String() string {
+	id := "default identity"
+	if !s.SpiffeID.IsZero() {
+		id = s.SpiffeID.String()
+	}
+	return fmt.Sprintf("JWT-SVID of %s from the SPIFFE Workload API at %s, audience %q", id, s.EndpointSocket, s.Audience)
+}
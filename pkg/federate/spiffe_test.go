@@ -0,0 +1,110 @@
+package federate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeWorkloadAPI is a SPIFFE Workload API issuing JWT-SVIDs for a fixed set of SPIFFE IDs
+type fakeWorkloadAPI struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	ids []string
+}
+
+// FetchJWTSVID returns a JWT-SVID per SPIFFE ID, or the requested one only
+func (f *fakeWorkloadAPI) FetchJWTSVID(ctx context.Context, request *workload.JWTSVIDRequest) (*workload.JWTSVIDResponse, error) {
+	response := &workload.JWTSVIDResponse{}
+	for _, id := range f.ids {
+		if request.SpiffeId == "" || request.SpiffeId == id {
+			response.Svids = append(response.Svids, &workload.JWTSVID{SpiffeId: id, Svid: mockJWTSVID(id, request.Audience)})
+		}
+	}
+	return response, nil
+}
+
+// mockJWTSVID returns a JWT-SVID of id for audience expiring in 2100. The plugin parses it without verifying its signature
+func mockJWTSVID(id string, audience []string) string {
+	encode := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	return strings.Join([]string{
+		encode(map[string]string{"alg": "ES256", "typ": "JWT"}),
+		encode(map[string]any{"sub": id, "aud": audience, "exp": time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC).Unix()}),
+		base64.RawURLEncoding.EncodeToString([]byte("signature")),
+	}, ".")
+}
+
+// startFakeWorkloadAPI serves a fake Workload API for ids on a unix socket and returns its address
+func startFakeWorkloadAPI(t *testing.T, ids ...string) string {
+	// unix socket paths are limited in length, t.TempDir() may exceed it
+	dir, err := os.MkdirTemp("", "kvl-spiffe")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, &fakeWorkloadAPI{ids: ids})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "unix://" + socket
+}
+
+// tests the spiffe login presents the JWT-SVID selected by SPIFFE ID, from the socket in SPIFFE_ENDPOINT_SOCKET
+func TestSpiffeAuthLogin(t *testing.T) {
+	t.Setenv("SPIFFE_ENDPOINT_SOCKET", startFakeWorkloadAPI(t, "spiffe://example.org/web", "spiffe://example.org/kvl"))
+	vault, opts := mockVault(t)
+	vault.AddJWTLogin("/jwt", "kvl", mockJWTSVID("spiffe://example.org/kvl", []string{"vault"}))
+	authenticator := &SpiffeAuth{Role: "kvl", SpiffeID: "spiffe://example.org/kvl"}
+	require.NoError(t, authenticator.LoadEnv())
+	opts.Authenticator = authenticator
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	source := authenticator.Source.(*SpiffeJWTSource)
+	jwt, err := source.Token(context.Background())
+	require.NoError(t, err)
+	claims, err := base64.RawURLEncoding.DecodeString(strings.Split(jwt, ".")[1])
+	require.NoError(t, err)
+	assert.Contains(t, string(claims), `"sub":"spiffe://example.org/kvl"`)
+	assert.Contains(t, string(claims), `"aud":["vault"]`)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"POST auth/jwt/login"}, vault.Requests())
+}
+
+// tests the default identity is used without a SPIFFE ID and an unknown SPIFFE ID fails
+func TestSpiffeJWTSourceSelection(t *testing.T) {
+	socket := startFakeWorkloadAPI(t, "spiffe://example.org/web")
+
+	source := &SpiffeJWTSource{EndpointSocket: socket, Audience: "vault"}
+	jwt, err := source.Token(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, jwt)
+
+	authenticator := &SpiffeAuth{Role: "kvl", EndpointSocket: socket, SpiffeID: "spiffe://example.org/kvl"}
+	require.NoError(t, authenticator.Validate())
+	_, err = authenticator.Source.Token(context.Background())
+	assert.ErrorContains(t, err, "SPIFFE Workload API at "+socket)
+}
+
+// tests the spiffe login requires a role, a socket and a well-formed SPIFFE ID
+func TestSpiffeAuthValidate(t *testing.T) {
+	assert.ErrorContains(t, (&SpiffeAuth{}).Validate(), "vault-jwt-role")
+	assert.ErrorContains(t, (&SpiffeAuth{Role: "kvl"}).Validate(), "SPIFFE_ENDPOINT_SOCKET")
+	assert.ErrorContains(t, (&SpiffeAuth{Role: "kvl", EndpointSocket: "unix:///run/spire/agent.sock", SpiffeID: "kvl"}).Validate(), "spiffe-id must be a SPIFFE ID")
+}
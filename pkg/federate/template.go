@@ -0,0 +1,172 @@
+package federate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// const to define cobra command flag names that supply the mount point and role of the kubernetes secret engine,
+// both may be Go templates over TemplateData
+const (
+	FlagVaultSecretsMount = "vault-secrets-mount"
+	FlagVaultSecretRole   = "vault-secret-role"
+)
+
+// const to define cobra command flag names that supply the environment and profile fields of TemplateData
+const (
+	FlagEnvironment = "environment"
+	FlagProfile     = "profile"
+)
+
+// defaultSecretsMount is the template of the kubernetes secret engine mount used when Options.SecretsMount is unset
+const defaultSecretsMount = "/kubernetes/{{.ClusterName}}"
+
+// TemplateData holds the fields that mount points and role names may refer to as Go templates,
+// ex. /k8s-creds/{{.Environment}}/{{.ClusterName}} or {{.Profile.team}}-deployer
+type TemplateData struct {
+	// ClusterName is the downstream cluster name
+	ClusterName string
+	// ClusterServer is the URL of the downstream cluster's API server. It is only known when federating
+	// an ExecCredential carrying Spec.Cluster.Server, it is empty otherwise
+	ClusterServer string
+	// Environment and Profile are taken from Options.Environment and Options.Profile
+	Environment string
+	Profile     map[string]string
+}
+
+// loginTemplater is implemented by authenticators whose mount point and login role may be Go templates.
+// role is empty for methods without a login role
+type loginTemplater interface {
+	loginFields() (mount string, role string)
+	// withLoginFields returns an authenticator logging in with the rendered mount and role, leaving the receiver unchanged
+	withLoginFields(mount string, role string) Authenticator
+}
+
+// loginDelegator is implemented by authenticators logging in with one of several authenticators, ex. auto.
+// The mount point and login role templates of each delegate are rendered
+type loginDelegator interface {
+	delegates() map[string]Authenticator
+	// withDelegates returns an authenticator logging in with delegates, leaving the receiver unchanged
+	withDelegates(delegates map[string]Authenticator) Authenticator
+}
+
+// sourceRenderer is implemented by credential sources whose settings may be Go templates, render returns
+// a copy of the source with its templates rendered for data
+type sourceRenderer interface {
+	render(data TemplateData) (CredentialSource, error)
+}
+
+// templateData returns the data templates are rendered with for clusterName and its API server URL
+func (o *Options) templateData(clusterName, clusterServer string) TemplateData {
+	return TemplateData{ClusterName: clusterName, ClusterServer: clusterServer, Environment: o.Environment, Profile: o.Profile}
+}
+
+// renderTemplate renders text, the value of the setting name, for data. Missing profile fields are errors
+func renderTemplate(name, text string, data TemplateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("malformed %s template %q: %s", name, text, err)
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("failure rendering %s template %q: %s", name, text, err)
+	}
+	return rendered.String(), nil
+}
+
+// dependsOnCluster reports whether text, the value of the setting name, renders differently for different clusters
+func dependsOnCluster(name, text string, data TemplateData) (bool, error) {
+	var renderings [2]string
+	for i, clusterName := range []string{"cluster-a", "cluster-b"} {
+		rendered, err := renderTemplate(name, text, TemplateData{ClusterName: clusterName, ClusterServer: "https://" + clusterName + ".example.com", Environment: data.Environment, Profile: data.Profile})
+		if err != nil {
+			return false, err
+		}
+		renderings[i] = rendered
+	}
+	return renderings[0] != renderings[1], nil
+}
+
+// loginDependsOnCluster reports whether the mount point or login role template of authenticator, or of one of
+// its delegates, renders differently for different clusters
+func loginDependsOnCluster(authenticator Authenticator, data TemplateData) (bool, error) {
+	switch a := authenticator.(type) {
+	case loginTemplater:
+		mount, role := a.loginFields()
+		for name, text := range map[string]string{"auth mount": mount, "login role": role} {
+			if perCluster, err := dependsOnCluster(name, text, data); err != nil || perCluster {
+				return perCluster, err
+			}
+		}
+	case loginDelegator:
+		for name, delegate := range a.delegates() {
+			perCluster, err := loginDependsOnCluster(delegate, data)
+			if err != nil {
+				return false, fmt.Errorf("%s: %s", name, err)
+			}
+			if perCluster {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// renderLogin returns a copy of authenticator with the templates of its mount point and login role, or of those
+// of its delegates, rendered for data. An authenticator without templates is returned as is
+func renderLogin(authenticator Authenticator, data TemplateData) (Authenticator, error) {
+	switch a := authenticator.(type) {
+	case loginTemplater:
+		mount, role := a.loginFields()
+		if !strings.Contains(mount+role, "{{") {
+			return authenticator, nil
+		}
+		renderedMount, renderedRole, err := (&loginTemplates{mount: mount, role: role}).renderFields(data)
+		if err != nil {
+			return nil, err
+		}
+		return a.withLoginFields(renderedMount, renderedRole), nil
+	case loginDelegator:
+		delegates := map[string]Authenticator{}
+		rendered := false
+		for name, delegate := range a.delegates() {
+			renderedDelegate, err := renderLogin(delegate, data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			delegates[name] = renderedDelegate
+			rendered = rendered || renderedDelegate != delegate
+		}
+		if !rendered {
+			return authenticator, nil
+		}
+		return a.withDelegates(delegates), nil
+	}
+	return authenticator, nil
+}
+
+// loginTemplates holds the templates of an authenticator's mount point and login role, see renderLogin
+type loginTemplates struct {
+	mount string
+	role  string
+}
+
+// renderFields returns the mount point and login role rendered for data
+func (t *loginTemplates) renderFields(data TemplateData) (string, string, error) {
+	mount, err := renderTemplate("auth mount", t.mount, data)
+	if err != nil {
+		return "", "", err
+	}
+	if !isAbsolutePath(mount) {
+		return "", "", fmt.Errorf("auth mount must render to an absolute path, ex. /kubernetes/argocd: %q renders to %q", t.mount, mount)
+	}
+	role, err := renderTemplate("login role", t.role, data)
+	if err != nil {
+		return "", "", err
+	}
+	return mount, role, nil
+}
@@ -0,0 +1,132 @@
+package federate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockTemplatedOptions returns opts with mounts and roles that are templates over the environment and profile
+func mockTemplatedOptions(t *testing.T, opts Options, authMount string) Options {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("psat-jwt"), 0600))
+	opts.Authenticator = &KubernetesAuth{Mount: authMount, Role: "{{.Profile.team}}-login", TokenPath: tokenPath}
+	opts.Environment = "prod"
+	opts.Profile = map[string]string{"team": "payments"}
+	opts.SecretsMount = "/k8s-creds/{{.Environment}}/{{.ClusterName}}"
+	opts.SecretRole = "{{.Profile.team}}-deployer"
+	return opts
+}
+
+// tests mounts and roles are rendered for the federated cluster
+func TestFederateTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		authMount string
+		loginPath string
+		// perCluster is set when the login depends on the cluster, a Session then requires the cluster's TemplateData
+		perCluster bool
+	}{
+		{name: "login templates over the environment", authMount: "/k8s-auth/{{.Environment}}", loginPath: "/k8s-auth/prod"},
+		{name: "login templates over the cluster", authMount: "/k8s-auth/{{.ClusterName}}", loginPath: "/k8s-auth/dev", perCluster: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, opts := mockVault(t)
+			templated := mockTemplatedOptions(t, opts, tt.authMount)
+			vault.AddKubernetesLogin(tt.loginPath, "payments-login", "psat-jwt")
+			vault.AddKubernetesCreds("/k8s-creds/prod/dev", "payments-deployer")
+			federator, err := NewFederator(templated)
+			require.NoError(t, err)
+
+			status, err := federator.Federate(context.Background(), mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://dev.example.com","config":null},"interactive":false}}`))
+			require.NoError(t, err)
+			assert.NotEmpty(t, status.Token)
+			assert.Equal(t, []string{"POST auth" + tt.loginPath + "/login", "POST k8s-creds/prod/dev/creds/payments-deployer"}, vault.Requests())
+
+			assert.Equal(t, tt.perCluster, federator.LoginDependsOnCluster())
+			_, err = federator.Login(context.Background(), TemplateData{})
+			if tt.perCluster {
+				assert.ErrorContains(t, err, "the auth mount or login role template depends on the downstream cluster, a cluster name is required")
+				assert.Equal(t, kvlerrors.InputValidation, kvlerrors.CategoryOf(err))
+			} else {
+				assert.NoError(t, err)
+			}
+			session, err := federator.Login(context.Background(), federator.TemplateData("dev"))
+			require.NoError(t, err)
+			_, err = session.Credential(context.Background(), "dev")
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// tests logins templated over the cluster run concurrently for many clusters, each with its own mount,
+// and leave the configured authenticator unchanged
+func TestFederateAllLoginTemplates(t *testing.T) {
+	vault, opts := mockVault(t)
+	templated := mockTemplatedOptions(t, opts, "/k8s-auth/{{.ClusterName}}")
+	clusterNames := []string{"dev", "stage", "prod"}
+	for _, clusterName := range clusterNames {
+		vault.AddKubernetesLogin("/k8s-auth/"+clusterName, "payments-login", "psat-jwt")
+		vault.AddKubernetesCreds("/k8s-creds/prod/"+clusterName, "payments-deployer")
+	}
+	federator, err := NewFederator(templated)
+	require.NoError(t, err)
+
+	results, err := federator.FederateAll(context.Background(), clusterNames, 3)
+	require.NoError(t, err)
+	for _, result := range results {
+		assert.NoError(t, result.Err, result.ClusterName)
+	}
+	assert.ElementsMatch(t, []string{
+		"POST auth/k8s-auth/dev/login", "POST k8s-creds/prod/dev/creds/payments-deployer",
+		"POST auth/k8s-auth/stage/login", "POST k8s-creds/prod/stage/creds/payments-deployer",
+		"POST auth/k8s-auth/prod/login", "POST k8s-creds/prod/prod/creds/payments-deployer",
+	}, vault.Requests())
+	assert.Equal(t, "/k8s-auth/{{.ClusterName}}", templated.Authenticator.(*KubernetesAuth).Mount)
+}
+
+// tests a plan shows the rendered mounts and roles
+func TestPlanTemplates(t *testing.T) {
+	federator, err := NewFederator(mockTemplatedOptions(t, mockOptions, "/k8s-auth/{{.Environment}}/{{.ClusterName}}"))
+	require.NoError(t, err)
+
+	plan, err := federator.Plan(mockExecCredential(t, `{"kind":"ExecCredential","apiVersion":"client.authentication.k8s.io/v1","spec":{"cluster":{"server":"https://stage.example.com","config":null},"interactive":false}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/k8s-auth/prod/stage", plan.Login.Mount)
+	assert.Equal(t, "payments-login", plan.Login.Role)
+	assert.Equal(t, "/k8s-creds/prod/stage", plan.Credential.Mount)
+	assert.Equal(t, "payments-deployer", plan.Credential.Role)
+	assert.Equal(t, []string{"POST k8s-creds/prod/stage/creds/payments-deployer"}, plan.Credential.Requests)
+}
+
+// tests malformed templates are reported when the Federator is created
+func TestNewFederatorInvalidTemplates(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(opts *Options)
+		expectedError string
+	}{
+		{name: "malformed secrets mount", modify: func(opts *Options) { opts.SecretsMount = "/k8s-creds/{{.ClusterName" }, expectedError: "malformed secrets mount template"},
+		{name: "unknown field", modify: func(opts *Options) { opts.SecretsMount = "/k8s-creds/{{.Cluster}}" }, expectedError: "can't evaluate field Cluster"},
+		{name: "missing profile field", modify: func(opts *Options) { opts.SecretRole = "{{.Profile.owner}}-deployer" }, expectedError: `map has no entry for key "owner"`},
+		{name: "relative secrets mount", modify: func(opts *Options) { opts.SecretsMount = "{{.ClusterName}}" }, expectedError: "secrets mount must render to an absolute path"},
+		{name: "cluster source", modify: func(opts *Options) {
+			opts.Sources = map[string]CredentialSource{"legacy": &KubernetesSecretsSource{Mount: "/k8s/{{.Profile.region}}"}}
+		}, expectedError: `kubernetes credential source: failure rendering secrets mount template`},
+		{name: "malformed login role", modify: func(opts *Options) { opts.Authenticator.(*KubernetesAuth).Role = "{{end}}" }, expectedError: "malformed login role template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := mockTemplatedOptions(t, mockOptions, "/k8s-auth/{{.Environment}}")
+			tt.modify(&opts)
+			_, err := NewFederator(opts)
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
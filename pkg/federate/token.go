@@ -0,0 +1,135 @@
+package federate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// AuthMethodToken is the name of the authentication method presenting an existing vault token
+const AuthMethodToken = "token"
+
+// const to define cobra command flag name that supplies the file holding an existing vault token
+const FlagVaultTokenFile = "vault-token-file"
+
+func init() {
+	RegisterAuthenticator(AuthMethodToken, func() Authenticator { return &TokenAuth{} })
+}
+
+// TokenAuth uses an existing vault token, ex. of a `vault login` on a developer laptop
+type TokenAuth struct {
+	// Token is the vault token, defaults to VAULT_TOKEN
+	Token string
+	// TokenFile holds the vault token when Token is unset, defaults to ~/.vault-token as written by the vault CLI
+	TokenFile string
+}
+
+// Name returns the name of the token authentication method
+func (a *TokenAuth) Name() string {
+	return AuthMethodToken
+}
+
+// Usage returns the description of the token subcommand
+func (a *TokenAuth) Usage() (string, string) {
+	return "Authenticates to Hashicorp Vault with an existing vault token",
+		`Authenticates to Hashicorp Vault with an existing vault token.
+It takes the token from VAULT_TOKEN or else from --vault-token-file, which defaults to ~/.vault-token
+as written by vault login. The token is looked up to verify it and obtain its ttl.`
+}
+
+// AddFlags registers the flags of the token subcommand
+func (a *TokenAuth) AddFlags(flags *pflag.FlagSet) []string {
+	flags.StringVar(&a.TokenFile, FlagVaultTokenFile, "", "file holding the vault token when VAULT_TOKEN is unset, defaults to ~/.vault-token")
+	return nil
+}
+
+// LoadEnv fills an unset token from VAULT_TOKEN
+func (a *TokenAuth) LoadEnv() error {
+	if a.Token == "" {
+		a.Token = os.Getenv("VAULT_TOKEN")
+	}
+	return nil
+}
+
+// Validate applies the default token file
+func (a *TokenAuth) Validate() error {
+	if a.TokenFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("%s is unset and the home directory is unknown: %s", FlagVaultTokenFile, err)
+		}
+		a.TokenFile = filepath.Join(home, ".vault-token")
+	}
+	return nil
+}
+
+// applicable reports why no vault token is available, nil when one is
+func (a *TokenAuth) applicable() error {
+	if a.Token != "" {
+		return nil
+	}
+	if _, err := os.Stat(a.TokenFile); err != nil {
+		return fmt.Errorf("VAULT_TOKEN is unset and %s cannot be read: %s", a.TokenFile, errors.Unwrap(err))
+	}
+	return nil
+}
+
+// Login sets the vault token on client and looks it up to verify it and obtain its ttl
+func (a *TokenAuth) Login(ctx context.Context, client VaultAPI) (time.Duration, error) {
+	token, err := a.token()
+	if err != nil {
+		return 0, kvlerrors.Wrap(kvlerrors.InputValidation, fmt.Errorf("authToVaultWithToken() cannot read the vault token: %w", err))
+	}
+	if err := client.SetToken(token); err != nil {
+		return 0, fmt.Errorf("authToVaultWithToken() SetToken: %s", err)
+	}
+	resp, err := client.Read(ctx, "auth/token/lookup-self")
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithToken() TokenLookupSelf: %w", err)
+	}
+	ttl, err := durationField(resp.Data, "ttl")
+	if err != nil {
+		return 0, fmt.Errorf("authToVaultWithToken() TokenLookupSelf: %s", err)
+	}
+	return ttl, nil
+}
+
+// token returns Token or else the content of TokenFile
+func (a *TokenAuth) token() (string, error) {
+	if a.Token != "" {
+		return a.Token, nil
+	}
+	data, err := os.ReadFile(a.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// loginIdentity returns a digest of the vault token, different tokens being different identities
+func (a *TokenAuth) loginIdentity() string {
+	token, err := a.token()
+	if err != nil {
+		return ""
+	}
+	return identityDigest(token)
+}
+
+// PlanLogin describes the token lookup for dry runs
+func (a *TokenAuth) PlanLogin() LoginPlan {
+	input := "vault token from VAULT_TOKEN"
+	if a.Token == "" {
+		input = "vault token read from " + a.TokenFile
+	}
+	return LoginPlan{
+		Input:    input,
+		Requests: []string{"GET auth/token/lookup-self"},
+	}
+}
@@ -0,0 +1,136 @@
+package federate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// JWTSource supplies the JWT presented to Vault's kubernetes or jwt authentication backend
+type JWTSource interface {
+	// Token returns a JWT
+	Token(ctx context.Context) (string, error)
+	// String describes where the token comes from, never the token itself
+	String() string
+}
+
+// PsatFile reads the token from a file at every login, ex. a projected service account token
+type PsatFile string
+
+// Token reads the token from the file
+func (f PsatFile) Token(ctx context.Context) (string, error) {
+	jwt, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(jwt)), nil
+}
+
+func (f PsatFile) String() string {
+	description := "psat read from " + string(f)
+	if _, err := os.Stat(string(f)); err != nil {
+		description += fmt.Sprintf(" (%s)", err)
+	}
+	return description
+}
+
+// PsatStatic is a token read once, from an environment variable or STDIN
+type PsatStatic struct {
+	JWT string
+	// From describes where JWT was read from
+	From string
+}
+
+// Token returns the token
+func (s PsatStatic) Token(ctx context.Context) (string, error) {
+	if s.JWT == "" {
+		return "", fmt.Errorf("%s is empty", s.From)
+	}
+	return s.JWT, nil
+}
+
+func (s PsatStatic) String() string {
+	return "psat read from " + s.From
+}
+
+// TokenRequestSource requests a token of a service account through the kubernetes TokenRequest API at every login
+type TokenRequestSource struct {
+	// Namespace and ServiceAccount name the service account the token is requested for
+	Namespace      string
+	ServiceAccount string
+	// Audiences of the token, the kubernetes API server's audiences when empty
+	Audiences []string
+	// ExpirationSeconds is the requested lifetime of the token, the API server's default when 0
+	ExpirationSeconds int64
+	// Kubeconfig is the kubeconfig file to reach the kubernetes API server, in-cluster configuration is used when empty
+	Kubeconfig string
+	// Clientset is the kubernetes client, created from Kubeconfig when nil
+	Clientset kubernetes.Interface
+
+	mu sync.Mutex
+}
+
+// Token requests a token for the service account
+func (s *TokenRequestSource) Token(ctx context.Context) (string, error) {
+	clientset, err := s.clientset()
+	if err != nil {
+		return "", err
+	}
+	request := &authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{Audiences: s.Audiences}}
+	if s.ExpirationSeconds > 0 {
+		request.Spec.ExpirationSeconds = &s.ExpirationSeconds
+	}
+	response, err := clientset.CoreV1().ServiceAccounts(s.Namespace).CreateToken(ctx, s.ServiceAccount, request, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("TokenRequest for serviceaccount %s/%s: %w", s.Namespace, s.ServiceAccount, err)
+	}
+	if response.Status.Token == "" {
+		return "", fmt.Errorf("TokenRequest for serviceaccount %s/%s: response does not contain a token", s.Namespace, s.ServiceAccount)
+	}
+	return response.Status.Token, nil
+}
+
+func (s *TokenRequestSource) String() string {
+	description := fmt.Sprintf("psat requested by TokenRequest for serviceaccount %s/%s", s.Namespace, s.ServiceAccount)
+	if len(s.Audiences) > 0 {
+		description += ", audiences " + strings.Join(s.Audiences, ",")
+	}
+	if s.ExpirationSeconds > 0 {
+		description += fmt.Sprintf(", expiration %ds", s.ExpirationSeconds)
+	}
+	if s.Kubeconfig != "" {
+		return description + ", kubeconfig " + s.Kubeconfig
+	}
+	return description + ", in-cluster configuration"
+}
+
+// clientset returns Clientset, creating it from Kubeconfig or the in-cluster configuration the first time
+func (s *TokenRequestSource) clientset() (kubernetes.Interface, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Clientset != nil {
+		return s.Clientset, nil
+	}
+	var config *rest.Config
+	var err error
+	if s.Kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", s.Kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("TokenRequest cannot configure the kubernetes client: %w", err)
+	}
+	if s.Clientset, err = kubernetes.NewForConfig(config); err != nil {
+		return nil, fmt.Errorf("TokenRequest cannot create the kubernetes client: %w", err)
+	}
+	return s.Clientset, nil
+}
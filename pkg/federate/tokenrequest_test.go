@@ -0,0 +1,107 @@
+package federate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeTokenRequests returns a fake clientset answering TokenRequests with a token naming the service account,
+// and the requests it received
+func fakeTokenRequests(t *testing.T) (*fake.Clientset, *[]*authenticationv1.TokenRequest) {
+	clientset := fake.NewSimpleClientset()
+	requests := []*authenticationv1.TokenRequest{}
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateActionImpl)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		request := create.GetObject().(*authenticationv1.TokenRequest)
+		requests = append(requests, request)
+		if create.GetNamespace() == "denied" {
+			return true, nil, errors.New("serviceaccounts \"kvl\" is forbidden")
+		}
+		response := request.DeepCopy()
+		response.Status.Token = "tokenrequest-" + create.GetNamespace() + "-" + create.Name
+		return true, response, nil
+	})
+	return clientset, &requests
+}
+
+// tests the kubernetes login presents a token requested with the configured audiences and lifetime
+func TestTokenRequestSourceLogin(t *testing.T) {
+	clientset, requests := fakeTokenRequests(t)
+	vault, opts := mockVault(t)
+	vault.AddKubernetesLogin("/kubernetes/argocd", "kvl-login", "tokenrequest-argocd-kvl")
+	authenticator := &KubernetesAuth{Mount: "/kubernetes/argocd", TokenRequestServiceAccount: "argocd/kvl",
+		TokenRequestAudiences: []string{"vault"}, TokenRequestExpirationSeconds: 900}
+	require.NoError(t, authenticator.Validate())
+	authenticator.Source.(*TokenRequestSource).Clientset = clientset
+	opts.Authenticator = authenticator
+	federator, err := NewFederator(opts)
+	require.NoError(t, err)
+
+	_, err = federator.Login(context.Background(), TemplateData{})
+	require.NoError(t, err)
+	require.Len(t, *requests, 1)
+	assert.Equal(t, []string{"vault"}, (*requests)[0].Spec.Audiences)
+	assert.Equal(t, int64(900), *(*requests)[0].Spec.ExpirationSeconds)
+	assert.Equal(t, "psat requested by TokenRequest for serviceaccount argocd/kvl, audiences vault, expiration 900s, in-cluster configuration", authenticator.PlanLogin().Input)
+}
+
+// tests a denied TokenRequest fails the login before contacting Vault
+func TestTokenRequestSourceDenied(t *testing.T) {
+	clientset, _ := fakeTokenRequests(t)
+	source := &TokenRequestSource{Namespace: "denied", ServiceAccount: "kvl", Clientset: clientset}
+	_, err := source.Token(context.Background())
+	assert.ErrorContains(t, err, "TokenRequest for serviceaccount denied/kvl: serviceaccounts \"kvl\" is forbidden")
+}
+
+// tests the token sources selected by the psat flags
+func TestKubernetesAuthPsatSources(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("file-jwt\n"), 0600))
+	t.Setenv("KVL_PSAT", "env-jwt")
+	stdin = strings.NewReader("stdin-jwt\n")
+	t.Cleanup(func() { stdin = os.Stdin })
+
+	tests := []struct {
+		name     string
+		auth     KubernetesAuth
+		expected string
+		err      string
+	}{
+		{name: "file", auth: KubernetesAuth{TokenPath: tokenPath}, expected: "file-jwt"},
+		{name: "env", auth: KubernetesAuth{TokenEnv: "KVL_PSAT"}, expected: "env-jwt"},
+		{name: "stdin", auth: KubernetesAuth{TokenPath: "-"}, expected: "stdin-jwt"},
+		{name: "unset env", auth: KubernetesAuth{TokenEnv: "KVL_UNSET"}, err: "unset or empty environment variable"},
+		{name: "env and tokenrequest", auth: KubernetesAuth{TokenEnv: "KVL_PSAT", TokenRequestServiceAccount: "argocd/kvl"}, err: "mutually exclusive"},
+		{name: "audience without tokenrequest", auth: KubernetesAuth{TokenRequestAudiences: []string{"vault"}}, err: "require token-request-service-account"},
+		{name: "service account without namespace", auth: KubernetesAuth{TokenRequestServiceAccount: "kvl"}, err: "namespace/name"},
+		{name: "short expiration", auth: KubernetesAuth{TokenRequestServiceAccount: "argocd/kvl", TokenRequestExpirationSeconds: 60}, err: "at least 600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.auth.Mount = "/kubernetes/argocd"
+			err := tt.auth.Validate()
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			jwt, err := tt.auth.Source.Token(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, jwt)
+		})
+	}
+}
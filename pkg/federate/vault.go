@@ -2,89 +2,125 @@ package federate
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	kvlerrors "github.com/guardanet/kubectl-vaultlogin/pkg/errors"
 	vaultcg "github.com/hashicorp/vault-client-go"
-	"github.com/hashicorp/vault-client-go/schema"
 )
 
-// authToVaultWithApprole authenticates to Vault using approle and retrieving RoleId and SecretId from env variables APPROLE_ROLE_ID and APPROLE_SECRET_ID respectively.
-// Upon successful authentication it popules the client with the recevied vault token.
-func authToVaultWithApprole(ctx *context.Context, client *vaultcg.Client, mountPath string) error {
-	resp, err := client.Auth.AppRoleLogin(
-		*ctx,
-		schema.AppRoleLoginRequest{
-			RoleId:   os.Getenv("APPROLE_ROLE_ID"),
-			SecretId: os.Getenv("APPROLE_SECRET_ID"),
-		},
-		vaultcg.WithMountPath(mountPath), // optional, defaults to "approle"
-	)
-	if err != nil {
-		return fmt.Errorf("authToVaultWithApprole() AppRoleLogin: %s", err)
-	}
-	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		return fmt.Errorf("authToVaultWithApprole() SetToken: %s", err)
-	}
-	return nil
+// VaultAPI is the subset of the Vault client used by authenticators, credential sources and the admin commands.
+// It is implemented by *vaultcg.Client, paths are relative to /v1, ex. auth/approle/login
+type VaultAPI interface {
+	Read(ctx context.Context, path string, options ...vaultcg.RequestOption) (*vaultcg.Response[map[string]any], error)
+	Write(ctx context.Context, path string, body map[string]any, options ...vaultcg.RequestOption) (*vaultcg.Response[map[string]any], error)
+	Delete(ctx context.Context, path string, options ...vaultcg.RequestOption) (*vaultcg.Response[map[string]any], error)
+	SetToken(token string) error
 }
 
-// authToVaultWithKubernetes authenticates to Vault using kubernetes authentication and exchanging its PSAT for a vault token.
-// Upon successful authentication it popules the client with the recevied vault token.
-func authToVaultWithKubernetes(ctx *context.Context, client *vaultcg.Client, vaultKubernetesLoginRole string, mountPath string, tokenPath string) error {
-	path, err := os.ReadFile(tokenPath)
-	if err != nil {
-		log.Fatal(err)
+// newVaultClient prepares a hashicorp vault client for the given vault address.
+// httpClient is used for requests when set, ex. to trust a private CA, timeout defaults to 30 seconds
+func newVaultClient(vaddr string, httpClient *http.Client, timeout time.Duration) (*vaultcg.Client, error) {
+	if timeout <= 0 {
+		timeout = defaultVaultTimeout
+	}
+	options := []vaultcg.ClientOption{
+		vaultcg.WithAddress(vaddr),
+		vaultcg.WithRequestTimeout(timeout),
 	}
-	resp, err := client.Auth.KubernetesLogin(
-		*ctx,
-		schema.KubernetesLoginRequest{
-			// Jwt:  os.Getenv("KVL_TOKEN"),
-			Jwt:  string(path),
-			Role: vaultKubernetesLoginRole},
-		vaultcg.WithMountPath(mountPath),
-	)
+	if httpClient != nil {
+		options = append(options, vaultcg.WithHTTPClient(httpClient))
+	}
+	client, err := vaultcg.New(options...)
 	if err != nil {
-		return fmt.Errorf("authToVaultWithKubernetes() KubernetesLogin: %s", err)
+		return nil, fmt.Errorf("failure preparing vault client: %s", err)
 	}
-	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		return fmt.Errorf("authToVaultWithKubernetes() SetToken: %s", err)
+	return client, nil
+}
+
+// reservedNamespaces are names Vault refuses for namespaces
+var reservedNamespaces = map[string]bool{"root": true, "sys": true, "audit": true, "auth": true, "cubbyhole": true, "identity": true}
+
+// normalizeNamespace checks a Vault Enterprise namespace, a path of alphanumeric elements ex. team-a/dev, and strips
+// slashes around it. An empty namespace designates the root namespace
+func normalizeNamespace(namespace string) (string, error) {
+	trimmed := strings.Trim(namespace, "/")
+	if trimmed == "" {
+		return "", nil
+	}
+	for _, element := range strings.Split(trimmed, "/") {
+		if !pathElement.MatchString(element) || reservedNamespaces[element] {
+			return "", fmt.Errorf("must be a path of alphanumeric elements other than %s, ex. team-a/dev: %q", "root, sys, audit, auth, cubbyhole and identity", namespace)
+		}
 	}
-	return nil
+	return trimmed, nil
 }
 
-// generateK8sToken returns a kubernetes bearer token that it obtained from Vault
-func generateK8sToken(ctx *context.Context, client *vaultcg.Client, roleName string, clusterName string) (string, error) {
+// pathElement matches an element of a Vault namespace path or an object name in a path, ex. an approle role
+var pathElement = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
-	resp, err := client.Secrets.KubernetesGenerateCredentials(*ctx, roleName, schema.KubernetesGenerateCredentialsRequest{KubernetesNamespace: "kube-priv"},
-		vaultcg.WithMountPath("/kubernetes/"+clusterName),
-	)
-	if err != nil {
-		return "", fmt.Errorf("generateK8sToken() KubernetesGenerateCredentials: cluster=%s, role=%s, error=%s", clusterName, roleName, err)
+// withNamespace mentions the Vault namespace of a failed request in err, when set
+func withNamespace(namespace string, err error) error {
+	if namespace == "" || err == nil {
+		return err
 	}
-	return resp.Data["service_account_token"].(string), nil
+	return fmt.Errorf("vault namespace %s: %w", namespace, err)
 }
 
-func generateFakeK8sToken() string {
-	// Define the signing method and the secret key
-	signingKey := []byte("fake_secret_key")
+// vaultPath joins a mount point, with or without slashes, and path elements to a Vault API path
+func vaultPath(mount string, elems ...string) string {
+	return path.Join(append([]string{strings.Trim(mount, "/")}, elems...)...)
+}
 
-	// Create a new token object
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub":  "1234567890",                            // subject
-		"name": "John Doe",                              // name
-		"iat":  time.Now().Unix(),                       // issued at
-		"exp":  time.Now().Add(minTokenDuration).Unix(), // expiration time
-	})
+// setVaultToken populates the client with the vault token from a login response and returns the token's ttl
+func setVaultToken(client VaultAPI, resp *vaultcg.Response[map[string]any], caller string) (time.Duration, error) {
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return 0, fmt.Errorf("%s login response does not contain auth information", caller)
+	}
+	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
+		return 0, fmt.Errorf("%s SetToken: %s", caller, err)
+	}
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
 
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString(signingKey)
-	if err != nil {
-		fmt.Println("Error signing token:", err)
-		return ""
+// categorizeVaultError classifies err from a Vault request: responses with a 5xx status, a sealed Vault and
+// transport failures make Vault unreachable while other error responses are reported as denied
+func categorizeVaultError(err error, denied kvlerrors.Category) error {
+	if err == nil {
+		return nil
+	}
+	var responseErr *vaultcg.ResponseError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &responseErr) && responseErr.StatusCode >= http.StatusInternalServerError:
+		return kvlerrors.Wrap(kvlerrors.VaultUnreachable, err)
+	case errors.As(err, &responseErr):
+		return kvlerrors.Wrap(denied, err)
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return kvlerrors.Wrap(kvlerrors.VaultUnreachable, err)
+	}
+	return kvlerrors.Wrap(kvlerrors.General, err)
+}
+
+// durationField reads a duration in seconds from a Vault response field, as returned in ttl fields
+func durationField(data map[string]any, field string) (time.Duration, error) {
+	switch value := data[field].(type) {
+	case json.Number:
+		seconds, err := value.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("field %s is not a number of seconds: %s", field, value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	case float64:
+		return time.Duration(value) * time.Second, nil
+	default:
+		return 0, fmt.Errorf("field %s is not a number of seconds: %v", field, data[field])
 	}
-	return tokenString
 }
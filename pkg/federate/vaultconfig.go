@@ -0,0 +1,186 @@
+package federate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// const to define cobra command flag names that supply the TLS settings of the connection to Vault
+const (
+	FlagVaultCACert        = "vault-cacert"
+	FlagVaultCAPath        = "vault-capath"
+	FlagVaultClientCert    = "vault-client-cert"
+	FlagVaultClientKey     = "vault-client-key"
+	FlagVaultTLSServerName = "vault-tls-server-name"
+)
+
+// const to define cobra command flag name that supplies the timeout of requests to Vault
+const FlagVaultTimeout = "vault-timeout"
+
+// const to define cobra command flag name that supplies the proxy of the connection to Vault
+const FlagVaultProxy = "vault-proxy"
+
+// const to define cobra command flag name that allows a plain http vault-address, ex. of a local dev server
+const FlagAllowHTTP = "allow-http"
+
+// DefaultVaultAddress is the Vault address used when neither a flag, the configuration file nor VAULT_ADDR set one
+const DefaultVaultAddress = "https://127.0.0.1:8200"
+
+// defaultVaultTimeout is the timeout of requests to Vault when none is set
+const defaultVaultTimeout = 30 * time.Second
+
+// VaultConfig holds the settings of the connection to Vault, as set by flags, the vault section of the
+// configuration file or the environment variables of the vault CLI, see LoadEnv
+type VaultConfig struct {
+	// Address is the full URL with port to Hashicorp Vault
+	Address string `mapstructure:"address"`
+	// Namespace is the Vault Enterprise namespace of all requests
+	Namespace string `mapstructure:"namespace"`
+	// CACert is a PEM file and CAPath a directory of PEM files of the CAs trusted to sign Vault's certificate
+	CACert string `mapstructure:"cacert"`
+	CAPath string `mapstructure:"capath"`
+	// ClientCert and ClientKey are the PEM files of the certificate presented to Vault, ex. for the cert auth method
+	ClientCert string `mapstructure:"client-cert"`
+	ClientKey  string `mapstructure:"client-key"`
+	// TLSServerName is the name expected in Vault's certificate, it defaults to the host of Address
+	TLSServerName string `mapstructure:"tls-server-name"`
+	// Timeout limits each request to Vault
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Proxy is the URL of an http, https or socks5 proxy to Vault. HTTPS_PROXY and NO_PROXY apply when unset
+	Proxy string `mapstructure:"proxy"`
+	// AllowHTTP accepts an http Address, meant for local dev servers
+	AllowHTTP bool `mapstructure:"allow-http"`
+}
+
+// LoadEnv fills unset settings from the environment variables of the vault CLI: VAULT_ADDR, VAULT_NAMESPACE,
+// VAULT_CACERT, VAULT_CAPATH, VAULT_CLIENT_CERT, VAULT_CLIENT_KEY, VAULT_TLS_SERVER_NAME, VAULT_CLIENT_TIMEOUT
+// and VAULT_PROXY_ADDR
+func (c *VaultConfig) LoadEnv() error {
+	env := VaultConfig{
+		Address:       os.Getenv("VAULT_ADDR"),
+		Namespace:     os.Getenv("VAULT_NAMESPACE"),
+		CACert:        os.Getenv("VAULT_CACERT"),
+		CAPath:        os.Getenv("VAULT_CAPATH"),
+		ClientCert:    os.Getenv("VAULT_CLIENT_CERT"),
+		ClientKey:     os.Getenv("VAULT_CLIENT_KEY"),
+		TLSServerName: os.Getenv("VAULT_TLS_SERVER_NAME"),
+		Proxy:         os.Getenv("VAULT_PROXY_ADDR"),
+	}
+	if timeout := os.Getenv("VAULT_CLIENT_TIMEOUT"); timeout != "" {
+		var err error
+		if env.Timeout, err = parseTimeout(timeout); err != nil {
+			return fmt.Errorf("malformed VAULT_CLIENT_TIMEOUT: %s", err)
+		}
+	}
+	c.Merge(env)
+	return nil
+}
+
+// Merge fills the settings of c left unset with those of other, so that c takes precedence
+func (c *VaultConfig) Merge(other VaultConfig) {
+	for _, field := range []struct{ value, other *string }{
+		{&c.Address, &other.Address},
+		{&c.Namespace, &other.Namespace},
+		{&c.CACert, &other.CACert},
+		{&c.CAPath, &other.CAPath},
+		{&c.ClientCert, &other.ClientCert},
+		{&c.ClientKey, &other.ClientKey},
+		{&c.TLSServerName, &other.TLSServerName},
+		{&c.Proxy, &other.Proxy},
+	} {
+		if *field.value == "" {
+			*field.value = *field.other
+		}
+	}
+	if c.Timeout == 0 {
+		c.Timeout = other.Timeout
+	}
+	c.AllowHTTP = c.AllowHTTP || other.AllowHTTP
+}
+
+// HTTPClient returns an http client trusting the configured CAs, presenting the client certificate and
+// connecting through the proxy. Without settings it behaves as http.DefaultClient
+func (c *VaultConfig) HTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.TLSServerName}
+
+	if c.CACert != "" || c.CAPath != "" {
+		pool, err := loadCAs(c.CACert, c.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", FlagVaultClientCert, FlagVaultClientKey)
+	}
+	if c.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failure loading the vault client certificate: %s", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("mal formed %s: %s", FlagVaultProxy, c.Proxy)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("only http, https, socks5 and socks5h are allowed in %s: %s", FlagVaultProxy, c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// loadCAs returns a pool of the certificates in the PEM file caCert and in the PEM files of the directory caPath
+func loadCAs(caCert, caPath string) (*x509.CertPool, error) {
+	var files []string
+	if caCert != "" {
+		files = append(files, caCert)
+	}
+	if caPath != "" {
+		entries, err := os.ReadDir(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading %s: %s", FlagVaultCAPath, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(caPath, entry.Name()))
+			}
+		}
+	}
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failure reading vault CA certificate: %s", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) && file == caCert {
+			return nil, fmt.Errorf("no PEM certificate found in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+// parseTimeout reads a timeout as the vault CLI does, a duration ex. 90s or a number of seconds
+func parseTimeout(timeout string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(timeout)); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(timeout)
+}
@@ -0,0 +1,125 @@
+package federate
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tests settings left unset are filled from the VAULT_* environment variables
+func TestVaultConfigLoadEnv(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "https://env.example.com:8200")
+	t.Setenv("VAULT_CAPATH", "/etc/ssl/vault")
+	t.Setenv("VAULT_TLS_SERVER_NAME", "vault.internal")
+	t.Setenv("VAULT_PROXY_ADDR", "socks5://127.0.0.1:1080")
+	t.Setenv("VAULT_CLIENT_TIMEOUT", "1m30s")
+
+	config := VaultConfig{Address: "https://flag.example.com:8200"}
+	require.NoError(t, config.LoadEnv())
+	assert.Equal(t, VaultConfig{
+		Address:       "https://flag.example.com:8200",
+		CAPath:        "/etc/ssl/vault",
+		TLSServerName: "vault.internal",
+		Proxy:         "socks5://127.0.0.1:1080",
+		Timeout:       90 * time.Second,
+	}, config)
+}
+
+// tests the http client trusts the configured CAs and checks the configured server name
+func TestVaultConfigHTTPClientTLS(t *testing.T) {
+	vault := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(vault.Close)
+	caPath := t.TempDir()
+	caCert := filepath.Join(caPath, "ca.pem")
+	require.NoError(t, os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: vault.Certificate().Raw}), 0600))
+
+	tests := []struct {
+		name          string
+		config        VaultConfig
+		expectedError string
+	}{
+		{name: "system CAs", config: VaultConfig{}, expectedError: "certificate"},
+		{name: "cacert", config: VaultConfig{CACert: caCert}},
+		{name: "capath", config: VaultConfig{CAPath: caPath}},
+		{name: "tls server name", config: VaultConfig{CACert: caCert, TLSServerName: "example.com"}},
+		{name: "wrong tls server name", config: VaultConfig{CACert: caCert, TLSServerName: "vault.example.org"}, expectedError: "vault.example.org"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := tt.config.HTTPClient()
+			require.NoError(t, err)
+			resp, err := client.Get(vault.URL)
+			if tt.expectedError != "" {
+				assert.ErrorContains(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+		})
+	}
+}
+
+// tests requests to Vault go through the configured proxy
+func TestVaultConfigHTTPClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	t.Cleanup(proxy.Close)
+
+	config := VaultConfig{Proxy: proxy.URL}
+	client, err := config.HTTPClient()
+	require.NoError(t, err)
+	resp, err := client.Get("http://vault.invalid:8200/v1/sys/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "http://vault.invalid:8200/v1/sys/health", proxied)
+}
+
+// tests invalid connection settings are reported
+func TestVaultConfigHTTPClientInvalid(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		name          string
+		config        VaultConfig
+		expectedError string
+	}{
+		{name: "proxy scheme", config: VaultConfig{Proxy: "ftp://proxy:21"}, expectedError: "only http, https, socks5 and socks5h are allowed in vault-proxy"},
+		{name: "proxy without host", config: VaultConfig{Proxy: "proxy:3128"}, expectedError: "mal formed vault-proxy"},
+		{name: "client cert without key", config: VaultConfig{ClientCert: "/etc/ssl/client.pem"}, expectedError: "vault-client-cert and vault-client-key must be set together"},
+		{name: "missing client cert", config: VaultConfig{ClientCert: "/nonexistent/client.pem", ClientKey: "/nonexistent/client-key.pem"}, expectedError: "failure loading the vault client certificate"},
+		{name: "cacert without certificate", config: VaultConfig{CACert: notPEM}, expectedError: "no PEM certificate found"},
+		{name: "missing capath", config: VaultConfig{CAPath: "/nonexistent"}, expectedError: "failure reading vault-capath"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.config.HTTPClient()
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+// tests an http vault address is refused unless allowed
+func TestNewFederatorAllowHTTP(t *testing.T) {
+	opts := mockOptions
+	opts.VaultAddress = "http://127.0.0.1:8200"
+	_, err := NewFederator(opts)
+	assert.ErrorContains(t, err, "only https is allowed in vault-address, unless --allow-http is set")
+
+	opts.AllowHTTP = true
+	_, err = NewFederator(opts)
+	assert.NoError(t, err)
+
+	opts.VaultAddress = "ftp://127.0.0.1:8200"
+	_, err = NewFederator(opts)
+	assert.ErrorContains(t, err, "only https is allowed in vault-address")
+}
@@ -0,0 +1,128 @@
+// Package proxy serves a downstream kubernetes API server on a local address and authenticates the requests it
+// forwards with Vault issued bearer tokens, for tools that cannot run exec credential plugins
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/clientgo"
+)
+
+// shutdownTimeout bounds how long Serve waits for in-flight requests once its context is cancelled
+const shutdownTimeout = 5 * time.Second
+
+// Config holds the settings of a Proxy
+type Config struct {
+	// Server is the URL of the downstream kubernetes API server, ex. https://dev.example.com:6443
+	Server string
+	// TLSConfig verifies the API server, typically trusting the cluster's CA. Defaults to the system roots
+	TLSConfig *tls.Config
+	// Tokens supplies the bearer token of the downstream cluster and replaces it before it expires
+	Tokens *clientgo.TokenSource
+	// Logger receives operational messages, if nil they are discarded
+	Logger *log.Logger
+}
+
+// Proxy is a reverse proxy to a downstream kubernetes API server. It replaces the credentials of each request
+// with the bearer token of its TokenSource and passes streaming responses, ex. watches, and upgraded
+// connections, ex. exec and port-forward, through
+type Proxy struct {
+	config  Config
+	handler *httputil.ReverseProxy
+}
+
+// New validates config, applies defaults and returns a Proxy
+func New(config Config) (*Proxy, error) {
+	server, err := url.Parse(config.Server)
+	if err != nil || server.Scheme != "https" || server.Host == "" {
+		return nil, fmt.Errorf("New(): the kubernetes API server must be an https URL: %s", config.Server)
+	}
+	if config.Tokens == nil {
+		return nil, errors.New("New(): a token source is required")
+	}
+	if config.Logger == nil {
+		config.Logger = log.New(io.Discard, "", 0)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLSConfig.Clone()
+	// client certificates and proxies of the environment are not used, the bearer token is the only credential
+	transport.Proxy = nil
+	// HTTP/2 has no connection upgrades, exec, attach and port-forward are sent over HTTP/1.1 connections
+	upgradeTransport := transport.Clone()
+	upgradeTransport.ForceAttemptHTTP2 = false
+	upgradeTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	if upgradeTransport.TLSClientConfig == nil {
+		upgradeTransport.TLSClientConfig = &tls.Config{}
+	}
+	upgradeTransport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	p := &Proxy{config: config}
+	p.handler = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(server)
+			// credentials supplied by the client are never forwarded
+			r.Out.Header.Del("Authorization")
+			r.Out.Header.Del("Proxy-Authorization")
+		},
+		Transport: config.Tokens.WrapTransport(&upgradeRouter{transport: transport, upgradeTransport: upgradeTransport}),
+		// watches and logs are streamed as the API server writes them
+		FlushInterval: -1,
+		ErrorLog:      config.Logger,
+		ErrorHandler:  p.serveError,
+	}
+	return p, nil
+}
+
+// upgradeRouter sends requests for a connection upgrade through upgradeTransport and others through transport
+type upgradeRouter struct {
+	transport        http.RoundTripper
+	upgradeTransport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (u *upgradeRouter) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get("Upgrade") != "" {
+		return u.upgradeTransport.RoundTrip(r)
+	}
+	return u.transport.RoundTrip(r)
+}
+
+// ServeHTTP forwards r to the API server
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+// serveError answers a request that could not be forwarded, ex. as no token could be obtained from Vault
+func (p *Proxy) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	p.config.Logger.Printf("%s %s: %s", r.Method, r.URL.Path, err)
+	http.Error(w, fmt.Sprintf("kubectl-vaultlogin proxy: %s", err), http.StatusBadGateway)
+}
+
+// Serve forwards the requests of clients connecting to l until ctx is cancelled. l is closed on return
+func (p *Proxy) Serve(ctx context.Context, l net.Listener) error {
+	server := &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		// upgraded connections are hijacked and not tracked by Shutdown, Close ends them too
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			server.Close()
+		}
+	}()
+	p.config.Logger.Printf("proxying %s to %s", l.Addr(), p.config.Server)
+	if err := server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Serve(): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guardanet/kubectl-vaultlogin/pkg/clientgo"
+	"github.com/guardanet/kubectl-vaultlogin/pkg/federate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIssuer issues numbered tokens valid for 15 minutes, or fails once err is set
+type fakeIssuer struct {
+	mu     sync.Mutex
+	issued int
+	err    error
+}
+
+func (f *fakeIssuer) Credential(ctx context.Context, clusterName string) (*federate.Credential, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.issued++
+	return &federate.Credential{
+		ClusterName:         clusterName,
+		Token:               fmt.Sprintf("%s-token-%d", clusterName, f.issued),
+		ExpirationTimestamp: time.Now().Add(15 * time.Minute),
+	}, nil
+}
+
+// fakeAPIServer answers with the Authorization header it received, rejects tokens listed in revoked, streams
+// a watch on /watch, releasing its second event once release is closed, and echoes upgraded connections on /exec
+func fakeAPIServer(t *testing.T, revoked map[string]bool, release chan struct{}) *httptest.Server {
+	server := httptest.NewTLSServer(fakeAPIHandler(t, revoked, release))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fakeAPIHandler is the handler of fakeAPIServer
+func fakeAPIHandler(t *testing.T, revoked map[string]bool, release chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if revoked[strings.TrimPrefix(authorization, "Bearer ")] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/watch":
+			fmt.Fprintln(w, `{"type":"ADDED"}`)
+			w.(http.Flusher).Flush()
+			<-release
+			fmt.Fprintln(w, `{"type":"MODIFIED"}`)
+		case "/exec":
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			defer conn.Close()
+			fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", r.Header.Get("Upgrade"))
+			buf.Flush()
+			line, _ := buf.ReadString('\n')
+			fmt.Fprintf(conn, "%s %s", authorization, line)
+		default:
+			fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), authorization)
+		}
+	})
+}
+
+// startProxy serves a Proxy to server on a local address and returns its URL
+func startProxy(t *testing.T, server *httptest.Server, issuer clientgo.CredentialIssuer) string {
+	p, err := New(Config{
+		Server:    server.URL,
+		TLSConfig: server.Client().Transport.(*http.Transport).TLSClientConfig,
+		Tokens:    clientgo.NewTokenSource(issuer, "dev", 0),
+	})
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Serve(ctx, l) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return "http://" + l.Addr().String()
+}
+
+// tests requests are forwarded with the vault issued token in place of the client's credentials,
+// and a token the API server rejects is replaced
+func TestProxyAuthenticates(t *testing.T) {
+	revoked := map[string]bool{}
+	proxyURL := startProxy(t, fakeAPIServer(t, revoked, nil), &fakeIssuer{})
+
+	req, err := http.NewRequest(http.MethodGet, proxyURL+"/api/v1/namespaces?limit=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer client-token")
+	req.Header.Set("Proxy-Authorization", "Basic Y2xpZW50")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "GET /api/v1/namespaces?limit=1 Bearer dev-token-1", string(body))
+
+	revoked["dev-token-1"] = true
+	resp, err = http.Get(proxyURL + "/api")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = http.Get(proxyURL + "/api")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "GET /api Bearer dev-token-2", string(body))
+}
+
+// tests watch events reach the client as the API server writes them
+func TestProxyStreams(t *testing.T) {
+	release := make(chan struct{})
+	proxyURL := startProxy(t, fakeAPIServer(t, nil, release), &fakeIssuer{})
+
+	resp, err := http.Get(proxyURL + "/watch")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	event, err := events.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "{\"type\":\"ADDED\"}\n", event)
+
+	close(release)
+	event, err = events.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "{\"type\":\"MODIFIED\"}\n", event)
+}
+
+// tests upgraded connections, as of exec and port-forward, are authenticated and passed through
+func TestProxyUpgrades(t *testing.T) {
+	testProxyUpgrades(t, fakeAPIServer(t, nil, nil))
+}
+
+// tests upgraded connections are passed through to an API server negotiating HTTP/2, as real API servers do
+func TestProxyUpgradesHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(fakeAPIHandler(t, nil, nil))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	testProxyUpgrades(t, server)
+}
+
+// testProxyUpgrades upgrades a connection to server through the proxy and echoes a line over it
+func testProxyUpgrades(t *testing.T, server *httptest.Server) {
+	proxyURL := startProxy(t, server, &fakeIssuer{})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyURL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "POST /exec HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer client-token\r\nConnection: Upgrade\r\nUpgrade: SPDY/3.1\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "SPDY/3.1", resp.Header.Get("Upgrade"))
+
+	fmt.Fprint(conn, "ls\n")
+	echoed, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer dev-token-1 ls\n", echoed)
+}
+
+// tests a failure to obtain a token is answered with 502 and the proxy's settings are validated
+func TestProxyErrors(t *testing.T) {
+	proxyURL := startProxy(t, fakeAPIServer(t, nil, nil), &fakeIssuer{err: errors.New("permission denied")})
+	resp, err := http.Get(proxyURL + "/api")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Contains(t, string(body), "permission denied")
+
+	_, err = New(Config{Server: "http://dev.example.com", Tokens: clientgo.NewTokenSource(&fakeIssuer{}, "dev", 0)})
+	assert.ErrorContains(t, err, "the kubernetes API server must be an https URL")
+	_, err = New(Config{Server: "https://dev.example.com"})
+	assert.ErrorContains(t, err, "a token source is required")
+}
@@ -0,0 +1,574 @@
+// Package vaultfake provides an in-memory stand-in for Hashicorp Vault served over httptest. It implements the
+// kubernetes, approle, jwt and oidc login endpoints, response wrapping, sys/mounts, sys/auth, sys/capabilities-self and Vault's kubernetes secret engine, so the federation logic can
+// be tested offline, including permission denied, sealed Vault and malformed responses
+package vaultfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failure is an error the fake Vault answers with instead of handling a request
+type Failure int
+
+const (
+	// Forbidden answers with 403 permission denied
+	Forbidden Failure = iota + 1
+	// Sealed answers with 503 Vault is sealed
+	Sealed
+	// Malformed answers with 200 and a body that is not JSON
+	Malformed
+)
+
+// AnyPath makes a failure apply to every request
+const AnyPath = "*"
+
+// Request is a request received by the fake Vault
+type Request struct {
+	// Token is the vault token of the request, empty for login requests
+	Token string
+	// Body is the decoded JSON body
+	Body map[string]any
+	// Namespace is the X-Vault-Namespace header, empty in the root namespace
+	Namespace string
+	// Query holds the query parameters
+	Query url.Values
+}
+
+// HandlerFunc handles a request and returns a status code and a value encoded as the JSON response
+type HandlerFunc func(r *Request) (int, any)
+
+// Server is a fake Vault. Paths passed to its methods are relative to /v1 and may carry leading or trailing slashes
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	tokenTTL time.Duration
+	serial   int
+	// tokens maps issued vault tokens to their metadata
+	tokens   map[string]map[string]string
+	routes   map[string]route
+	failures map[string]Failure
+	requests []string
+	// namespaces holds the X-Vault-Namespace header of each request in requests
+	namespaces []string
+	wrapped    map[string]wrapped
+	// mounts maps the paths of secret engines enabled through sys/mounts to their type, nil until EnableMounts
+	mounts map[string]string
+	// stored holds what was written under enabled secret engines by path
+	stored map[string]map[string]any
+	// capabilities maps paths to the capabilities sys/capabilities-self reports, nil until SetCapabilities
+	capabilities map[string][]string
+}
+
+// wrapped is a response held behind a response-wrapping token
+type wrapped struct {
+	creationPath string
+	data         map[string]any
+}
+
+// route is a request handler and whether it requires a vault token
+type route struct {
+	handler HandlerFunc
+	public  bool
+}
+
+// New starts a fake Vault over TLS. Use its Client() to trust the server's certificate and Close() it when done
+func New() *Server {
+	s := &Server{
+		tokenTTL: time.Hour,
+		tokens:   map[string]map[string]string{},
+		routes:   map[string]route{},
+		failures: map[string]Failure{},
+	}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	s.HandleFunc(http.MethodPost, "auth/token/renew-self", func(r *Request) (int, any) {
+		return http.StatusOK, s.authResponse(r.Token)
+	})
+	s.HandleFunc(http.MethodGet, "auth/token/lookup-self", func(r *Request) (int, any) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return http.StatusOK, map[string]any{"data": map[string]any{"id": r.Token, "meta": s.tokens[r.Token], "ttl": int(s.tokenTTL.Seconds())}}
+	})
+	return s
+}
+
+// SetTokenTTL sets the ttl of vault tokens issued by login endpoints, 1h by default
+func (s *Server) SetTokenTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenTTL = ttl
+}
+
+// HandleFunc registers handler for authenticated requests with method to path
+func (s *Server) HandleFunc(method string, path string, handler HandlerFunc) {
+	s.handle(method, path, handler, false)
+}
+
+func (s *Server) handle(method string, path string, handler HandlerFunc, public bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes[method+" "+cleanPath(path)] = route{handler: handler, public: public}
+}
+
+// AddLogin enables an authentication method under mount issuing a vault token for logins accepted by accept
+func (s *Server) AddLogin(mount string, accept func(r *Request) bool) {
+	s.handle(http.MethodPost, path.Join("auth", cleanPath(mount), "login"), func(r *Request) (int, any) {
+		if !accept(r) {
+			return http.StatusForbidden, vaultError("permission denied")
+		}
+		return http.StatusOK, s.authResponse(s.newToken(nil))
+	}, true)
+}
+
+// IssueToken issues a vault token accepted by authenticated endpoints, as a prior vault login would
+func (s *Server) IssueToken() string {
+	return s.newToken(nil)
+}
+
+// AddOIDCAuth enables oidc authentication under mount for role. Its auth_url redirects straight to the
+// requested redirect_uri with an authorization code, whose callback issues a vault token when the state,
+// code and client_nonce match
+func (s *Server) AddOIDCAuth(mount string, role string) {
+	mount = cleanPath(mount)
+	// nonces maps the state of each requested auth_url to its client_nonce
+	nonces := map[string]string{}
+	s.handle(http.MethodPost, path.Join("auth", mount, "oidc", "auth_url"), func(r *Request) (int, any) {
+		if r.Body["role"] != role {
+			return http.StatusBadRequest, vaultError(fmt.Sprintf("role %q could not be found", r.Body["role"]))
+		}
+		state := fmt.Sprintf("state-%d", s.nextSerial())
+		s.mu.Lock()
+		nonces[state] = fmt.Sprint(r.Body["client_nonce"])
+		s.mu.Unlock()
+		authURL := fmt.Sprintf("%s?%s", r.Body["redirect_uri"], url.Values{"code": {"fake-code"}, "state": {state}}.Encode())
+		return http.StatusOK, map[string]any{"data": map[string]any{"auth_url": authURL}}
+	}, true)
+	s.handle(http.MethodGet, path.Join("auth", mount, "oidc", "callback"), func(r *Request) (int, any) {
+		state := r.Query.Get("state")
+		s.mu.Lock()
+		nonce, exists := nonces[state]
+		delete(nonces, state)
+		s.mu.Unlock()
+		if !exists || r.Query.Get("code") != "fake-code" || r.Query.Get("client_nonce") != nonce {
+			return http.StatusBadRequest, vaultError("invalid state, code or client nonce")
+		}
+		return http.StatusOK, s.authResponse(s.newToken(nil))
+	}, true)
+}
+
+// AddKubernetesLogin enables kubernetes authentication under mount accepting jwt for role
+func (s *Server) AddKubernetesLogin(mount string, role string, jwt string) {
+	s.AddLogin(mount, func(r *Request) bool {
+		return r.Body["role"] == role && r.Body["jwt"] == jwt
+	})
+}
+
+// AddJWTLogin enables jwt authentication under mount accepting jwt for role
+func (s *Server) AddJWTLogin(mount string, role string, jwt string) {
+	s.AddKubernetesLogin(mount, role, jwt)
+}
+
+// AddApproleLogin enables approle authentication under mount accepting roleID and secretID of the role named default
+func (s *Server) AddApproleLogin(mount string, roleID string, secretID string) {
+	s.AddApproleRole(mount, "default", roleID, secretID)
+}
+
+// AddApproleRole enables approle authentication under mount accepting roleID and secretID of role. Tokens carry
+// the role_name metadata and may generate, look up and destroy SecretIDs of role, destroyed ones are no longer accepted
+func (s *Server) AddApproleRole(mount string, role string, roleID string, secretID string) {
+	mount = cleanPath(mount)
+	rolePath := path.Join("auth", mount, "role", role)
+	// secretIDs maps the live SecretIDs of role to their accessors
+	secretIDs := map[string]string{secretID: "accessor-" + secretID}
+	s.handle(http.MethodPost, path.Join("auth", mount, "login"), func(r *Request) (int, any) {
+		s.mu.Lock()
+		_, live := secretIDs[fmt.Sprint(r.Body["secret_id"])]
+		s.mu.Unlock()
+		if r.Body["role_id"] != roleID || !live {
+			return http.StatusBadRequest, vaultError("invalid role or secret ID")
+		}
+		return http.StatusOK, s.authResponse(s.newToken(map[string]string{"role_name": role}))
+	}, true)
+	s.HandleFunc(http.MethodPost, path.Join(rolePath, "secret-id"), func(r *Request) (int, any) {
+		generated := fmt.Sprintf("secret-id-%d", s.nextSerial())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		secretIDs[generated] = "accessor-" + generated
+		return http.StatusOK, map[string]any{"data": map[string]any{"secret_id": generated, "secret_id_accessor": secretIDs[generated], "secret_id_ttl": 0}}
+	})
+	s.HandleFunc(http.MethodPost, path.Join(rolePath, "secret-id", "lookup"), func(r *Request) (int, any) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		accessor, live := secretIDs[fmt.Sprint(r.Body["secret_id"])]
+		if !live {
+			return http.StatusNotFound, vaultError()
+		}
+		return http.StatusOK, map[string]any{"data": map[string]any{"secret_id_accessor": accessor}}
+	})
+	s.HandleFunc(http.MethodPost, path.Join(rolePath, "secret-id-accessor", "destroy"), func(r *Request) (int, any) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for id, accessor := range secretIDs {
+			if accessor == r.Body["secret_id_accessor"] {
+				delete(secretIDs, id)
+				return http.StatusNoContent, nil
+			}
+		}
+		return http.StatusBadRequest, vaultError("failed to find accessor entry for secret_id_accessor")
+	})
+}
+
+// WrapSecretID issues a single use response-wrapping token of secretID, as created by approle's secret-id endpoint
+// of role under mount, and serves sys/wrapping/lookup and sys/wrapping/unwrap for it
+func (s *Server) WrapSecretID(mount string, role string, secretID string) string {
+	return s.Wrap(path.Join("auth", cleanPath(mount), "role", role, "secret-id"), map[string]any{
+		"secret_id":          secretID,
+		"secret_id_accessor": "accessor-" + secretID,
+	})
+}
+
+// Wrap issues a single use response-wrapping token of data created by creationPath and serves sys/wrapping/lookup
+// and sys/wrapping/unwrap for it
+func (s *Server) Wrap(creationPath string, data map[string]any) string {
+	token := fmt.Sprintf("hvs.wrapping-%d", s.nextSerial())
+	s.mu.Lock()
+	if s.wrapped == nil {
+		s.wrapped = map[string]wrapped{}
+	}
+	s.wrapped[token] = wrapped{creationPath: creationPath, data: data}
+	s.mu.Unlock()
+
+	s.handle(http.MethodPost, "sys/wrapping/lookup", func(r *Request) (int, any) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w, exists := s.wrapped[fmt.Sprint(r.Body["token"])]
+		if !exists {
+			return http.StatusBadRequest, vaultError("wrapping token is not valid or does not exist")
+		}
+		return http.StatusOK, map[string]any{"data": map[string]any{"creation_path": w.creationPath, "creation_ttl": 300}}
+	}, true)
+	s.handle(http.MethodPost, "sys/wrapping/unwrap", func(r *Request) (int, any) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w, exists := s.wrapped[r.Token]
+		if !exists {
+			return http.StatusBadRequest, vaultError("wrapping token is not valid or does not exist")
+		}
+		delete(s.wrapped, r.Token)
+		return http.StatusOK, map[string]any{"data": w.data}
+	}, true)
+	return token
+}
+
+// AddKubernetesCreds enables role in a kubernetes secret engine mounted under mount. Every request
+// issues a new service account token with a 10 minutes lease
+func (s *Server) AddKubernetesCreds(mount string, role string) {
+	s.HandleFunc(http.MethodPost, path.Join(cleanPath(mount), "creds", role), func(r *Request) (int, any) {
+		serial := s.nextSerial()
+		return http.StatusOK, map[string]any{
+			"lease_id":       fmt.Sprintf("%s/creds/%s/%d", cleanPath(mount), role, serial),
+			"lease_duration": 600,
+			"renewable":      false,
+			"data": map[string]any{
+				"service_account_name":      fmt.Sprintf("v-%s-%d", role, serial),
+				"service_account_namespace": r.Body["kubernetes_namespace"],
+				"service_account_token":     fmt.Sprintf("fake-k8s-token-%d", serial),
+			},
+		}
+	})
+}
+
+// AddOIDCRole enables an identity OIDC role issuing tokens valid for ttl
+func (s *Server) AddOIDCRole(role string, ttl time.Duration) {
+	s.HandleFunc(http.MethodGet, path.Join("identity/oidc/token", role), func(*Request) (int, any) {
+		return http.StatusOK, map[string]any{
+			"data": map[string]any{
+				"client_id": role,
+				"token":     fmt.Sprintf("fake-oidc-token-%d", s.nextSerial()),
+				"ttl":       int(ttl.Seconds()),
+			},
+		}
+	})
+}
+
+// SetKV stores data at secretPath in a KV v2 secret engine mounted under mount
+func (s *Server) SetKV(mount string, secretPath string, data map[string]any) {
+	s.HandleFunc(http.MethodGet, path.Join(cleanPath(mount), "data", secretPath), func(*Request) (int, any) {
+		return http.StatusOK, map[string]any{
+			"data": map[string]any{"data": data, "metadata": map[string]any{"version": 1}},
+		}
+	})
+}
+
+// SetCapabilities makes sys/capabilities-self report capabilities on secretPath for every token, other paths report deny
+func (s *Server) SetCapabilities(secretPath string, capabilities ...string) {
+	s.mu.Lock()
+	if s.capabilities == nil {
+		s.capabilities = map[string][]string{}
+		s.routes[http.MethodPost+" sys/capabilities-self"] = route{handler: s.capabilitiesSelf}
+	}
+	s.capabilities[cleanPath(secretPath)] = capabilities
+	s.mu.Unlock()
+}
+
+// capabilitiesSelf answers sys/capabilities-self with the capabilities of each requested path, by path as Vault does
+func (s *Server) capabilitiesSelf(r *Request) (int, any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths, _ := r.Body["paths"].([]any)
+	data := map[string]any{}
+	for _, requested := range paths {
+		capabilities, exists := s.capabilities[cleanPath(fmt.Sprint(requested))]
+		if !exists {
+			capabilities = []string{"deny"}
+		}
+		data[fmt.Sprint(requested)] = capabilities
+	}
+	return http.StatusOK, map[string]any{"data": data}
+}
+
+// EnableMounts serves sys/mounts and sys/auth, so secret engines and auth methods can be listed, enabled and
+// disabled. Those enabled this way store what is written to them: GET returns it, DELETE removes it and
+// disabling them drops it all
+func (s *Server) EnableMounts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mounts = map[string]string{}
+	s.stored = map[string]map[string]any{}
+}
+
+// Mounts returns the paths of the secret engines and auth methods enabled through sys/mounts and sys/auth and
+// their type. Paths of auth methods start with auth/, ex. auth/kubernetes/argocd
+func (s *Server) Mounts() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mounts := map[string]string{}
+	for mount, engine := range s.mounts {
+		mounts[mount] = engine
+	}
+	return mounts
+}
+
+// Stored returns what was last written to secretPath under a secret engine or auth method enabled through
+// sys/mounts or sys/auth, nil if nothing
+func (s *Server) Stored(secretPath string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stored[cleanPath(secretPath)]
+}
+
+// mountsRoute returns a handler of sys/mounts, sys/auth and of the paths under what they enabled, s.mu must be held
+func (s *Server) mountsRoute(method string, requestPath string) (route, bool) {
+	if s.mounts == nil {
+		return route{}, false
+	}
+	// each listing endpoint enables mounts under a prefix of the API paths
+	for listPath, prefix := range map[string]string{"sys/mounts": "", "sys/auth": "auth/"} {
+		if requestPath == listPath && method == http.MethodGet {
+			return route{handler: func(*Request) (int, any) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				data := map[string]any{}
+				for mount, engine := range s.mounts {
+					if relative, found := strings.CutPrefix(mount, prefix); found && (prefix != "" || !strings.HasPrefix(mount, "auth/")) {
+						data[relative+"/"] = map[string]any{"type": engine}
+					}
+				}
+				return http.StatusOK, map[string]any{"data": data}
+			}}, true
+		}
+		relative, found := strings.CutPrefix(requestPath, listPath+"/")
+		if !found {
+			continue
+		}
+		mount := prefix + relative
+		switch method {
+		case http.MethodPost:
+			return route{handler: func(r *Request) (int, any) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				if _, exists := s.mounts[mount]; exists {
+					return http.StatusBadRequest, vaultError(fmt.Sprintf("path is already in use at %s/", relative))
+				}
+				s.mounts[mount] = fmt.Sprint(r.Body["type"])
+				return http.StatusNoContent, nil
+			}}, true
+		case http.MethodDelete:
+			return route{handler: func(*Request) (int, any) {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				delete(s.mounts, mount)
+				for stored := range s.stored {
+					if strings.HasPrefix(stored, mount+"/") {
+						delete(s.stored, stored)
+					}
+				}
+				return http.StatusNoContent, nil
+			}}, true
+		}
+		return route{}, false
+	}
+	for mount := range s.mounts {
+		if !strings.HasPrefix(requestPath, mount+"/") {
+			continue
+		}
+		return route{handler: func(r *Request) (int, any) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			switch method {
+			case http.MethodPost, http.MethodPut:
+				s.stored[requestPath] = r.Body
+				return http.StatusNoContent, nil
+			case http.MethodDelete:
+				delete(s.stored, requestPath)
+				return http.StatusNoContent, nil
+			}
+			data, exists := s.stored[requestPath]
+			if !exists {
+				return http.StatusNotFound, vaultError()
+			}
+			return http.StatusOK, map[string]any{"data": data}
+		}}, true
+	}
+	return route{}, false
+}
+
+// Fail makes requests to path, or to any path with AnyPath, answer with failure until Recover is called
+func (s *Server) Fail(path string, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[cleanPath(path)] = failure
+}
+
+// Recover removes a failure injected with Fail
+func (s *Server) Recover(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, cleanPath(path))
+}
+
+// Requests returns the requests received so far, as method and path, ex. POST auth/approle/login
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Namespaces returns the X-Vault-Namespace header of the requests received so far, in the order of Requests
+func (s *Server) Namespaces() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.namespaces...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	requestPath := cleanPath(strings.TrimPrefix(r.URL.Path, "/v1/"))
+	key := r.Method + " " + requestPath
+
+	s.mu.Lock()
+	s.requests = append(s.requests, key)
+	s.namespaces = append(s.namespaces, r.Header.Get("X-Vault-Namespace"))
+	failure, failing := s.failures[requestPath]
+	if !failing {
+		failure, failing = s.failures[AnyPath]
+	}
+	rt, exists := s.routes[key]
+	if !exists {
+		rt, exists = s.mountsRoute(r.Method, requestPath)
+	}
+	_, authorized := s.tokens[r.Header.Get("X-Vault-Token")]
+	s.mu.Unlock()
+
+	if failing {
+		switch failure {
+		case Forbidden:
+			writeJSON(w, http.StatusForbidden, vaultError("permission denied"))
+		case Sealed:
+			writeJSON(w, http.StatusServiceUnavailable, vaultError("Vault is sealed"))
+		case Malformed:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"data": {"token"`)
+		}
+		return
+	}
+	if !exists {
+		writeJSON(w, http.StatusNotFound, vaultError())
+		return
+	}
+	if !rt.public && !authorized {
+		writeJSON(w, http.StatusForbidden, vaultError("permission denied"))
+		return
+	}
+
+	body := map[string]any{}
+	if r.Body != nil {
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				writeJSON(w, http.StatusBadRequest, vaultError("failed to parse JSON input: "+err.Error()))
+				return
+			}
+		}
+	}
+	code, response := rt.handler(&Request{Token: r.Header.Get("X-Vault-Token"), Body: body, Namespace: r.Header.Get("X-Vault-Namespace"), Query: r.URL.Query()})
+	writeJSON(w, code, response)
+}
+
+// newToken issues a vault token accepted by authenticated endpoints, carrying meta
+func (s *Server) newToken(meta map[string]string) string {
+	token := fmt.Sprintf("hvs.fake-%d", s.nextSerial())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = meta
+	return token
+}
+
+func (s *Server) nextSerial() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serial++
+	return s.serial
+}
+
+// authResponse is a login or renew response for token
+func (s *Server) authResponse(token string) map[string]any {
+	s.mu.Lock()
+	ttl := s.tokenTTL
+	s.mu.Unlock()
+	// like Vault, responses without data carry a null data field
+	return map[string]any{
+		"data": nil,
+		"auth": map[string]any{
+			"client_token":   token,
+			"accessor":       "accessor-" + token,
+			"policies":       []string{"default"},
+			"lease_duration": int(ttl.Seconds()),
+			"renewable":      true,
+		},
+	}
+}
+
+// vaultError is a Vault error response body
+func vaultError(messages ...string) map[string]any {
+	return map[string]any{"errors": append([]string{}, messages...)}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// cleanPath strips slashes around a Vault API path
+func cleanPath(p string) string {
+	return strings.Trim(p, "/")
+}